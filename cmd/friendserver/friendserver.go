@@ -5,10 +5,13 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"google.golang.org/grpc"
 
 	"github.com/phuhao00/pandaparty/config"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	mongox "github.com/phuhao00/pandaparty/infra/mongo"
 	nsqx "github.com/phuhao00/pandaparty/infra/nsq"
 	pb "github.com/phuhao00/pandaparty/infra/pb/protocol/friend"
@@ -16,6 +19,8 @@ import (
 	"github.com/phuhao00/pandaparty/internal/friendserver"
 )
 
+const serverName = "friendserver"
+
 func main() {
 	log.Println("FriendServer starting...")
 
@@ -57,7 +62,7 @@ func main() {
 	friendHandler := friendserver.NewFriendHandler(mongoClient.GetReal(), redisClient.GetReal(), nsqProducer.GetReal(), cfg)
 
 	// 启动gRPC服务器
-	port := cfg.Server.ServiceRpcPorts["friendserver"]
+	port := cfg.Server.ServiceRpcPorts[serverName]
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Fatalf("监听端口失败: %v", err)
@@ -67,7 +72,49 @@ func main() {
 	pb.RegisterFriendServiceServer(grpcServer, friendHandler)
 
 	log.Printf("FriendServer启动在端口 %d", port)
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("启动gRPC服务失败: %v", err)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("启动gRPC服务失败: %v", err)
+		}
+	}()
+
+	// Register FriendServer RPC service with the discovery registry (Consul unless configured otherwise)
+	var serviceID string
+	registry, err := discovery.NewRegistry(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize discovery registry: %v", err)
+	} else {
+		registrationHost := cfg.Server.Host // Default
+		if cfg.Server.RegisterSelfAsHost {
+			registrationHost = serverName // Override with the server's own name
+		}
+
+		if registrationHost == "" {
+			log.Fatalf("Registration host is empty for %s after config evaluation", serverName)
+		}
+
+		serviceID = serverName + "-rpc"
+		serviceNameStr := serverName + "-rpc"
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: port})
+		if err != nil {
+			log.Printf("Failed to register %s RPC service: %v", serviceNameStr, err)
+			serviceID = ""
+		} else {
+			log.Printf("%s RPC service registered successfully on port %d with host %s", serviceNameStr, port, registrationHost)
+		}
+	}
+
+	// Graceful shutdown handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan // Block until a signal is received
+
+	log.Printf("Shutting down %s...", serverName)
+	if registry != nil && serviceID != "" {
+		if err := registry.Deregister(serviceID); err != nil {
+			log.Printf("Failed to deregister service %s: %v", serviceID, err)
+		}
 	}
+	grpcServer.GracefulStop()
+	log.Printf("%s shut down gracefully.", serverName)
 }
@@ -11,7 +11,8 @@ import (
 	"time" // Added for RPCClient timeout configuration
 
 	"github.com/phuhao00/pandaparty/config"
-	consulx "github.com/phuhao00/pandaparty/infra/consul" // Added for Consul client initialization
+	consulx "github.com/phuhao00/pandaparty/infra/consul" // The game coordinator still talks to Consul directly
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/phuhao00/pandaparty/infra/mongo"
 	"github.com/phuhao00/pandaparty/infra/network" // RPCClient, keep if other client calls are made
 	redisx "github.com/phuhao00/pandaparty/infra/redis"
@@ -62,18 +63,23 @@ func main() {
 		log.Println("Connected to Redis successfully")
 	}
 
-	// Initialize Consul Client (needed for RoomService to make RPC calls)
-	consulClient, err := consulx.NewConsulClient(cfg.Consul)
+	// Initialize the discovery registry (Consul unless configured otherwise)
+	registry, err := discovery.NewRegistry(cfg)
 	serviceID := ""
+	var resolver *discovery.Resolver
+	var consulClient *consulx.ConsulClient // Only set when Consul is the registry
 	if err != nil {
-		log.Printf("Failed to initialize Consul client: %v. RPC calls to other services might fail.", err)
+		log.Printf("Failed to initialize discovery registry: %v. RPC calls to other services might fail.", err)
 		// Depending on requirements, might choose to exit or continue.
-		// For now, RoomService can attempt to create its own client if this one is nil.
 	} else {
-		log.Println("Consul client initialized successfully for GameServer.")
-		// Register GameServer RPC service with Consul
-		// rpcPort is already validated at the start of main
+		log.Println("Discovery registry initialized successfully for GameServer.")
+		if consulRegistry, ok := registry.(*discovery.ConsulRegistry); ok {
+			consulClient = consulRegistry.Client()
+		}
+		resolver = discovery.NewResolver(registry)
 
+		// Register GameServer RPC service with the registry
+		// rpcPort is already validated at the start of main
 		registrationHost := cfg.Server.Host // Default
 		if cfg.Server.RegisterSelfAsHost {
 			registrationHost = serverName // Override with the server's own name
@@ -85,18 +91,23 @@ func main() {
 
 		serviceID = serverName + "-rpc"
 		serviceNameStr := serverName + "-rpc" // Using serviceNameStr to avoid conflict
-		err = consulClient.RegisterService(serviceID, serviceNameStr, registrationHost, rpcPort)
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: rpcPort})
 		if err != nil {
-			log.Printf("Failed to register %s RPC service with Consul: %v", serviceNameStr, err)
+			log.Printf("Failed to register %s RPC service: %v", serviceNameStr, err)
+			serviceID = ""
 		} else {
-			log.Printf("%s RPC service registered with Consul successfully on port %d with host %s", serviceNameStr, rpcPort, registrationHost)
+			log.Printf("%s RPC service registered successfully on port %d with host %s", serviceNameStr, rpcPort, registrationHost)
 		}
 	}
 
 	// Initialize RPCClient
 	// Use defaultMaxConnsPerEndpoint (e.g., 10) and defaultDialTimeout (e.g., 5s)
 	// These values can be made configurable later if needed.
-	rpcClient := network.NewRPCClient(consulClient, 10, 5*time.Second)
+	rpcOpts := []network.ClientOption{network.WithClientInterceptors(network.ClientLoggingInterceptor(nil))}
+	if resolver != nil {
+		rpcOpts = append(rpcOpts, network.WithResolver(resolver))
+	}
+	rpcClient := network.NewRPCClient(nil, 10, 5*time.Second, rpcOpts...)
 	// defer rpcClient.CloseAllConnections() // Explicitly closed during graceful shutdown
 	log.Println("RPCClient initialized.")
 
@@ -123,10 +134,10 @@ func main() {
 		}
 	}()
 
-	if mongoClient != nil && redisClient != nil && consulClient != nil {
-		log.Printf("%s started successfully with DB, Redis, and Consul.", serverName)
+	if mongoClient != nil && redisClient != nil && registry != nil {
+		log.Printf("%s started successfully with DB, Redis, and discovery.", serverName)
 	} else {
-		log.Printf("%s started with one or more core components missing (DB, Redis, or Consul).", serverName)
+		log.Printf("%s started with one or more core components missing (DB, Redis, or discovery).", serverName)
 	}
 
 	// Keep the server running
@@ -174,14 +185,19 @@ func main() {
 		}
 	}
 
-	// Deregister from Consul
+	// Stop watching the registry
+	if resolver != nil {
+		resolver.Close()
+	}
+
+	// Deregister from the discovery registry
 	// serviceID was captured when the service was registered
-	if consulClient != nil && serviceID != "" {
-		log.Printf("Deregistering service %s from Consul...", serviceID)
-		if err := consulClient.DeregisterService(serviceID); err != nil {
-			log.Printf("Failed to deregister service %s from Consul: %v", serviceID, err)
+	if serviceID != "" {
+		log.Printf("Deregistering service %s...", serviceID)
+		if err := registry.Deregister(serviceID); err != nil {
+			log.Printf("Failed to deregister service %s: %v", serviceID, err)
 		} else {
-			log.Println("Service deregistered from Consul successfully.")
+			log.Println("Service deregistered successfully.")
 		}
 	}
 
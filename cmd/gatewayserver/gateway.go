@@ -10,7 +10,8 @@ import (
 	"syscall"   // Added for signal handling
 
 	"github.com/phuhao00/pandaparty/config"
-	consulx "github.com/phuhao00/pandaparty/infra/consul" // Added for Consul
+	consulx "github.com/phuhao00/pandaparty/infra/consul" // The gateway still talks to Consul directly
+	"github.com/phuhao00/pandaparty/infra/discovery"
 )
 
 const serverName = "gatewayserver"
@@ -28,13 +29,17 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Println("Configuration loaded successfully")
 
-	// Initialize Consul Client
-	consulClient, err := consulx.NewConsulClient(cfg.Consul)
+	// Initialize the discovery registry (Consul unless configured otherwise)
+	registry, err := discovery.NewRegistry(cfg)
+	var consulClient *consulx.ConsulClient // Only set when Consul is the registry
 	if err != nil {
-		log.Printf("Failed to initialize Consul client for %s: %v", serverName, err)
+		log.Printf("Failed to initialize discovery registry for %s: %v", serverName, err)
 		// Making this non-fatal for now, but logging the error.
 	} else {
-		log.Printf("Consul client initialized successfully for %s", serverName)
+		log.Printf("Discovery registry initialized successfully for %s", serverName)
+		if consulRegistry, ok := registry.(*discovery.ConsulRegistry); ok {
+			consulClient = consulRegistry.Client()
+		}
 	}
 
 	// TCP Port Setup
@@ -52,7 +57,7 @@ func main() {
 	// TCP Service Registration
 	var tcpServiceIDGame string // Declare outside to be accessible in shutdown
 	var tcpServiceIDRoom string // Declare outside to be accessible in shutdown
-	if consulClient != nil {
+	if registry != nil {
 		registrationHost := cfg.Server.Host // Default
 		if cfg.Server.RegisterSelfAsHost {
 			registrationHost = serverName // Override with the server's own name
@@ -65,20 +70,20 @@ func main() {
 		tcpServiceIDGame = serverName + "-tcp" + "-game"
 		tcpServiceNameGame := serverName + "-tcp" + "-game"
 		// gameServerTCPPort is already available and validated
-		err = consulClient.RegisterService(tcpServiceIDGame, tcpServiceNameGame, registrationHost, gameServerTCPPort)
+		err = registry.Register(discovery.Instance{ID: tcpServiceIDGame, Service: tcpServiceNameGame, Address: registrationHost, Port: gameServerTCPPort})
 		if err != nil {
-			log.Printf("Failed to register %s TCP service with Consul: %v", tcpServiceNameGame, err)
+			log.Printf("Failed to register %s TCP service: %v", tcpServiceNameGame, err)
 		} else {
-			log.Printf("%s TCP service registered with Consul successfully on port %d with host %s", tcpServiceNameGame, gameServerTCPPort, registrationHost)
+			log.Printf("%s TCP service registered successfully on port %d with host %s", tcpServiceNameGame, gameServerTCPPort, registrationHost)
 		}
 		tcpServiceIDRoom = serverName + "-tcp" + "-room"
 		tcpServiceNameRoom := serverName + "-tcp" + "-room"
 		// gameServerTCPPort is already available and validated
-		err = consulClient.RegisterService(tcpServiceIDRoom, tcpServiceNameRoom, registrationHost, gameServerTCPPort)
+		err = registry.Register(discovery.Instance{ID: tcpServiceIDRoom, Service: tcpServiceNameRoom, Address: registrationHost, Port: gameServerTCPPort})
 		if err != nil {
-			log.Printf("Failed to register %s TCP service with Consul: %v", tcpServiceNameRoom, err)
+			log.Printf("Failed to register %s TCP service: %v", tcpServiceNameRoom, err)
 		} else {
-			log.Printf("%s TCP service registered with Consul successfully on port %d with host %s", tcpServiceNameRoom, gameServerTCPPort, registrationHost)
+			log.Printf("%s TCP service registered successfully on port %d with host %s", tcpServiceNameRoom, gameServerTCPPort, registrationHost)
 		}
 	}
 
@@ -101,7 +106,7 @@ func main() {
 
 	// RPC Service Registration
 	var rpcServiceID string // Declare outside to be accessible in shutdown
-	if consulClient != nil {
+	if registry != nil {
 		registrationHost := cfg.Server.Host // Default
 		if cfg.Server.RegisterSelfAsHost {
 			registrationHost = serverName // Override with the server's own name
@@ -111,14 +116,14 @@ func main() {
 			log.Fatalf("Registration host is empty for %s RPC service after config evaluation", serverName)
 		}
 
-		rpcServiceID := serverName + "-rpc"
+		rpcServiceID = serverName + "-rpc"
 		rpcServiceName := serverName + "-rpc"
 		// rpcPort is already available and validated
-		err = consulClient.RegisterService(rpcServiceID, rpcServiceName, registrationHost, rpcPort)
+		err = registry.Register(discovery.Instance{ID: rpcServiceID, Service: rpcServiceName, Address: registrationHost, Port: rpcPort})
 		if err != nil {
-			log.Printf("Failed to register %s RPC service with Consul: %v", rpcServiceName, err)
+			log.Printf("Failed to register %s RPC service: %v", rpcServiceName, err)
 		} else {
-			log.Printf("%s RPC service registered with Consul successfully on port %d with host %s", rpcServiceName, rpcPort, registrationHost)
+			log.Printf("%s RPC service registered successfully on port %d with host %s", rpcServiceName, rpcPort, registrationHost)
 		}
 	}
 	tcpListenGameAddr := fmt.Sprintf("0.0.0.0:%d", gameServerTCPPort)
@@ -147,30 +152,30 @@ func main() {
 	if err != nil {
 		log.Printf("Failed to stop gateway server for %s: %v", serverName, err)
 	}
-	// Deregister from Consul
-	if consulClient != nil {
+	// Deregister from the discovery registry
+	if registry != nil {
 		if tcpServiceIDGame != "" {
-			log.Printf("Deregistering TCP game service %s from Consul...", tcpServiceIDGame)
-			if err := consulClient.DeregisterService(tcpServiceIDGame); err != nil {
-				log.Printf("Failed to deregister TCP game service %s from Consul: %v", tcpServiceIDGame, err)
+			log.Printf("Deregistering TCP game service %s...", tcpServiceIDGame)
+			if err := registry.Deregister(tcpServiceIDGame); err != nil {
+				log.Printf("Failed to deregister TCP game service %s: %v", tcpServiceIDGame, err)
 			} else {
-				log.Println("TCP game service deregistered from Consul successfully.")
+				log.Println("TCP game service deregistered successfully.")
 			}
 		}
 		if tcpServiceIDRoom != "" {
-			log.Printf("Deregistering TCP room  service %s from Consul...", tcpServiceIDRoom)
-			if err := consulClient.DeregisterService(tcpServiceIDRoom); err != nil {
-				log.Printf("Failed to deregister TCP room service %s from Consul: %v", tcpServiceIDRoom, err)
+			log.Printf("Deregistering TCP room  service %s...", tcpServiceIDRoom)
+			if err := registry.Deregister(tcpServiceIDRoom); err != nil {
+				log.Printf("Failed to deregister TCP room service %s: %v", tcpServiceIDRoom, err)
 			} else {
-				log.Println("TCP room service deregistered from Consul successfully.")
+				log.Println("TCP room service deregistered successfully.")
 			}
 		}
 		if rpcServiceID != "" {
-			log.Printf("Deregistering RPC service %s from Consul...", rpcServiceID)
-			if err := consulClient.DeregisterService(rpcServiceID); err != nil {
-				log.Printf("Failed to deregister RPC service %s from Consul: %v", rpcServiceID, err)
+			log.Printf("Deregistering RPC service %s...", rpcServiceID)
+			if err := registry.Deregister(rpcServiceID); err != nil {
+				log.Printf("Failed to deregister RPC service %s: %v", rpcServiceID, err)
 			} else {
-				log.Println("RPC service deregistered from Consul successfully.")
+				log.Println("RPC service deregistered successfully.")
 			}
 		}
 	}
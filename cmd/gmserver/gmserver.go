@@ -10,8 +10,8 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure" // Add this import
 
-	"github.com/phuhao00/pandaparty/config"               // Added for config loading
-	consulx "github.com/phuhao00/pandaparty/infra/consul" // Added for Consul client
+	"github.com/phuhao00/pandaparty/config" // Added for config loading
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/phuhao00/pandaparty/infra/pb/protocol/gm" // Corrected import path for GM protocol messages
 	internalgm "github.com/phuhao00/pandaparty/internal/gmserver"
 )
@@ -286,13 +286,13 @@ func main() {
 	}
 	log.Println("Configuration loaded successfully") // Ensure this log is present
 
-	// Initialize Consul Client
-	consulClient, err := consulx.NewConsulClient(cfg.Consul)
+	// Initialize the discovery registry (Consul unless configured otherwise)
+	registry, err := discovery.NewRegistry(cfg)
 	if err != nil {
-		log.Printf("Failed to initialize Consul client for %s: %v", serverName, err)
+		log.Printf("Failed to initialize discovery registry for %s: %v", serverName, err)
 		// Non-fatal for now, server continues
 	} else {
-		log.Printf("Consul client initialized successfully for %s", serverName)
+		log.Printf("Discovery registry initialized successfully for %s", serverName)
 		// Already loaded and checked below
 		// httpPort is defined below, ensure it's available for this block
 		httpPort := cfg.Server.GMServerHTTPPort
@@ -312,11 +312,11 @@ func main() {
 		serviceID := serverName + "-http"
 		serviceNameStr := serverName + "-http" // Renamed to avoid conflict with const
 
-		err = consulClient.RegisterService(serviceID, serviceNameStr, registrationHost, httpPort)
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: httpPort})
 		if err != nil {
-			log.Printf("Failed to register %s HTTP service with Consul: %v", serviceNameStr, err)
+			log.Printf("Failed to register %s HTTP service: %v", serviceNameStr, err)
 		} else {
-			log.Printf("%s HTTP service registered with Consul successfully on port %d with host %s", serviceNameStr, httpPort, registrationHost)
+			log.Printf("%s HTTP service registered successfully on port %d with host %s", serviceNameStr, httpPort, registrationHost)
 		}
 	}
 
@@ -329,10 +329,19 @@ func main() {
 		}
 	}
 
+	// With a registry, the connection follows the registered game server instances instead.
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if registry != nil {
+		resolver := discovery.NewResolver(registry)
+		defer resolver.Close()
+		gameServerAddr = discovery.GRPCScheme + ":///gameserver-rpc"
+		dialOpts = append(dialOpts, grpc.WithResolvers(resolver.GRPCBuilder()))
+	}
+
 	log.Printf("Connecting to game server at: %s", gameServerAddr)
 
 	// Fix: Add insecure credentials to the gRPC client connection
-	grpcClient, err := grpc.NewClient(gameServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	grpcClient, err := grpc.NewClient(gameServerAddr, dialOpts...)
 	if err != nil {
 		log.Fatalf("Failed to connect to game server: %v", err)
 	}
@@ -7,7 +7,7 @@ import (
 	"os"
 
 	"github.com/phuhao00/pandaparty/config"
-	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/phuhao00/pandaparty/infra/mongo"
 	redisx "github.com/phuhao00/pandaparty/infra/redis"   // Added for Redis client
 	"github.com/phuhao00/pandaparty/internal/loginserver" // Added import
@@ -59,13 +59,13 @@ func main() {
 		// Optionally, defer redisClient.Close()
 	}
 
-	// Initialize Consul Client
-	consulClient, err := consulx.NewConsulClient(cfg.Consul)
+	// Initialize the discovery registry (Consul unless configured otherwise)
+	registry, err := discovery.NewRegistry(cfg)
 	if err != nil {
-		log.Printf("Failed to initialize Consul client: %v", err)
+		log.Printf("Failed to initialize discovery registry: %v", err)
 	} else {
-		log.Println("Consul client initialized successfully")
-		// Register LoginServer service with Consul
+		log.Println("Discovery registry initialized successfully")
+		// Register LoginServer service with the registry
 		// Assuming cfg.Server.Host is the address where other services can reach this server.
 		// If loginserver runs on a different machine or needs a specific externally visible IP,
 		// that should be configured. For now, using cfg.Server.Host.
@@ -81,12 +81,12 @@ func main() {
 		}
 
 		serviceID := serverName + "-http"
-		serviceNameStr := serverName + "-http"                                                    // Using serviceNameStr to avoid conflict with const
-		err = consulClient.RegisterService(serviceID, serviceNameStr, registrationHost, httpPort) // Use registrationHost
+		serviceNameStr := serverName + "-http" // Using serviceNameStr to avoid conflict with const
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: httpPort})
 		if err != nil {
-			log.Printf("Failed to register %s with Consul: %v", serviceNameStr, err)
+			log.Printf("Failed to register %s: %v", serviceNameStr, err)
 		} else {
-			log.Printf("%s registered with Consul successfully on port %d with host %s", serviceNameStr, httpPort, registrationHost) // Log the host used
+			log.Printf("%s registered successfully on port %d with host %s", serviceNameStr, httpPort, registrationHost) // Log the host used
 		}
 	}
 
@@ -113,8 +113,8 @@ func main() {
 	log.Printf("%s running...", serverName) // This line might not be reached if ListenAndServe blocks indefinitely and successfully
 
 	// Final status log update (optional, for clarity)
-	if mongoClient != nil && redisClient != nil && consulClient != nil {
-		log.Printf("%s started successfully with DB, Redis, and Consul.", serverName)
+	if mongoClient != nil && redisClient != nil && registry != nil {
+		log.Printf("%s started successfully with DB, Redis, and service discovery.", serverName)
 	} else {
 		log.Printf("%s started with one or more core components missing.", serverName)
 	}
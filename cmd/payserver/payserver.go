@@ -11,7 +11,7 @@ import (
 
 	"fmt" // Added for RPC server address formatting
 	"github.com/phuhao00/pandaparty/config"
-	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/phuhao00/pandaparty/infra/mongo"
 	"github.com/phuhao00/pandaparty/infra/network" // Added for RPC Server
 	nsqx "github.com/phuhao00/pandaparty/infra/nsq"
@@ -61,13 +61,13 @@ func main() {
 	}
 	payServerRPCPort := 0
 	var serviceID string // Declare serviceID to be accessible in shutdown
-	// Initialize Consul Client
-	consulClient, err := consulx.NewConsulClient(cfg.Consul)
+	// Initialize the discovery registry (Consul unless configured otherwise)
+	registry, err := discovery.NewRegistry(cfg)
 	if err != nil {
-		log.Printf("Failed to initialize Consul client: %v", err)
+		log.Printf("Failed to initialize discovery registry: %v", err)
 	} else {
-		log.Println("Consul client initialized successfully")
-		// Register PayServer service with Consul, using specific RPC port
+		log.Println("Discovery registry initialized successfully")
+		// Register PayServer service with the registry, using specific RPC port
 		payServerRPCPort, ok := cfg.Server.ServiceRpcPorts[serverName]
 		if !ok || payServerRPCPort == 0 {
 			log.Fatalf("RPC port for %s not configured in server.yaml (server.servicerpcports.%s)", serverName, serverName)
@@ -88,16 +88,17 @@ func main() {
 		// serviceID is already declared above
 		serviceID = serverName + "-rpc"
 		serviceNameStr := serverName + "-rpc" // Using serviceNameStr to avoid conflict with const
-		err = consulClient.RegisterService(serviceID, serviceNameStr, registrationHost, payServerRPCPort)
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: payServerRPCPort})
 		if err != nil {
-			log.Printf("Failed to register %s with Consul: %v", serviceNameStr, err)
+			log.Printf("Failed to register %s: %v", serviceNameStr, err)
+			serviceID = ""
 		} else {
-			log.Printf("%s registered with Consul successfully on port %d with host %s", serviceNameStr, payServerRPCPort, registrationHost)
+			log.Printf("%s registered successfully on port %d with host %s", serviceNameStr, payServerRPCPort, registrationHost)
 		}
 	}
 
 	// Initialize RPC Server and Handlers for PayServer
-	rpcServer, err := network.NewRPCServer(consulClient) // Pass consulClient
+	rpcServer, err := network.NewRPCServer(nil, network.WithInterceptors(network.LoggingInterceptor(nil)))
 	if err != nil {
 		log.Fatalf("Failed to create RPC server for %s: %v", serverName, err)
 	}
@@ -123,8 +124,8 @@ func main() {
 	log.Println("Initializing pay-specific services...") // Placeholder for pay-specific logic
 
 	// Final status log
-	if mongoClient != nil && redisClient != nil && nsqProducer != nil && consulClient != nil && rpcServer != nil {
-		log.Printf("%s started successfully with all components (DB, Redis, NSQ, Consul, RPC)", serverName)
+	if mongoClient != nil && redisClient != nil && nsqProducer != nil && registry != nil && rpcServer != nil {
+		log.Printf("%s started successfully with all components (DB, Redis, NSQ, discovery, RPC)", serverName)
 	} else {
 		log.Printf("%s started with one or more components missing or failed to initialize.", serverName)
 	}
@@ -174,13 +175,13 @@ func main() {
 		log.Println("NSQ producer stopped.")
 	}
 
-	// Deregister from Consul
-	if consulClient != nil && serviceID != "" {
-		log.Printf("Deregistering service %s from Consul...", serviceID)
-		if err := consulClient.DeregisterService(serviceID); err != nil {
-			log.Printf("Failed to deregister service %s from Consul: %v", serviceID, err)
+	// Deregister from the discovery registry
+	if registry != nil && serviceID != "" {
+		log.Printf("Deregistering service %s...", serviceID)
+		if err := registry.Deregister(serviceID); err != nil {
+			log.Printf("Failed to deregister service %s: %v", serviceID, err)
 		} else {
-			log.Println("Service deregistered from Consul successfully.")
+			log.Println("Service deregistered successfully.")
 		}
 	}
 
@@ -12,6 +12,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/phuhao00/pandaparty/config"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/phuhao00/pandaparty/infra/mongo"
 	pbroom "github.com/phuhao00/pandaparty/infra/pb/protocol/room"
 	redisx "github.com/phuhao00/pandaparty/infra/redis"
@@ -73,9 +74,35 @@ func main() {
 	}()
 	defer grpcServer.Stop() // Explicitly closed during graceful shutdown
 
+	// Register RoomServer RPC service with the discovery registry (Consul unless configured otherwise)
+	var serviceID string // Declare serviceID to be accessible in shutdown
+	registry, err := discovery.NewRegistry(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize discovery registry: %v", err)
+	} else {
+		registrationHost := cfg.Server.Host // Default
+		if cfg.Server.RegisterSelfAsHost {
+			registrationHost = serverName // Override with the server's own name
+		}
+
+		if registrationHost == "" {
+			log.Fatalf("Registration host is empty for %s after config evaluation", serverName)
+		}
+
+		serviceID = serverName + "-rpc"
+		serviceNameStr := serverName + "-rpc" // Using serviceNameStr to avoid conflict with const
+		err = registry.Register(discovery.Instance{ID: serviceID, Service: serviceNameStr, Address: registrationHost, Port: rpcPort})
+		if err != nil {
+			log.Printf("Failed to register %s RPC service: %v", serviceNameStr, err)
+			serviceID = ""
+		} else {
+			log.Printf("%s RPC service registered successfully on port %d with host %s", serviceNameStr, rpcPort, registrationHost)
+		}
+	}
+
 	// Final status log
-	if mongoClient != nil && redisClient != nil {
-		log.Printf("%s started successfully with all components (DB, Redis)", serverName)
+	if mongoClient != nil && redisClient != nil && registry != nil {
+		log.Printf("%s started successfully with all components (DB, Redis, discovery)", serverName)
 	} else {
 		log.Printf("%s started with one or more components missing or failed to initialize.", serverName)
 	}
@@ -86,6 +113,15 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan // Block until a signal is received
 	log.Printf("Shutting down %s...", serverName)
+	// Deregister first, so no new calls are routed here while the server drains
+	if registry != nil && serviceID != "" {
+		log.Printf("Deregistering service %s...", serviceID)
+		if err := registry.Deregister(serviceID); err != nil {
+			log.Printf("Failed to deregister service %s: %v", serviceID, err)
+		} else {
+			log.Println("Service deregistered successfully.")
+		}
+	}
 	// Close gRPC Server
 	if grpcServer != nil {
 		log.Println("Stopping gRPC server...")
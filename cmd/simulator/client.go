@@ -6,14 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/looplab/fsm"
-	"github.com/phuhao00/dafuweng/config"
-	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"log"
 	"net"
 	"net/http"
 	"time"
 
-	consulapi "github.com/hashicorp/consul/api"
 	"github.com/phuhao00/dafuweng/infra/network"
 	modelpb "github.com/phuhao00/pandaparty/infra/pb/model"
 	pbgs "github.com/phuhao00/pandaparty/infra/pb/protocol/gameserver"
@@ -41,12 +39,11 @@ type LoginResponse struct {
 
 // SimulatedClient holds state and methods for a single simulated client.
 type SimulatedClient struct {
-	UserID           string
-	SessionToken     string
-	Username         string
-	Password         string
-	LoginServerAddr  string
-	ConsulServerAddr string
+	UserID          string
+	SessionToken    string
+	Username        string
+	Password        string
+	LoginServerAddr string
 	// RoomClient      roompb.RoomServiceClient // Removed
 	// grpcConn        *grpc.ClientConn           // Removed
 	logger             *log.Logger
@@ -58,33 +55,29 @@ type SimulatedClient struct {
 	directGameServiceConn   *grpc.ClientConn       // Added: Direct gRPC connection to gameserver
 	directGameServiceClient pbgs.GameServiceClient // Added: Client for direct gameserver communication
 	rpcClient               *network.RPCClient
-	consulClient            *consulapi.Client
-	gatewayServiceAddress   string           // Added to store discovered gateway address
-	RoomServiceName         string           // Added for direct room server calls
-	playerFSM               *fsm.FSM         // Added for FSM
-	behaviorManager         *BehaviorManager // Added for Behavior Trees
-	CurrentRoomID           string           // Added: ID of the room the client is currently in
+	resolver                *discovery.Resolver // Cache of service instances, shared by rpcClient and the gRPC connection
+	gatewayServiceAddress   string              // Added to store discovered gateway address
+	RoomServiceName         string              // Added for direct room server calls
+	playerFSM               *fsm.FSM            // Added for FSM
+	behaviorManager         *BehaviorManager    // Added for Behavior Trees
+	CurrentRoomID           string              // Added: ID of the room the client is currently in
 }
 
-// NewSimulatedClient creates and initializes a new SimulatedClient.
-func NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServiceName, gameServiceName, roomServiceName string, logger *log.Logger, bm *BehaviorManager) (*SimulatedClient, error) {
-	consulCfg := consulapi.DefaultConfig()
-	consulCfg.Address = consulAddr
-	//cClient, err := consulapi.NewClient(consulCfg)
-	consulClient, err := consulx.NewConsulClient(config.ConsulConfig{Addr: consulAddr})
-	if err != nil {
-		logger.Printf("Error creating Consul client: %v", err)
-		return nil, fmt.Errorf("failed to create Consul client at %s: %w", consulAddr, err)
+// NewSimulatedClient creates and initializes a new SimulatedClient that finds the servers in registry.
+func NewSimulatedClient(loginAddr string, registry discovery.Registry, username, password, gatewayServiceName, gameServiceName, roomServiceName string, logger *log.Logger, bm *BehaviorManager) (*SimulatedClient, error) {
+	if registry == nil {
+		return nil, fmt.Errorf("no discovery registry to find the servers in")
 	}
 
-	// Initialize RPCClient. Assuming NewRPCClient requires a non-nil consul client.
-	// And that it doesn't return an error itself, but might panic if consul client is nil.
-	// The check above for cClient error should prevent passing nil cClient.
-	rpcClient := network.NewRPCClient(consulClient, 0, 0) // Using 0,0 for default maxConns and timeout.
+	// One resolver serves every lookup of the client, so the registry is only watched, not queried per call.
+	resolver := discovery.NewResolver(registry)
+
+	// Initialize RPCClient. Service names are resolved by the resolver, so it needs no Consul client.
+	// Calls for a room go to the room server instance that holds it.
+	rpcClient := network.NewRPCClient(nil, 0, 0, network.WithResolver(resolver), network.WithServiceBalancer(roomServiceName, network.ConsistentHashBalancer())) // Using 0,0 for default maxConns and timeout.
 	if rpcClient == nil {
-		// This case implies NewRPCClient might return nil on other failures not just nil consulClient.
-		// Or if it's a simple constructor, this might not be reachable if cClient is guaranteed non-nil.
-		logger.Printf("Error: NewRPCClient returned nil even with a valid Consul client.")
+		logger.Printf("Error: NewRPCClient returned nil.")
+		resolver.Close()
 		return nil, fmt.Errorf("failed to initialize RPCClient")
 	}
 
@@ -92,15 +85,14 @@ func NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServic
 		Username:           username,
 		Password:           password,
 		LoginServerAddr:    loginAddr,
-		ConsulServerAddr:   consulAddr,
 		GatewayServiceName: gatewayServiceName,
 		GameServiceName:    gameServiceName,
 		RoomServiceName:    roomServiceName, // Ensure RoomServiceName is initialized
 		logger:             logger,
-		consulClient:       consulClient.GetReal(), // Store the initialized Consul client
-		rpcClient:          rpcClient,              // Store the initialized RPC client
-		behaviorManager:    bm,                     // Initialize BehaviorManager
-		CurrentRoomID:      "",                     // Initialize CurrentRoomID
+		rpcClient:          rpcClient, // Store the initialized RPC client
+		resolver:           resolver,  // Share the resolver with the RPC client
+		behaviorManager:    bm,        // Initialize BehaviorManager
+		CurrentRoomID:      "",        // Initialize CurrentRoomID
 	}
 
 	// Initialize FSM
@@ -109,25 +101,19 @@ func NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServic
 	sc.playerFSM = NewPlayerFSM("Idle", sc)
 
 	// Discover and connect to the GameService directly
-	if sc.GameServiceName != "" && sc.consulClient != nil {
-		serviceEntries, _, err := sc.consulClient.Health().Service(sc.GameServiceName, "", true, nil)
+	if sc.GameServiceName != "" {
+		instances, err := sc.resolver.Instances(context.Background(), sc.GameServiceName)
 		if err != nil {
-			logger.Printf("Error discovering GameService '%s' via Consul: %v", sc.GameServiceName, err)
+			logger.Printf("Error discovering GameService '%s': %v", sc.GameServiceName, err)
 			// Depending on requirements, this could be a fatal error for client setup
-			return nil, fmt.Errorf("failed to query consul for GameService '%s': %w", sc.GameServiceName, err)
-		} else if len(serviceEntries) == 0 {
-			logger.Printf("No healthy instances found for GameService '%s' via Consul.", sc.GameServiceName)
+			return nil, fmt.Errorf("failed to discover GameService '%s': %w", sc.GameServiceName, err)
+		} else if len(instances) == 0 {
+			logger.Printf("No healthy instances found for GameService '%s'.", sc.GameServiceName)
 			return nil, fmt.Errorf("no healthy instances found for GameService '%s'", sc.GameServiceName)
 		} else {
-			// Use the first healthy instance
-			instance := serviceEntries[0]
-			address := instance.Service.Address
-			if address == "" {
-				address = instance.Node.Address
-			}
-			port := instance.Service.Port
-			gameServiceAddress := fmt.Sprintf("%s:%d", address, port)
-			logger.Printf("GameService '%s' discovered at %s. Attempting direct gRPC connection.", sc.GameServiceName, gameServiceAddress)
+			// The connection follows the instances of the service as they change.
+			gameServiceAddress := discovery.GRPCScheme + ":///" + sc.GameServiceName
+			logger.Printf("GameService '%s' discovered with %d instances. Attempting direct gRPC connection.", sc.GameServiceName, len(instances))
 
 			// Establish direct gRPC connection
 			// Consider context for dial, e.g., with timeout for setup
@@ -135,6 +121,7 @@ func NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServic
 			defer dialCancel()
 
 			conn, err := grpc.DialContext(dialCtx, gameServiceAddress,
+				grpc.WithResolvers(sc.resolver.GRPCBuilder()),
 				grpc.WithTransportCredentials(insecure.NewCredentials()),
 				grpc.WithBlock(),
 			)
@@ -148,12 +135,7 @@ func NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServic
 			}
 		}
 	} else {
-		if sc.GameServiceName == "" {
-			logger.Println("GameServiceName is empty, skipping direct GameService connection setup.")
-		}
-		if sc.consulClient == nil {
-			logger.Println("ConsulClient is nil, skipping direct GameService connection setup.")
-		}
+		logger.Println("GameServiceName is empty, skipping direct GameService connection setup.")
 	}
 
 	return sc, nil
@@ -234,31 +216,20 @@ func (sc *SimulatedClient) DiscoverAndConnectRoomService() error {
 }
 */
 
-// DiscoverGatewayService discovers the Gateway service using Consul.
-// This method now uses the sc.consulClient field.
+// DiscoverGatewayService discovers the Gateway service through the client's resolver.
 func (sc *SimulatedClient) DiscoverGatewayService() (string, error) {
-	if sc.consulClient == nil {
-		return "", fmt.Errorf("Consul client not initialized, cannot discover gateway service")
-	}
-	sc.logger.Printf("Attempting to discover service '%s' via Consul at '%s'", sc.GatewayServiceName, sc.ConsulServerAddr)
+	sc.logger.Printf("Attempting to discover service '%s'", sc.GatewayServiceName)
 
-	serviceEntries, _, err := sc.consulClient.Health().Service(sc.GatewayServiceName, "", true, nil)
+	instances, err := sc.resolver.Instances(context.Background(), sc.GatewayServiceName)
 	if err != nil {
-		return "", fmt.Errorf("failed to query consul for service '%s': %w", sc.GatewayServiceName, err)
+		return "", fmt.Errorf("failed to discover service '%s': %w", sc.GatewayServiceName, err)
 	}
-	if len(serviceEntries) == 0 {
+	if len(instances) == 0 {
 		return "", fmt.Errorf("no healthy instances found for service '%s'", sc.GatewayServiceName)
 	}
 
-	// Use the first healthy instance
-	instance := serviceEntries[0]
-	address := instance.Service.Address
-	// If Service.Address is empty, use Node.Address. This is common in some Consul setups.
-	if address == "" {
-		address = instance.Node.Address
-	}
-	port := instance.Service.Port
-	serviceAddress := fmt.Sprintf("%s:%d", address, port)
+	// Use the first healthy instance; the resolver already falls back to the node address.
+	serviceAddress := instances[0].Addr()
 
 	sc.logger.Printf("Service '%s' discovered at %s", sc.GatewayServiceName, serviceAddress)
 	return serviceAddress, nil
@@ -365,7 +336,7 @@ func (sc *SimulatedClient) CreateRoom(ctx context.Context, roomName string, room
 	resp := &pbroom.CreateRoomResponse{}
 
 	sc.logger.Printf("Attempting to CreateRoom '%s' via RPC to gateway %s", roomName, sc.gatewayServiceAddress)
-	err := sc.rpcClient.Call(sc.GameServiceName, "CreateRoom", req, resp)
+	err := sc.rpcClient.Call(ctx, sc.GameServiceName, "CreateRoom", req, resp)
 	if err != nil {
 		sc.logger.Printf("CreateRoom RPC call to gateway failed: %v", err)
 		return nil, fmt.Errorf("rpcClient.Call to CreateRoom (via gateway) failed: %w", err)
@@ -397,7 +368,7 @@ func (sc *SimulatedClient) JoinRoom(ctx context.Context, roomID string) (*pbroom
 	resp := &pbroom.JoinRoomResponse{}
 
 	sc.logger.Printf("Attempting to JoinRoom %s via RPC to gateway %s", roomID, sc.gatewayServiceAddress)
-	err := sc.rpcClient.Call(sc.GatewayServiceName, "JoinRoom", req, resp)
+	err := sc.rpcClient.Call(ctx, sc.GatewayServiceName, "JoinRoom", req, resp)
 	if err != nil {
 		// JoinRoom can have legitimate failures (e.g., room full, already joined)
 		sc.logger.Printf("JoinRoom RPC call to gateway returned: %v (this may be expected)", err)
@@ -425,7 +396,7 @@ func (sc *SimulatedClient) PlayerReady(ctx context.Context, roomID string, isRea
 	resp := &pbroom.PlayerReadyResponse{}
 
 	sc.logger.Printf("Attempting to set PlayerReady (isReady: %v) for room %s via RPC to room server %s", isReady, roomID, sc.RoomServiceName)
-	err := sc.rpcClient.Call(sc.RoomServiceName, "PlayerReady", req, resp) // Using sc.RoomServiceName
+	err := sc.rpcClient.Call(network.WithRoutingKey(ctx, roomID), sc.RoomServiceName, "PlayerReady", req, resp) // Using sc.RoomServiceName
 	if err != nil {
 		sc.logger.Printf("PlayerReady RPC call failed: %v", err)
 		return nil, fmt.Errorf("rpcClient.Call to PlayerReady failed: %w", err)
@@ -449,6 +420,9 @@ func (sc *SimulatedClient) Close() {
 		// If it does return an error, it should be logged.
 		sc.rpcClient.CloseAllConnections()
 	}
+	if sc.resolver != nil {
+		sc.resolver.Close()
+	}
 	if sc.gatewayConn != nil {
 		sc.logger.Println("Closing TCP connection to GatewayService.")
 		if err := sc.gatewayConn.Close(); err != nil {
@@ -480,7 +454,7 @@ func (sc *SimulatedClient) GetRoomList(ctx context.Context, req *pbroom.GetRoomL
 
 	resp := &pbroom.GetRoomListResponse{}
 	sc.logger.Printf("Attempting to GetRoomList via RPC to room server %s with request: %v", sc.RoomServiceName, req)
-	err := sc.rpcClient.Call(sc.RoomServiceName, "GetRoomList", req, resp)
+	err := sc.rpcClient.Call(ctx, sc.RoomServiceName, "GetRoomList", req, resp)
 	if err != nil {
 		sc.logger.Printf("GetRoomList RPC call failed: %v", err)
 		return nil, fmt.Errorf("rpcClient.Call to GetRoomList failed: %w", err)
@@ -510,7 +484,7 @@ func (sc *SimulatedClient) LeaveRoom(ctx context.Context, roomID string) (*pbroo
 	resp := &pbroom.LeaveRoomResponse{}
 
 	sc.logger.Printf("Attempting to LeaveRoom %s via RPC to gateway %s", roomID, sc.gatewayServiceAddress)
-	err := sc.rpcClient.Call(sc.GatewayServiceName, "LeaveRoom", req, resp)
+	err := sc.rpcClient.Call(ctx, sc.GatewayServiceName, "LeaveRoom", req, resp)
 	if err != nil {
 		sc.logger.Printf("LeaveRoom RPC call to gateway failed: %v", err)
 		return nil, fmt.Errorf("rpcClient.Call to LeaveRoom (via gateway) failed: %w", err)
@@ -536,7 +510,7 @@ func (sc *SimulatedClient) StartGame(ctx context.Context, roomID string) (*pbroo
 	resp := &pbroom.StartGameResponse{}
 
 	sc.logger.Printf("Attempting to StartGame for room %s via RPC to room server %s", roomID, sc.RoomServiceName)
-	err := sc.rpcClient.Call(sc.RoomServiceName, "StartGame", req, resp)
+	err := sc.rpcClient.Call(network.WithRoutingKey(ctx, roomID), sc.RoomServiceName, "StartGame", req, resp)
 	if err != nil {
 		sc.logger.Printf("StartGame RPC call failed: %v", err)
 		return nil, fmt.Errorf("rpcClient.Call to StartGame failed: %w", err)
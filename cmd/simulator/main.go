@@ -6,6 +6,8 @@ import (
 	"fmt"
 	b3 "github.com/magicsea/behavior3go"
 	"github.com/magicsea/behavior3go/core"
+	"github.com/phuhao00/pandaparty/config"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"log"
 	"os"
 	"sync"
@@ -22,6 +24,8 @@ var (
 	baseUsername       *string
 	loginServerAddr    *string
 	consulAddr         *string
+	discoveryBackend   *string
+	discoveryDir       *string
 	userPassword       *string
 	gatewayServiceName *string
 	gameServiceName    *string
@@ -37,18 +41,28 @@ func init() {
 	numClients = flag.Int("numClients", 1, "Number of concurrent clients to simulate.")
 	baseUsername = flag.String("baseUsername", "simUser", "Base username for simulated clients. A numeric suffix will be added in stress mode (e.g., simUser_0, simUser_1).")
 	loginServerAddr = flag.String("loginServer", "http://localhost:8081", "Login server address (e.g., http://localhost:8081).")
-	consulAddr = flag.String("consulServer", "localhost:8500", "Consul server address (e.g., localhost:8500).")
+	consulAddr = flag.String("consulServer", "localhost:8500", "Consul server address (e.g., localhost:8500), used by the consul discovery backend.")
+	discoveryBackend = flag.String("discoveryBackend", "consul", "Where the servers are discovered: consul or file.")
+	discoveryDir = flag.String("discoveryDir", "", "Directory of the file discovery backend, shared with the servers.")
 	userPassword = flag.String("password", "simPass", "Common password for all simulated users.")
-	gatewayServiceName = flag.String("gatewayServiceName", "gatewayserver-tcp", "The name of the gateway TCP service registered in Consul.")
-	gameServiceName = flag.String("gameServiceName", "gameserver-rpc", "The name of the game server gRPC service registered in Consul.")
-	roomServiceName = flag.String("roomServiceName", "roomserver-rpc", "The name of the room server RPC service registered in Consul.")
+	gatewayServiceName = flag.String("gatewayServiceName", "gatewayserver-tcp", "The name of the gateway TCP service registered in discovery.")
+	gameServiceName = flag.String("gameServiceName", "gameserver-rpc", "The name of the game server gRPC service registered in discovery.")
+	roomServiceName = flag.String("roomServiceName", "roomserver-rpc", "The name of the room server RPC service registered in discovery.")
 	defaultTargetTile = flag.String("defaultTargetTile", "tile_default_target", "Default target tile ID for the Move action.")
 	defaultCardID = flag.String("defaultCardID", "card_default_001", "Default card ID for the PlayCard action.")
 	actionDelayMs = flag.Int("actionDelayMs", 0, "Milliseconds to wait between actions in a scenario.")
 }
 
+// newRegistry returns the discovery registry the servers are found in, selected by the command-line flags.
+func newRegistry() (discovery.Registry, error) {
+	return discovery.NewRegistry(&config.ServerConfig{
+		Consul:    config.ConsulConfig{Addr: *consulAddr},
+		Discovery: config.DiscoveryConfig{Backend: *discoveryBackend, Dir: *discoveryDir},
+	})
+}
+
 // runSingleClientScenario executes a sequence of actions for a single simulated client.
-func runSingleClientScenario(scenarioCtx context.Context, clientID int, loginAddr, consulAddr, username, password, gatewayServiceName, gameServiceName, roomServiceNameValue string, bm *BehaviorManager) {
+func runSingleClientScenario(scenarioCtx context.Context, clientID int, loginAddr string, registry discovery.Registry, username, password, gatewayServiceName, gameServiceName, roomServiceNameValue string, bm *BehaviorManager) {
 	logger := log.New(os.Stdout, fmt.Sprintf("[Client %s (ID:%d)] ", username, clientID), log.LstdFlags|log.Lmicroseconds)
 
 	// Helper function for delays (can be used by BT actions if needed, or for loop delay)
@@ -59,7 +73,7 @@ func runSingleClientScenario(scenarioCtx context.Context, clientID int, loginAdd
 	// 	}
 	// }
 
-	client, err := NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServiceName, gameServiceName, roomServiceNameValue, logger, bm)
+	client, err := NewSimulatedClient(loginAddr, registry, username, password, gatewayServiceName, gameServiceName, roomServiceNameValue, logger, bm)
 	if err != nil {
 		logger.Printf("Failed to create simulated client: %v", err)
 		atomic.AddInt64(&failedScenarios, 1)
@@ -329,9 +343,14 @@ func main() {
 	}
 	log.Printf("BehaviorManager initialized successfully with %d tree configurations.", len(bm.GetAllTreeConfigs()))
 
+	registry, err := newRegistry()
+	if err != nil {
+		log.Fatalf("FATAL: Failed to initialize discovery registry: %v", err)
+	}
+
 	mainLogger := log.New(os.Stdout, "[SimulatorCLI] ", log.LstdFlags|log.Lmicroseconds)
 	mainLogger.Printf("Starting simulation with %d client(s)...", *numClients)
-	mainLogger.Printf("Login Server: %s, Consul Server: %s, Base Username: %s", *loginServerAddr, *consulAddr, *baseUsername)
+	mainLogger.Printf("Login Server: %s, Discovery: %s, Base Username: %s", *loginServerAddr, *discoveryBackend, *baseUsername)
 	mainLogger.Printf("Gateway Service: %s, Game Service: %s, Room Service: %s", *gatewayServiceName, *gameServiceName, *roomServiceName) // Added roomServiceName to log
 
 	if *numClients <= 0 {
@@ -348,7 +367,7 @@ func main() {
 		// The context for the scenario is created here.
 		ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
 		defer cancel()
-		runSingleClientScenario(ctx, 0, *loginServerAddr, *consulAddr, *baseUsername, *userPassword, *gatewayServiceName, *gameServiceName, *roomServiceName, bm)
+		runSingleClientScenario(ctx, 0, *loginServerAddr, registry, *baseUsername, *userPassword, *gatewayServiceName, *gameServiceName, *roomServiceName, bm)
 	} else {
 		var wg sync.WaitGroup
 		for i := 0; i < *numClients; i++ {
@@ -358,7 +377,7 @@ func main() {
 				defer wg.Done()
 				ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
 				defer cancel()
-				runSingleClientScenario(ctx, id, *loginServerAddr, *consulAddr, user, *userPassword, *gatewayServiceName, *gameServiceName, *roomServiceName, bm)
+				runSingleClientScenario(ctx, id, *loginServerAddr, registry, user, *userPassword, *gatewayServiceName, *gameServiceName, *roomServiceName, bm)
 			}(i, username)
 		}
 		wg.Wait()
@@ -25,6 +25,7 @@ func ensureFlagsParsed() {
 		_ = flag.Set("baseUsername", "testSimUserInteg") // Use a distinct username for tests
 		_ = flag.Set("loginServer", "http://localhost:8081")
 		_ = flag.Set("consulServer", "localhost:8500")
+		_ = flag.Set("discoveryBackend", "consul")
 		_ = flag.Set("password", "testSimPassInteg")
 		_ = flag.Set("gatewayServiceName", "gatewayserver-tcp")
 		_ = flag.Set("gameServiceName", "gameserver-rpc")
@@ -79,12 +80,16 @@ func TestBasicLoginScenario(t *testing.T) {
 	// 3. Create SimulatedClient
 	// Use flag values for addresses, etc.
 	// Note: The NewSimulatedClient signature in the prompt was:
-	// client, err := NewSimulatedClient(loginAddr, consulAddr, username, password, gatewayServiceName, gameServiceName, roomServiceName, bm, logger)
+	// client, err := NewSimulatedClient(loginAddr, registry, username, password, gatewayServiceName, gameServiceName, roomServiceName, bm, logger)
 	// I need to ensure this matches the actual current signature.
 	// Based on previous steps, it is (..., logger, bm)
+	registry, err := newRegistry()
+	if err != nil {
+		t.Fatalf("Failed to create discovery registry: %v", err)
+	}
 	client, err := NewSimulatedClient(
 		*loginServerAddr,
-		*consulAddr,
+		registry,
 		*baseUsername, // This is a pointer, needs dereferencing
 		*userPassword,
 		*gatewayServiceName,
@@ -31,6 +31,13 @@ type ConsulConfig struct {
 	Addr string `yaml:"addr"`
 }
 
+// DiscoveryConfig selects where services register and find each other.
+type DiscoveryConfig struct {
+	Backend string              `yaml:"backend,omitempty"` // "consul" (default), "static", "file" or "memory"
+	Dir     string              `yaml:"dir,omitempty"`     // Directory of the "file" backend, shared by all servers on the machine
+	Static  map[string][]string `yaml:"static,omitempty"`  // Instances of the "static" backend: service name -> list of "host:port"
+}
+
 type NSQConfig struct {
 	NSQDAddr                string   `yaml:"nsqd_addr,omitempty"`                 // Kept for single-node setup or fallback
 	NSQDAddresses           []string `yaml:"nsqd_addresses,omitempty"`            // For producer to connect to a list of nsqd instances
@@ -40,12 +47,13 @@ type NSQConfig struct {
 }
 
 type ServerConfig struct {
-	Redis  RedisConfig  `yaml:"redis"`
-	Mongo  MongoConfig  `yaml:"mongo"`
-	Consul ConsulConfig `yaml:"consul"`
-	NSQ    NSQConfig    `yaml:"nsq"`
-	Server ServerInfo   `yaml:"server"` // Added ServerInfo for host, port, rpcport
-	Friend FriendConfig `yaml:"friend"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Mongo     MongoConfig     `yaml:"mongo"`
+	Consul    ConsulConfig    `yaml:"consul"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	NSQ       NSQConfig       `yaml:"nsq"`
+	Server    ServerInfo      `yaml:"server"` // Added ServerInfo for host, port, rpcport
+	Friend    FriendConfig    `yaml:"friend"`
 }
 
 // ServerInfo holds basic server address information
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"google.golang.org/protobuf/proto"
 	"log"
+	"runtime/debug"
 	"sync"
+	"time"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
 )
 
 type ActorID int64
@@ -25,13 +29,50 @@ type IActor interface {
 
 // --- Actor Implementation ---
 
-// actorMessage is an internal wrapper for messages in the mailbox.
-type actorMessage struct {
+// Envelope wraps a message in an actor's mailbox together with the sender's context and,
+// for Ask, the channels the reply goes to.
+type Envelope struct {
 	ctx     context.Context // Context passed with Tell/Ask
 	message proto.Message
 	// For Ask pattern
 	replyCh chan<- interface{} // channel to send the response back
 	errorCh chan<- error       // channel to send an error back
+	// For internal control messages (restart, escalation); message is nil when set.
+	system interface{}
+	// Set for messages delivered by a timer.
+	timer *timer
+	// Set while the message is in the stash; its Ask is answered after it is unstashed.
+	stashed bool
+}
+
+// Message returns the user message, or nil for a system message.
+func (e *Envelope) Message() proto.Message {
+	return e.message
+}
+
+// IsSystem reports whether the envelope carries an internal control message such as a
+// supervisor's restart request. Mailboxes must never block or drop these.
+func (e *Envelope) IsSystem() bool {
+	return e.system != nil
+}
+
+// done returns the Done channel of the sender's context, or nil if it has none.
+func (e *Envelope) done() <-chan struct{} {
+	if e.ctx == nil {
+		return nil
+	}
+	return e.ctx.Done()
+}
+
+// fail sends err to an Ask sender without blocking; it does nothing for Tell.
+func (e *Envelope) fail(err error) {
+	if e.errorCh == nil {
+		return
+	}
+	select {
+	case e.errorCh <- err:
+	default: // Ask already gave up
+	}
 }
 
 // ActorProcessor defines the interface for message processing logic within an Actor.
@@ -43,50 +84,220 @@ type ActorProcessor interface {
 	ProcessMessage(actorCtx IActorContext, msg proto.Message) (response proto.Message, err error)
 }
 
+// PreStarter can be implemented by an ActorProcessor to run code before the first message,
+// both when the actor starts and after each restart.
+type PreStarter interface {
+	PreStart(actorCtx IActorContext)
+}
+
+// PostStopper can be implemented by an ActorProcessor to run code after the actor has stopped
+// processing messages and its children have stopped, but before its watchers are notified.
+type PostStopper interface {
+	PostStop(actorCtx IActorContext)
+}
+
+// PreRestarter can be implemented by an ActorProcessor to clean up before a restart.
+// It is called on the failed processor with the failure reason, before the children are stopped.
+type PreRestarter interface {
+	PreRestart(actorCtx IActorContext, reason interface{})
+}
+
 // IActorContext provides methods for the ActorProcessor to interact with its environment.
 type IActorContext interface {
-	Self() IActor // Gets a reference to the actor itself.
-	// TODO: Add other methods like SpawnChild, Watch, etc. if needed.
+	Self() IActor       // Gets a reference to the actor itself.
+	Parent() IActor     // Gets the parent actor, or nil for a root actor.
+	Children() []IActor // Lists the live children of the actor.
+	// Context returns the context the current message was sent with. For Ask it is cancelled
+	// when the caller gives up, so long-running handlers can check it and stop early.
+	// Outside ProcessMessage it returns context.Background().
+	Context() context.Context
+	// SpawnChild creates and starts a child actor supervised by this actor.
+	// Children are stopped before their parent stops or restarts.
+	SpawnChild(name string, processor ActorProcessor, opts ...Option) (IActor, error)
+	// Watch registers interest in the termination of target. When target stops or crashes,
+	// a *pbactor.Terminated message is delivered to this actor's ProcessMessage.
+	// Watching an actor that has already stopped delivers Terminated immediately.
+	Watch(target IActor) error
+	// Unwatch removes a registration made by Watch.
+	Unwatch(target IActor) error
+	// System returns the ActorSystem that owns the actor, or nil if it was created with NewActor.
+	System() *ActorSystem
+	// Become makes behavior handle the following messages instead of the current behavior.
+	// The processor's ProcessMessage is the initial behavior, and is restored on restart.
+	Become(behavior Behavior)
+	// BecomeStacked is like Become but keeps the current behavior, so Unbecome returns to it.
+	BecomeStacked(behavior Behavior)
+	// Unbecome returns to the behavior that was active before the last Become or BecomeStacked.
+	Unbecome()
+	// Stash defers the current message; UnstashAll delivers the stashed messages again, in
+	// order, before anything else in the mailbox. An Ask is answered once it is processed
+	// after being unstashed. Stashed messages are unstashed on restart and go to the dead
+	// letters when the actor stops.
+	Stash() error
+	UnstashAll()
+	// ScheduleOnce delivers message to this actor's mailbox after delay.
+	ScheduleOnce(delay time.Duration, message proto.Message) Cancellable
+	// ScheduleRepeatedly delivers message to this actor's mailbox after initialDelay and then
+	// every interval. A tick is skipped while the previous one is still in the mailbox.
+	ScheduleRepeatedly(initialDelay, interval time.Duration, message proto.Message) Cancellable
+	// SetReceiveTimeout delivers a *pbactor.ReceiveTimeout when the actor has received no message
+	// for timeout, and again after every further timeout of inactivity. Messages from
+	// ScheduleOnce and ScheduleRepeatedly do not count as activity. timeout <= 0 disables it.
+	// Timers and the receive timeout are cancelled when the actor stops or restarts.
+	SetReceiveTimeout(timeout time.Duration)
 }
 
 // Actor is the concrete implementation of the IActor interface.
 type Actor struct {
 	id        ActorID
 	name      string
+	path      string // Slash-separated names from the root actor down to this one
 	processor ActorProcessor
-	mailbox   chan *actorMessage
+	producer  func() ActorProcessor // Optional; builds a fresh processor on restart
+	mailbox   Mailbox
 	stopCh    chan struct{}  // Channel to signal the actor to stop
+	stopOnce  sync.Once      // Guards closing stopCh
 	wg        sync.WaitGroup // To wait for the processing goroutine to finish
 	self      IActor         // Stores its own IActor interface reference
+	actorCtx  *actorContextImpl
+
+	callingThread bool       // Set by WithCallingThreadDispatcher
+	dispatchMu    sync.Mutex // Held by the goroutine processing messages inline
+	started       bool       // Set once an inline actor has run PreStart; protected by dispatchMu
+	finished      bool       // Set once an inline actor has finished; protected by dispatchMu
+
+	system       *ActorSystem       // Owning system; nil for actors created with NewActor
+	parent       *Actor             // Supervisor of this actor; nil for root actors
+	strategy     SupervisorStrategy // Strategy this actor applies to its own children
+	children     map[ActorID]*Actor // Live children, keyed by ID
+	childrenMu   sync.RWMutex       // Protects children
+	restartStats restartStatistics  // Recent failures, used to enforce restart limits
+	stopReason   interface{}        // Failure that stopped the actor, reported in Terminated
+
+	watchers   map[ActorID]*Actor // Actors to notify when this actor terminates
+	watching   map[ActorID]*Actor // Actors this actor watches
+	terminated bool               // Set once watchers have been notified
+	watchMu    sync.Mutex         // Protects watchers, watching and terminated
+
+	timers         timers        // Live timers, cancelled on stop and restart
+	receiveTimeout time.Duration // Set by SetReceiveTimeout; 0 when disabled
+	receiveTimer   *timer        // Pending ReceiveTimeout delivery
+
+	behaviors []Behavior  // Stack set by Become; the top one handles messages
+	stashed   []*Envelope // Messages deferred by Stash
+	unstashed []*Envelope // Messages released by UnstashAll, processed before the mailbox
+	releasing *Envelope   // Message stashed and unstashed by its own handler, still being processed
+
+	createdAt time.Time    // Reported as the start of idleness until the first message
+	metrics   actorMetrics // Counters reported by Stats
 }
 
 const defaultMailboxSize = 128
 
-// NewActor creates and starts a new actor.
-func NewActor(id ActorID, name string, processor ActorProcessor, mailboxSize ...int) *Actor {
+// actorOptions collects the settings applied by Option values.
+type actorOptions struct {
+	mailboxSize int
+	mailbox     MailboxProducer
+	strategy    SupervisorStrategy
+	producer    func() ActorProcessor
+	// callingThread selects the calling-thread dispatcher.
+	callingThread bool
+}
+
+// Option configures an actor created by NewActor or IActorContext.SpawnChild.
+type Option func(*actorOptions)
+
+// WithMailboxSize sets the capacity of the default DropNewestMailbox. Values <= 0 keep the
+// default of 128. It has no effect together with WithMailbox.
+func WithMailboxSize(size int) Option {
+	return func(o *actorOptions) {
+		if size > 0 {
+			o.mailboxSize = size
+		}
+	}
+}
+
+// WithMailbox selects the mailbox policy, e.g. WithMailbox(BlockingMailbox(256, time.Second)).
+// Without it the actor uses a DropNewestMailbox.
+func WithMailbox(producer MailboxProducer) Option {
+	return func(o *actorOptions) {
+		o.mailbox = producer
+	}
+}
+
+// WithSupervisorStrategy sets the strategy the actor applies to its children.
+// Actors without one use DefaultSupervisorStrategy.
+func WithSupervisorStrategy(strategy SupervisorStrategy) Option {
+	return func(o *actorOptions) {
+		o.strategy = strategy
+	}
+}
+
+// WithProducer makes the actor build a new processor with producer each time it restarts,
+// discarding the state of the failed one. Without it a restarted actor keeps its processor.
+func WithProducer(producer func() ActorProcessor) Option {
+	return func(o *actorOptions) {
+		o.producer = producer
+	}
+}
+
+// NewActor creates and starts a new root actor that is not owned by an ActorSystem.
+// Use ActorSystem.Spawn to get ID allocation, lookup and ordered shutdown.
+func NewActor(id ActorID, name string, processor ActorProcessor, opts ...Option) *Actor {
+	actor := newActor(id, name, processor, nil, nil, opts...)
+	actor.launch()
+	return actor
+}
+
+func newActor(id ActorID, name string, processor ActorProcessor, parent *Actor, system *ActorSystem, opts ...Option) *Actor {
 	if processor == nil {
 		log.Panic("ActorProcessor cannot be nil")
 	}
-	size := defaultMailboxSize
-	if len(mailboxSize) > 0 && mailboxSize[0] > 0 {
-		size = mailboxSize[0]
+	options := actorOptions{mailboxSize: defaultMailboxSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.strategy == nil {
+		options.strategy = DefaultSupervisorStrategy()
+	}
+	if options.mailbox == nil {
+		options.mailbox = DropNewestMailbox(options.mailboxSize)
 	}
 
 	actor := &Actor{
 		id:        id,
 		name:      name,
+		path:      actorPath(parent, name),
 		processor: processor,
-		mailbox:   make(chan *actorMessage, size),
+		producer:  options.producer,
+		mailbox:   options.mailbox(),
 		stopCh:    make(chan struct{}),
+		system:    system,
+		parent:    parent,
+		strategy:  options.strategy,
+		children:  make(map[ActorID]*Actor),
+		watchers:  make(map[ActorID]*Actor),
+		watching:  make(map[ActorID]*Actor),
 	}
 	actor.self = actor // Self-reference for IActorContext
-
-	actor.wg.Add(1)
-	go actor.run()
-
+	actor.createdAt = time.Now()
+	actor.actorCtx = &actorContextImpl{actor: actor}
+	actor.callingThread = options.callingThread
 	return actor
 }
 
+// launch starts processing messages, on a goroutine of its own or, for the calling-thread
+// dispatcher, on the calling goroutine. Callers launch an actor once it is registered, and
+// without holding locks that its PreStart might need.
+func (a *Actor) launch() {
+	a.wg.Add(1)
+	if a.callingThread {
+		a.startInline()
+	} else {
+		go a.run()
+	}
+}
+
 // Id returns the actor's unique identifier.
 func (a *Actor) Id() ActorID {
 	return a.id
@@ -97,39 +308,38 @@ func (a *Actor) Name() string {
 	return a.name
 }
 
+// Path returns the actor's position in the hierarchy, e.g. "/room-100/seat-2".
+func (a *Actor) Path() string {
+	return a.path
+}
+
+func actorPath(parent *Actor, name string) string {
+	if parent == nil {
+		return "/" + name
+	}
+	return parent.path + "/" + name
+}
+
 // Tell sends an asynchronous message to the actor.
 // The message is added to the actor's mailbox and processed sequentially.
 // Returns an error if the message cannot be sent (e.g., mailbox full or actor stopped).
+// Whether Tell blocks on a full mailbox depends on the mailbox policy.
 func (a *Actor) Tell(ctx context.Context, message proto.Message) error {
 	if message == nil {
 		return errors.New("cannot Tell a nil message")
 	}
-	msg := &actorMessage{
+	msg := &Envelope{
 		ctx:     ctx,
 		message: message,
 	}
 
-	// Non-blocking send to mailbox, or error if stopped/full
-	select {
-	case a.mailbox <- msg:
-		return nil
-	case <-a.stopCh:
-		return errors.New("actor stopped, cannot Tell message")
-	default:
-		// Try one more time with a select that can also catch stopCh
-		select {
-		case a.mailbox <- msg:
-			return nil
-		case <-a.stopCh:
+	if err := a.post(msg); err != nil {
+		if errors.Is(err, errMailboxStopped) {
 			return errors.New("actor stopped, cannot Tell message")
-		default:
-			// This indicates mailbox is full.
-			// Depending on desired behavior, could block, return error, or drop.
-			// For now, return error for full mailbox.
-			log.Printf("Actor %s (%d) mailbox full. Message dropped: %T", a.name, a.id, message)
-			return errors.New("actor mailbox is full")
 		}
+		return err
 	}
+	return nil
 }
 
 // Ask sends a message to the actor and waits for a response.
@@ -145,7 +355,7 @@ func (a *Actor) Ask(ctx context.Context, message proto.Message) (interface{}, er
 	replyCh := make(chan interface{}, 1)
 	errorCh := make(chan error, 1)
 
-	msg := &actorMessage{
+	msg := &Envelope{
 		ctx:     ctx, // Context for the message processing itself
 		message: message,
 		replyCh: replyCh,
@@ -153,26 +363,30 @@ func (a *Actor) Ask(ctx context.Context, message proto.Message) (interface{}, er
 	}
 
 	// Send to mailbox, checking if actor is stopped.
-	select {
-	case a.mailbox <- msg:
-		// Message sent, now wait for reply or context done.
-	case <-a.stopCh:
-		return nil, errors.New("actor stopped, cannot Ask message")
-	case <-ctx.Done(): // Check caller's context before even sending if mailbox is full
-		return nil, ctx.Err()
+	if err := a.post(msg); err != nil {
+		if errors.Is(err, errMailboxStopped) {
+			return nil, errors.New("actor stopped, cannot Ask message")
+		}
+		return nil, err
 	}
 
 	// Wait for the response, error, or context cancellation from the caller.
 	select {
-	case response := <-replyCh:
+	// Both channels are closed once the actor has answered, so whichever is picked first,
+	// the answer is taken from the one that holds it.
+	case response, ok := <-replyCh:
+		if !ok {
+			return nil, <-errorCh
+		}
 		return response, nil
-	case err := <-errorCh:
+	case err, ok := <-errorCh:
+		if !ok {
+			return <-replyCh, nil
+		}
 		return nil, err
 	case <-ctx.Done(): // Caller's context (e.g., for timeout)
-		// TODO: How to notify the actor that the Ask caller has timed out and is no longer waiting?
-		// This is important if the ProcessMessage is very long-running.
-		// For now, the actor will still process and attempt to send to replyCh/errorCh,
-		// but the send will not block due to buffered channels.
+		// The processor sees the cancellation through IActorContext.Context(); if the message is
+		// still queued it is skipped. Replies sent afterwards do not block: the channels are buffered.
 		log.Printf("Actor %s (%d) Ask call timed out by caller for message: %T", a.name, a.id, message)
 		return nil, ctx.Err()
 	case <-a.stopCh: // Actor itself stopped while Ask was waiting
@@ -181,88 +395,457 @@ func (a *Actor) Ask(ctx context.Context, message proto.Message) (interface{}, er
 }
 
 // Stop signals the actor to terminate its processing goroutine.
-// It waits for the goroutine to finish before returning.
+// It waits for the goroutine, and those of its children, to finish before returning.
+// Calling Stop more than once is safe.
 func (a *Actor) Stop() {
-	close(a.stopCh) // Signal the run loop to stop
-	a.wg.Wait()     // Wait for the run loop to exit
-	// Note: Mailbox is not explicitly closed here while run loop might be draining.
-	// The run loop will exit when stopCh is closed and mailbox is eventually empty or handled.
+	a.signalStop() // Signal the run loop to stop
+	if a.callingThread {
+		a.dispatch()
+	}
+	a.wg.Wait() // Wait for the run loop to exit
+}
+
+// signalStop closes stopCh without waiting for the run loop to exit.
+// It is safe to call from the actor's own goroutine.
+func (a *Actor) signalStop() {
+	a.stopOnce.Do(func() {
+		close(a.stopCh)
+	})
+}
+
+// MailboxDepth returns the number of messages waiting in the actor's mailbox.
+func (a *Actor) MailboxDepth() int {
+	return a.mailbox.Len()
+}
+
+// post hands msg to the mailbox. A message the mailbox evicts to make room fails its Ask.
+// Rejected and evicted messages go to the dead letters, except rejected Asks, whose
+// senders get the error instead.
+func (a *Actor) post(msg *Envelope) error {
+	err := errMailboxStopped
+	select {
+	case <-a.stopCh:
+	default:
+		var dropped *Envelope
+		dropped, err = a.mailbox.Post(msg, a.stopCh)
+		if dropped != nil {
+			dropped.fail(ErrMailboxFull)
+			if dropped.timer != nil {
+				dropped.timer.pending.Store(false)
+			}
+			a.deadLetter(dropped, DeadLetterEvicted, nil)
+		}
+	}
+	if err != nil && msg.replyCh == nil {
+		reason := DeadLetterMailboxFull
+		if errors.Is(err, errMailboxStopped) {
+			reason = DeadLetterActorStopped
+		}
+		a.deadLetter(msg, reason, nil)
+	}
+	if err == nil && a.callingThread {
+		a.dispatch()
+	}
+	return err
+}
+
+// sendSystem enqueues an internal control message. Mailboxes accept these without blocking.
+func (a *Actor) sendSystem(system interface{}) {
+	if err := a.post(&Envelope{ctx: context.Background(), system: system}); err != nil && !errors.Is(err, errMailboxStopped) {
+		log.Printf("Actor %s (%d) failed to enqueue system message %T: %v", a.name, a.id, system, err)
+	}
+}
+
+// spawnChild creates a child actor and registers it with a.
+func (a *Actor) spawnChild(name string, processor ActorProcessor, opts ...Option) (*Actor, error) {
+	if processor == nil {
+		return nil, errors.New("cannot spawn child with a nil ActorProcessor")
+	}
+	child, err := a.addChild(name, processor, opts...)
+	if err != nil {
+		return nil, err
+	}
+	child.launch()
+	return child, nil
+}
+
+// addChild creates a child actor and registers it with a, without starting it.
+func (a *Actor) addChild(name string, processor ActorProcessor, opts ...Option) (*Actor, error) {
+	a.childrenMu.Lock()
+	defer a.childrenMu.Unlock()
+	select {
+	case <-a.stopCh:
+		return nil, fmt.Errorf("actor %s (%d) is stopping, cannot spawn child %s", a.name, a.id, name)
+	default:
+	}
+	var child *Actor
+	if a.system != nil {
+		var err error
+		if child, err = a.system.spawn(0, false, name, processor, a, opts...); err != nil {
+			return nil, err
+		}
+	} else {
+		child = newActor(defaultIDSource.NextID(), name, processor, a, nil, opts...)
+	}
+	a.children[child.id] = child
+	return child, nil
+}
+
+// childActors returns a snapshot of the live children.
+func (a *Actor) childActors() []*Actor {
+	a.childrenMu.RLock()
+	defer a.childrenMu.RUnlock()
+	children := make([]*Actor, 0, len(a.children))
+	for _, child := range a.children {
+		children = append(children, child)
+	}
+	return children
+}
+
+func (a *Actor) removeChild(id ActorID) {
+	a.childrenMu.Lock()
+	delete(a.children, id)
+	a.childrenMu.Unlock()
+}
+
+// stopChildren stops all children and waits for them to exit.
+func (a *Actor) stopChildren() {
+	for _, child := range a.childActors() {
+		child.Stop()
+	}
 }
 
 // run is the actor's main processing loop.
 // It should not be called directly. It's started by NewActor.
 func (a *Actor) run() {
 	defer a.wg.Done()
-	defer func() {
-		// Drain mailbox on stop, replying with errors for any pending Ask messages
-		// This prevents senders of Ask from being stuck indefinitely if actor stops.
-		close(a.mailbox)             // Close mailbox to signal no more messages will be accepted or processed
-		for msg := range a.mailbox { // Range over remaining messages
-			if msg.replyCh != nil { // This was an Ask
-				select {
-				case msg.errorCh <- errors.New("actor stopped before processing Ask message"):
-				default: // Avoid blocking if errorCh is not listened to (e.g., Ask already timed out)
-				}
-			}
-		}
-		log.Printf("Actor %s (%d) processing loop stopped.", a.name, a.id)
-	}()
+	defer a.finish()
 
 	log.Printf("Actor %s (%d) processing loop started.", a.name, a.id)
-	actorCtx := &actorContextImpl{actor: a}
+	if !a.start() {
+		return
+	}
 
 	for {
 		select {
-		case msg, ok := <-a.mailbox:
-			if !ok { // Mailbox closed, should only happen if stopCh was also closed.
+		case <-a.stopCh: // Stop signal received
+			return
+		default:
+		}
+		msg := a.nextMessage()
+		if msg == nil {
+			select {
+			case <-a.mailbox.Ready():
+			case <-a.stopCh:
 				return
 			}
-			if msg == nil { // Should not happen with proper Tell/Ask
-				continue
-			}
+			continue
+		}
+		if !a.process(msg) {
+			return
+		}
+	}
+}
+
+// start runs PreStart. It returns false, after signalling the stop, if the actor must stop.
+func (a *Actor) start() bool {
+	if !a.callPreStart(a.actorCtx) {
+		a.signalStop()
+		return false
+	}
+	return true
+}
 
-			// Process the message using the provided processor
-			// We need to handle the context of the message (msg.ctx) vs actor's lifecycle context.
-			// For now, ProcessMessage gets the IActorContext which can provide actor's self-ref.
-			// The msg.ctx could be used inside ProcessMessage if needed for that specific message's lifecycle.
-			response, err := a.processor.ProcessMessage(actorCtx, msg.message)
-
-			if msg.replyCh != nil { // This was an Ask message
-				if err != nil {
-					select {
-					case msg.errorCh <- err:
-					case <-a.stopCh: // Actor stopped while trying to send error
-					case <-msg.ctx.Done(): // Original Ask context timed out/cancelled
-					}
-				} else {
-					select {
-					case msg.replyCh <- response: // response can be proto.Message or any interface{}
-					case <-a.stopCh: // Actor stopped while trying to send reply
-					case <-msg.ctx.Done(): // Original Ask context timed out/cancelled
-					}
-				}
-				close(msg.replyCh)
-				close(msg.errorCh)
-			} else if err != nil { // This was a Tell message and processing resulted in an error
-				log.Printf("Error processing Tell message for actor %s (%d): %v. Message: %T", a.name, a.id, err, msg.message)
-				// For Tell, errors are typically logged or sent to a dead letter queue.
+// process handles one message from the mailbox. It returns false, after signalling the stop,
+// if the actor must stop.
+func (a *Actor) process(msg *Envelope) bool {
+	actorCtx := a.actorCtx
+	if msg.system != nil {
+		if !a.handleSystem(actorCtx, msg.system) {
+			a.signalStop()
+			return false
+		}
+		return true
+	}
+	if msg.timer != nil && !msg.timer.accept() {
+		return true // Cancelled after the tick was enqueued
+	}
+	if msg.replyCh != nil && msg.ctx != nil && msg.ctx.Err() != nil {
+		// The caller has given up on this Ask; nobody is waiting for the result.
+		log.Printf("Actor %s (%d) skipped %T: Ask %v before processing", a.name, a.id, msg.message, msg.ctx.Err())
+		msg.fail(msg.ctx.Err())
+		return true
+	}
+
+	// Process the message using the provided processor.
+	// A panic inside ProcessMessage is recovered and handed to the supervisor.
+	// The processor reaches msg.ctx through IActorContext.Context().
+	actorCtx.current = msg
+	start := time.Now()
+	response, failure, err := a.invoke(actorCtx, msg)
+	a.metrics.record(start, failure != nil || err != nil)
+	actorCtx.current = nil
+	stashed := msg.stashed
+	if a.releasing == msg {
+		msg.stashed, a.releasing = false, nil
+	}
+	if failure != nil {
+		err = fmt.Errorf("actor %s (%d) failed processing %T: %v", a.name, a.id, msg.message, failure)
+	}
+
+	// A stashed message is answered once it is unstashed and processed again.
+	if msg.replyCh != nil && !stashed { // This was an Ask message
+		if err != nil {
+			select {
+			case msg.errorCh <- err:
+			case <-a.stopCh: // Actor stopped while trying to send error
+			case <-msg.ctx.Done(): // Original Ask context timed out/cancelled
+			}
+		} else {
+			select {
+			case msg.replyCh <- response: // response can be proto.Message or any interface{}
+			case <-a.stopCh: // Actor stopped while trying to send reply
+			case <-msg.ctx.Done(): // Original Ask context timed out/cancelled
 			}
+		}
+		close(msg.replyCh)
+		close(msg.errorCh)
+	} else if err != nil && !stashed { // This was a Tell message and processing resulted in an error
+		a.deadLetter(msg, DeadLetterProcessingFailed, err)
+	}
 
-		case <-a.stopCh: // Stop signal received
-			return
+	if terminated, ok := msg.message.(*pbactor.Terminated); ok {
+		a.forgetWatched(ActorID(terminated.ActorId))
+	}
+
+	if failure != nil && !a.handleFailure(actorCtx, failure) {
+		a.signalStop()
+		return false
+	}
+	if msg.timer == nil || msg.timer.receiveTimeout {
+		a.resetReceiveTimeout()
+	}
+	return true
+}
+
+// finish releases everything the actor holds once it has stopped processing messages.
+func (a *Actor) finish() {
+	a.cancelTimers()
+	// Children never outlive their parent.
+	a.stopChildren()
+	a.callPostStop(a.actorCtx)
+	a.discardStash()
+	// Drain mailbox on stop, replying with errors for any pending Ask messages
+	// This prevents senders of Ask from being stuck indefinitely if actor stops.
+	// The mailbox is not closed: concurrent Tell/Ask calls may still be sending to it.
+	for msg := a.mailbox.Pop(); msg != nil; msg = a.mailbox.Pop() {
+		// Avoid blocking if errorCh is not listened to (e.g., Ask already timed out)
+		msg.fail(errors.New("actor stopped before processing Ask message"))
+		a.deadLetter(msg, DeadLetterUnprocessed, nil)
+	}
+	if a.parent != nil {
+		a.parent.removeChild(a.id)
+	}
+	if a.system != nil {
+		a.system.unregister(a)
+		a.system.eventStream.UnsubscribeAll(a)
+	}
+	a.notifyWatchers()
+	log.Printf("Actor %s (%d) processing loop stopped.", a.name, a.id)
+}
+
+// invoke calls the processor and recovers from a panic, returning the panic value as failure.
+func (a *Actor) invoke(actorCtx IActorContext, msg *Envelope) (response proto.Message, failure interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Actor %s (%d) panicked processing %T: %v\n%s", a.name, a.id, msg.message, r, debug.Stack())
+			response, failure, err = nil, r, nil
 		}
+	}()
+	response, err = a.processMessage(actorCtx, msg.message)
+	return response, nil, err
+}
+
+// handleFailure asks the supervisor what to do after a failure and applies the directive.
+// It returns false if the actor must stop.
+func (a *Actor) handleFailure(actorCtx IActorContext, reason interface{}) bool {
+	strategy := defaultStrategy
+	if a.parent != nil {
+		strategy = a.parent.strategy
+	}
+	directive := strategy.HandleFailure(a.parent, a, reason)
+	log.Printf("Actor %s (%d) failure handled with directive %s.", a.name, a.id, directive)
+
+	switch directive {
+	case ResumeDirective:
+		return true
+	case RestartDirective:
+		return a.restart(actorCtx, reason)
+	case EscalateDirective:
+		if a.parent != nil {
+			a.parent.sendSystem(&failureMessage{child: a, reason: reason})
+		}
+		a.stopReason = reason
+		return false
+	default:
+		a.stopReason = reason
+		return false
+	}
+}
+
+// handleSystem processes an internal control message. It returns false if the actor must stop.
+func (a *Actor) handleSystem(actorCtx IActorContext, system interface{}) bool {
+	switch m := system.(type) {
+	case *restartMessage:
+		return a.restart(actorCtx, m.reason)
+	case *failureMessage:
+		// A child escalated; treat it as a failure of this actor.
+		log.Printf("Actor %s (%d) received escalated failure from child %s (%d): %v", a.name, a.id, m.child.name, m.child.id, m.reason)
+		return a.handleFailure(actorCtx, m.reason)
+	default:
+		log.Printf("Actor %s (%d) ignoring unknown system message %T", a.name, a.id, system)
+		return true
 	}
 }
 
+// restart runs PreRestart, stops the children and, if the actor has a producer, swaps in a
+// fresh processor before running PreStart again. It returns false if the actor must stop.
+func (a *Actor) restart(actorCtx IActorContext, reason interface{}) bool {
+	a.metrics.restarts.Add(1)
+	if hook, ok := a.processor.(PreRestarter); ok {
+		a.safeHook("PreRestart", func() { hook.PreRestart(actorCtx, reason) })
+	}
+	a.stopChildren()
+	a.cancelTimers()
+	a.behaviors = nil
+	a.unstashAll(nil)
+	if a.producer != nil {
+		a.processor = a.producer()
+	}
+	log.Printf("Actor %s (%d) restarted after failure: %v", a.name, a.id, reason)
+	return a.callPreStart(actorCtx)
+}
+
+// callPreStart runs the PreStart hook if the processor has one. A panicking PreStart stops the
+// actor, since restarting would most likely fail the same way. It returns false in that case.
+func (a *Actor) callPreStart(actorCtx IActorContext) bool {
+	hook, ok := a.processor.(PreStarter)
+	if !ok {
+		return true
+	}
+	if r := a.safeHook("PreStart", func() { hook.PreStart(actorCtx) }); r != nil {
+		a.stopReason = r
+		return false
+	}
+	return true
+}
+
+func (a *Actor) callPostStop(actorCtx IActorContext) {
+	if hook, ok := a.processor.(PostStopper); ok {
+		a.safeHook("PostStop", func() { hook.PostStop(actorCtx) })
+	}
+}
+
+// safeHook runs a lifecycle hook, recovering and returning any panic value.
+func (a *Actor) safeHook(name string, hook func()) (failure interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Actor %s (%d) panicked in %s: %v\n%s", a.name, a.id, name, r, debug.Stack())
+			failure = r
+		}
+	}()
+	hook()
+	return nil
+}
+
+// defaultStrategy supervises root actors.
+var defaultStrategy = DefaultSupervisorStrategy()
+
 // actorContextImpl implements IActorContext.
 type actorContextImpl struct {
-	actor *Actor // Reference to the actor instance
+	actor   *Actor    // Reference to the actor instance
+	current *Envelope // Message being processed
 }
 
 func (aci *actorContextImpl) Self() IActor {
 	return aci.actor.self // Return the stored IActor interface
 }
 
+func (aci *actorContextImpl) Context() context.Context {
+	if aci.current == nil || aci.current.ctx == nil {
+		return context.Background()
+	}
+	return aci.current.ctx
+}
+
+func (aci *actorContextImpl) Become(behavior Behavior) {
+	aci.actor.become(behavior)
+}
+
+func (aci *actorContextImpl) BecomeStacked(behavior Behavior) {
+	aci.actor.becomeStacked(behavior)
+}
+
+func (aci *actorContextImpl) Unbecome() {
+	aci.actor.unbecome()
+}
+
+func (aci *actorContextImpl) Stash() error {
+	return aci.actor.stash(aci.current)
+}
+
+func (aci *actorContextImpl) UnstashAll() {
+	aci.actor.unstashAll(aci.current)
+}
+
+func (aci *actorContextImpl) Parent() IActor {
+	if aci.actor.parent == nil {
+		return nil
+	}
+	return aci.actor.parent.self
+}
+
+func (aci *actorContextImpl) Children() []IActor {
+	children := aci.actor.childActors()
+	result := make([]IActor, 0, len(children))
+	for _, child := range children {
+		result = append(result, child.self)
+	}
+	return result
+}
+
+func (aci *actorContextImpl) System() *ActorSystem {
+	return aci.actor.system
+}
+
+func (aci *actorContextImpl) Watch(target IActor) error {
+	return aci.actor.watch(target)
+}
+
+func (aci *actorContextImpl) Unwatch(target IActor) error {
+	return aci.actor.unwatch(target)
+}
+
+func (aci *actorContextImpl) ScheduleOnce(delay time.Duration, message proto.Message) Cancellable {
+	return aci.actor.schedule(delay, 0, message, false)
+}
+
+func (aci *actorContextImpl) ScheduleRepeatedly(initialDelay, interval time.Duration, message proto.Message) Cancellable {
+	return aci.actor.schedule(initialDelay, interval, message, false)
+}
+
+func (aci *actorContextImpl) SetReceiveTimeout(timeout time.Duration) {
+	aci.actor.setReceiveTimeout(timeout)
+}
+
+func (aci *actorContextImpl) SpawnChild(name string, processor ActorProcessor, opts ...Option) (IActor, error) {
+	child, err := aci.actor.spawnChild(name, processor, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return child.self, nil
+}
+
 // --- Helper for proto.Message compatibility with Ask's interface{} response ---
 // If ActorProcessor always returns proto.Message, Ask can be more type-safe internally.
 // However, the IActor.Ask signature is interface{}.
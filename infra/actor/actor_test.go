@@ -0,0 +1,494 @@
+package actor_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// counter counts "inc", replies to "get", fails on "error", panics on "panic" and waits for
+// gate on "block". Any other message is forwarded to probe.
+type counter struct {
+	count int64
+	probe actor.IActor
+	gate  chan struct{}
+	setup func(actorCtx actor.IActorContext) // Run by PreStart if set
+}
+
+func (c *counter) PreStart(actorCtx actor.IActorContext) {
+	if c.setup != nil {
+		c.setup(actorCtx)
+	}
+}
+
+func (c *counter) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	if m, ok := msg.(*wrapperspb.StringValue); ok {
+		switch m.Value {
+		case "inc":
+			c.count++
+			return nil, nil
+		case "get":
+			return wrapperspb.Int64(c.count), nil
+		case "error":
+			return nil, errors.New("counter refused")
+		case "panic":
+			panic("counter crashed")
+		case "block":
+			<-c.gate
+			return nil, nil
+		}
+	}
+	if c.probe != nil {
+		return nil, c.probe.Tell(actorCtx.Context(), msg)
+	}
+	return nil, nil
+}
+
+func tell(t *testing.T, target actor.IActor, msg proto.Message) {
+	t.Helper()
+	require.NoError(t, target.Tell(context.Background(), msg))
+}
+
+func newSystem(t *testing.T) *actor.ActorSystem {
+	system := actor.NewActorSystem(t.Name())
+	t.Cleanup(system.Shutdown)
+	return system
+}
+
+func TestActor_RestartKeepsOrReplacesProcessor(t *testing.T) {
+	system := newSystem(t)
+
+	kept := testkit.Spawn(t, system, "kept", &counter{})
+	tell(t, kept, wrapperspb.String("inc"))
+	tell(t, kept, wrapperspb.String("panic"))
+	testkit.AskReply(t, kept, wrapperspb.String("get"), wrapperspb.Int64(1))
+
+	fresh := testkit.Spawn(t, system, "fresh", &counter{}, actor.WithProducer(func() actor.ActorProcessor { return &counter{} }))
+	tell(t, fresh, wrapperspb.String("inc"))
+	tell(t, fresh, wrapperspb.String("panic"))
+	testkit.AskReply(t, fresh, wrapperspb.String("get"), wrapperspb.Int64(0))
+}
+
+func TestActor_StopDirectiveNotifiesWatchers(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+
+	var child actor.IActor
+	stopOnFailure := actor.NewOneForOneStrategy(-1, 0, func(reason interface{}) actor.Directive { return actor.StopDirective })
+	parent := testkit.Spawn(t, system, "parent", &counter{setup: func(actorCtx actor.IActorContext) {
+		var err error
+		child, err = actorCtx.SpawnChild("child", &counter{}, actor.WithCallingThreadDispatcher())
+		require.NoError(t, err)
+	}}, actor.WithSupervisorStrategy(stopOnFailure))
+	require.NotNil(t, child)
+	_, ok := system.FindByPath("/parent/child")
+	assert.True(t, ok)
+
+	probe.Watch(child)
+	tell(t, child, wrapperspb.String("panic"))
+	terminated := probe.ExpectTerminated(child)
+	assert.Contains(t, terminated.Reason, "counter crashed")
+	_, ok = system.FindByPath("/parent/child")
+	assert.False(t, ok)
+	testkit.AskReply(t, parent, wrapperspb.String("get"), wrapperspb.Int64(0)) // The parent lives on
+}
+
+func TestActor_DeadLetters(t *testing.T) {
+	system := newSystem(t)
+	deadLetters := testkit.NewDeadLetterProbe(t, system)
+	sender := testkit.NewTestProbe(t, system, "sender")
+
+	target := testkit.Spawn(t, system, "target", &counter{})
+	require.NoError(t, target.Tell(actor.WithSender(context.Background(), sender.Ref()), wrapperspb.String("error")))
+	letter := deadLetters.ExpectDeadLetter(actor.DeadLetterProcessingFailed)
+	assert.Equal(t, "/sender", letter.Sender)
+	assert.Equal(t, "/target", letter.Recipient)
+	assert.Equal(t, "google.protobuf.StringValue", letter.MessageType())
+
+	target.Stop()
+	assert.Error(t, target.Tell(context.Background(), wrapperspb.String("inc")))
+	deadLetters.ExpectDeadLetter(actor.DeadLetterActorStopped)
+	testkit.AskError(t, target, wrapperspb.String("get"))
+	deadLetters.ExpectNoDeadLetter(20 * time.Millisecond) // Failed Asks are reported to the caller only
+}
+
+func TestActor_MailboxPolicies(t *testing.T) {
+	system := newSystem(t)
+	deadLetters := testkit.NewDeadLetterProbe(t, system)
+	probe := testkit.NewTestProbe(t, system, "probe")
+
+	newBlocked := func(name string, mailbox actor.MailboxProducer) (*actor.Actor, chan struct{}) {
+		gate := make(chan struct{})
+		a, err := system.Spawn(name, &counter{probe: probe.Ref(), gate: gate}, actor.WithMailbox(mailbox))
+		require.NoError(t, err)
+		tell(t, a, wrapperspb.String("block"))
+		require.Eventually(t, func() bool { return a.MailboxDepth() == 0 }, time.Second, time.Millisecond)
+		return a, gate
+	}
+
+	newest, gate := newBlocked("newest", actor.DropNewestMailbox(1))
+	tell(t, newest, wrapperspb.String("a"))
+	assert.ErrorIs(t, newest.Tell(context.Background(), wrapperspb.String("b")), actor.ErrMailboxFull)
+	assert.Equal(t, 1, newest.MailboxDepth())
+	deadLetters.ExpectDeadLetter(actor.DeadLetterMailboxFull)
+	close(gate)
+	probe.ExpectMsg(wrapperspb.String("a"))
+
+	oldest, gate := newBlocked("oldest", actor.DropOldestMailbox(1))
+	tell(t, oldest, wrapperspb.String("a"))
+	tell(t, oldest, wrapperspb.String("b"))
+	evicted := deadLetters.ExpectDeadLetter(actor.DeadLetterEvicted)
+	assert.True(t, proto.Equal(wrapperspb.String("a"), evicted.Message))
+	close(gate)
+	probe.ExpectMsg(wrapperspb.String("b"))
+
+	blocking, gate := newBlocked("blocking", actor.BlockingMailbox(1, 20*time.Millisecond))
+	tell(t, blocking, wrapperspb.String("a"))
+	assert.ErrorIs(t, blocking.Tell(context.Background(), wrapperspb.String("b")), actor.ErrMailboxFull)
+	deadLetters.ExpectDeadLetter(actor.DeadLetterMailboxFull)
+	close(gate)
+	probe.ExpectMsg(wrapperspb.String("a"))
+
+	priority, gate := newBlocked("priority", actor.PriorityMailbox(1, func(msg proto.Message) bool {
+		m, ok := msg.(*wrapperspb.StringValue)
+		return ok && m.Value == "urgent"
+	}))
+	tell(t, priority, wrapperspb.String("normal"))
+	tell(t, priority, wrapperspb.String("urgent")) // Accepted beyond capacity, delivered first
+	close(gate)
+	probe.ExpectMsg(wrapperspb.String("urgent"))
+	probe.ExpectMsg(wrapperspb.String("normal"))
+}
+
+func TestActor_TimersAndReceiveTimeout(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+
+	var ticks actor.Cancellable
+	testkit.Spawn(t, system, "timers", &counter{probe: probe.Ref(), setup: func(actorCtx actor.IActorContext) {
+		actorCtx.ScheduleOnce(10*time.Millisecond, wrapperspb.String("once"))
+		ticks = actorCtx.ScheduleRepeatedly(20*time.Millisecond, 20*time.Millisecond, wrapperspb.String("tick"))
+	}})
+	probe.ExpectMsg(wrapperspb.String("once"))
+	probe.ExpectMsg(wrapperspb.String("tick"))
+	probe.ExpectMsg(wrapperspb.String("tick"))
+	ticks.Cancel()
+	probe.ExpectNoMsg(60 * time.Millisecond)
+
+	idle := testkit.Spawn(t, system, "idle", &counter{probe: probe.Ref(), setup: func(actorCtx actor.IActorContext) {
+		actorCtx.SetReceiveTimeout(30 * time.Millisecond)
+	}})
+	testkit.ExpectMsgType[*pbactor.ReceiveTimeout](probe)
+	testkit.ExpectMsgType[*pbactor.ReceiveTimeout](probe) // Repeats while the actor stays idle
+	idle.Stop()
+	probe.ExpectNoMsg(60 * time.Millisecond)
+}
+
+func TestActor_TimerTicksInTheMailbox(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	gate := make(chan struct{})
+	var actorCtx actor.IActorContext
+	a, err := system.Spawn("busy", &counter{probe: probe.Ref(), gate: gate, setup: func(c actor.IActorContext) { actorCtx = c }})
+	require.NoError(t, err)
+	tell(t, a, wrapperspb.String("block"))
+	require.Eventually(t, func() bool { return a.MailboxDepth() == 0 }, time.Second, time.Millisecond)
+
+	ticks := actorCtx.ScheduleRepeatedly(time.Millisecond, time.Millisecond, wrapperspb.String("tick"))
+	once := actorCtx.ScheduleOnce(time.Millisecond, wrapperspb.String("once"))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 2, a.MailboxDepth(), "a repeating timer skips ticks while one is waiting")
+
+	once.Cancel() // Its message is already in the mailbox and is discarded
+	close(gate)
+	probe.ExpectMsg(wrapperspb.String("tick"))
+	probe.ExpectMsg(wrapperspb.String("tick"))
+	ticks.Cancel()
+}
+
+func TestActor_RestartCancelsTimers(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	scheduled := false
+	a := testkit.Spawn(t, system, "restarted", &counter{probe: probe.Ref(), setup: func(actorCtx actor.IActorContext) {
+		if !scheduled { // PreStart runs again after the restart
+			scheduled = true
+			actorCtx.ScheduleOnce(20*time.Millisecond, wrapperspb.String("once"))
+			actorCtx.SetReceiveTimeout(20 * time.Millisecond)
+		}
+	}})
+
+	tell(t, a, wrapperspb.String("panic"))
+	probe.ExpectNoMsg(60 * time.Millisecond)
+}
+
+// handler adapts a function to actor.ActorProcessor.
+type handler func(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error)
+
+func (h handler) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	return h(actorCtx, msg)
+}
+
+func TestActor_MessageContext(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	a := testkit.Spawn(t, system, "sender-aware", handler(func(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+		sender := actor.SenderFromContext(actorCtx.Context())
+		return nil, sender.Tell(context.Background(), msg)
+	}))
+
+	require.NoError(t, a.Tell(actor.WithSender(context.Background(), probe.Ref()), wrapperspb.String("hello")))
+	probe.ExpectMsg(wrapperspb.String("hello"))
+}
+
+func TestActor_AskCancellationReachesTheProcessor(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	a, err := system.Spawn("patient", handler(func(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+		<-actorCtx.Context().Done()
+		return nil, probe.Ref().Tell(context.Background(), wrapperspb.String(actorCtx.Context().Err().Error()))
+	}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = a.Ask(ctx, wrapperspb.String("wait"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	probe.ExpectMsg(wrapperspb.String(context.DeadlineExceeded.Error()))
+}
+
+func TestActor_SkipsAbandonedAsks(t *testing.T) {
+	system := newSystem(t)
+	gate := make(chan struct{})
+	a, err := system.Spawn("busy", &counter{gate: gate})
+	require.NoError(t, err)
+	tell(t, a, wrapperspb.String("block"))
+	require.Eventually(t, func() bool { return a.MailboxDepth() == 0 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.Ask(ctx, wrapperspb.String("inc"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	close(gate)
+	testkit.AskReply(t, a, wrapperspb.String("get"), wrapperspb.Int64(0)) // The abandoned "inc" was never processed
+}
+
+// room collects players in the lobby, stashing "ready" checks until it is full, then
+// switches to the game behavior, which reports them to probe.
+type room struct {
+	players int
+	probe   actor.IActor
+}
+
+func (r *room) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	switch msg.(*wrapperspb.StringValue).Value {
+	case "join":
+		r.players++
+		if r.players == 2 {
+			actorCtx.BecomeStacked(r.inGame)
+			actorCtx.UnstashAll()
+		}
+	case "ready":
+		return nil, actorCtx.Stash()
+	}
+	return wrapperspb.String("lobby"), nil
+}
+
+func (r *room) inGame(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	switch msg.(*wrapperspb.StringValue).Value {
+	case "ready":
+		return nil, r.probe.Tell(actorCtx.Context(), msg)
+	case "end":
+		actorCtx.Unbecome()
+	}
+	return wrapperspb.String("game"), nil
+}
+
+func TestActor_BecomeAndStash(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	r := testkit.Spawn(t, system, "room", &room{probe: probe.Ref()})
+
+	tell(t, r, wrapperspb.String("ready"))
+	testkit.AskReply(t, r, wrapperspb.String("join"), wrapperspb.String("lobby"))
+	probe.ExpectNoMsg(10 * time.Millisecond)
+	testkit.AskReply(t, r, wrapperspb.String("join"), wrapperspb.String("lobby"))
+	probe.ExpectMsg(wrapperspb.String("ready"))
+
+	testkit.AskReply(t, r, wrapperspb.String("other"), wrapperspb.String("game"))
+	testkit.AskReply(t, r, wrapperspb.String("end"), wrapperspb.String("game"))
+	testkit.AskReply(t, r, wrapperspb.String("other"), wrapperspb.String("lobby"))
+}
+
+// loopback hands published bodies to the bridges of every connected system, like an NSQ topic
+// with one channel per service.
+type loopback struct {
+	bridges []*actor.EventBridge
+}
+
+func (l *loopback) Publish(topic string, body []byte) error {
+	for _, bridge := range l.bridges {
+		if err := bridge.Receive(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestEventStream_PublishAndBridge(t *testing.T) {
+	system := newSystem(t)
+	stream := system.EventStream()
+	texts := testkit.NewTestProbe(t, system, "texts")
+	ints := testkit.NewTestProbe(t, system, "ints")
+	stream.Subscribe(texts.Ref(), (*wrapperspb.StringValue)(nil))
+	stream.Subscribe(ints.Ref(), (*wrapperspb.Int64Value)(nil))
+
+	delivered, err := stream.Publish(context.Background(), wrapperspb.String("offline"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	texts.ExpectMsg(wrapperspb.String("offline"))
+	ints.ExpectNoMsg(10 * time.Millisecond)
+
+	ints.Ref().Stop() // Subscriptions end with the actor
+	delivered, err = stream.Publish(context.Background(), wrapperspb.Int64(1))
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	remote := newSystem(t)
+	remoteProbe := testkit.NewTestProbe(t, remote, "probe")
+	remote.EventStream().Subscribe(remoteProbe.Ref(), (*wrapperspb.StringValue)(nil))
+
+	queue := &loopback{}
+	local := stream.Bridge(queue, "local")
+	t.Cleanup(local.Close)
+	local.Relay((*wrapperspb.StringValue)(nil), "events")
+	peer := remote.EventStream().Bridge(queue, "remote")
+	t.Cleanup(peer.Close)
+	queue.bridges = []*actor.EventBridge{local, peer}
+
+	_, err = stream.Publish(context.Background(), wrapperspb.String("reloaded"))
+	require.NoError(t, err)
+	texts.ExpectMsg(wrapperspb.String("reloaded"))
+	remoteProbe.ExpectMsg(wrapperspb.String("reloaded"))
+	texts.ExpectNoMsg(20 * time.Millisecond) // The bridge ignores its own event
+
+	_, err = stream.Publish(context.Background(), wrapperspb.Int64(2)) // Not relayed
+	require.NoError(t, err)
+	texts.ExpectNoMsg(10 * time.Millisecond)
+	remoteProbe.ExpectNoMsg(20 * time.Millisecond)
+
+	_, err = stream.Publish(context.Background(), nil)
+	assert.Error(t, err)
+	texts.ExpectNoMsg(10 * time.Millisecond)
+}
+
+func TestTypedActor_HandlersAndAsk(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+
+	var total int64
+	handlers := actor.NewHandlers()
+	actor.Handle(handlers, func(actorCtx actor.IActorContext, req *wrapperspb.Int64Value) (*wrapperspb.Int64Value, error) {
+		total += req.Value
+		return wrapperspb.Int64(total), nil
+	})
+	actor.Handle(handlers, func(actorCtx actor.IActorContext, req *wrapperspb.BoolValue) (*wrapperspb.StringValue, error) {
+		return nil, nil
+	})
+	actor.HandleTell(handlers, func(actorCtx actor.IActorContext, req *wrapperspb.StringValue) error {
+		return probe.Ref().Tell(actorCtx.Context(), req)
+	})
+	ref := testkit.Spawn(t, system, "adder", handlers)
+
+	adder := actor.Typed[*wrapperspb.Int64Value, *wrapperspb.Int64Value](ref)
+	sum, err := adder.Ask(context.Background(), wrapperspb.Int64(2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), sum.Value)
+
+	require.NoError(t, ref.Tell(context.Background(), wrapperspb.String("hello")))
+	probe.ExpectMsg(wrapperspb.String("hello"))
+
+	reply, err := ref.Ask(context.Background(), wrapperspb.Bool(true))
+	require.NoError(t, err)
+	assert.Nil(t, reply) // A typed nil reply is passed on as nil
+
+	_, err = actor.AskAs[*wrapperspb.StringValue](context.Background(), ref, wrapperspb.Int64(1))
+	assert.ErrorIs(t, err, actor.ErrUnexpectedReply)
+	testkit.AskError(t, ref, wrapperspb.Double(1)) // No handler
+}
+
+func TestTypedActor_InterfaceTypes(t *testing.T) {
+	system := newSystem(t)
+	assert.PanicsWithValue(t, "actor: cannot register a handler for protoreflect.ProtoMessage: Req must be a concrete message type such as *pb.Foo", func() {
+		actor.Handle(actor.NewHandlers(), func(actorCtx actor.IActorContext, req proto.Message) (proto.Message, error) {
+			return req, nil
+		})
+	})
+	assert.Panics(t, func() {
+		actor.HandleTell(actor.NewHandlers(), func(actorCtx actor.IActorContext, req proto.Message) error { return nil })
+	})
+
+	handlers := actor.NewHandlers()
+	actor.Handle(handlers, func(actorCtx actor.IActorContext, req *wrapperspb.Int64Value) (proto.Message, error) {
+		if req.Value < 0 {
+			return nil, errors.New("negative")
+		}
+		return nil, nil
+	})
+	ref := testkit.Spawn(t, system, "nil-replier", handlers)
+	err := testkit.AskError(t, ref, wrapperspb.Int64(1))
+	assert.ErrorContains(t, err, "handler for google.protobuf.Int64Value returned a nil protoreflect.ProtoMessage")
+	err = testkit.AskError(t, ref, wrapperspb.Int64(-1))
+	assert.ErrorContains(t, err, "negative", "the handler's own error is kept")
+}
+
+func TestActorSystem_StatsAndDebugHandler(t *testing.T) {
+	system := newSystem(t)
+	busy := testkit.Spawn(t, system, "busy", &counter{})
+	testkit.Spawn(t, system, "idle", &counter{})
+
+	tell(t, busy, wrapperspb.String("inc"))
+	tell(t, busy, wrapperspb.String("error"))
+	tell(t, busy, wrapperspb.String("panic"))
+	stats := busy.Stats()
+	assert.Equal(t, "/busy", stats.Path)
+	assert.Equal(t, int64(3), stats.Processed)
+	assert.Equal(t, int64(2), stats.Errors)
+	assert.Equal(t, int64(1), stats.Restarts)
+	assert.False(t, stats.LastMessage.IsZero())
+	var counted int64
+	for _, bucket := range stats.Latency {
+		counted += bucket.Count
+	}
+	assert.Equal(t, stats.Processed, counted)
+
+	recorder := httptest.NewRecorder()
+	system.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/actors?sort=errors&limit=1", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var listing struct {
+		System string             `json:"system"`
+		Actors []actor.ActorStats `json:"actors"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &listing))
+	assert.Equal(t, t.Name(), listing.System)
+	require.Len(t, listing.Actors, 1)
+	assert.Equal(t, "/busy", listing.Actors[0].Path)
+
+	recorder = httptest.NewRecorder()
+	system.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/actors?prefix=/idle", nil))
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &listing))
+	require.Len(t, listing.Actors, 1)
+	assert.Equal(t, int64(0), listing.Actors[0].Processed)
+}
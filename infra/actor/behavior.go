@@ -0,0 +1,90 @@
+package actor
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Behavior handles messages in place of ActorProcessor.ProcessMessage while it is active.
+// See IActorContext.Become.
+type Behavior func(actorCtx IActorContext, msg proto.Message) (response proto.Message, err error)
+
+// become replaces the active behavior, or pushes one if only the processor is active.
+func (a *Actor) become(behavior Behavior) {
+	if len(a.behaviors) == 0 {
+		a.behaviors = append(a.behaviors, behavior)
+		return
+	}
+	a.behaviors[len(a.behaviors)-1] = behavior
+}
+
+func (a *Actor) becomeStacked(behavior Behavior) {
+	a.behaviors = append(a.behaviors, behavior)
+}
+
+func (a *Actor) unbecome() {
+	if n := len(a.behaviors); n > 0 {
+		a.behaviors[n-1] = nil
+		a.behaviors = a.behaviors[:n-1]
+	}
+}
+
+// processMessage dispatches msg to the active behavior.
+func (a *Actor) processMessage(actorCtx IActorContext, msg proto.Message) (proto.Message, error) {
+	if n := len(a.behaviors); n > 0 {
+		return a.behaviors[n-1](actorCtx, msg)
+	}
+	return a.processor.ProcessMessage(actorCtx, msg)
+}
+
+// stash defers the message being processed until UnstashAll.
+func (a *Actor) stash(current *Envelope) error {
+	if current == nil {
+		return errors.New("actor: Stash called outside ProcessMessage")
+	}
+	if current.stashed {
+		return errors.New("actor: message already stashed")
+	}
+	current.stashed = true
+	a.stashed = append(a.stashed, current)
+	return nil
+}
+
+// unstashAll queues the stashed messages, in their original order, ahead of the mailbox.
+// If current, the message being processed, is among them it stays marked as stashed until
+// its handler returns, so it is answered only once, when it is processed again.
+func (a *Actor) unstashAll(current *Envelope) {
+	if len(a.stashed) == 0 {
+		return
+	}
+	for _, msg := range a.stashed {
+		if msg == current {
+			a.releasing = msg
+			continue
+		}
+		msg.stashed = false
+	}
+	a.unstashed = append(a.stashed, a.unstashed...)
+	a.stashed = nil
+}
+
+// nextMessage returns the next unstashed message, or else the next message in the mailbox.
+func (a *Actor) nextMessage() *Envelope {
+	if len(a.unstashed) > 0 {
+		msg := a.unstashed[0]
+		a.unstashed[0] = nil
+		a.unstashed = a.unstashed[1:]
+		return msg
+	}
+	return a.mailbox.Pop()
+}
+
+// discardStash sends the stashed and unstashed messages to the dead letters when the actor stops.
+func (a *Actor) discardStash() {
+	for _, msg := range append(a.unstashed, a.stashed...) {
+		msg.fail(errors.New("actor stopped before processing Ask message"))
+		a.deadLetter(msg, DeadLetterUnprocessed, nil)
+	}
+	a.stashed, a.unstashed = nil, nil
+}
@@ -0,0 +1,149 @@
+package actor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// gatekeeper stashes messages until it receives "open". While open it forwards messages to
+// probe and answers them with "handled", goes back to stashing on "close" and panics on "panic".
+type gatekeeper struct {
+	probe actor.IActor
+}
+
+func (g *gatekeeper) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	if msg.(*wrapperspb.StringValue).Value != "open" {
+		return nil, actorCtx.Stash()
+	}
+	actorCtx.Become(g.open)
+	actorCtx.UnstashAll()
+	return nil, nil
+}
+
+func (g *gatekeeper) open(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	switch msg.(*wrapperspb.StringValue).Value {
+	case "close":
+		actorCtx.Unbecome()
+		return nil, nil
+	case "panic":
+		panic("gatekeeper crashed")
+	}
+	if err := g.probe.Tell(actorCtx.Context(), msg); err != nil {
+		return nil, err
+	}
+	return wrapperspb.String("handled"), nil
+}
+
+func TestBehavior_UnstashKeepsOrder(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	gate := testkit.Spawn(t, system, "gate", &gatekeeper{probe: probe.Ref()})
+
+	for _, value := range []string{"a", "b", "c"} {
+		tell(t, gate, wrapperspb.String(value))
+	}
+	probe.ExpectNoMsg(10 * time.Millisecond)
+	tell(t, gate, wrapperspb.String("open"))
+	probe.ExpectMsg(wrapperspb.String("a"))
+	probe.ExpectMsg(wrapperspb.String("b"))
+	probe.ExpectMsg(wrapperspb.String("c"))
+	testkit.AskReply(t, gate, wrapperspb.String("d"), wrapperspb.String("handled"))
+	probe.ExpectMsg(wrapperspb.String("d"))
+
+	tell(t, gate, wrapperspb.String("close")) // Unbecome returns to ProcessMessage
+	tell(t, gate, wrapperspb.String("e"))
+	probe.ExpectNoMsg(10 * time.Millisecond)
+}
+
+func TestBehavior_StashedAskIsAnsweredAfterUnstash(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	gate := testkit.Spawn(t, system, "gate", &gatekeeper{probe: probe.Ref()})
+
+	replies := make(chan interface{}, 1)
+	go func() {
+		reply, err := gate.Ask(context.Background(), wrapperspb.String("question"))
+		assert.NoError(t, err)
+		replies <- reply
+	}()
+	select {
+	case reply := <-replies:
+		t.Fatalf("stashed Ask was answered with %v before it was unstashed", reply)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	tell(t, gate, wrapperspb.String("open"))
+	probe.ExpectMsg(wrapperspb.String("question"))
+	select {
+	case reply := <-replies:
+		assert.True(t, proto.Equal(wrapperspb.String("handled"), reply.(proto.Message)))
+	case <-time.After(testkit.DefaultTimeout):
+		t.Fatal("stashed Ask was not answered after it was unstashed")
+	}
+}
+
+func TestBehavior_RestartUnstashesAndResetsBehavior(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	gate := testkit.Spawn(t, system, "gate", &gatekeeper{probe: probe.Ref()})
+
+	tell(t, gate, wrapperspb.String("open"))
+	tell(t, gate, wrapperspb.String("panic"))
+	tell(t, gate, wrapperspb.String("a"))
+	probe.ExpectNoMsg(10 * time.Millisecond) // Stashed again by ProcessMessage
+	tell(t, gate, wrapperspb.String("open"))
+	probe.ExpectMsg(wrapperspb.String("a"))
+}
+
+func TestBehavior_StopDiscardsTheStash(t *testing.T) {
+	system := newSystem(t)
+	deadLetters := testkit.NewDeadLetterProbe(t, system)
+	gate := testkit.Spawn(t, system, "gate", &gatekeeper{})
+
+	tell(t, gate, wrapperspb.String("a"))
+	gate.Stop()
+	letter := deadLetters.ExpectDeadLetter(actor.DeadLetterUnprocessed)
+	assert.True(t, proto.Equal(wrapperspb.String("a"), letter.Message))
+}
+
+func TestBehavior_StashTwice(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	a := testkit.Spawn(t, system, "stasher", handler(func(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+		require.NoError(t, actorCtx.Stash())
+		return nil, probe.Ref().Tell(context.Background(), wrapperspb.String(actorCtx.Stash().Error()))
+	}))
+
+	tell(t, a, wrapperspb.String("a"))
+	probe.ExpectMsg(wrapperspb.String("actor: message already stashed"))
+}
+
+func TestBehavior_StashAndUnstashInOneHandler(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	seen := map[string]bool{}
+	a := testkit.Spawn(t, system, "retrier", handler(func(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+		value := msg.(*wrapperspb.StringValue).Value
+		if !seen[value] { // Deliver every message a second time
+			seen[value] = true
+			require.NoError(t, actorCtx.Stash())
+			actorCtx.UnstashAll()
+			return wrapperspb.String("first"), nil
+		}
+		return wrapperspb.String("second"), probe.Ref().Tell(context.Background(), msg)
+	}))
+
+	tell(t, a, wrapperspb.String("tell"))
+	probe.ExpectMsg(wrapperspb.String("tell"))
+	testkit.AskReply(t, a, wrapperspb.String("ask"), wrapperspb.String("second"))
+	probe.ExpectMsg(wrapperspb.String("ask"))
+	probe.ExpectNoMsg(10 * time.Millisecond)
+}
@@ -0,0 +1,213 @@
+package actor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DeadLetterReason tells why a message ended up in the dead letters.
+type DeadLetterReason string
+
+const (
+	DeadLetterActorStopped     DeadLetterReason = "actor stopped"       // Sent to an actor that had stopped
+	DeadLetterMailboxFull      DeadLetterReason = "mailbox full"        // Rejected by the mailbox policy
+	DeadLetterEvicted          DeadLetterReason = "evicted"             // Dropped from the mailbox to make room
+	DeadLetterUnprocessed      DeadLetterReason = "unprocessed at stop" // Still in the mailbox when the actor stopped
+	DeadLetterProcessingFailed DeadLetterReason = "processing failed"   // ProcessMessage returned an error for a Tell
+)
+
+// DeadLetter describes a message that could not be delivered or processed.
+type DeadLetter struct {
+	Sender      string // Path of the sender, set with WithSender; empty if unknown
+	Recipient   string // Path of the recipient
+	RecipientID ActorID
+	Message     proto.Message
+	Reason      DeadLetterReason
+	Err         error // Processing error, for DeadLetterProcessingFailed
+	Time        time.Time
+}
+
+// MessageType returns the full protobuf name of the message.
+func (d DeadLetter) MessageType() string {
+	if d.Message == nil {
+		return ""
+	}
+	return string(d.Message.ProtoReflect().Descriptor().FullName())
+}
+
+type senderKey struct{}
+
+// WithSender returns a copy of ctx that names sender as the sender of messages told or asked
+// with it, so that dead letters can report who sent them.
+func WithSender(ctx context.Context, sender IActor) context.Context {
+	return context.WithValue(ctx, senderKey{}, sender)
+}
+
+// SenderFromContext returns the sender stored by WithSender, or nil.
+func SenderFromContext(ctx context.Context) IActor {
+	if ctx == nil {
+		return nil
+	}
+	sender, _ := ctx.Value(senderKey{}).(IActor)
+	return sender
+}
+
+// actorRef formats an actor for logs and dead letters, preferring its path.
+func actorRef(a IActor) string {
+	if a == nil {
+		return ""
+	}
+	if p, ok := a.(interface{ Path() string }); ok {
+		return p.Path()
+	}
+	return a.Name()
+}
+
+// DeadLetters collects the dead letters of an ActorSystem and fans them out to subscribers.
+type DeadLetters struct {
+	system string
+	count  atomic.Int64
+	mu     sync.RWMutex
+	subs   map[*DeadLetterSubscription]struct{}
+}
+
+func newDeadLetters(system string) *DeadLetters {
+	return &DeadLetters{system: system, subs: make(map[*DeadLetterSubscription]struct{})}
+}
+
+// Count returns the number of dead letters recorded so far.
+func (d *DeadLetters) Count() int64 {
+	return d.count.Load()
+}
+
+// DeadLetterSubscription receives dead letters until it is cancelled.
+type DeadLetterSubscription struct {
+	owner   *DeadLetters
+	ch      chan DeadLetter
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Subscribe returns a subscription that buffers up to buffer dead letters. Dead letters that
+// arrive while the buffer is full are dropped rather than blocking the actors.
+func (d *DeadLetters) Subscribe(buffer int) *DeadLetterSubscription {
+	if buffer <= 0 {
+		buffer = defaultMailboxSize
+	}
+	sub := &DeadLetterSubscription{owner: d, ch: make(chan DeadLetter, buffer)}
+	d.mu.Lock()
+	d.subs[sub] = struct{}{}
+	d.mu.Unlock()
+	return sub
+}
+
+// C returns the channel dead letters are delivered on. It is closed by Cancel.
+func (s *DeadLetterSubscription) C() <-chan DeadLetter {
+	return s.ch
+}
+
+// Dropped returns the number of dead letters lost because the buffer was full.
+func (s *DeadLetterSubscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Cancel ends the subscription and closes C. It is safe to call more than once.
+func (s *DeadLetterSubscription) Cancel() {
+	s.once.Do(func() {
+		s.owner.mu.Lock()
+		delete(s.owner.subs, s)
+		s.owner.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+func (d *DeadLetters) publish(letter DeadLetter) {
+	d.count.Add(1)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for sub := range d.subs {
+		select {
+		case sub.ch <- letter:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Publisher publishes a message body to a topic. *nsqx.Producer implements it. Dead letters
+// and event stream bridges are published with it.
+type Publisher interface {
+	Publish(topic string, body []byte) error
+}
+
+// Forward publishes every dead letter to topic as a serialized pbactor.DeadLetter until the
+// returned subscription is cancelled.
+func (d *DeadLetters) Forward(publisher Publisher, topic string) *DeadLetterSubscription {
+	sub := d.Subscribe(1024)
+	go func() {
+		for letter := range sub.C() {
+			body, err := proto.Marshal(d.toProto(letter))
+			if err != nil {
+				log.Printf("DeadLetters %s failed to marshal dead letter for %s: %v", d.system, letter.Recipient, err)
+				continue
+			}
+			if err := publisher.Publish(topic, body); err != nil {
+				log.Printf("DeadLetters %s failed to publish to %s: %v", d.system, topic, err)
+			}
+		}
+	}()
+	return sub
+}
+
+func (d *DeadLetters) toProto(letter DeadLetter) *pbactor.DeadLetter {
+	pb := &pbactor.DeadLetter{
+		System:      d.system,
+		Sender:      letter.Sender,
+		Recipient:   letter.Recipient,
+		RecipientId: int64(letter.RecipientID),
+		MessageType: letter.MessageType(),
+		Reason:      string(letter.Reason),
+		Timestamp:   letter.Time.UnixMilli(),
+	}
+	if letter.Err != nil {
+		pb.Error = letter.Err.Error()
+	}
+	if letter.Message != nil {
+		if packed, err := anypb.New(letter.Message); err == nil {
+			pb.Message = packed
+		}
+	}
+	return pb
+}
+
+// deadLetter records a user message the actor could not deliver or process. Actors outside
+// an ActorSystem only log it.
+func (a *Actor) deadLetter(msg *Envelope, reason DeadLetterReason, err error) {
+	if msg.IsSystem() {
+		return
+	}
+	letter := DeadLetter{
+		Sender:      actorRef(SenderFromContext(msg.ctx)),
+		Recipient:   a.path,
+		RecipientID: a.id,
+		Message:     msg.message,
+		Reason:      reason,
+		Err:         err,
+		Time:        time.Now(),
+	}
+	if err != nil {
+		log.Printf("Dead letter for actor %s (%d): %T %s: %v", a.name, a.id, msg.message, reason, err)
+	} else {
+		log.Printf("Dead letter for actor %s (%d): %T %s", a.name, a.id, msg.message, reason)
+	}
+	if a.system != nil {
+		a.system.deadLetters.publish(letter)
+	}
+}
@@ -0,0 +1,103 @@
+package actor_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// published records what a Publisher was given.
+type published struct {
+	mu     sync.Mutex
+	topics []string
+	bodies [][]byte
+}
+
+func (p *published) Publish(topic string, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append(p.topics, topic)
+	p.bodies = append(p.bodies, body)
+	return nil
+}
+
+func (p *published) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.bodies)
+}
+
+func TestDeadLetters_SubscriptionDropsWhenFull(t *testing.T) {
+	system := newSystem(t)
+	sub := system.DeadLetters().Subscribe(1)
+	stopped := testkit.Spawn(t, system, "stopped", &counter{})
+	stopped.Stop()
+
+	for i := 0; i < 3; i++ {
+		assert.Error(t, stopped.Tell(context.Background(), wrapperspb.String("inc")))
+	}
+	assert.Equal(t, int64(3), system.DeadLetters().Count())
+	assert.Equal(t, int64(2), sub.Dropped())
+	letter := <-sub.C()
+	assert.Equal(t, actor.DeadLetterActorStopped, letter.Reason)
+
+	sub.Cancel()
+	sub.Cancel()
+	_, open := <-sub.C()
+	assert.False(t, open)
+	assert.Error(t, stopped.Tell(context.Background(), wrapperspb.String("inc"))) // Not delivered to the cancelled subscription
+}
+
+func TestDeadLetters_UnprocessedAtStop(t *testing.T) {
+	system := newSystem(t)
+	deadLetters := testkit.NewDeadLetterProbe(t, system)
+	gate := make(chan struct{})
+	a, err := system.Spawn("busy", &counter{gate: gate})
+	require.NoError(t, err)
+	tell(t, a, wrapperspb.String("block"))
+	require.Eventually(t, func() bool { return a.MailboxDepth() == 0 }, time.Second, time.Millisecond)
+	tell(t, a, wrapperspb.String("inc"))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond) // Let Stop signal first
+		close(gate)
+	}()
+	a.Stop()
+	letter := deadLetters.ExpectDeadLetter(actor.DeadLetterUnprocessed)
+	assert.True(t, proto.Equal(wrapperspb.String("inc"), letter.Message))
+}
+
+func TestDeadLetters_Forward(t *testing.T) {
+	system := newSystem(t)
+	publisher := &published{}
+	sub := system.DeadLetters().Forward(publisher, "dead-letters")
+	t.Cleanup(sub.Cancel)
+	sender := testkit.NewTestProbe(t, system, "sender")
+	target := testkit.Spawn(t, system, "target", &counter{})
+
+	require.NoError(t, target.Tell(actor.WithSender(context.Background(), sender.Ref()), wrapperspb.String("error")))
+	require.Eventually(t, func() bool { return publisher.len() == 1 }, time.Second, time.Millisecond)
+
+	var letter pbactor.DeadLetter
+	require.NoError(t, proto.Unmarshal(publisher.bodies[0], &letter))
+	assert.Equal(t, "dead-letters", publisher.topics[0])
+	assert.Equal(t, system.Name(), letter.System)
+	assert.Equal(t, "/sender", letter.Sender)
+	assert.Equal(t, "/target", letter.Recipient)
+	assert.Equal(t, int64(target.Id()), letter.RecipientId)
+	assert.Equal(t, "google.protobuf.StringValue", letter.MessageType)
+	assert.Equal(t, string(actor.DeadLetterProcessingFailed), letter.Reason)
+	assert.Contains(t, letter.Error, "counter refused")
+	message, err := letter.Message.UnmarshalNew()
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("error"), message))
+}
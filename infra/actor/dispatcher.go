@@ -0,0 +1,83 @@
+package actor
+
+import "log"
+
+// WithCallingThreadDispatcher makes the actor process messages synchronously on the goroutine
+// that sends them, instead of on a goroutine of its own. Tell returns after the message has
+// been processed, which makes unit tests deterministic. It is meant for tests only:
+//
+//   - A message sent while another goroutine is processing is picked up by that goroutine.
+//   - Asking the actor from its own ProcessMessage blocks until the Ask's context expires.
+//   - Timer messages are processed on the timer's goroutine.
+//   - A blocking mailbox blocks a sender that is also the one processing; prefer the default.
+func WithCallingThreadDispatcher() Option {
+	return func(o *actorOptions) {
+		o.callingThread = true
+	}
+}
+
+// startInline runs PreStart on the calling goroutine for the calling-thread dispatcher.
+// Messages sent to the actor in the meantime, including by PreStart, are processed next.
+func (a *Actor) startInline() {
+	a.dispatchMu.Lock()
+	log.Printf("Actor %s (%d) started on the calling-thread dispatcher.", a.name, a.id)
+	a.started = true
+	if !a.start() {
+		a.finishInline()
+	}
+	a.dispatchMu.Unlock()
+	a.dispatch()
+}
+
+// dispatch processes the queued messages on the calling goroutine, unless another goroutine
+// is already doing so. It keeps going until the mailbox is empty or the actor has finished.
+func (a *Actor) dispatch() {
+	for {
+		if !a.dispatchMu.TryLock() {
+			return // The goroutine holding the lock checks the mailbox again before leaving
+		}
+		a.drainInline()
+		done := a.finished || !a.started // startInline dispatches once PreStart has run
+		a.dispatchMu.Unlock()
+		if done || (a.mailbox.Len() == 0 && !a.stopping()) {
+			return
+		}
+	}
+}
+
+// drainInline processes messages until the mailbox is empty or the actor stops.
+// The caller holds dispatchMu.
+func (a *Actor) drainInline() {
+	for a.started && !a.finished {
+		if a.stopping() {
+			a.finishInline()
+			return
+		}
+		msg := a.nextMessage()
+		if msg == nil {
+			return
+		}
+		if !a.process(msg) {
+			a.finishInline()
+		}
+	}
+}
+
+func (a *Actor) finishInline() {
+	if a.finished {
+		return
+	}
+	a.finished = true
+	a.finish()
+	a.wg.Done()
+}
+
+// stopping reports whether Stop has been signalled.
+func (a *Actor) stopping() bool {
+	select {
+	case <-a.stopCh:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,228 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// EventStream delivers published messages to every actor subscribed to their protobuf type.
+// It is meant for in-process broadcasts such as "player went offline" or "config reloaded".
+// Subscriptions of an actor owned by the system end when the actor stops.
+type EventStream struct {
+	system string
+
+	mu      sync.RWMutex
+	subs    map[protoreflect.FullName]map[IActor]struct{}
+	bridges []*EventBridge
+}
+
+func newEventStream(system string) *EventStream {
+	return &EventStream{system: system, subs: make(map[protoreflect.FullName]map[IActor]struct{})}
+}
+
+// Subscribe makes subscriber receive every event with the same protobuf type as msgType,
+// which may be a typed nil such as (*pb.PlayerOffline)(nil). Subscribing twice is a no-op.
+func (s *EventStream) Subscribe(subscriber IActor, msgType proto.Message) {
+	name := messageName(msgType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[name] == nil {
+		s.subs[name] = make(map[IActor]struct{})
+	}
+	s.subs[name][subscriber] = struct{}{}
+}
+
+// Unsubscribe removes a subscription made by Subscribe.
+func (s *EventStream) Unsubscribe(subscriber IActor, msgType proto.Message) {
+	name := messageName(msgType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs[name], subscriber)
+	if len(s.subs[name]) == 0 {
+		delete(s.subs, name)
+	}
+}
+
+// UnsubscribeAll removes every subscription of subscriber.
+func (s *EventStream) UnsubscribeAll(subscriber IActor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, subscribers := range s.subs {
+		delete(subscribers, subscriber)
+		if len(subscribers) == 0 {
+			delete(s.subs, name)
+		}
+	}
+}
+
+// Publish tells event to every subscriber of its type and relays it through the bridges
+// that carry its type. It returns the number of local subscribers the event was told to.
+// A subscriber that cannot take the event records a dead letter; the others still get it.
+func (s *EventStream) Publish(ctx context.Context, event proto.Message) (int, error) {
+	if event == nil {
+		return 0, errors.New("cannot Publish a nil event")
+	}
+	delivered := s.publishLocal(ctx, event)
+	s.mu.RLock()
+	bridges := s.bridges
+	s.mu.RUnlock()
+	for _, bridge := range bridges {
+		bridge.relay(event)
+	}
+	return delivered, nil
+}
+
+func (s *EventStream) publishLocal(ctx context.Context, event proto.Message) int {
+	if event == nil {
+		return 0
+	}
+	s.mu.RLock()
+	subscribers := make([]IActor, 0, len(s.subs[messageName(event)]))
+	for subscriber := range s.subs[messageName(event)] {
+		subscribers = append(subscribers, subscriber)
+	}
+	s.mu.RUnlock()
+
+	delivered := 0
+	for _, subscriber := range subscribers {
+		if err := subscriber.Tell(ctx, event); err != nil {
+			log.Printf("EventStream %s failed to deliver %T to %s: %v", s.system, event, actorRef(subscriber), err)
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+func messageName(msg proto.Message) protoreflect.FullName {
+	return msg.ProtoReflect().Descriptor().FullName()
+}
+
+// EventBridge relays selected event types between an EventStream and a message queue such
+// as NSQ, so that subscribers in other services receive them too. Events are sent as
+// serialized pbactor.StreamEvent messages; a bridge ignores the events it published itself.
+type EventBridge struct {
+	stream    *EventStream
+	publisher Publisher
+	origin    string
+	outbox    chan outboundEvent
+	done      chan struct{}
+	once      sync.Once
+
+	mu     sync.RWMutex
+	topics map[protoreflect.FullName]string
+}
+
+type outboundEvent struct {
+	topic string
+	body  []byte
+}
+
+// Bridge creates a bridge that publishes with publisher. origin must be unique among the
+// services sharing the topics, e.g. the server ID. Relay selects what is sent; feed incoming
+// bodies to Receive, for example from an nsqx.Consumer:
+//
+//	nsq.HandlerFunc(func(m *nsq.Message) error { return bridge.Receive(m.Body) })
+func (s *EventStream) Bridge(publisher Publisher, origin string) *EventBridge {
+	b := &EventBridge{
+		stream:    s,
+		publisher: publisher,
+		origin:    origin,
+		outbox:    make(chan outboundEvent, 1024),
+		done:      make(chan struct{}),
+		topics:    make(map[protoreflect.FullName]string),
+	}
+	s.mu.Lock()
+	s.bridges = append(append([]*EventBridge(nil), s.bridges...), b)
+	s.mu.Unlock()
+	go b.run()
+	return b
+}
+
+// Relay makes the bridge publish events with the protobuf type of msgType to topic.
+func (b *EventBridge) Relay(msgType proto.Message, topic string) {
+	b.mu.Lock()
+	b.topics[messageName(msgType)] = topic
+	b.mu.Unlock()
+}
+
+// Receive publishes an event received from the message queue to the local subscribers.
+// Events published by this bridge are ignored. The event type must be linked into the binary.
+func (b *EventBridge) Receive(body []byte) error {
+	var event pbactor.StreamEvent
+	if err := proto.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	if event.Origin == b.origin || event.Message == nil {
+		return nil
+	}
+	msg, err := event.Message.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	b.stream.publishLocal(context.Background(), msg)
+	return nil
+}
+
+// Close stops relaying. Events queued for publishing are dropped. It is safe to call more than once.
+func (b *EventBridge) Close() {
+	b.once.Do(func() {
+		s := b.stream
+		s.mu.Lock()
+		bridges := make([]*EventBridge, 0, len(s.bridges))
+		for _, other := range s.bridges {
+			if other != b {
+				bridges = append(bridges, other)
+			}
+		}
+		s.bridges = bridges
+		s.mu.Unlock()
+		close(b.done)
+	})
+}
+
+func (b *EventBridge) relay(event proto.Message) {
+	b.mu.RLock()
+	topic, ok := b.topics[messageName(event)]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+	packed, err := anypb.New(event)
+	if err != nil {
+		log.Printf("EventStream %s failed to pack %T: %v", b.stream.system, event, err)
+		return
+	}
+	body, err := proto.Marshal(&pbactor.StreamEvent{Origin: b.origin, Message: packed})
+	if err != nil {
+		log.Printf("EventStream %s failed to marshal %T: %v", b.stream.system, event, err)
+		return
+	}
+	select {
+	case b.outbox <- outboundEvent{topic: topic, body: body}:
+	case <-b.done:
+	default:
+		log.Printf("EventStream %s dropped %T for %s: bridge outbox is full", b.stream.system, event, topic)
+	}
+}
+
+// run publishes queued events until the bridge is closed, so that Publish never waits on the queue.
+func (b *EventBridge) run() {
+	for {
+		select {
+		case event := <-b.outbox:
+			if err := b.publisher.Publish(event.topic, event.body); err != nil {
+				log.Printf("EventStream %s failed to publish to %s: %v", b.stream.system, event.topic, err)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package actor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrMailboxFull is returned by Tell and Ask when the mailbox policy rejects a message.
+var ErrMailboxFull = errors.New("actor mailbox is full")
+
+// errMailboxStopped is returned by Mailbox.Post when the actor stops while the post is blocked.
+var errMailboxStopped = errors.New("actor stopped")
+
+// Mailbox queues the messages of one actor.
+//
+// Post may be called from any goroutine; Pop is only called from the actor's own goroutine.
+// System envelopes (see Envelope.IsSystem) drive supervision and must always be accepted
+// without blocking, even beyond the mailbox capacity.
+type Mailbox interface {
+	// Post enqueues env. A blocking policy must give up when the envelope's context is done or
+	// stop is closed. If the policy evicts a queued message to make room, it returns it as dropped.
+	Post(env *Envelope, stop <-chan struct{}) (dropped *Envelope, err error)
+	// Pop removes and returns the next message, or nil if the mailbox is empty.
+	Pop() *Envelope
+	// Ready returns a channel that receives a value after a Post. The actor waits on it
+	// whenever Pop returns nil.
+	Ready() <-chan struct{}
+	// Len returns the number of queued messages.
+	Len() int
+}
+
+// MailboxProducer creates the mailbox of a new actor. Use WithMailbox to select one.
+type MailboxProducer func() Mailbox
+
+// BlockingMailbox holds up to size messages. Posting to a full mailbox waits up to timeout
+// for room, then fails with ErrMailboxFull. timeout <= 0 waits until the sender's context is
+// done or the actor stops.
+func BlockingMailbox(size int, timeout time.Duration) MailboxProducer {
+	return func() Mailbox {
+		return &boundedMailbox{mailboxQueue: newMailboxQueue(), size: mailboxCapacity(size), timeout: timeout, space: make(chan struct{}, 1)}
+	}
+}
+
+// DropOldestMailbox holds up to size messages, evicting the oldest one to make room for a new one.
+// An evicted Ask fails with ErrMailboxFull. System messages are never evicted; if only they fill
+// the mailbox, the new message is rejected with ErrMailboxFull instead.
+func DropOldestMailbox(size int) MailboxProducer {
+	return func() Mailbox {
+		return &droppingMailbox{mailboxQueue: newMailboxQueue(), size: mailboxCapacity(size), dropOldest: true}
+	}
+}
+
+// DropNewestMailbox holds up to size messages and rejects new ones with ErrMailboxFull while full.
+// It is the default policy, with a size of 128.
+func DropNewestMailbox(size int) MailboxProducer {
+	return func() Mailbox {
+		return &droppingMailbox{mailboxQueue: newMailboxQueue(), size: mailboxCapacity(size)}
+	}
+}
+
+// UnboundedMailbox never rejects a message. Only use it for actors whose senders are
+// naturally rate limited, since a stuck actor grows it without limit.
+func UnboundedMailbox() MailboxProducer {
+	return func() Mailbox {
+		return &droppingMailbox{mailboxQueue: newMailboxQueue()}
+	}
+}
+
+// PriorityMailbox delivers system messages and control messages before all other messages.
+// Control messages are those for which isControl returns true; a nil isControl uses
+// IsControlMessage. Other messages are bounded by size and rejected while full, like
+// DropNewestMailbox; control messages are never rejected.
+func PriorityMailbox(size int, isControl func(proto.Message) bool) MailboxProducer {
+	if isControl == nil {
+		isControl = IsControlMessage
+	}
+	return func() Mailbox {
+		return &priorityMailbox{mailboxQueue: newMailboxQueue(), size: mailboxCapacity(size), isControl: isControl}
+	}
+}
+
+// IsControlMessage reports whether msg is generated by the actor runtime to manage the
+// actor's lifecycle, such as Terminated.
+func IsControlMessage(msg proto.Message) bool {
+	_, ok := msg.(*pbactor.Terminated)
+	return ok
+}
+
+func mailboxCapacity(size int) int {
+	if size <= 0 {
+		return defaultMailboxSize
+	}
+	return size
+}
+
+// envelopeQueue is a FIFO of envelopes.
+type envelopeQueue struct {
+	items []*Envelope
+	head  int
+}
+
+func (q *envelopeQueue) len() int {
+	return len(q.items) - q.head
+}
+
+func (q *envelopeQueue) push(env *Envelope) {
+	q.items = append(q.items, env)
+}
+
+func (q *envelopeQueue) pop() *Envelope {
+	if q.head == len(q.items) {
+		return nil
+	}
+	env := q.items[q.head]
+	q.items[q.head] = nil
+	q.head++
+	switch {
+	case q.head == len(q.items):
+		q.items, q.head = q.items[:0], 0
+	case q.head > 64 && q.head*2 > len(q.items):
+		// Reclaim the consumed prefix once it dominates the slice.
+		n := copy(q.items, q.items[q.head:])
+		clear(q.items[n:])
+		q.items, q.head = q.items[:n], 0
+	}
+	return env
+}
+
+// removeFirst removes and returns the oldest envelope accepted by match.
+func (q *envelopeQueue) removeFirst(match func(*Envelope) bool) *Envelope {
+	for i := q.head; i < len(q.items); i++ {
+		if env := q.items[i]; match(env) {
+			copy(q.items[i:], q.items[i+1:])
+			q.items[len(q.items)-1] = nil
+			q.items = q.items[:len(q.items)-1]
+			return env
+		}
+	}
+	return nil
+}
+
+// mailboxQueue is the locked queue and ready signal shared by the built-in mailboxes.
+type mailboxQueue struct {
+	mu    sync.Mutex
+	queue envelopeQueue
+	ready chan struct{}
+}
+
+func newMailboxQueue() mailboxQueue {
+	return mailboxQueue{ready: make(chan struct{}, 1)}
+}
+
+func (m *mailboxQueue) signal() {
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailboxQueue) Ready() <-chan struct{} {
+	return m.ready
+}
+
+func (m *mailboxQueue) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queue.len()
+}
+
+func (m *mailboxQueue) Pop() *Envelope {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queue.pop()
+}
+
+// droppingMailbox implements DropOldestMailbox, DropNewestMailbox and, with size 0, UnboundedMailbox.
+type droppingMailbox struct {
+	mailboxQueue
+	size       int
+	dropOldest bool
+}
+
+func (m *droppingMailbox) Post(env *Envelope, stop <-chan struct{}) (dropped *Envelope, err error) {
+	m.mu.Lock()
+	if m.size > 0 && !env.IsSystem() && m.queue.len() >= m.size {
+		if !m.dropOldest {
+			m.mu.Unlock()
+			return nil, ErrMailboxFull
+		}
+		dropped = m.queue.removeFirst(func(queued *Envelope) bool { return !queued.IsSystem() })
+		if dropped == nil { // Only system messages are queued, and they are never evicted
+			m.mu.Unlock()
+			return nil, ErrMailboxFull
+		}
+	}
+	m.queue.push(env)
+	m.mu.Unlock()
+	m.signal()
+	return dropped, nil
+}
+
+// boundedMailbox implements BlockingMailbox.
+type boundedMailbox struct {
+	mailboxQueue
+	size    int
+	timeout time.Duration
+	space   chan struct{} // Signalled when Pop frees a slot
+}
+
+func (m *boundedMailbox) Post(env *Envelope, stop <-chan struct{}) (*Envelope, error) {
+	var expired <-chan time.Time
+	for {
+		m.mu.Lock()
+		if env.IsSystem() || m.queue.len() < m.size {
+			m.queue.push(env)
+			more := m.queue.len() < m.size
+			m.mu.Unlock()
+			m.signal()
+			if more {
+				m.freed() // Pass the wake-up on to the next blocked sender
+			}
+			return nil, nil
+		}
+		m.mu.Unlock()
+
+		if expired == nil && m.timeout > 0 {
+			t := time.NewTimer(m.timeout)
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case <-m.space:
+		case <-expired:
+			return nil, ErrMailboxFull
+		case <-env.done():
+			return nil, env.ctx.Err()
+		case <-stop:
+			return nil, errMailboxStopped
+		}
+	}
+}
+
+func (m *boundedMailbox) Pop() *Envelope {
+	env := m.mailboxQueue.Pop()
+	if env != nil {
+		m.freed()
+	}
+	return env
+}
+
+func (m *boundedMailbox) freed() {
+	select {
+	case m.space <- struct{}{}:
+	default:
+	}
+}
+
+// priorityMailbox implements PriorityMailbox.
+type priorityMailbox struct {
+	mailboxQueue // Normal messages
+	urgent       envelopeQueue
+	size         int
+	isControl    func(proto.Message) bool
+}
+
+func (m *priorityMailbox) Post(env *Envelope, stop <-chan struct{}) (*Envelope, error) {
+	m.mu.Lock()
+	switch {
+	case env.IsSystem() || m.isControl(env.message):
+		m.urgent.push(env)
+	case m.queue.len() >= m.size:
+		m.mu.Unlock()
+		return nil, ErrMailboxFull
+	default:
+		m.queue.push(env)
+	}
+	m.mu.Unlock()
+	m.signal()
+	return nil, nil
+}
+
+func (m *priorityMailbox) Pop() *Envelope {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if env := m.urgent.pop(); env != nil {
+		return env
+	}
+	return m.queue.pop()
+}
+
+func (m *priorityMailbox) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.urgent.len() + m.queue.len()
+}
@@ -0,0 +1,128 @@
+package actor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func userEnvelope(value string) *Envelope {
+	return &Envelope{ctx: context.Background(), message: wrapperspb.String(value)}
+}
+
+func systemEnvelope() *Envelope {
+	return &Envelope{ctx: context.Background(), system: &restartMessage{}}
+}
+
+// popAll drains m and returns the values of its user messages, with "system" for system messages.
+func popAll(m Mailbox) []string {
+	var values []string
+	for env := m.Pop(); env != nil; env = m.Pop() {
+		if env.IsSystem() {
+			values = append(values, "system")
+			continue
+		}
+		if s, ok := env.message.(*wrapperspb.StringValue); ok {
+			values = append(values, s.Value)
+		} else {
+			values = append(values, string(proto.MessageName(env.message).Name()))
+		}
+	}
+	return values
+}
+
+func post(t *testing.T, m Mailbox, env *Envelope) {
+	t.Helper()
+	dropped, err := m.Post(env, nil)
+	require.NoError(t, err)
+	require.Nil(t, dropped)
+}
+
+func TestMailbox_DropNewest(t *testing.T) {
+	m := DropNewestMailbox(2)()
+	post(t, m, userEnvelope("a"))
+	post(t, m, userEnvelope("b"))
+	_, err := m.Post(userEnvelope("c"), nil)
+	assert.ErrorIs(t, err, ErrMailboxFull)
+	post(t, m, systemEnvelope()) // System messages go beyond the capacity
+	assert.Equal(t, 3, m.Len())
+	assert.Equal(t, []string{"a", "b", "system"}, popAll(m))
+}
+
+func TestMailbox_DropOldest(t *testing.T) {
+	m := DropOldestMailbox(2)()
+	post(t, m, systemEnvelope())
+	post(t, m, userEnvelope("a"))
+	dropped, err := m.Post(userEnvelope("b"), nil)
+	require.NoError(t, err)
+	require.NotNil(t, dropped)
+	assert.Equal(t, "a", dropped.message.(*wrapperspb.StringValue).Value, "system messages are never evicted")
+	assert.Equal(t, []string{"system", "b"}, popAll(m))
+}
+
+func TestMailbox_DropOldestWithOnlySystemMessages(t *testing.T) {
+	m := DropOldestMailbox(1)()
+	post(t, m, systemEnvelope())
+	_, err := m.Post(userEnvelope("a"), nil)
+	assert.ErrorIs(t, err, ErrMailboxFull, "nothing could be evicted to make room")
+	assert.Equal(t, 1, m.Len())
+	assert.Equal(t, []string{"system"}, popAll(m))
+}
+
+func TestMailbox_Unbounded(t *testing.T) {
+	m := UnboundedMailbox()()
+	for i := 0; i < 10*defaultMailboxSize; i++ {
+		post(t, m, userEnvelope("a"))
+	}
+	assert.Equal(t, 10*defaultMailboxSize, m.Len())
+}
+
+func TestMailbox_BlockingWaitsForRoom(t *testing.T) {
+	m := BlockingMailbox(1, 20*time.Millisecond)()
+	post(t, m, userEnvelope("a"))
+
+	start := time.Now()
+	_, err := m.Post(userEnvelope("b"), nil)
+	assert.ErrorIs(t, err, ErrMailboxFull)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		m.Pop()
+	}()
+	post(t, m, userEnvelope("c")) // Accepted once the pop frees a slot
+	assert.Equal(t, []string{"c"}, popAll(m))
+}
+
+func TestMailbox_BlockingGivesUp(t *testing.T) {
+	m := BlockingMailbox(1, 0)()
+	post(t, m, userEnvelope("a"))
+	post(t, m, systemEnvelope())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := m.Post(&Envelope{ctx: ctx, message: wrapperspb.String("b")}, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	stop := make(chan struct{})
+	close(stop)
+	_, err = m.Post(userEnvelope("c"), stop)
+	assert.ErrorIs(t, err, errMailboxStopped)
+	assert.Equal(t, []string{"a", "system"}, popAll(m))
+}
+
+func TestMailbox_Priority(t *testing.T) {
+	m := PriorityMailbox(1, nil)()
+	post(t, m, userEnvelope("a"))
+	_, err := m.Post(userEnvelope("b"), nil)
+	assert.ErrorIs(t, err, ErrMailboxFull)
+	post(t, m, &Envelope{ctx: context.Background(), message: &pbactor.Terminated{}})
+	post(t, m, systemEnvelope())
+	assert.Equal(t, []string{"Terminated", "system", "a"}, popAll(m))
+}
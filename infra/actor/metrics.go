@@ -0,0 +1,164 @@
+package actor
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the processing latency histogram. Slower messages
+// fall into a final, unbounded bucket.
+var latencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// actorMetrics counts what an actor processes. It is updated by the processing goroutine and
+// read by Stats from any goroutine.
+type actorMetrics struct {
+	processed   atomic.Int64
+	errors      atomic.Int64
+	restarts    atomic.Int64
+	lastMessage atomic.Int64 // Unix nanoseconds; 0 before the first message
+	totalNanos  atomic.Int64
+	buckets     [len(latencyBuckets) + 1]atomic.Int64
+}
+
+func (m *actorMetrics) record(start time.Time, failed bool) {
+	elapsed := time.Since(start)
+	m.processed.Add(1)
+	if failed {
+		m.errors.Add(1)
+	}
+	m.lastMessage.Store(start.UnixNano())
+	m.totalNanos.Add(int64(elapsed))
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return elapsed <= latencyBuckets[i] })
+	m.buckets[i].Add(1)
+}
+
+// LatencyBucket counts the messages processed within UpperBound. The last bucket of a
+// histogram has a zero UpperBound and counts everything slower.
+type LatencyBucket struct {
+	UpperBound time.Duration `json:"upper_bound"`
+	Count      int64         `json:"count"`
+}
+
+// ActorStats is a snapshot of an actor's runtime statistics.
+type ActorStats struct {
+	ID           ActorID         `json:"id"`
+	Name         string          `json:"name"`
+	Path         string          `json:"path"`
+	MailboxDepth int             `json:"mailbox_depth"`
+	Processed    int64           `json:"processed"` // User messages handled, including failed ones
+	Errors       int64           `json:"errors"`    // Messages that returned an error or panicked
+	Restarts     int64           `json:"restarts"`
+	LastMessage  time.Time       `json:"last_message"` // Zero if no message was processed yet
+	Idle         time.Duration   `json:"idle"`         // Time since LastMessage, or since the actor started
+	MeanLatency  time.Duration   `json:"mean_latency"`
+	Latency      []LatencyBucket `json:"latency"`
+}
+
+// Stats returns a snapshot of the actor's statistics.
+func (a *Actor) Stats() ActorStats {
+	m := &a.metrics
+	stats := ActorStats{
+		ID:           a.id,
+		Name:         a.name,
+		Path:         a.path,
+		MailboxDepth: a.MailboxDepth(),
+		Processed:    m.processed.Load(),
+		Errors:       m.errors.Load(),
+		Restarts:     m.restarts.Load(),
+		Latency:      make([]LatencyBucket, len(m.buckets)),
+	}
+	since := a.createdAt
+	if last := m.lastMessage.Load(); last != 0 {
+		stats.LastMessage = time.Unix(0, last)
+		since = stats.LastMessage
+	}
+	stats.Idle = time.Since(since)
+	if stats.Processed > 0 {
+		stats.MeanLatency = time.Duration(m.totalNanos.Load() / stats.Processed)
+	}
+	for i := range m.buckets {
+		if i < len(latencyBuckets) {
+			stats.Latency[i].UpperBound = latencyBuckets[i]
+		}
+		stats.Latency[i].Count = m.buckets[i].Load()
+	}
+	return stats
+}
+
+// Stats returns a snapshot of the statistics of all live actors, sorted by path.
+func (s *ActorSystem) Stats() []ActorStats {
+	actors := s.Actors()
+	stats := make([]ActorStats, 0, len(actors))
+	for _, a := range actors {
+		if actor, ok := a.(*Actor); ok {
+			stats = append(stats, actor.Stats())
+		}
+	}
+	return stats
+}
+
+// DebugHandler returns an HTTP handler that lists the live actors and their statistics as
+// JSON, with durations in nanoseconds. Query parameters:
+//
+//   - prefix: only actors whose path starts with it, e.g. "/room-100"
+//   - sort: "mailbox", "idle" or "errors" to list the highest values first; by path otherwise
+//   - limit: at most this many actors
+func (s *ActorSystem) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		stats := s.Stats()
+		if prefix := query.Get("prefix"); prefix != "" {
+			filtered := stats[:0]
+			for _, st := range stats {
+				if strings.HasPrefix(st.Path, prefix) {
+					filtered = append(filtered, st)
+				}
+			}
+			stats = filtered
+		}
+		switch query.Get("sort") {
+		case "mailbox":
+			sort.SliceStable(stats, func(i, j int) bool { return stats[i].MailboxDepth > stats[j].MailboxDepth })
+		case "idle":
+			sort.SliceStable(stats, func(i, j int) bool { return stats[i].Idle > stats[j].Idle })
+		case "errors":
+			sort.SliceStable(stats, func(i, j int) bool { return stats[i].Errors > stats[j].Errors })
+		case "", "path":
+		default:
+			http.Error(w, "unknown sort "+query.Get("sort"), http.StatusBadRequest)
+			return
+		}
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit "+limit, http.StatusBadRequest)
+				return
+			}
+			if n < len(stats) {
+				stats = stats[:n]
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(struct {
+			System string       `json:"system"`
+			Actors []ActorStats `json:"actors"`
+		}{System: s.name, Actors: stats})
+	})
+}
@@ -0,0 +1,176 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	journalCollection   = "actor_journal"
+	snapshotsCollection = "actor_snapshots"
+)
+
+// journalDocument is one Append in the journal collection: its events, with sequence numbers
+// Seq to LastSeq. A single document is written atomically, so Append is all-or-nothing
+// without a transaction, which would need a replica set.
+type journalDocument struct {
+	PersistenceID string         `bson:"persistence_id"`
+	Seq           int64          `bson:"seq"` // Sequence number of the first event
+	LastSeq       int64          `bson:"last_seq"`
+	Events        []journalEvent `bson:"events"`
+	CreatedAt     time.Time      `bson:"created_at"`
+}
+
+// journalEvent is one event of a journalDocument.
+type journalEvent struct {
+	TypeURL string `bson:"type_url"`
+	Payload []byte `bson:"payload"`
+}
+
+// snapshotDocument is the latest snapshot of one actor; only one is kept per persistence ID.
+type snapshotDocument struct {
+	PersistenceID string    `bson:"_id"`
+	Seq           int64     `bson:"seq"`
+	TypeURL       string    `bson:"type_url"`
+	Payload       []byte    `bson:"payload"`
+	CreatedAt     time.Time `bson:"created_at"`
+}
+
+// MongoJournal stores events in the actor_journal collection and snapshots in actor_snapshots.
+// Events and snapshots are stored as serialized protobuf with their type URL, so the message
+// types must be linked into the process that replays them.
+type MongoJournal struct {
+	mongoClient *mongo.Client
+	dbName      string
+}
+
+// NewMongoJournal creates a journal in database dbName. Call EnsureIndexes once at start-up.
+func NewMongoJournal(mongoClient *mongo.Client, dbName string) *MongoJournal {
+	return &MongoJournal{mongoClient: mongoClient, dbName: dbName}
+}
+
+func (j *MongoJournal) events() *mongo.Collection {
+	return j.mongoClient.Database(j.dbName).Collection(journalCollection)
+}
+
+func (j *MongoJournal) snapshots() *mongo.Collection {
+	return j.mongoClient.Database(j.dbName).Collection(snapshotsCollection)
+}
+
+// EnsureIndexes creates the unique (persistence_id, seq) index that rejects concurrent writers.
+// Batches are only ever appended after the last one a writer has seen, so two writers that
+// both append after the same batch start at the same sequence number.
+func (j *MongoJournal) EnsureIndexes(ctx context.Context) error {
+	_, err := j.events().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "persistence_id", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("persistence: failed to create journal index: %w", err)
+	}
+	return nil
+}
+
+// Append implements Journal. The events are stored as one document, which is limited to 16MB.
+func (j *MongoJournal) Append(ctx context.Context, persistenceID string, fromSeq int64, events []proto.Message) error {
+	if len(events) == 0 {
+		return nil
+	}
+	doc := journalDocument{
+		PersistenceID: persistenceID,
+		Seq:           fromSeq,
+		LastSeq:       fromSeq + int64(len(events)) - 1,
+		Events:        make([]journalEvent, 0, len(events)),
+		CreatedAt:     time.Now(),
+	}
+	for _, event := range events {
+		a, err := anypb.New(event)
+		if err != nil {
+			return fmt.Errorf("persistence: failed to pack event %T: %w", event, err)
+		}
+		doc.Events = append(doc.Events, journalEvent{TypeURL: a.GetTypeUrl(), Payload: a.GetValue()})
+	}
+	if _, err := j.events().InsertOne(ctx, doc); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("persistence: %s sequence number %d already taken: %w", persistenceID, fromSeq, err)
+		}
+		return fmt.Errorf("persistence: failed to append events of %s: %w", persistenceID, err)
+	}
+	return nil
+}
+
+// Replay implements Journal.
+func (j *MongoJournal) Replay(ctx context.Context, persistenceID string, fromSeq int64, fn func(seq int64, event proto.Message) error) error {
+	filter := bson.M{"persistence_id": persistenceID, "last_seq": bson.M{"$gte": fromSeq}}
+	cursor, err := j.events().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return fmt.Errorf("persistence: failed to query events of %s: %w", persistenceID, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc journalDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("persistence: failed to decode event of %s: %w", persistenceID, err)
+		}
+		for i, packed := range doc.Events {
+			seq := doc.Seq + int64(i)
+			if seq < fromSeq {
+				continue // The batch started before fromSeq
+			}
+			event, err := (&anypb.Any{TypeUrl: packed.TypeURL, Value: packed.Payload}).UnmarshalNew()
+			if err != nil {
+				return fmt.Errorf("persistence: failed to unpack event %d of %s: %w", seq, persistenceID, err)
+			}
+			if err := fn(seq, event); err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// SaveSnapshot implements Journal.
+func (j *MongoJournal) SaveSnapshot(ctx context.Context, persistenceID string, seq int64, snapshot proto.Message) error {
+	a, err := anypb.New(snapshot)
+	if err != nil {
+		return fmt.Errorf("persistence: failed to pack snapshot %T: %w", snapshot, err)
+	}
+	doc := snapshotDocument{
+		PersistenceID: persistenceID,
+		Seq:           seq,
+		TypeURL:       a.GetTypeUrl(),
+		Payload:       a.GetValue(),
+		CreatedAt:     time.Now(),
+	}
+	_, err = j.snapshots().ReplaceOne(ctx, bson.M{"_id": persistenceID}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("persistence: failed to save snapshot of %s: %w", persistenceID, err)
+	}
+	return nil
+}
+
+// LoadSnapshot implements Journal.
+func (j *MongoJournal) LoadSnapshot(ctx context.Context, persistenceID string) (int64, proto.Message, error) {
+	var doc snapshotDocument
+	err := j.snapshots().FindOne(ctx, bson.M{"_id": persistenceID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("persistence: failed to load snapshot of %s: %w", persistenceID, err)
+	}
+	state, err := (&anypb.Any{TypeUrl: doc.TypeURL, Value: doc.Payload}).UnmarshalNew()
+	if err != nil {
+		return 0, nil, fmt.Errorf("persistence: failed to unpack snapshot of %s: %w", persistenceID, err)
+	}
+	return doc.Seq, state, nil
+}
@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// wallet deposits Int64Value commands, answers "balance" and panics on "crash".
+type wallet struct {
+	balance int64
+}
+
+func (w *wallet) PersistenceID() string { return "wallet-1" }
+
+func (w *wallet) ProcessMessage(actorCtx Context, msg proto.Message) (proto.Message, error) {
+	switch m := msg.(type) {
+	case *wrapperspb.Int64Value:
+		actorCtx.Persist(wrapperspb.Int64(m.Value))
+	case *wrapperspb.StringValue:
+		if m.Value == "crash" {
+			panic("wallet crashed")
+		}
+	}
+	return wrapperspb.Int64(w.balance), nil
+}
+
+func (w *wallet) ApplyEvent(event proto.Message) {
+	w.balance += event.(*wrapperspb.Int64Value).Value
+}
+
+func (w *wallet) Snapshot() proto.Message { return wrapperspb.Int64(w.balance) }
+
+func (w *wallet) RestoreSnapshot(snapshot proto.Message) error {
+	w.balance = snapshot.(*wrapperspb.Int64Value).Value
+	return nil
+}
+
+func askBalance(t *testing.T, a actor.IActor, msg proto.Message) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := a.Ask(ctx, msg)
+	require.NoError(t, err)
+	return reply.(*wrapperspb.Int64Value).Value
+}
+
+func TestPersistentActor_Recovers(t *testing.T) {
+	journal := NewMemoryJournal()
+	newWallet := func() actor.ActorProcessor {
+		return NewPersistentActor(func() PersistentProcessor { return &wallet{} }, journal, WithSnapshotEvery(2))
+	}
+	system := actor.NewActorSystem("persistence-test")
+	defer system.Shutdown()
+
+	a, err := system.Spawn("wallet", newWallet())
+	require.NoError(t, err)
+	for _, amount := range []int64{10, 20, 30} {
+		askBalance(t, a, wrapperspb.Int64(amount))
+	}
+	assert.Equal(t, int64(60), askBalance(t, a, wrapperspb.String("balance")))
+
+	seq, snapshot, err := journal.LoadSnapshot(context.Background(), "wallet-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), seq)
+	assert.Equal(t, int64(30), snapshot.(*wrapperspb.Int64Value).Value)
+
+	// A panic restarts the actor, which rebuilds its state from the snapshot and event 3.
+	_, err = a.Ask(context.Background(), wrapperspb.String("crash"))
+	require.Error(t, err)
+	assert.Equal(t, int64(60), askBalance(t, a, wrapperspb.String("balance")))
+
+	// A new incarnation continues from the journal.
+	a.Stop()
+	a, err = system.Spawn("wallet", newWallet())
+	require.NoError(t, err)
+	assert.Equal(t, int64(65), askBalance(t, a, wrapperspb.Int64(5)))
+
+	var replayed []int64
+	require.NoError(t, journal.Replay(context.Background(), "wallet-1", 1, func(seq int64, event proto.Message) error {
+		replayed = append(replayed, event.(*wrapperspb.Int64Value).Value)
+		return nil
+	}))
+	assert.Equal(t, []int64{10, 20, 30, 5}, replayed)
+}
+
+func TestMemoryJournal_RejectsConflictingAppend(t *testing.T) {
+	journal := NewMemoryJournal()
+	ctx := context.Background()
+	require.NoError(t, journal.Append(ctx, "p", 1, []proto.Message{wrapperspb.Int64(1), wrapperspb.Int64(2)}))
+	assert.Error(t, journal.Append(ctx, "p", 2, []proto.Message{wrapperspb.Int64(3)}))
+	assert.NoError(t, journal.Append(ctx, "p", 3, []proto.Message{wrapperspb.Int64(3)}))
+}
+
+// testAppendIsAtomic checks that a rejected Append stores none of its events, and that a
+// replay may start in the middle of a batch.
+func testAppendIsAtomic(t *testing.T, journal Journal) {
+	ctx := context.Background()
+	require.NoError(t, journal.Append(ctx, "p", 1, []proto.Message{wrapperspb.Int64(1), wrapperspb.Int64(2)}))
+	require.Error(t, journal.Append(ctx, "p", 2, []proto.Message{wrapperspb.Int64(20), wrapperspb.Int64(30), wrapperspb.Int64(40)}))
+	require.NoError(t, journal.Append(ctx, "p", 3, []proto.Message{wrapperspb.Int64(3), wrapperspb.Int64(4)}))
+
+	replay := func(fromSeq int64) (seqs, values []int64) {
+		require.NoError(t, journal.Replay(ctx, "p", fromSeq, func(seq int64, event proto.Message) error {
+			seqs = append(seqs, seq)
+			values = append(values, event.(*wrapperspb.Int64Value).Value)
+			return nil
+		}))
+		return seqs, values
+	}
+	seqs, values := replay(1)
+	assert.Equal(t, []int64{1, 2, 3, 4}, seqs)
+	assert.Equal(t, []int64{1, 2, 3, 4}, values, "no event of the rejected batch is stored")
+	seqs, values = replay(4)
+	assert.Equal(t, []int64{4}, seqs)
+	assert.Equal(t, []int64{4}, values)
+}
+
+func TestMemoryJournal_AppendIsAtomic(t *testing.T) {
+	testAppendIsAtomic(t, NewMemoryJournal())
+}
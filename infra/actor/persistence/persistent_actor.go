@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// Default values for PersistentActor options.
+const (
+	defaultSnapshotEvery  = 100
+	defaultJournalTimeout = 5 * time.Second
+)
+
+// PersistentProcessor holds the state and logic of an event-sourced actor.
+//
+// ProcessMessage records state changes as events with Context.Persist instead of mutating
+// state directly. Persist applies each event right away through ApplyEvent, so the reply can
+// reflect the new state, and the events are appended to the journal when ProcessMessage returns.
+// On start and after every restart the state is rebuilt from the latest snapshot and the
+// events recorded after it.
+type PersistentProcessor interface {
+	// PersistenceID identifies the actor's events in the journal, e.g. "wallet-42".
+	PersistenceID() string
+	// ProcessMessage handles one message, like actor.ActorProcessor.ProcessMessage.
+	ProcessMessage(actorCtx Context, msg proto.Message) (response proto.Message, err error)
+	// ApplyEvent changes the state according to one event. It is used both for new events and
+	// during recovery, so it must not have side effects other than changing the state.
+	ApplyEvent(event proto.Message)
+	// Snapshot returns the current state to be stored as a snapshot.
+	Snapshot() proto.Message
+	// RestoreSnapshot replaces the state with a snapshot during recovery.
+	RestoreSnapshot(snapshot proto.Message) error
+}
+
+// Context is the actor context passed to PersistentProcessor.ProcessMessage.
+type Context interface {
+	actor.IActorContext
+	// Persist applies events to the state and queues them for the journal. The events are
+	// appended even if ProcessMessage then returns an error.
+	Persist(events ...proto.Message)
+	// LastSequenceNr returns the sequence number of the last persisted event.
+	LastSequenceNr() int64
+}
+
+// Option configures a PersistentActor.
+type Option func(*PersistentActor)
+
+// WithSnapshotEvery takes a snapshot after every n events. n <= 0 disables snapshots.
+func WithSnapshotEvery(n int64) Option {
+	return func(pa *PersistentActor) {
+		pa.snapshotEvery = n
+	}
+}
+
+// WithJournalTimeout bounds every journal operation. The default is 5s.
+func WithJournalTimeout(timeout time.Duration) Option {
+	return func(pa *PersistentActor) {
+		if timeout > 0 {
+			pa.journalTimeout = timeout
+		}
+	}
+}
+
+// PersistentActor is an actor.ActorProcessor that runs a PersistentProcessor on top of a
+// Journal. Spawn it like any other processor:
+//
+//	system.Spawn("wallet-42", persistence.NewPersistentActor(func() persistence.PersistentProcessor {
+//		return &Wallet{playerID: 42}
+//	}, journal))
+//
+// If appending to the journal fails, the actor panics so that its supervisor restarts it and
+// recovery brings the state back in line with the journal.
+type PersistentActor struct {
+	producer       func() PersistentProcessor
+	journal        Journal
+	snapshotEvery  int64
+	journalTimeout time.Duration
+
+	processor    PersistentProcessor
+	seq          int64           // Sequence number of the last persisted event
+	lastSnapshot int64           // Sequence number covered by the last snapshot
+	pending      []proto.Message // Events persisted by the message being processed
+}
+
+// NewPersistentActor creates the processor. producer is called on every (re)start to get a
+// processor with empty state, which is then recovered from journal.
+func NewPersistentActor(producer func() PersistentProcessor, journal Journal, opts ...Option) *PersistentActor {
+	pa := &PersistentActor{
+		producer:       producer,
+		journal:        journal,
+		snapshotEvery:  defaultSnapshotEvery,
+		journalTimeout: defaultJournalTimeout,
+	}
+	for _, opt := range opts {
+		opt(pa)
+	}
+	return pa
+}
+
+// PreStart recovers the state from the journal. It implements actor.PreStarter; a failed
+// recovery stops the actor.
+func (pa *PersistentActor) PreStart(actorCtx actor.IActorContext) {
+	pa.processor = pa.producer()
+	pa.seq, pa.lastSnapshot, pa.pending = 0, 0, nil
+	id := pa.processor.PersistenceID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pa.journalTimeout)
+	defer cancel()
+	seq, snapshot, err := pa.journal.LoadSnapshot(ctx, id)
+	if err != nil {
+		panic(fmt.Errorf("persistence: recovery of %s failed: %w", id, err))
+	}
+	if snapshot != nil {
+		if err := pa.processor.RestoreSnapshot(snapshot); err != nil {
+			panic(fmt.Errorf("persistence: restoring snapshot %d of %s failed: %w", seq, id, err))
+		}
+		pa.seq, pa.lastSnapshot = seq, seq
+	}
+	replayed := 0
+	err = pa.journal.Replay(ctx, id, pa.seq+1, func(seq int64, event proto.Message) error {
+		pa.processor.ApplyEvent(event)
+		pa.seq = seq
+		replayed++
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("persistence: replay of %s failed: %w", id, err))
+	}
+	log.Printf("PersistentActor %s recovered at sequence number %d (snapshot %d, %d events replayed)", id, pa.seq, pa.lastSnapshot, replayed)
+}
+
+// ProcessMessage implements actor.ActorProcessor.
+func (pa *PersistentActor) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	response, err := pa.processor.ProcessMessage(&persistentContext{IActorContext: actorCtx, actor: pa}, msg)
+	if len(pa.pending) == 0 {
+		return response, err
+	}
+
+	events := pa.pending
+	pa.pending = nil
+	id := pa.processor.PersistenceID()
+	ctx, cancel := context.WithTimeout(context.Background(), pa.journalTimeout)
+	defer cancel()
+	if appendErr := pa.journal.Append(ctx, id, pa.seq+1, events); appendErr != nil {
+		// The state already includes the events; restart so that recovery discards them.
+		panic(fmt.Errorf("persistence: failed to persist %d events of %s: %w", len(events), id, appendErr))
+	}
+	pa.seq += int64(len(events))
+
+	if pa.snapshotEvery > 0 && pa.seq-pa.lastSnapshot >= pa.snapshotEvery {
+		if snapErr := pa.journal.SaveSnapshot(ctx, id, pa.seq, pa.processor.Snapshot()); snapErr != nil {
+			log.Printf("PersistentActor %s failed to save snapshot at %d: %v", id, pa.seq, snapErr)
+		} else {
+			pa.lastSnapshot = pa.seq
+		}
+	}
+	return response, err
+}
+
+// persistentContext implements Context.
+type persistentContext struct {
+	actor.IActorContext
+	actor *PersistentActor
+}
+
+func (pc *persistentContext) Persist(events ...proto.Message) {
+	for _, event := range events {
+		if event == nil {
+			continue
+		}
+		pc.actor.processor.ApplyEvent(event)
+		pc.actor.pending = append(pc.actor.pending, event)
+	}
+}
+
+func (pc *persistentContext) LastSequenceNr() int64 {
+	return pc.actor.seq
+}
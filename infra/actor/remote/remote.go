@@ -0,0 +1,301 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/discovery"
+	"github.com/phuhao00/pandaparty/infra/network"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// RPC method names served by every process that enables remoting.
+const (
+	MethodTell = "Actor.Tell"
+	MethodAsk  = "Actor.Ask"
+	MethodStop = "Actor.Stop"
+)
+
+// defaultAskTimeout bounds how long the receiving side waits for its local actor to answer a remote Ask.
+const defaultAskTimeout = 5 * time.Second
+
+// Address locates an actor in another process.
+//
+// Service is either a direct "host:port" or a service name. When it is a service name,
+// Instance selects one registered instance by its instance ID (e.g. "gameserver-1"); an
+// empty Instance lets the RPC client pick any healthy instance.
+// The target actor is identified by Path if set, otherwise by ID.
+type Address struct {
+	Service  string
+	Instance string
+	ID       actor.ActorID
+	Path     string
+}
+
+func (a Address) String() string {
+	target := a.Path
+	if target == "" {
+		target = a.ID.String()
+	}
+	if a.Instance != "" {
+		return fmt.Sprintf("%s/%s%s", a.Service, a.Instance, target)
+	}
+	return a.Service + target
+}
+
+// Remote connects a local ActorSystem to the RPC layer. It serves Tell/Ask/Stop requests for
+// local actors and creates references to actors in other processes.
+//
+// Remote trusts every process that can reach its RPC server: any of them may Tell or Ask any
+// local actor. Keep the port on the internal network, or guard it with server interceptors
+// (see network.WithInterceptors and network.RequireMetadata). Stop requests are only served
+// for the actors passed to AllowStop.
+type Remote struct {
+	system     *actor.ActorSystem
+	client     *network.RPCClient
+	resolver   *discovery.Resolver
+	askTimeout time.Duration
+
+	stoppableMu sync.Mutex
+	stoppable   map[actor.IActor]bool // Local actors that peers may stop
+}
+
+// NewRemote registers the actor RPC handlers on server (which may be nil for a client-only
+// process) and uses client to reach other processes. resolver resolves Address.Instance
+// and can be nil if only direct "host:port" addresses are used.
+func NewRemote(system *actor.ActorSystem, server *network.RPCServer, client *network.RPCClient, resolver *discovery.Resolver) *Remote {
+	r := &Remote{
+		system:     system,
+		client:     client,
+		resolver:   resolver,
+		askTimeout: defaultAskTimeout,
+		stoppable:  make(map[actor.IActor]bool),
+	}
+	if server != nil {
+		server.Handle(MethodTell, r.handleTell)
+		server.Handle(MethodAsk, r.handleAsk)
+		server.RegisterHandler(MethodStop, r.handleStop)
+	}
+	return r
+}
+
+// AllowStop lets other processes stop target through RemoteActor.Stop. Stop requests for any
+// other local actor are refused.
+func (r *Remote) AllowStop(target actor.IActor) {
+	r.stoppableMu.Lock()
+	defer r.stoppableMu.Unlock()
+	r.stoppable[target] = true
+}
+
+// DisallowStop undoes AllowStop.
+func (r *Remote) DisallowStop(target actor.IActor) {
+	r.stoppableMu.Lock()
+	defer r.stoppableMu.Unlock()
+	delete(r.stoppable, target)
+}
+
+// ActorOf returns a reference to the actor at addr. No network traffic happens until the
+// reference is used, so a missing actor is only reported by Tell/Ask.
+func (r *Remote) ActorOf(addr Address) actor.IActor {
+	return &RemoteActor{remote: r, addr: addr}
+}
+
+// resolve turns an Address into something RPCClient.Call accepts.
+func (r *Remote) resolve(ctx context.Context, addr Address) (string, error) {
+	if addr.Instance == "" {
+		return addr.Service, nil
+	}
+	if _, _, err := net.SplitHostPort(addr.Service); err == nil {
+		return addr.Service, nil
+	}
+	if r.resolver == nil {
+		return "", fmt.Errorf("remote: cannot resolve instance %s of %s without a resolver", addr.Instance, addr.Service)
+	}
+	instances, err := r.resolver.Instances(ctx, addr.Service)
+	if err != nil {
+		return "", fmt.Errorf("remote: failed to resolve %s: %w", addr, err)
+	}
+	for _, instance := range instances {
+		if instance.ID == addr.Instance {
+			return instance.Addr(), nil
+		}
+	}
+	return "", fmt.Errorf("remote: no healthy instance %s of service %s", addr.Instance, addr.Service)
+}
+
+// call sends an envelope to addr and waits for the reply or for ctx to end.
+func (r *Remote) call(ctx context.Context, addr Address, method string, message proto.Message) (*pbactor.RemoteReply, error) {
+	endpoint, err := r.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &pbactor.RemoteEnvelope{TargetId: int64(addr.ID), TargetPath: addr.Path}
+	if message != nil {
+		if envelope.Message, err = anypb.New(message); err != nil {
+			return nil, fmt.Errorf("remote: failed to pack %T for %s: %w", message, addr, err)
+		}
+	}
+
+	reply := &pbactor.RemoteReply{}
+	if err := r.client.Call(ctx, endpoint, method, envelope, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// target finds the local actor addressed by an incoming envelope.
+func (r *Remote) target(envelope *pbactor.RemoteEnvelope) (actor.IActor, error) {
+	if envelope.TargetPath != "" {
+		if target, ok := r.system.FindByPath(envelope.TargetPath); ok {
+			return target, nil
+		}
+		return nil, fmt.Errorf("no actor at path %s", envelope.TargetPath)
+	}
+	if target, ok := r.system.FindByID(actor.ActorID(envelope.TargetId)); ok {
+		return target, nil
+	}
+	return nil, fmt.Errorf("no actor with ID %d", envelope.TargetId)
+}
+
+// decode unmarshals an incoming envelope and unpacks its message. The message type must be
+// linked into this process so that its descriptor is registered.
+func (r *Remote) decode(payload []byte) (actor.IActor, proto.Message, error) {
+	envelope := &pbactor.RemoteEnvelope{}
+	if err := proto.Unmarshal(payload, envelope); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal RemoteEnvelope: %w", err)
+	}
+	target, err := r.target(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	if envelope.Message == nil {
+		return nil, nil, errors.New("RemoteEnvelope has no message")
+	}
+	message, err := envelope.Message.UnmarshalNew()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unpack %s: %w", envelope.Message.GetTypeUrl(), err)
+	}
+	return target, message, nil
+}
+
+func (r *Remote) handleTell(ctx context.Context, payload []byte) ([]byte, error) {
+	target, message, err := r.decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	// The message outlives the RPC, so it keeps the caller's metadata but not its deadline.
+	if err := target.Tell(context.WithoutCancel(ctx), message); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&pbactor.RemoteReply{})
+}
+
+func (r *Remote) handleAsk(ctx context.Context, payload []byte) ([]byte, error) {
+	target, message, err := r.decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.askTimeout)
+	defer cancel()
+	response, err := target.Ask(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	reply := &pbactor.RemoteReply{}
+	if responseMsg, ok := response.(proto.Message); ok && responseMsg.ProtoReflect().IsValid() {
+		if reply.Message, err = anypb.New(responseMsg); err != nil {
+			return nil, fmt.Errorf("failed to pack reply %T: %w", responseMsg, err)
+		}
+	}
+	return proto.Marshal(reply)
+}
+
+func (r *Remote) handleStop(payload []byte) ([]byte, error) {
+	envelope := &pbactor.RemoteEnvelope{}
+	if err := proto.Unmarshal(payload, envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal RemoteEnvelope: %w", err)
+	}
+	target, err := r.target(envelope)
+	if err != nil {
+		return nil, err
+	}
+	r.stoppableMu.Lock()
+	allowed := r.stoppable[target]
+	delete(r.stoppable, target)
+	r.stoppableMu.Unlock()
+	if !allowed {
+		return nil, fmt.Errorf("actor %s (%d) cannot be stopped remotely", target.Name(), target.Id())
+	}
+	log.Printf("Remote: stopping actor %s (%d) on request", target.Name(), target.Id())
+	target.Stop()
+	return proto.Marshal(&pbactor.RemoteReply{})
+}
+
+// RemoteActor is an IActor that forwards every call to an actor in another process.
+type RemoteActor struct {
+	remote *Remote
+	addr   Address
+}
+
+// Address returns where the actor lives.
+func (ra *RemoteActor) Address() Address {
+	return ra.addr
+}
+
+// Id returns the ID in the address; it is zero for actors addressed by path.
+func (ra *RemoteActor) Id() actor.ActorID {
+	return ra.addr.ID
+}
+
+// Name returns the address in string form.
+func (ra *RemoteActor) Name() string {
+	return ra.addr.String()
+}
+
+// Tell delivers message to the remote actor's mailbox. It returns once the remote process has
+// accepted the message, not after it has been processed.
+func (ra *RemoteActor) Tell(ctx context.Context, message proto.Message) error {
+	if message == nil {
+		return errors.New("cannot Tell a nil message")
+	}
+	_, err := ra.remote.call(ctx, ra.addr, MethodTell, message)
+	return err
+}
+
+// Ask sends message to the remote actor and returns its reply as a proto.Message
+// (nil if the remote processor returned nil).
+func (ra *RemoteActor) Ask(ctx context.Context, message proto.Message) (interface{}, error) {
+	if message == nil {
+		return nil, errors.New("cannot Ask a nil message")
+	}
+	reply, err := ra.remote.call(ctx, ra.addr, MethodAsk, message)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Message == nil {
+		return nil, nil
+	}
+	response, err := reply.Message.UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("remote: failed to unpack reply %s from %s: %w", reply.Message.GetTypeUrl(), ra.addr, err)
+	}
+	return response, nil
+}
+
+// Stop asks the owning process to stop the actor. The process refuses unless it has passed the
+// actor to Remote.AllowStop; the refusal is only logged.
+func (ra *RemoteActor) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultAskTimeout)
+	defer cancel()
+	if _, err := ra.remote.call(ctx, ra.addr, MethodStop, nil); err != nil {
+		log.Printf("Remote: failed to stop %s: %v", ra.addr, err)
+	}
+}
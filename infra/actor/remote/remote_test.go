@@ -0,0 +1,187 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/discovery"
+	"github.com/phuhao00/pandaparty/infra/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// remoteNodeEnv makes the test binary act as the second process instead of running tests.
+const remoteNodeEnv = "PANDAPARTY_REMOTE_TEST_NODE"
+
+const playerActorID actor.ActorID = 42
+
+// playerProcessor replies to every string with a greeting that names its process.
+type playerProcessor struct{}
+
+func (playerProcessor) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	req, ok := msg.(*wrapperspb.StringValue)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message %T", msg)
+	}
+	return wrapperspb.String(fmt.Sprintf("%s from pid %d", req.Value, os.Getpid())), nil
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(remoteNodeEnv) == "1" {
+		runRemoteNode()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runRemoteNode hosts a player actor behind an RPCServer, prints the listen address and
+// serves until stdin is closed by the parent test.
+func runRemoteNode() {
+	system := actor.NewActorSystem("remote-node")
+	if _, err := system.SpawnWithID(playerActorID, "player-42", playerProcessor{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	server, _ := network.NewRPCServer(nil)
+	NewRemote(system, server, nil, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go server.Serve(listener)
+	fmt.Println(listener.Addr().String())
+
+	bufio.NewReader(os.Stdin).ReadString('\n') // Blocks until the parent closes stdin
+	server.Close()
+	system.Shutdown()
+}
+
+// startRemoteNode launches the second process and returns its RPC address.
+func startRemoteNode(t *testing.T) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), remoteNodeEnv+"=1")
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if _, _, err := net.SplitHostPort(line); err == nil {
+			go func() {
+				for scanner.Scan() { // Keep draining the child's log output
+				}
+			}()
+			return line
+		}
+	}
+	t.Fatalf("remote node exited before reporting its address: %v", scanner.Err())
+	return ""
+}
+
+func TestRemoteActor_AcrossProcesses(t *testing.T) {
+	addr := startRemoteNode(t)
+
+	client := network.NewRPCClient(nil, 2, 2*time.Second)
+	defer client.CloseAllConnections()
+	local := NewRemote(actor.NewActorSystem("local"), nil, client, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	byID := local.ActorOf(Address{Service: addr, ID: playerActorID})
+	reply, err := byID.Ask(ctx, wrapperspb.String("hello"))
+	require.NoError(t, err)
+	require.IsType(t, &wrapperspb.StringValue{}, reply)
+	greeting := reply.(*wrapperspb.StringValue).Value
+	assert.True(t, strings.HasPrefix(greeting, "hello from pid "))
+	assert.NotEqual(t, fmt.Sprintf("hello from pid %d", os.Getpid()), greeting, "reply must come from the other process")
+
+	byPath := local.ActorOf(Address{Service: addr, Path: "/player-42"})
+	require.NoError(t, byPath.Tell(ctx, wrapperspb.String("fire and forget")))
+
+	missing := local.ActorOf(Address{Service: addr, Path: "/nobody"})
+	_, err = missing.Ask(ctx, wrapperspb.String("hello"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no actor at path /nobody")
+}
+
+func TestRemoteActor_ResolvesInstances(t *testing.T) {
+	addr := startRemoteNode(t)
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	resolver := discovery.NewResolver(discovery.NewStaticRegistry(
+		discovery.Instance{ID: "players-1", Service: "players", Address: host, Port: portNum},
+	))
+	defer resolver.Close()
+
+	client := network.NewRPCClient(nil, 2, 2*time.Second)
+	defer client.CloseAllConnections()
+	local := NewRemote(actor.NewActorSystem("local"), nil, client, resolver)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	player := local.ActorOf(Address{Service: "players", Instance: "players-1", ID: playerActorID})
+	reply, err := player.Ask(ctx, wrapperspb.String("hello"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply.(*wrapperspb.StringValue).Value, "hello from pid "))
+
+	missing := local.ActorOf(Address{Service: "players", Instance: "players-2", ID: playerActorID})
+	_, err = missing.Ask(ctx, wrapperspb.String("hello"))
+	assert.ErrorContains(t, err, "no healthy instance players-2 of service players")
+}
+
+func TestRemote_StopOnlyAllowedActors(t *testing.T) {
+	system := actor.NewActorSystem(t.Name())
+	t.Cleanup(system.Shutdown)
+	server, err := network.NewRPCServer(nil)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Close()
+	owner := NewRemote(system, server, nil, nil)
+
+	player, err := system.SpawnWithID(playerActorID, "player-42", playerProcessor{})
+	require.NoError(t, err)
+	client := network.NewRPCClient(nil, 1, 2*time.Second)
+	defer client.CloseAllConnections()
+	peer := NewRemote(actor.NewActorSystem("peer"), nil, client, nil)
+	ref := peer.ActorOf(Address{Service: listener.Addr().String(), ID: playerActorID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = peer.call(ctx, ref.(*RemoteActor).Address(), MethodStop, nil)
+	assert.ErrorContains(t, err, "cannot be stopped remotely")
+	_, ok := system.FindByID(playerActorID)
+	assert.True(t, ok, "the actor must survive a stop it did not allow")
+
+	owner.AllowStop(player)
+	ref.Stop()
+	require.Eventually(t, func() bool {
+		_, ok := system.FindByID(playerActorID)
+		return !ok
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// Logic picks the routees a message goes to. Select is called concurrently by all senders
+// and must not modify routees.
+type Logic interface {
+	Select(msg proto.Message, routees []actor.IActor) []actor.IActor
+}
+
+// RoundRobin sends each message to the next routee in turn.
+func RoundRobin() Logic {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (l *roundRobin) Select(msg proto.Message, routees []actor.IActor) []actor.IActor {
+	if len(routees) == 0 {
+		return nil
+	}
+	i := (l.next.Add(1) - 1) % uint64(len(routees))
+	return routees[i : i+1]
+}
+
+// Random sends each message to a randomly chosen routee.
+func Random() Logic {
+	return randomLogic{}
+}
+
+type randomLogic struct{}
+
+func (randomLogic) Select(msg proto.Message, routees []actor.IActor) []actor.IActor {
+	if len(routees) == 0 {
+		return nil
+	}
+	i := rand.Intn(len(routees))
+	return routees[i : i+1]
+}
+
+// Broadcast sends every message to all routees. An Ask returns the first successful reply.
+func Broadcast() Logic {
+	return broadcastLogic{}
+}
+
+type broadcastLogic struct{}
+
+func (broadcastLogic) Select(msg proto.Message, routees []actor.IActor) []actor.IActor {
+	return routees
+}
+
+// ConsistentHash sends all messages with the same key to the same routee, e.g. all chat
+// messages of one conversation. Resizing the pool only moves the keys of added or removed
+// routees. key must not return "" for messages that need affinity.
+func ConsistentHash(key func(msg proto.Message) string) Logic {
+	return consistentHash{key: key}
+}
+
+type consistentHash struct {
+	key func(msg proto.Message) string
+}
+
+// Select uses rendezvous hashing: the routee with the highest hash of (routee, key) wins.
+func (l consistentHash) Select(msg proto.Message, routees []actor.IActor) []actor.IActor {
+	var best int
+	var bestScore uint64
+	key := l.key(msg)
+	for i, r := range routees {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s#%s", r.Name(), key)
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if len(routees) == 0 {
+		return nil
+	}
+	return routees[best : best+1]
+}
+
+// SmallestMailbox sends each message to the routee with the fewest queued messages.
+func SmallestMailbox() Logic {
+	return smallestMailbox{}
+}
+
+type smallestMailbox struct{}
+
+func (smallestMailbox) Select(msg proto.Message, routees []actor.IActor) []actor.IActor {
+	best, bestDepth := -1, 0
+	for i, r := range routees {
+		depth := 0
+		if d, ok := r.(interface{ MailboxDepth() int }); ok {
+			depth = d.MailboxDepth()
+		}
+		if best < 0 || depth < bestDepth {
+			best, bestDepth = i, depth
+		}
+		if depth == 0 {
+			break // Cannot do better than an empty mailbox
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return routees[best : best+1]
+}
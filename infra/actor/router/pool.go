@@ -0,0 +1,240 @@
+// Package router fronts a pool of identical actors with a single actor reference that
+// spreads messages over them.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// Pool is a router with a resizable pool of routees created from one processor factory.
+// It implements actor.IActor: Tell and Ask select routees with the pool's Logic in the
+// caller's goroutine, so routing adds no extra mailbox hop.
+//
+// The routees are children of a pool actor at "/<name>", named "routee-0", "routee-1", ...
+// They are restarted with a fresh processor from the factory when they fail. A routee that
+// stops for good is removed until the next Resize.
+type Pool struct {
+	name    string
+	logic   Logic
+	head    *actor.Actor
+	routees atomic.Pointer[[]actor.IActor] // Sorted by index; replaced, never modified
+}
+
+// NewPool spawns a pool of size routees in system. opts apply to every routee, e.g.
+// actor.WithMailbox.
+func NewPool(system *actor.ActorSystem, name string, size int, logic Logic, factory func() actor.ActorProcessor, opts ...actor.Option) (*Pool, error) {
+	if logic == nil || factory == nil {
+		return nil, errors.New("router: pool needs a Logic and a processor factory")
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("router: invalid pool size %d", size)
+	}
+	p := &Pool{name: name, logic: logic}
+	p.routees.Store(&[]actor.IActor{})
+	head := &poolHead{
+		pool:    p,
+		factory: factory,
+		opts:    append([]actor.Option{actor.WithProducer(factory)}, opts...),
+	}
+	var err error
+	if p.head, err = system.Spawn(name, head); err != nil {
+		return nil, fmt.Errorf("router: failed to spawn pool %s: %w", name, err)
+	}
+	if err := p.Resize(context.Background(), size); err != nil {
+		p.Stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Id returns the ID of the pool actor.
+func (p *Pool) Id() actor.ActorID {
+	return p.head.Id()
+}
+
+// Name returns the pool name.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Path returns the path of the pool actor.
+func (p *Pool) Path() string {
+	return p.head.Path()
+}
+
+// Routees returns the current routees.
+func (p *Pool) Routees() []actor.IActor {
+	return append([]actor.IActor(nil), *p.routees.Load()...)
+}
+
+// Size returns the current number of routees.
+func (p *Pool) Size() int {
+	return len(*p.routees.Load())
+}
+
+// Resize grows or shrinks the pool to size routees and waits until it is done. Removed
+// routees are stopped; messages still in their mailboxes go to the dead letters.
+func (p *Pool) Resize(ctx context.Context, size int) error {
+	if size < 0 {
+		return fmt.Errorf("router: invalid pool size %d", size)
+	}
+	_, err := p.head.Ask(ctx, &pbactor.RouterResize{Size: int32(size)})
+	return err
+}
+
+// Tell routes message to the routees selected by the pool's Logic.
+func (p *Pool) Tell(ctx context.Context, message proto.Message) error {
+	targets := p.logic.Select(message, *p.routees.Load())
+	if len(targets) == 0 {
+		return fmt.Errorf("router: pool %s has no routees", p.name)
+	}
+	var errs []error
+	for _, target := range targets {
+		if err := target.Tell(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ask routes message like Tell and waits for the reply. If the Logic selects several
+// routees, the first successful reply wins.
+func (p *Pool) Ask(ctx context.Context, message proto.Message) (interface{}, error) {
+	targets := p.logic.Select(message, *p.routees.Load())
+	switch len(targets) {
+	case 0:
+		return nil, fmt.Errorf("router: pool %s has no routees", p.name)
+	case 1:
+		return targets[0].Ask(ctx, message)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Abandon the slower routees once a reply is in
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	results := make(chan result, len(targets))
+	for _, target := range targets {
+		go func(target actor.IActor) {
+			reply, err := target.Ask(ctx, message)
+			results <- result{reply, err}
+		}(target)
+	}
+	var lastErr error
+	for range targets {
+		r := <-results
+		if r.err == nil {
+			return r.reply, nil
+		}
+		lastErr = r.err
+	}
+	return nil, lastErr
+}
+
+// Stop stops the pool actor and all routees.
+func (p *Pool) Stop() {
+	p.head.Stop()
+}
+
+// poolHead is the processor of the pool actor. It owns the routees and publishes the
+// routee list to the Pool whenever it changes.
+type poolHead struct {
+	pool    *Pool
+	size    int
+	factory func() actor.ActorProcessor
+	opts    []actor.Option
+	routees map[int]actor.IActor // By index in the routee name
+}
+
+func routeeName(i int) string {
+	return fmt.Sprintf("routee-%d", i)
+}
+
+// PreStart respawns the routees after a restart, which stops the children. On the first
+// start the pool is empty until NewPool resizes it.
+func (h *poolHead) PreStart(actorCtx actor.IActorContext) {
+	h.routees = make(map[int]actor.IActor)
+	if err := h.resize(actorCtx, h.size); err != nil {
+		log.Printf("Router %s started with %d of %d routees: %v", h.pool.name, len(h.routees), h.size, err)
+	}
+}
+
+func (h *poolHead) PostStop(actorCtx actor.IActorContext) {
+	h.pool.routees.Store(&[]actor.IActor{})
+}
+
+func (h *poolHead) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	switch m := msg.(type) {
+	case *pbactor.RouterResize:
+		h.size = int(m.Size)
+		return nil, h.resize(actorCtx, h.size)
+	case *pbactor.Terminated:
+		for i, routee := range h.routees {
+			if routee.Id() == actor.ActorID(m.ActorId) {
+				log.Printf("Router %s lost routee %s: %s", h.pool.name, m.Name, m.Reason)
+				delete(h.routees, i)
+				h.publish()
+			}
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("router: pool %s cannot handle %T; send it through the Pool", h.pool.name, msg)
+	}
+}
+
+// resize spawns missing routees below size and stops those at or above it.
+func (h *poolHead) resize(actorCtx actor.IActorContext, size int) error {
+	var errs []error
+	for i := 0; i < size; i++ {
+		if _, ok := h.routees[i]; ok {
+			continue
+		}
+		routee, err := actorCtx.SpawnChild(routeeName(i), h.factory(), h.opts...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := actorCtx.Watch(routee); err != nil {
+			errs = append(errs, err)
+		}
+		h.routees[i] = routee
+	}
+
+	var removed []actor.IActor
+	for i, routee := range h.routees {
+		if i >= size {
+			delete(h.routees, i)
+			removed = append(removed, routee)
+		}
+	}
+	h.publish() // Stop routing to removed routees before stopping them
+	for _, routee := range removed {
+		_ = actorCtx.Unwatch(routee)
+		routee.Stop()
+	}
+	return errors.Join(errs...)
+}
+
+// publish makes the current routees visible to senders.
+func (h *poolHead) publish() {
+	indexes := make([]int, 0, len(h.routees))
+	for i := range h.routees {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	routees := make([]actor.IActor, 0, len(indexes))
+	for _, i := range indexes {
+		routees = append(routees, h.routees[i])
+	}
+	h.pool.routees.Store(&routees)
+}
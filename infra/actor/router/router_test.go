@@ -0,0 +1,107 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoProcessor replies with the name of the routee that handled the message.
+type echoProcessor struct {
+	mu   *sync.Mutex
+	seen map[string]int
+}
+
+func (p *echoProcessor) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	name := actorCtx.Self().Name()
+	p.mu.Lock()
+	p.seen[name]++
+	p.mu.Unlock()
+	return wrapperspb.String(name), nil
+}
+
+func newTestPool(t *testing.T, size int, logic Logic) (*Pool, func() map[string]int) {
+	system := actor.NewActorSystem("router-test")
+	t.Cleanup(system.Shutdown)
+	var mu sync.Mutex
+	seen := map[string]int{}
+	pool, err := NewPool(system, "workers", size, logic, func() actor.ActorProcessor {
+		return &echoProcessor{mu: &mu, seen: seen}
+	})
+	require.NoError(t, err)
+	snapshot := func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]int, len(seen))
+		for k, v := range seen {
+			copied[k] = v
+		}
+		return copied
+	}
+	return pool, snapshot
+}
+
+func ask(t *testing.T, pool *Pool, msg proto.Message) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := pool.Ask(ctx, msg)
+	require.NoError(t, err)
+	return reply.(*wrapperspb.StringValue).Value
+}
+
+func TestPool_RoundRobinAndResize(t *testing.T) {
+	pool, seen := newTestPool(t, 3, RoundRobin())
+	require.Equal(t, 3, pool.Size())
+	for i := 0; i < 6; i++ {
+		ask(t, pool, wrapperspb.String("work"))
+	}
+	assert.Equal(t, map[string]int{"routee-0": 2, "routee-1": 2, "routee-2": 2}, seen())
+
+	require.NoError(t, pool.Resize(context.Background(), 5))
+	assert.Equal(t, 5, pool.Size())
+	require.NoError(t, pool.Resize(context.Background(), 1))
+	require.Equal(t, 1, pool.Size())
+	assert.Equal(t, "routee-0", ask(t, pool, wrapperspb.String("work")))
+}
+
+func TestPool_ConsistentHash(t *testing.T) {
+	key := func(msg proto.Message) string { return msg.(*wrapperspb.StringValue).Value }
+	pool, _ := newTestPool(t, 4, ConsistentHash(key))
+
+	owners := map[string]string{}
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("conversation-%d", i)
+		owners[k] = ask(t, pool, wrapperspb.String(k))
+		assert.Equal(t, owners[k], ask(t, pool, wrapperspb.String(k)))
+	}
+
+	// Growing the pool only moves keys to the new routee.
+	require.NoError(t, pool.Resize(context.Background(), 5))
+	for k, owner := range owners {
+		if now := ask(t, pool, wrapperspb.String(k)); now != owner {
+			assert.Equal(t, "routee-4", now)
+		}
+	}
+}
+
+func TestPool_Broadcast(t *testing.T) {
+	pool, seen := newTestPool(t, 3, Broadcast())
+	require.NoError(t, pool.Tell(context.Background(), wrapperspb.String("announce")))
+	assert.Eventually(t, func() bool { return len(seen()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Regexp(t, `^routee-\d$`, ask(t, pool, wrapperspb.String("first")))
+}
+
+func TestPool_SmallestMailboxAndRandom(t *testing.T) {
+	for _, logic := range []Logic{SmallestMailbox(), Random()} {
+		pool, _ := newTestPool(t, 2, logic)
+		assert.Regexp(t, `^routee-\d$`, ask(t, pool, wrapperspb.String("work")))
+	}
+}
@@ -0,0 +1,92 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/phuhao00/pandaparty/infra/discovery"
+)
+
+// Member is one process that can own shards.
+type Member struct {
+	ID      string // Unique member ID, normally the instance ID (e.g. "roomserver-1")
+	Address string // "host:port" of the member's RPCServer
+}
+
+// MembershipProvider lists the members that shards may be placed on.
+type MembershipProvider interface {
+	Members() ([]Member, error)
+}
+
+// DiscoveryMembership takes the members from the healthy instances of a service, as seen by a
+// discovery.Resolver.
+type DiscoveryMembership struct {
+	resolver    *discovery.Resolver
+	serviceName string
+}
+
+// NewDiscoveryMembership creates a provider for the instances of serviceName.
+func NewDiscoveryMembership(resolver *discovery.Resolver, serviceName string) *DiscoveryMembership {
+	return &DiscoveryMembership{resolver: resolver, serviceName: serviceName}
+}
+
+// Members implements MembershipProvider.
+func (d *DiscoveryMembership) Members() ([]Member, error) {
+	if d.resolver == nil {
+		return nil, fmt.Errorf("sharding: resolver is not initialized")
+	}
+	instances, err := d.resolver.Instances(context.Background(), d.serviceName)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(instances))
+	for _, instance := range instances {
+		members = append(members, Member{ID: instance.ID, Address: instance.Addr()})
+	}
+	return members, nil
+}
+
+// StaticMembership is a fixed member list that can be changed at runtime.
+// It is meant for tests and for deployments without service discovery.
+type StaticMembership struct {
+	mu      sync.RWMutex
+	members []Member
+}
+
+// NewStaticMembership creates a provider returning members.
+func NewStaticMembership(members ...Member) *StaticMembership {
+	return &StaticMembership{members: members}
+}
+
+// Set replaces the member list.
+func (s *StaticMembership) Set(members ...Member) {
+	s.mu.Lock()
+	s.members = members
+	s.mu.Unlock()
+}
+
+// Members implements MembershipProvider.
+func (s *StaticMembership) Members() ([]Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make([]Member, len(s.members))
+	copy(members, s.members)
+	return members, nil
+}
+
+// sortMembers orders members by ID so every region computes the same placement.
+func sortMembers(members []Member) []Member {
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+func containsMember(members []Member, id string) bool {
+	for _, m := range members {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
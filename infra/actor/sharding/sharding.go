@@ -0,0 +1,599 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/network"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Default values for Config.
+const (
+	defaultNumShards         = 64
+	defaultRebalanceInterval = 5 * time.Second
+	defaultHandOffTimeout    = 10 * time.Second
+	defaultBufferSize        = 1000
+	defaultAskTimeout        = 5 * time.Second
+	maxHops                  = 3 // Forwarding limit for envelopes that meet a stale ownership view
+)
+
+// EntityFactory creates the processor for a newly started entity.
+type EntityFactory func(entityID string) actor.ActorProcessor
+
+// Config describes one sharded entity type, e.g. rooms.
+type Config struct {
+	TypeName          string             // Entity type, e.g. "room". Used in actor names and RPC method names.
+	SelfID            string             // This member's ID; must match the ID it has in Membership.
+	Membership        MembershipProvider // Source of the members shards are placed on.
+	NumShards         int                // Number of shards. Must be the same on every member. Default 64.
+	RebalanceInterval time.Duration      // How often membership is refreshed. Default 5s.
+	HandOffTimeout    time.Duration      // How long a new owner waits for the previous owner's hand-off. Default 10s.
+	BufferSize        int                // Messages buffered per shard while it migrates. Default 1000.
+	EntityOptions     []actor.Option     // Options for every entity actor.
+}
+
+// shardState is the local view of one shard.
+type shardState int
+
+const (
+	shardRemote     shardState = iota // Owned by another member; messages are forwarded
+	shardActive                       // Owned by this member; entities run locally
+	shardHandingOff                   // Moving away; entities are being stopped and messages are buffered
+	shardAcquiring                    // Moving here; waiting for the previous owner to release it and buffering messages
+)
+
+type shard struct {
+	id       int
+	state    shardState
+	owner    Member
+	entities map[string]actor.IActor
+	buffer   []*pendingMessage
+	previous Member    // For shardAcquiring: the member expected to release the shard
+	deadline time.Time // For shardAcquiring: when to stop waiting for the release
+}
+
+// pendingMessage is a message buffered while its shard migrates.
+type pendingMessage struct {
+	ctx      context.Context
+	entityID string
+	message  proto.Message
+	hops     int32
+	result   chan askResult // Non-nil for Ask
+}
+
+type askResult struct {
+	response interface{}
+	err      error
+}
+
+// ShardRegion runs the entities of one type whose shards are owned by this member and routes
+// messages for all other entities to their owners. Every member hosting the type runs one
+// ShardRegion with the same TypeName and NumShards.
+//
+// Shards are placed with rendezvous hashing over the sorted member list, so every region
+// computes the same placement independently and a membership change only moves the shards of
+// the members that joined or left. When a shard moves, the old owner stops its entities and
+// sends a hand-off; the new owner buffers messages for the shard until the hand-off arrives or
+// HandOffTimeout passes (e.g. because the old owner crashed). Because the old owner may notice
+// the change later than the new one, the new owner also asks it on every rebalance whether it
+// has already released the shard.
+type ShardRegion struct {
+	cfg     Config
+	factory EntityFactory
+	system  *actor.ActorSystem
+	client  *network.RPCClient
+
+	mu        sync.Mutex
+	members   []Member
+	shards    []*shard
+	handedOff map[int]time.Time // Hand-offs received before this region noticed it owns the shard
+	started   bool
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewShardRegion creates a region and registers its RPC handlers on server.
+// Call Start to compute the initial placement and begin rebalancing.
+func NewShardRegion(cfg Config, factory EntityFactory, system *actor.ActorSystem, server *network.RPCServer, client *network.RPCClient) (*ShardRegion, error) {
+	if cfg.TypeName == "" || cfg.SelfID == "" {
+		return nil, errors.New("sharding: TypeName and SelfID are required")
+	}
+	if cfg.Membership == nil || factory == nil || system == nil || server == nil || client == nil {
+		return nil, errors.New("sharding: Membership, factory, system, server and client are required")
+	}
+	if cfg.NumShards <= 0 {
+		cfg.NumShards = defaultNumShards
+	}
+	if cfg.RebalanceInterval <= 0 {
+		cfg.RebalanceInterval = defaultRebalanceInterval
+	}
+	if cfg.HandOffTimeout <= 0 {
+		cfg.HandOffTimeout = defaultHandOffTimeout
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultBufferSize
+	}
+
+	r := &ShardRegion{
+		cfg:       cfg,
+		factory:   factory,
+		system:    system,
+		client:    client,
+		shards:    make([]*shard, cfg.NumShards),
+		handedOff: make(map[int]time.Time),
+		stopCh:    make(chan struct{}),
+	}
+	for i := range r.shards {
+		r.shards[i] = &shard{id: i, entities: make(map[string]actor.IActor)}
+	}
+	server.Handle(r.method("Tell"), r.handleTell)
+	server.Handle(r.method("Ask"), r.handleAsk)
+	server.RegisterHandler(r.method("HandOff"), r.handleHandOff)
+	server.RegisterHandler(r.method("Release"), r.handleRelease)
+	return r, nil
+}
+
+func (r *ShardRegion) method(name string) string {
+	return "Sharding." + r.cfg.TypeName + "." + name
+}
+
+// Start computes the initial placement and rebalances every RebalanceInterval until Stop.
+func (r *ShardRegion) Start() error {
+	if err := r.Rebalance(); err != nil {
+		return err
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cfg.RebalanceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Rebalance(); err != nil {
+					log.Printf("ShardRegion %s: rebalance failed, keeping previous placement: %v", r.cfg.TypeName, err)
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends rebalancing and stops the local entities. Deregister the member from the
+// membership source first so that other regions take over its shards without waiting.
+func (r *ShardRegion) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	r.wg.Wait()
+
+	r.mu.Lock()
+	var entities []actor.IActor
+	for _, sh := range r.shards {
+		for _, entity := range sh.entities {
+			entities = append(entities, entity)
+		}
+		sh.entities = make(map[string]actor.IActor)
+		sh.state = shardRemote
+	}
+	r.mu.Unlock()
+	for _, entity := range entities {
+		entity.Stop()
+	}
+}
+
+// Rebalance refreshes the member list and moves shards whose owner changed. Hand-offs of
+// shards leaving this member complete before it returns.
+func (r *ShardRegion) Rebalance() error {
+	members, err := r.cfg.Membership.Members()
+	if err != nil {
+		return fmt.Errorf("sharding: failed to list members: %w", err)
+	}
+	if len(members) == 0 {
+		return errors.New("sharding: no members available")
+	}
+	members = sortMembers(members)
+	others := make([]Member, 0, len(members))
+	for _, m := range members {
+		if m.ID != r.cfg.SelfID {
+			others = append(others, m)
+		}
+	}
+
+	now := time.Now()
+	var activated, handingOff, lost, acquiring []*shard
+
+	r.mu.Lock()
+	first := !r.started
+	r.started = true
+	for _, sh := range r.shards {
+		newOwner := placement(sh.id, members)
+		if newOwner.ID == r.cfg.SelfID {
+			switch sh.state {
+			case shardRemote:
+				previous := sh.owner
+				if first && len(others) > 0 {
+					previous = placement(sh.id, others)
+				}
+				handedOff, ok := r.handedOff[sh.id]
+				if (ok && now.Sub(handedOff) <= r.cfg.HandOffTimeout) || previous.ID == "" || !containsMember(members, previous.ID) {
+					sh.state = shardActive
+					activated = append(activated, sh)
+				} else {
+					sh.state = shardAcquiring
+					sh.previous = previous
+					sh.deadline = now.Add(r.cfg.HandOffTimeout)
+					acquiring = append(acquiring, sh)
+				}
+				delete(r.handedOff, sh.id)
+			case shardAcquiring:
+				if now.After(sh.deadline) || !containsMember(members, sh.previous.ID) {
+					log.Printf("ShardRegion %s: no hand-off for shard %d from %s, taking it over.", r.cfg.TypeName, sh.id, sh.previous.ID)
+					sh.state = shardActive
+					activated = append(activated, sh)
+				} else {
+					acquiring = append(acquiring, sh)
+				}
+			}
+		} else {
+			switch sh.state {
+			case shardActive:
+				sh.state = shardHandingOff
+				handingOff = append(handingOff, sh)
+			case shardAcquiring:
+				sh.state = shardRemote
+				lost = append(lost, sh)
+			}
+		}
+		sh.owner = newOwner
+	}
+	r.members = members
+	r.mu.Unlock()
+
+	for _, sh := range activated {
+		r.flush(sh)
+	}
+	for _, sh := range lost {
+		r.flush(sh)
+	}
+	var wg sync.WaitGroup
+	for _, sh := range handingOff {
+		wg.Add(1)
+		go func(sh *shard) {
+			defer wg.Done()
+			r.handOff(sh)
+		}(sh)
+	}
+	for _, sh := range acquiring {
+		wg.Add(1)
+		go func(sh *shard) {
+			defer wg.Done()
+			r.requestRelease(sh)
+		}(sh)
+	}
+	wg.Wait()
+	return nil
+}
+
+// requestRelease asks the previous owner of an acquiring shard whether it has released it,
+// and activates the shard if so.
+func (r *ShardRegion) requestRelease(sh *shard) {
+	r.mu.Lock()
+	previous := sh.previous
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.HandOffTimeout)
+	defer cancel()
+	request := &pbactor.ShardHandOff{ShardId: int32(sh.id), FromMember: r.cfg.SelfID}
+	if err := r.call(ctx, previous.Address, r.method("Release"), request, &pbactor.ShardHandOff{}); err != nil {
+		return // Not released yet or unreachable; the hand-off or the timeout will activate the shard.
+	}
+	r.activate(sh, previous.ID)
+}
+
+// activate makes an acquiring shard active once from has released it, and flushes its buffer.
+func (r *ShardRegion) activate(sh *shard, from string) {
+	r.mu.Lock()
+	activated := sh.state == shardAcquiring && sh.previous.ID == from
+	if activated {
+		sh.state = shardActive
+	}
+	r.mu.Unlock()
+	if activated {
+		log.Printf("ShardRegion %s: shard %d released by %s, now active.", r.cfg.TypeName, sh.id, from)
+		r.flush(sh)
+	}
+}
+
+// handOff stops the entities of a shard that moved away and tells the new owner.
+func (r *ShardRegion) handOff(sh *shard) {
+	r.mu.Lock()
+	entities := sh.entities
+	sh.entities = make(map[string]actor.IActor)
+	r.mu.Unlock()
+
+	for _, entity := range entities {
+		entity.Stop()
+	}
+
+	r.mu.Lock()
+	owner := sh.owner
+	if owner.ID == r.cfg.SelfID {
+		sh.state = shardActive // The shard came back while its entities were stopping
+	} else {
+		sh.state = shardRemote
+	}
+	r.mu.Unlock()
+
+	if owner.ID != r.cfg.SelfID {
+		log.Printf("ShardRegion %s: handing off shard %d (%d entities) to %s", r.cfg.TypeName, sh.id, len(entities), owner.ID)
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.HandOffTimeout)
+		err := r.call(ctx, owner.Address, r.method("HandOff"), &pbactor.ShardHandOff{ShardId: int32(sh.id), FromMember: r.cfg.SelfID}, &pbactor.RemoteReply{})
+		cancel()
+		if err != nil {
+			log.Printf("ShardRegion %s: hand-off of shard %d to %s failed, it will take over after its timeout: %v", r.cfg.TypeName, sh.id, owner.ID, err)
+		}
+	}
+	r.flush(sh)
+}
+
+// flush re-routes the messages buffered for a shard, in arrival order.
+func (r *ShardRegion) flush(sh *shard) {
+	r.mu.Lock()
+	buffered := sh.buffer
+	sh.buffer = nil
+	r.mu.Unlock()
+	if len(buffered) == 0 {
+		return
+	}
+	go func() {
+		for _, p := range buffered {
+			response, err := r.deliver(p.ctx, p.entityID, p.message, p.result != nil, p.hops)
+			if p.result != nil {
+				p.result <- askResult{response: response, err: err}
+			} else if err != nil {
+				log.Printf("ShardRegion %s: failed to deliver buffered %T to entity %s: %v", r.cfg.TypeName, p.message, p.entityID, err)
+			}
+		}
+	}()
+}
+
+// Tell sends message to an entity wherever it lives, starting the entity if needed.
+// While the entity's shard migrates the message is buffered and Tell returns nil.
+func (r *ShardRegion) Tell(ctx context.Context, entityID string, message proto.Message) error {
+	if message == nil {
+		return errors.New("cannot Tell a nil message")
+	}
+	_, err := r.deliver(ctx, entityID, message, false, 0)
+	return err
+}
+
+// Ask sends message to an entity wherever it lives and waits for its reply.
+func (r *ShardRegion) Ask(ctx context.Context, entityID string, message proto.Message) (interface{}, error) {
+	if message == nil {
+		return nil, errors.New("cannot Ask a nil message")
+	}
+	return r.deliver(ctx, entityID, message, true, 0)
+}
+
+// OwnerOf reports which member currently owns the shard of entityID, according to this region.
+func (r *ShardRegion) OwnerOf(entityID string) (Member, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	owner := r.shards[r.shardOf(entityID)].owner
+	return owner, owner.ID != ""
+}
+
+func (r *ShardRegion) deliver(ctx context.Context, entityID string, message proto.Message, ask bool, hops int32) (interface{}, error) {
+	r.mu.Lock()
+	sh := r.shards[r.shardOf(entityID)]
+	switch sh.state {
+	case shardActive:
+		entity, err := r.entityLocked(sh, entityID)
+		r.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if ask {
+			return entity.Ask(ctx, message)
+		}
+		return nil, entity.Tell(ctx, message)
+
+	case shardRemote:
+		owner := sh.owner
+		r.mu.Unlock()
+		if owner.ID == "" {
+			return nil, fmt.Errorf("sharding: shard %d of %s has no owner yet", sh.id, r.cfg.TypeName)
+		}
+		if hops >= maxHops {
+			return nil, fmt.Errorf("sharding: entity %s of %s forwarded %d times without reaching its owner", entityID, r.cfg.TypeName, hops)
+		}
+		return r.forward(ctx, owner, entityID, message, ask, hops)
+
+	default: // Migrating; buffer until the shard settles.
+		if len(sh.buffer) >= r.cfg.BufferSize {
+			r.mu.Unlock()
+			return nil, fmt.Errorf("sharding: buffer of shard %d of %s is full", sh.id, r.cfg.TypeName)
+		}
+		p := &pendingMessage{ctx: ctx, entityID: entityID, message: message, hops: hops}
+		if ask {
+			p.result = make(chan askResult, 1)
+		}
+		sh.buffer = append(sh.buffer, p)
+		r.mu.Unlock()
+		if !ask {
+			return nil, nil
+		}
+		select {
+		case res := <-p.result:
+			return res.response, res.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// entityLocked returns the running entity actor, starting it if needed. r.mu must be held.
+func (r *ShardRegion) entityLocked(sh *shard, entityID string) (actor.IActor, error) {
+	if entity, ok := sh.entities[entityID]; ok {
+		if _, alive := r.system.FindByID(entity.Id()); alive {
+			return entity, nil
+		}
+		delete(sh.entities, entityID)
+	}
+	entity, err := r.system.Spawn(r.cfg.TypeName+"-"+entityID, r.factory(entityID), r.cfg.EntityOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("sharding: failed to start entity %s of %s: %w", entityID, r.cfg.TypeName, err)
+	}
+	sh.entities[entityID] = entity
+	return entity, nil
+}
+
+func (r *ShardRegion) forward(ctx context.Context, owner Member, entityID string, message proto.Message, ask bool, hops int32) (interface{}, error) {
+	packed, err := anypb.New(message)
+	if err != nil {
+		return nil, fmt.Errorf("sharding: failed to pack %T: %w", message, err)
+	}
+	envelope := &pbactor.ShardEnvelope{EntityId: entityID, Message: packed, Ask: ask, Hops: hops + 1}
+	method := r.method("Tell")
+	if ask {
+		method = r.method("Ask")
+	}
+	reply := &pbactor.RemoteReply{}
+	if err := r.call(ctx, owner.Address, method, envelope, reply); err != nil {
+		return nil, err
+	}
+	if !ask || reply.Message == nil {
+		return nil, nil
+	}
+	return reply.Message.UnmarshalNew()
+}
+
+// call runs an RPC to address and gives up when ctx ends.
+func (r *ShardRegion) call(ctx context.Context, address, method string, request, response proto.Message) error {
+	return r.client.Call(ctx, address, method, request, response)
+}
+
+func (r *ShardRegion) decodeEnvelope(payload []byte) (*pbactor.ShardEnvelope, proto.Message, error) {
+	envelope := &pbactor.ShardEnvelope{}
+	if err := proto.Unmarshal(payload, envelope); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal ShardEnvelope: %w", err)
+	}
+	if envelope.Message == nil {
+		return nil, nil, errors.New("ShardEnvelope has no message")
+	}
+	message, err := envelope.Message.UnmarshalNew()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unpack %s: %w", envelope.Message.GetTypeUrl(), err)
+	}
+	return envelope, message, nil
+}
+
+func (r *ShardRegion) handleTell(ctx context.Context, payload []byte) ([]byte, error) {
+	envelope, message, err := r.decodeEnvelope(payload)
+	if err != nil {
+		return nil, err
+	}
+	// The message outlives the RPC, so it keeps the caller's metadata but not its deadline.
+	if _, err := r.deliver(context.WithoutCancel(ctx), envelope.EntityId, message, false, envelope.Hops); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&pbactor.RemoteReply{})
+}
+
+func (r *ShardRegion) handleAsk(ctx context.Context, payload []byte) ([]byte, error) {
+	envelope, message, err := r.decodeEnvelope(payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultAskTimeout)
+	defer cancel()
+	response, err := r.deliver(ctx, envelope.EntityId, message, true, envelope.Hops)
+	if err != nil {
+		return nil, err
+	}
+	reply := &pbactor.RemoteReply{}
+	if responseMsg, ok := response.(proto.Message); ok && responseMsg.ProtoReflect().IsValid() {
+		if reply.Message, err = anypb.New(responseMsg); err != nil {
+			return nil, fmt.Errorf("failed to pack reply %T: %w", responseMsg, err)
+		}
+	}
+	return proto.Marshal(reply)
+}
+
+func (r *ShardRegion) handleHandOff(payload []byte) ([]byte, error) {
+	handOff := &pbactor.ShardHandOff{}
+	if err := proto.Unmarshal(payload, handOff); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ShardHandOff: %w", err)
+	}
+	id := int(handOff.ShardId)
+	if id < 0 || id >= len(r.shards) {
+		return nil, fmt.Errorf("shard %d out of range [0, %d)", id, len(r.shards))
+	}
+
+	sh := r.shards[id]
+	r.mu.Lock()
+	if sh.state == shardRemote {
+		// The previous owner noticed the membership change first; remember the hand-off.
+		r.handedOff[id] = time.Now()
+	}
+	r.mu.Unlock()
+
+	log.Printf("ShardRegion %s: received hand-off of shard %d from %s", r.cfg.TypeName, id, handOff.FromMember)
+	r.activate(sh, handOff.FromMember)
+	return proto.Marshal(&pbactor.RemoteReply{})
+}
+
+// handleRelease answers a new owner asking whether this member still runs a shard.
+// It succeeds only once the shard's entities are stopped.
+func (r *ShardRegion) handleRelease(payload []byte) ([]byte, error) {
+	request := &pbactor.ShardHandOff{}
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ShardHandOff: %w", err)
+	}
+	id := int(request.ShardId)
+	if id < 0 || id >= len(r.shards) {
+		return nil, fmt.Errorf("shard %d out of range [0, %d)", id, len(r.shards))
+	}
+	r.mu.Lock()
+	state := r.shards[id].state
+	r.mu.Unlock()
+	if state != shardRemote {
+		return nil, fmt.Errorf("shard %d is still held by %s", id, r.cfg.SelfID)
+	}
+	return proto.Marshal(&pbactor.ShardHandOff{ShardId: request.ShardId, FromMember: r.cfg.SelfID})
+}
+
+// shardOf maps an entity ID to its shard.
+func (r *ShardRegion) shardOf(entityID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(entityID))
+	return int(h.Sum32() % uint32(len(r.shards)))
+}
+
+// placement picks the owner of a shard with rendezvous (highest random weight) hashing.
+func placement(shardID int, members []Member) Member {
+	var best Member
+	var bestScore uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s#%d", m.ID, shardID)
+		if score := h.Sum64(); best.ID == "" || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
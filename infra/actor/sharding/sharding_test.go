@@ -0,0 +1,170 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/discovery"
+	"github.com/phuhao00/pandaparty/infra/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// roomProcessor answers every message with the member hosting it and the number of
+// messages this incarnation of the entity has seen.
+type roomProcessor struct {
+	member string
+	seen   int
+}
+
+func (p *roomProcessor) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	p.seen++
+	return wrapperspb.String(fmt.Sprintf("%s:%d", p.member, p.seen)), nil
+}
+
+type testNode struct {
+	member Member
+	region *ShardRegion
+	system *actor.ActorSystem
+}
+
+func startTestNode(t *testing.T, id string, membership MembershipProvider, listener net.Listener) *testNode {
+	server, err := network.NewRPCServer(nil)
+	require.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	client := network.NewRPCClient(nil, 4, time.Second)
+	t.Cleanup(client.CloseAllConnections)
+
+	system := actor.NewActorSystem(id)
+	t.Cleanup(system.Shutdown)
+
+	cfg := Config{
+		TypeName:          "room",
+		SelfID:            id,
+		Membership:        membership,
+		NumShards:         16,
+		RebalanceInterval: time.Hour, // Tests drive Rebalance explicitly
+		HandOffTimeout:    2 * time.Second,
+	}
+	factory := func(entityID string) actor.ActorProcessor { return &roomProcessor{member: id} }
+	region, err := NewShardRegion(cfg, factory, system, server, client)
+	require.NoError(t, err)
+	t.Cleanup(region.Stop)
+	return &testNode{member: Member{ID: id, Address: listener.Addr().String()}, region: region, system: system}
+}
+
+func newListener(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return listener
+}
+
+func askString(t *testing.T, region *ShardRegion, entityID string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	reply, err := region.Ask(ctx, entityID, wrapperspb.String("ping"))
+	require.NoError(t, err)
+	return reply.(*wrapperspb.StringValue).Value
+}
+
+func TestShardRegion_RoutesAndRebalances(t *testing.T) {
+	membership := NewStaticMembership()
+	lisA, lisB := newListener(t), newListener(t)
+	membership.Set(Member{ID: "room-a", Address: lisA.Addr().String()}, Member{ID: "room-b", Address: lisB.Addr().String()})
+	a := startTestNode(t, "room-a", membership, lisA)
+	b := startTestNode(t, "room-b", membership, lisB)
+	require.NoError(t, a.region.Start())
+	require.NoError(t, b.region.Start())
+	require.NoError(t, a.region.Rebalance()) // Let a release anything b asked for during start-up
+	require.NoError(t, b.region.Rebalance())
+
+	// Both regions agree on placement and route to the owner.
+	owners := map[string]int{}
+	for i := 0; i < 20; i++ {
+		entityID := fmt.Sprintf("%d", i)
+		ownerA, ok := a.region.OwnerOf(entityID)
+		require.True(t, ok)
+		ownerB, _ := b.region.OwnerOf(entityID)
+		require.Equal(t, ownerA, ownerB)
+		owners[ownerA.ID]++
+
+		assert.Equal(t, ownerA.ID+":1", askString(t, a.region, entityID))
+		assert.Equal(t, ownerA.ID+":2", askString(t, b.region, entityID))
+	}
+	assert.Len(t, owners, 2, "entities should be spread over both members")
+
+	// room-b leaves: it hands its shards off and room-a takes them over.
+	membership.Set(a.member)
+	require.NoError(t, b.region.Rebalance())
+	require.NoError(t, a.region.Rebalance())
+	for i := 0; i < 20; i++ {
+		entityID := fmt.Sprintf("%d", i)
+		owner, _ := a.region.OwnerOf(entityID)
+		assert.Equal(t, "room-a", owner.ID)
+		reply := askString(t, a.region, entityID)
+		assert.Regexp(t, `^room-a:\d+$`, reply)
+	}
+	assert.Empty(t, b.system.Actors(), "room-b must have stopped its entities")
+}
+
+func TestShardRegion_BuffersWhileAcquiring(t *testing.T) {
+	membership := NewStaticMembership()
+	lisA, lisB := newListener(t), newListener(t)
+	memberA := Member{ID: "room-a", Address: lisA.Addr().String()}
+	memberB := Member{ID: "room-b", Address: lisB.Addr().String()}
+	membership.Set(memberA)
+	a := startTestNode(t, "room-a", membership, lisA)
+	require.NoError(t, a.region.Start())
+
+	// Find an entity that will move to room-b and start it on room-a.
+	var entityID string
+	for i := 0; entityID == ""; i++ {
+		candidate := fmt.Sprintf("room-%d", i)
+		if placement(a.region.shardOf(candidate), sortMembers([]Member{memberA, memberB})).ID == "room-b" {
+			entityID = candidate
+		}
+	}
+	assert.Equal(t, "room-a:1", askString(t, a.region, entityID))
+
+	// room-b joins and notices first: room-a has not released the shard, so b buffers.
+	membership.Set(memberA, memberB)
+	b := startTestNode(t, "room-b", membership, lisB)
+	require.NoError(t, b.region.Start())
+	require.NoError(t, b.region.Tell(context.Background(), entityID, wrapperspb.String("early")))
+
+	// room-a hands off; b activates the shard and delivers the buffered message first.
+	require.NoError(t, a.region.Rebalance())
+	assert.Eventually(t, func() bool {
+		_, ok := b.system.FindByPath("/room-" + entityID)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "room-b:2", askString(t, b.region, entityID))
+	assert.Equal(t, "room-b:3", askString(t, a.region, entityID))
+}
+
+func TestDiscoveryMembership_Members(t *testing.T) {
+	registry := discovery.NewMemoryRegistry()
+	require.NoError(t, registry.Register(discovery.Instance{ID: "roomserver-2", Service: "roomserver", Address: "10.0.0.2", Port: 9000}))
+	require.NoError(t, registry.Register(discovery.Instance{ID: "roomserver-1", Service: "roomserver", Address: "10.0.0.1", Port: 9000}))
+	require.NoError(t, registry.Register(discovery.Instance{ID: "gateway-1", Service: "gateway", Address: "10.0.0.3", Port: 8000}))
+	resolver := discovery.NewResolver(registry)
+	defer resolver.Close()
+
+	members, err := NewDiscoveryMembership(resolver, "roomserver").Members()
+	require.NoError(t, err)
+	assert.Equal(t, []Member{
+		{ID: "roomserver-1", Address: "10.0.0.1:9000"},
+		{ID: "roomserver-2", Address: "10.0.0.2:9000"},
+	}, members)
+
+	_, err = NewDiscoveryMembership(nil, "roomserver").Members()
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+package actor
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Directive tells a failed actor what to do after its ProcessMessage panicked.
+type Directive int
+
+const (
+	// ResumeDirective keeps the current processor (and its state) and continues with the next message.
+	ResumeDirective Directive = iota
+	// RestartDirective stops the actor's children and continues with a fresh processor
+	// if the actor was created WithProducer, or with the same processor otherwise.
+	RestartDirective
+	// StopDirective stops the failed actor.
+	StopDirective
+	// EscalateDirective stops the failed actor and fails its parent with the same reason,
+	// so the grandparent's strategy decides for the parent. For root actors it behaves like StopDirective.
+	EscalateDirective
+)
+
+func (d Directive) String() string {
+	switch d {
+	case ResumeDirective:
+		return "Resume"
+	case RestartDirective:
+		return "Restart"
+	case StopDirective:
+		return "Stop"
+	case EscalateDirective:
+		return "Escalate"
+	default:
+		return fmt.Sprintf("Directive(%d)", int(d))
+	}
+}
+
+// Decider maps a failure reason (the value recovered from the panic) to a Directive.
+type Decider func(reason interface{}) Directive
+
+// DefaultDecider restarts the actor on any failure.
+func DefaultDecider(reason interface{}) Directive {
+	return RestartDirective
+}
+
+// SupervisorStrategy decides how a parent handles the failure of one of its children.
+// HandleFailure runs on the failed child's goroutine and returns the directive the child
+// applies to itself. Strategies that also affect siblings notify them from here.
+type SupervisorStrategy interface {
+	HandleFailure(supervisor *Actor, child *Actor, reason interface{}) Directive
+}
+
+// Default restart limits used by DefaultSupervisorStrategy.
+const (
+	defaultMaxRetries     = 10
+	defaultWithinDuration = time.Minute
+)
+
+// DefaultSupervisorStrategy returns the strategy used for actors that were not given one:
+// one-for-one, restart on any failure, at most 10 restarts per minute.
+func DefaultSupervisorStrategy() SupervisorStrategy {
+	return NewOneForOneStrategy(defaultMaxRetries, defaultWithinDuration, DefaultDecider)
+}
+
+// OneForOneStrategy applies the directive only to the child that failed.
+type OneForOneStrategy struct {
+	MaxRetries     int           // Restarts allowed within WithinDuration before the child is stopped. Negative means unlimited.
+	WithinDuration time.Duration // Window for MaxRetries. Zero means failures never expire.
+	Decider        Decider       // Maps a failure reason to a directive. Nil means DefaultDecider.
+}
+
+// NewOneForOneStrategy creates a OneForOneStrategy.
+func NewOneForOneStrategy(maxRetries int, within time.Duration, decider Decider) *OneForOneStrategy {
+	return &OneForOneStrategy{MaxRetries: maxRetries, WithinDuration: within, Decider: decider}
+}
+
+// HandleFailure implements SupervisorStrategy.
+func (s *OneForOneStrategy) HandleFailure(supervisor *Actor, child *Actor, reason interface{}) Directive {
+	directive := decide(s.Decider, reason)
+	if directive == RestartDirective && !child.restartStats.requestRestart(s.MaxRetries, s.WithinDuration) {
+		log.Printf("Actor %s (%d) exceeded %d restarts within %v, stopping it.", child.name, child.id, s.MaxRetries, s.WithinDuration)
+		directive = StopDirective
+	}
+	return directive
+}
+
+// AllForOneStrategy applies the directive to the failed child and all of its siblings.
+// It is meant for children that only make sense together, e.g. the seats of one room.
+type AllForOneStrategy struct {
+	MaxRetries     int           // Restarts allowed within WithinDuration before the children are stopped. Negative means unlimited.
+	WithinDuration time.Duration // Window for MaxRetries. Zero means failures never expire.
+	Decider        Decider       // Maps a failure reason to a directive. Nil means DefaultDecider.
+}
+
+// NewAllForOneStrategy creates an AllForOneStrategy.
+func NewAllForOneStrategy(maxRetries int, within time.Duration, decider Decider) *AllForOneStrategy {
+	return &AllForOneStrategy{MaxRetries: maxRetries, WithinDuration: within, Decider: decider}
+}
+
+// HandleFailure implements SupervisorStrategy.
+func (s *AllForOneStrategy) HandleFailure(supervisor *Actor, child *Actor, reason interface{}) Directive {
+	directive := decide(s.Decider, reason)
+	if directive == RestartDirective && !child.restartStats.requestRestart(s.MaxRetries, s.WithinDuration) {
+		log.Printf("Actor %s (%d) exceeded %d restarts within %v, stopping it and its siblings.", child.name, child.id, s.MaxRetries, s.WithinDuration)
+		directive = StopDirective
+	}
+	if supervisor == nil {
+		return directive
+	}
+	for _, sibling := range supervisor.childActors() {
+		if sibling == child {
+			continue
+		}
+		switch directive {
+		case RestartDirective:
+			sibling.sendSystem(&restartMessage{reason: reason})
+		case StopDirective, EscalateDirective:
+			sibling.signalStop()
+		}
+	}
+	return directive
+}
+
+func decide(decider Decider, reason interface{}) Directive {
+	if decider == nil {
+		return DefaultDecider(reason)
+	}
+	return decider(reason)
+}
+
+// restartStatistics records recent failures of one actor to enforce restart limits.
+// It is only touched from the actor's own goroutine.
+type restartStatistics struct {
+	failures []time.Time
+}
+
+// requestRestart records a failure and reports whether another restart is allowed.
+func (r *restartStatistics) requestRestart(maxRetries int, within time.Duration) bool {
+	if maxRetries < 0 {
+		return true
+	}
+	now := time.Now()
+	if within > 0 {
+		kept := r.failures[:0]
+		for _, t := range r.failures {
+			if now.Sub(t) <= within {
+				kept = append(kept, t)
+			}
+		}
+		r.failures = kept
+	}
+	r.failures = append(r.failures, now)
+	return len(r.failures) <= maxRetries
+}
+
+// --- System messages used by supervision ---
+
+// restartMessage asks an actor to restart, e.g. because a sibling failed under AllForOneStrategy.
+type restartMessage struct {
+	reason interface{}
+}
+
+// failureMessage is sent to a parent when a child escalates its failure.
+type failureMessage struct {
+	child  *Actor
+	reason interface{}
+}
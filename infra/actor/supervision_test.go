@@ -0,0 +1,121 @@
+package actor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// spawnCounters returns a PreStart hook that spawns a counter child for each name. The
+// children get a fresh counter when they restart.
+func spawnCounters(t *testing.T, names ...string) func(actorCtx actor.IActorContext) {
+	return func(actorCtx actor.IActorContext) {
+		for _, name := range names {
+			_, err := actorCtx.SpawnChild(name, &counter{}, actor.WithProducer(func() actor.ActorProcessor { return &counter{} }))
+			require.NoError(t, err)
+		}
+	}
+}
+
+// spawnParent spawns a counter named "parent" that supervises counters with the given names.
+func spawnParent(t *testing.T, system *actor.ActorSystem, strategy actor.SupervisorStrategy, children ...string) *actor.Actor {
+	t.Helper()
+	parent, err := system.Spawn("parent", &counter{setup: spawnCounters(t, children...)},
+		actor.WithSupervisorStrategy(strategy), actor.WithProducer(func() actor.ActorProcessor {
+			return &counter{setup: spawnCounters(t, children...)}
+		}))
+	require.NoError(t, err)
+	return parent
+}
+
+// find waits for an actor to be spawned at path, e.g. by a PreStart hook, and returns it.
+func find(t *testing.T, system *actor.ActorSystem, path string) actor.IActor {
+	t.Helper()
+	var found actor.IActor
+	require.Eventually(t, func() bool {
+		var ok bool
+		found, ok = system.FindByPath(path)
+		return ok
+	}, time.Second, time.Millisecond, "no actor at %s", path)
+	return found
+}
+
+func directive(d actor.Directive) actor.Decider {
+	return func(reason interface{}) actor.Directive { return d }
+}
+
+func TestSupervision_ResumeKeepsState(t *testing.T) {
+	system := newSystem(t)
+	spawnParent(t, system, actor.NewOneForOneStrategy(-1, 0, directive(actor.ResumeDirective)), "child")
+	child := find(t, system, "/parent/child")
+
+	tell(t, child, wrapperspb.String("inc"))
+	tell(t, child, wrapperspb.String("panic"))
+	testkit.AskReply(t, child, wrapperspb.String("get"), wrapperspb.Int64(1))
+}
+
+func TestSupervision_RestartLimitStopsTheChild(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	spawnParent(t, system, actor.NewOneForOneStrategy(2, time.Minute, nil), "child")
+	child := find(t, system, "/parent/child")
+	probe.Watch(child)
+
+	for i := 0; i < 2; i++ {
+		tell(t, child, wrapperspb.String("inc"))
+		tell(t, child, wrapperspb.String("panic"))
+		testkit.AskReply(t, child, wrapperspb.String("get"), wrapperspb.Int64(0)) // Restarted with a fresh counter
+	}
+	assert.Equal(t, int64(2), child.(*actor.Actor).Stats().Restarts)
+
+	tell(t, child, wrapperspb.String("panic"))
+	terminated := probe.ExpectTerminated(child)
+	assert.Contains(t, terminated.Reason, "counter crashed")
+	_, ok := system.FindByPath("/parent/child")
+	assert.False(t, ok)
+}
+
+func TestSupervision_RestartStopsChildren(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	parent := spawnParent(t, system, actor.DefaultSupervisorStrategy(), "child")
+	child := find(t, system, "/parent/child")
+	probe.Watch(child)
+
+	tell(t, parent, wrapperspb.String("panic")) // Root actors restart under the default strategy
+	probe.ExpectTerminated(child)
+	testkit.AskReply(t, parent, wrapperspb.String("get"), wrapperspb.Int64(0))
+	assert.NotEqual(t, child.Id(), find(t, system, "/parent/child").Id(), "PreStart spawns a new child")
+}
+
+func TestSupervision_AllForOneRestartsSiblings(t *testing.T) {
+	system := newSystem(t)
+	spawnParent(t, system, actor.NewAllForOneStrategy(-1, 0, nil), "a", "b")
+	a := find(t, system, "/parent/a")
+	b := find(t, system, "/parent/b")
+
+	tell(t, a, wrapperspb.String("inc"))
+	tell(t, b, wrapperspb.String("inc"))
+	tell(t, a, wrapperspb.String("panic"))
+	testkit.AskReply(t, a, wrapperspb.String("get"), wrapperspb.Int64(0)) // a has told b to restart by now
+	testkit.AskReply(t, b, wrapperspb.String("get"), wrapperspb.Int64(0))
+}
+
+func TestSupervision_EscalateFailsTheParent(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	parent := spawnParent(t, system, actor.NewOneForOneStrategy(-1, 0, directive(actor.EscalateDirective)), "child")
+	child := find(t, system, "/parent/child")
+	probe.Watch(child)
+
+	tell(t, parent, wrapperspb.String("inc"))
+	tell(t, child, wrapperspb.String("panic"))
+	probe.ExpectTerminated(child)
+	testkit.AskReply(t, parent, wrapperspb.String("get"), wrapperspb.Int64(0)) // The parent restarted
+	assert.NotEqual(t, child.Id(), find(t, system, "/parent/child").Id())
+}
@@ -0,0 +1,227 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/phuhao00/pandaparty/help"
+)
+
+// IDSource supplies IDs for actors spawned without an explicit ActorID.
+type IDSource interface {
+	NextID() ActorID
+}
+
+// IDSourceFunc adapts a plain function to IDSource.
+type IDSourceFunc func() ActorID
+
+// NextID implements IDSource.
+func (f IDSourceFunc) NextID() ActorID {
+	return f()
+}
+
+// IDGeneratorSource returns an IDSource backed by a snowflake help.IDGenerator.
+func IDGeneratorSource(generator *help.IDGenerator) IDSource {
+	return IDSourceFunc(func() ActorID {
+		return ActorID(generator.GenerateInt64ID())
+	})
+}
+
+// defaultIDSource allocates IDs for children of actors that are not owned by a system.
+var defaultIDSource = IDGeneratorSource(help.GetDefaultIDGenerator())
+
+// ActorSystem owns a set of actors. It allocates their IDs, indexes them by ID and path,
+// and stops them in reverse spawn order on Shutdown. All methods are safe for concurrent use.
+type ActorSystem struct {
+	name        string
+	idSource    IDSource
+	deadLetters *DeadLetters
+	eventStream *EventStream
+
+	mu      sync.RWMutex
+	byID    map[ActorID]*Actor
+	byPath  map[string]*Actor
+	roots   []*Actor // Root actors in spawn order
+	stopped bool
+}
+
+// SystemOption configures an ActorSystem.
+type SystemOption func(*ActorSystem)
+
+// WithIDSource replaces the default ID source, help.GetDefaultIDGenerator().
+func WithIDSource(source IDSource) SystemOption {
+	return func(s *ActorSystem) {
+		if source != nil {
+			s.idSource = source
+		}
+	}
+}
+
+// NewActorSystem creates an empty actor system.
+func NewActorSystem(name string, opts ...SystemOption) *ActorSystem {
+	s := &ActorSystem{
+		name:        name,
+		idSource:    defaultIDSource,
+		deadLetters: newDeadLetters(name),
+		eventStream: newEventStream(name),
+		byID:        make(map[ActorID]*Actor),
+		byPath:      make(map[string]*Actor),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Name returns the system name.
+func (s *ActorSystem) Name() string {
+	return s.name
+}
+
+// DeadLetters returns the sink that records messages the system's actors could not deliver
+// or process.
+func (s *ActorSystem) DeadLetters() *DeadLetters {
+	return s.deadLetters
+}
+
+// EventStream returns the stream the system's actors publish and subscribe to events on.
+func (s *ActorSystem) EventStream() *EventStream {
+	return s.eventStream
+}
+
+// Spawn creates and starts a root actor with an ID taken from the system's IDSource.
+// The actor is reachable at path "/<name>"; root names must be unique.
+func (s *ActorSystem) Spawn(name string, processor ActorProcessor, opts ...Option) (*Actor, error) {
+	return s.spawnRoot(0, false, name, processor, opts...)
+}
+
+// SpawnWithID creates and starts a root actor with a caller-chosen ID, such as a player ID.
+// It fails if an actor with the same ID or path already exists.
+func (s *ActorSystem) SpawnWithID(id ActorID, name string, processor ActorProcessor, opts ...Option) (*Actor, error) {
+	return s.spawnRoot(id, true, name, processor, opts...)
+}
+
+func (s *ActorSystem) spawnRoot(id ActorID, hasID bool, name string, processor ActorProcessor, opts ...Option) (*Actor, error) {
+	actor, err := s.spawn(id, hasID, name, processor, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	actor.launch()
+	return actor, nil
+}
+
+func (s *ActorSystem) spawn(id ActorID, hasID bool, name string, processor ActorProcessor, parent *Actor, opts ...Option) (*Actor, error) {
+	if processor == nil {
+		return nil, errors.New("cannot spawn actor with a nil ActorProcessor")
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid actor name %q: must be non-empty and must not contain '/'", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil, fmt.Errorf("actor system %s is shut down, cannot spawn %s", s.name, name)
+	}
+	if !hasID {
+		id = s.idSource.NextID()
+	}
+	if existing, ok := s.byID[id]; ok {
+		return nil, fmt.Errorf("duplicate actor ID %d: already used by %s", id, existing.path)
+	}
+	path := actorPath(parent, name)
+	if _, ok := s.byPath[path]; ok {
+		return nil, fmt.Errorf("duplicate actor path %s", path)
+	}
+
+	actor := newActor(id, name, processor, parent, s, opts...)
+	s.byID[id] = actor
+	s.byPath[path] = actor
+	if parent == nil {
+		s.roots = append(s.roots, actor)
+	}
+	return actor, nil
+}
+
+// unregister removes a stopped actor from the indexes.
+func (s *ActorSystem) unregister(a *Actor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byID[a.id] == a {
+		delete(s.byID, a.id)
+	}
+	if s.byPath[a.path] == a {
+		delete(s.byPath, a.path)
+	}
+	if a.parent == nil {
+		for i, root := range s.roots {
+			if root == a {
+				s.roots = append(s.roots[:i], s.roots[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// FindByID returns the live actor with the given ID.
+func (s *ActorSystem) FindByID(id ActorID) (IActor, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	actor, ok := s.byID[id]
+	if !ok {
+		return nil, false
+	}
+	return actor.self, true
+}
+
+// FindByPath returns the live actor at path, e.g. "/room-100/seat-2".
+func (s *ActorSystem) FindByPath(path string) (IActor, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	actor, ok := s.byPath[path]
+	if !ok {
+		return nil, false
+	}
+	return actor.self, true
+}
+
+// Actors lists all live actors, children included, sorted by path.
+func (s *ActorSystem) Actors() []IActor {
+	s.mu.RLock()
+	actors := make([]*Actor, 0, len(s.byID))
+	for _, actor := range s.byID {
+		actors = append(actors, actor)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(actors, func(i, j int) bool { return actors[i].path < actors[j].path })
+	result := make([]IActor, 0, len(actors))
+	for _, actor := range actors {
+		result = append(result, actor.self)
+	}
+	return result
+}
+
+// Shutdown stops all root actors in reverse spawn order, each after its children, and waits
+// for them to exit. No actors can be spawned afterwards. Calling Shutdown again is a no-op.
+func (s *ActorSystem) Shutdown() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	roots := make([]*Actor, len(s.roots))
+	copy(roots, s.roots)
+	s.mu.Unlock()
+
+	log.Printf("Actor system %s shutting down %d root actors.", s.name, len(roots))
+	for i := len(roots) - 1; i >= 0; i-- {
+		roots[i].Stop()
+	}
+	log.Printf("Actor system %s shut down.", s.name)
+}
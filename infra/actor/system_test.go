@@ -0,0 +1,90 @@
+package actor_test
+
+import (
+	"testing"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActorSystem_FindByIDAndPath(t *testing.T) {
+	system := newSystem(t)
+	player, err := system.SpawnWithID(42, "player-42", &counter{setup: func(actorCtx actor.IActorContext) {
+		_, err := actorCtx.SpawnChild("bag", &counter{})
+		require.NoError(t, err)
+	}})
+	require.NoError(t, err)
+	bag := find(t, system, "/player-42/bag")
+
+	found, ok := system.FindByID(42)
+	require.True(t, ok)
+	assert.Equal(t, player, found)
+	found, ok = system.FindByID(bag.Id())
+	require.True(t, ok)
+	assert.Equal(t, bag, found)
+
+	var paths []string
+	for _, a := range system.Actors() {
+		paths = append(paths, a.(*actor.Actor).Path())
+	}
+	assert.Equal(t, []string{"/player-42", "/player-42/bag"}, paths)
+
+	player.Stop()
+	_, ok = system.FindByID(42)
+	assert.False(t, ok)
+	_, ok = system.FindByPath("/player-42/bag")
+	assert.False(t, ok, "children are unregistered with their parent")
+
+	_, err = system.SpawnWithID(42, "player-42", &counter{})
+	assert.NoError(t, err, "the ID and path of a stopped actor can be reused")
+}
+
+func TestActorSystem_RejectsDuplicatesAndInvalidNames(t *testing.T) {
+	system := newSystem(t)
+	testkit.Spawn(t, system, "room", &counter{})
+	_, err := system.SpawnWithID(7, "seven", &counter{})
+	require.NoError(t, err)
+
+	_, err = system.Spawn("room", &counter{})
+	assert.ErrorContains(t, err, "duplicate actor path /room")
+	_, err = system.SpawnWithID(7, "other", &counter{})
+	assert.ErrorContains(t, err, "duplicate actor ID 7")
+	for _, name := range []string{"", "a/b"} {
+		_, err = system.Spawn(name, &counter{})
+		assert.Error(t, err, "name %q", name)
+	}
+	_, err = system.Spawn("nil", nil)
+	assert.Error(t, err)
+}
+
+func TestActorSystem_IDSource(t *testing.T) {
+	next := actor.ActorID(100)
+	system := actor.NewActorSystem(t.Name(), actor.WithIDSource(actor.IDSourceFunc(func() actor.ActorID {
+		next++
+		return next
+	})))
+	t.Cleanup(system.Shutdown)
+
+	a := testkit.Spawn(t, system, "a", &counter{})
+	b := testkit.Spawn(t, system, "b", &counter{})
+	assert.Equal(t, actor.ActorID(101), a.Id())
+	assert.Equal(t, actor.ActorID(102), b.Id())
+}
+
+func TestActorSystem_ShutdownStopsRootsInReverseOrder(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe") // Spawned first, so stopped last
+	first := testkit.Spawn(t, system, "first", &counter{})
+	second := testkit.Spawn(t, system, "second", &counter{})
+	probe.Watch(first)
+	probe.Watch(second)
+
+	system.Shutdown()
+	probe.ExpectTerminated(second)
+	probe.ExpectTerminated(first)
+	assert.Empty(t, system.Actors())
+	_, err := system.Spawn("late", &counter{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package testkit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/stretchr/testify/require"
+)
+
+// DeadLetterProbe records the dead letters of an actor system.
+type DeadLetterProbe struct {
+	t   testing.TB
+	sub *actor.DeadLetterSubscription
+}
+
+// NewDeadLetterProbe subscribes to the dead letters of system until the test ends.
+func NewDeadLetterProbe(t testing.TB, system *actor.ActorSystem) *DeadLetterProbe {
+	sub := system.DeadLetters().Subscribe(1024)
+	t.Cleanup(sub.Cancel)
+	return &DeadLetterProbe{t: t, sub: sub}
+}
+
+// ExpectDeadLetter asserts that the next dead letter has the given reason and returns it.
+func (p *DeadLetterProbe) ExpectDeadLetter(reason actor.DeadLetterReason) actor.DeadLetter {
+	p.t.Helper()
+	select {
+	case letter := <-p.sub.C():
+		require.Equal(p.t, reason, letter.Reason, "dead letter %s for %s", letter.MessageType(), letter.Recipient)
+		return letter
+	case <-time.After(DefaultTimeout):
+		require.FailNow(p.t, fmt.Sprintf("no dead letter with reason %q within %v", reason, DefaultTimeout))
+		return actor.DeadLetter{}
+	}
+}
+
+// ExpectNoDeadLetter asserts that no dead letter is recorded within d.
+func (p *DeadLetterProbe) ExpectNoDeadLetter(d time.Duration) {
+	p.t.Helper()
+	select {
+	case letter := <-p.sub.C():
+		require.FailNow(p.t, fmt.Sprintf("unexpected dead letter %s for %s: %s", letter.MessageType(), letter.Recipient, letter.Reason))
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,150 @@
+package testkit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// probeBuffer is how many messages a TestProbe holds until they are received.
+const probeBuffer = 1024
+
+// TestProbe is an actor that records every message it receives. Pass Ref() to the actor
+// under test wherever it expects a collaborator, then assert with the Expect methods.
+type TestProbe struct {
+	t        testing.TB
+	ref      *actor.Actor
+	received chan proto.Message
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	autoReply func(msg proto.Message) proto.Message
+	actorCtx  actor.IActorContext
+}
+
+// NewTestProbe spawns a probe named name in system. The probe runs on the calling-thread
+// dispatcher, so a message is recorded by the time Tell returns. It is stopped when the test ends.
+// A probe holds up to probeBuffer messages that were not received; more fail the test.
+func NewTestProbe(t testing.TB, system *actor.ActorSystem, name string) *TestProbe {
+	t.Helper()
+	p := &TestProbe{t: t, received: make(chan proto.Message, probeBuffer), timeout: DefaultTimeout}
+	p.ref = Spawn(t, system, name, &probeProcessor{probe: p})
+	return p
+}
+
+// Ref returns the probe's actor.
+func (p *TestProbe) Ref() *actor.Actor {
+	return p.ref
+}
+
+// SetTimeout changes how long the Expect methods wait.
+func (p *TestProbe) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// SetAutoReply makes the probe answer every Ask with reply(msg). Without it Asks get a nil reply.
+func (p *TestProbe) SetAutoReply(reply func(msg proto.Message) proto.Message) {
+	p.mu.Lock()
+	p.autoReply = reply
+	p.mu.Unlock()
+}
+
+// Watch makes the probe receive a *pbactor.Terminated when target stops.
+func (p *TestProbe) Watch(target actor.IActor) {
+	p.t.Helper()
+	p.mu.Lock()
+	actorCtx := p.actorCtx
+	p.mu.Unlock()
+	require.NotNil(p.t, actorCtx, "test probe has not started")
+	// Watch only touches state guarded by the actors' own locks, so it is safe off the probe's goroutine.
+	require.NoError(p.t, actorCtx.Watch(target))
+}
+
+// Receive returns the next message, failing the test if none arrives in time.
+func (p *TestProbe) Receive() proto.Message {
+	p.t.Helper()
+	select {
+	case msg := <-p.received:
+		return msg
+	case <-time.After(p.timeout):
+		require.FailNow(p.t, fmt.Sprintf("test probe %s received no message within %v", p.ref.Name(), p.timeout))
+		return nil
+	}
+}
+
+// ExpectMsg asserts that the next message equals expected and returns it.
+func (p *TestProbe) ExpectMsg(expected proto.Message) proto.Message {
+	p.t.Helper()
+	msg := p.Receive()
+	if !proto.Equal(expected, msg) {
+		require.FailNow(p.t, fmt.Sprintf("test probe %s received an unexpected message", p.ref.Name()),
+			"expected %T %v\nreceived %T %v", expected, expected, msg, msg)
+	}
+	return msg
+}
+
+// ExpectNoMsg asserts that no message arrives within d.
+func (p *TestProbe) ExpectNoMsg(d time.Duration) {
+	p.t.Helper()
+	select {
+	case msg := <-p.received:
+		require.FailNow(p.t, fmt.Sprintf("test probe %s received %T %v, expected no message", p.ref.Name(), msg, msg))
+	case <-time.After(d):
+	}
+}
+
+// ExpectTerminated asserts that the next message reports that target stopped.
+func (p *TestProbe) ExpectTerminated(target actor.IActor) *pbactor.Terminated {
+	p.t.Helper()
+	terminated := ExpectMsgType[*pbactor.Terminated](p)
+	require.Equal(p.t, int64(target.Id()), terminated.ActorId, "Terminated for the wrong actor %s", terminated.Name)
+	return terminated
+}
+
+// ExpectMsgType asserts that the next message of probe has type T and returns it.
+func ExpectMsgType[T proto.Message](probe *TestProbe) T {
+	probe.t.Helper()
+	msg := probe.Receive()
+	typed, ok := msg.(T)
+	if !ok {
+		var zero T
+		require.FailNow(probe.t, fmt.Sprintf("test probe %s received %T, expected %T", probe.ref.Name(), msg, zero))
+	}
+	return typed
+}
+
+// probeProcessor feeds a TestProbe.
+type probeProcessor struct {
+	probe *TestProbe
+}
+
+func (pp *probeProcessor) PreStart(actorCtx actor.IActorContext) {
+	pp.probe.mu.Lock()
+	pp.probe.actorCtx = actorCtx
+	pp.probe.mu.Unlock()
+}
+
+func (pp *probeProcessor) ProcessMessage(actorCtx actor.IActorContext, msg proto.Message) (proto.Message, error) {
+	select {
+	case pp.probe.received <- msg:
+	default:
+		// Blocking here would hang the sender on the calling-thread dispatcher. Errorf, unlike
+		// FailNow, may be called from the sender's goroutine.
+		pp.probe.t.Errorf("test probe %s dropped %T: %d messages are waiting to be received",
+			actorCtx.Self().Name(), msg, cap(pp.probe.received))
+		return nil, nil
+	}
+	pp.probe.mu.Lock()
+	reply := pp.probe.autoReply
+	pp.probe.mu.Unlock()
+	if reply == nil {
+		return nil, nil
+	}
+	return reply(msg), nil
+}
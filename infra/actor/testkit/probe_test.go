@@ -0,0 +1,42 @@
+package testkit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// recordingT records the errors reported through it instead of failing the test.
+type recordingT struct {
+	testing.TB
+	mu     sync.Mutex
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+func TestTestProbe_FullBufferFailsInsteadOfBlocking(t *testing.T) {
+	system := actor.NewActorSystem(t.Name())
+	t.Cleanup(system.Shutdown)
+	rec := &recordingT{TB: t}
+	probe := NewTestProbe(rec, system, "probe")
+
+	for i := 0; i < probeBuffer; i++ {
+		require.NoError(t, probe.Ref().Tell(context.Background(), wrapperspb.Int64(int64(i))))
+	}
+	require.NoError(t, probe.Ref().Tell(context.Background(), wrapperspb.Int64(probeBuffer))) // Returns instead of hanging
+
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "test probe probe dropped *wrapperspb.Int64Value")
+	probe.ExpectMsg(wrapperspb.Int64(0))
+}
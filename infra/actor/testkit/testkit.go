@@ -0,0 +1,81 @@
+// Package testkit helps unit-test actors: probes that record what they receive, actors that
+// process messages synchronously, and assertions on Ask replies and dead letters.
+package testkit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultTimeout bounds the Expect and Ask helpers. TestProbe.SetTimeout changes it per probe.
+const DefaultTimeout = 3 * time.Second
+
+// Spawn spawns processor in system on the calling-thread dispatcher, so Tell returns only
+// after the message has been processed and tests need no sleeps. The actor is stopped when
+// the test ends.
+func Spawn(t testing.TB, system *actor.ActorSystem, name string, processor actor.ActorProcessor, opts ...actor.Option) *actor.Actor {
+	t.Helper()
+	opts = append([]actor.Option{actor.WithCallingThreadDispatcher(), actor.WithMailbox(actor.UnboundedMailbox())}, opts...)
+	ref, err := system.Spawn(name, processor, opts...)
+	require.NoError(t, err, "spawning %s", name)
+	t.Cleanup(ref.Stop)
+	return ref
+}
+
+// AskReply asks target and asserts that it replies without error with a message equal to
+// expected. It returns the reply.
+func AskReply(t testing.TB, target actor.IActor, msg proto.Message, expected proto.Message) proto.Message {
+	t.Helper()
+	reply := ask(t, target, msg)
+	require.NoError(t, reply.err, "Ask %T", msg)
+	got, _ := reply.value.(proto.Message)
+	require.Empty(t, cmpDiff(expected, got), "Ask %T replied with an unexpected message", msg)
+	return got
+}
+
+// AskReplyType asks target and asserts that it replies without error with a T.
+func AskReplyType[T proto.Message](t testing.TB, target actor.IActor, msg proto.Message) T {
+	t.Helper()
+	reply := ask(t, target, msg)
+	require.NoError(t, reply.err, "Ask %T", msg)
+	typed, ok := reply.value.(T)
+	if !ok {
+		var zero T
+		require.FailNow(t, fmt.Sprintf("Ask %T replied with %T, expected %T", msg, reply.value, zero))
+	}
+	return typed
+}
+
+// AskError asks target, asserts that it fails and returns the error.
+func AskError(t testing.TB, target actor.IActor, msg proto.Message) error {
+	t.Helper()
+	reply := ask(t, target, msg)
+	require.Error(t, reply.err, "Ask %T succeeded with %v", msg, reply.value)
+	return reply.err
+}
+
+type askResult struct {
+	value interface{}
+	err   error
+}
+
+func ask(t testing.TB, target actor.IActor, msg proto.Message) askResult {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	value, err := target.Ask(ctx, msg)
+	return askResult{value: value, err: err}
+}
+
+// cmpDiff returns a readable difference between two messages, or "" if they are equal.
+func cmpDiff(expected, got proto.Message) string {
+	if proto.Equal(expected, got) {
+		return ""
+	}
+	return fmt.Sprintf("expected %T %v\nreceived %T %v", expected, expected, got, got)
+}
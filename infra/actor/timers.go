@@ -0,0 +1,143 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// Cancellable is returned by IActorContext.ScheduleOnce and ScheduleRepeatedly.
+type Cancellable interface {
+	// Cancel stops the timer. A message it already put in the mailbox is discarded, so once
+	// Cancel returns on the actor's goroutine the processor will not see the message again.
+	Cancel()
+}
+
+// timer delivers a message into its actor's mailbox after a delay and, if interval > 0,
+// every interval after that.
+type timer struct {
+	actor     *Actor
+	message   proto.Message
+	interval  time.Duration
+	mu        sync.Mutex // Protects t
+	t         *time.Timer
+	cancelled atomic.Bool
+	pending   atomic.Bool // A tick is in the mailbox; repeating timers skip ticks until it is processed
+	// receiveTimeout marks the timer armed by SetReceiveTimeout.
+	receiveTimeout bool
+}
+
+// timers tracks the live timers of an actor so they can be cancelled when it stops or restarts.
+type timers struct {
+	mu     sync.Mutex
+	active map[*timer]struct{}
+}
+
+func (a *Actor) schedule(delay, interval time.Duration, message proto.Message, receiveTimeout bool) *timer {
+	t := &timer{actor: a, message: message, interval: interval, receiveTimeout: receiveTimeout}
+	a.timers.mu.Lock()
+	if a.timers.active == nil {
+		a.timers.active = make(map[*timer]struct{})
+	}
+	a.timers.active[t] = struct{}{}
+	a.timers.mu.Unlock()
+	t.mu.Lock()
+	t.t = time.AfterFunc(delay, t.fire)
+	t.mu.Unlock()
+	return t
+}
+
+func (t *timer) fire() {
+	if t.cancelled.Load() {
+		return
+	}
+	if t.pending.CompareAndSwap(false, true) {
+		err := t.actor.post(&Envelope{ctx: context.Background(), message: t.message, timer: t})
+		if err != nil && t.interval <= 0 {
+			// A one-shot tick that did not make it into the mailbox will never be accepted
+			t.forget()
+			return
+		}
+		if errors.Is(err, errMailboxStopped) {
+			return
+		}
+		if err != nil {
+			t.pending.Store(false)
+		}
+	}
+	if t.interval > 0 {
+		t.mu.Lock()
+		if !t.cancelled.Load() {
+			t.t.Reset(t.interval)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *timer) Cancel() {
+	if t.cancelled.Swap(true) {
+		return
+	}
+	t.mu.Lock()
+	t.t.Stop()
+	t.mu.Unlock()
+	t.forget()
+}
+
+// forget removes the timer from its actor's active timers.
+func (t *timer) forget() {
+	t.actor.timers.mu.Lock()
+	delete(t.actor.timers.active, t)
+	t.actor.timers.mu.Unlock()
+}
+
+// accept is called by the run loop when a tick is dequeued. It reports whether the message
+// should still be processed.
+func (t *timer) accept() bool {
+	t.pending.Store(false)
+	if t.cancelled.Load() {
+		return false
+	}
+	if t.interval <= 0 {
+		t.forget()
+	}
+	return true
+}
+
+// cancelTimers cancels every timer and the receive timeout.
+func (a *Actor) cancelTimers() {
+	a.timers.mu.Lock()
+	active := make([]*timer, 0, len(a.timers.active))
+	for t := range a.timers.active {
+		active = append(active, t)
+	}
+	a.timers.mu.Unlock()
+	for _, t := range active {
+		t.Cancel()
+	}
+	a.receiveTimeout = 0
+	a.receiveTimer = nil
+}
+
+// setReceiveTimeout sets the idle duration after which ReceiveTimeout is delivered and
+// restarts the countdown. timeout <= 0 disables it.
+func (a *Actor) setReceiveTimeout(timeout time.Duration) {
+	a.receiveTimeout = timeout
+	a.resetReceiveTimeout()
+}
+
+// resetReceiveTimeout restarts the receive timeout countdown, if one is set.
+func (a *Actor) resetReceiveTimeout() {
+	if a.receiveTimer != nil {
+		a.receiveTimer.Cancel()
+		a.receiveTimer = nil
+	}
+	if a.receiveTimeout > 0 {
+		a.receiveTimer = a.schedule(a.receiveTimeout, 0, &pbactor.ReceiveTimeout{}, true)
+	}
+}
@@ -0,0 +1,50 @@
+package actor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// gated waits for gate on every message.
+type gated struct {
+	gate chan struct{}
+}
+
+func (g *gated) ProcessMessage(actorCtx IActorContext, msg proto.Message) (proto.Message, error) {
+	<-g.gate
+	return nil, nil
+}
+
+func (a *Actor) hasTimer(t *timer) bool {
+	a.timers.mu.Lock()
+	defer a.timers.mu.Unlock()
+	_, ok := a.timers.active[t]
+	return ok
+}
+
+func TestTimer_OneShotIsForgottenWhenTheMailboxIsFull(t *testing.T) {
+	system := NewActorSystem(t.Name())
+	t.Cleanup(system.Shutdown)
+	gate := make(chan struct{})
+	t.Cleanup(func() { close(gate) })
+	a, err := system.Spawn("full", &gated{gate: gate}, WithMailbox(DropNewestMailbox(1)))
+	require.NoError(t, err)
+
+	require.NoError(t, a.Tell(context.Background(), wrapperspb.String("block")))
+	require.Eventually(t, func() bool { return a.MailboxDepth() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, a.Tell(context.Background(), wrapperspb.String("fill")))
+
+	once := a.schedule(time.Millisecond, 0, wrapperspb.String("once"), false)
+	require.Eventually(t, func() bool { return !a.hasTimer(once) }, time.Second, time.Millisecond)
+
+	repeating := a.schedule(time.Millisecond, time.Millisecond, wrapperspb.String("tick"), false)
+	time.Sleep(10 * time.Millisecond)
+	require.True(t, a.hasTimer(repeating), "a repeating timer keeps trying after a full mailbox")
+	repeating.Cancel()
+	require.False(t, a.hasTimer(repeating))
+}
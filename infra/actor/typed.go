@@ -0,0 +1,130 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrUnexpectedReply is returned by typed Asks when the actor replies with another type.
+var ErrUnexpectedReply = errors.New("actor replied with an unexpected message type")
+
+// TypedActor is a reference to an actor that answers Req with Resp. It checks at compile time
+// what is sent, and at run time what comes back.
+type TypedActor[Req, Resp proto.Message] struct {
+	ref IActor
+}
+
+// Typed wraps ref as a TypedActor. Nothing is checked until the first Ask.
+func Typed[Req, Resp proto.Message](ref IActor) TypedActor[Req, Resp] {
+	return TypedActor[Req, Resp]{ref: ref}
+}
+
+// Ref returns the untyped actor.
+func (t TypedActor[Req, Resp]) Ref() IActor {
+	return t.ref
+}
+
+// Tell sends req without waiting for a reply.
+func (t TypedActor[Req, Resp]) Tell(ctx context.Context, req Req) error {
+	return t.ref.Tell(ctx, req)
+}
+
+// Ask sends req and waits for a reply of type Resp.
+func (t TypedActor[Req, Resp]) Ask(ctx context.Context, req Req) (Resp, error) {
+	return AskAs[Resp](ctx, t.ref, req)
+}
+
+// AskAs asks target and returns its reply as a Resp. A nil reply yields the zero Resp;
+// any other type yields an error wrapping ErrUnexpectedReply.
+func AskAs[Resp proto.Message](ctx context.Context, target IActor, message proto.Message) (Resp, error) {
+	var zero Resp
+	reply, err := target.Ask(ctx, message)
+	if err != nil {
+		return zero, err
+	}
+	if reply == nil {
+		return zero, nil
+	}
+	resp, ok := reply.(Resp)
+	if !ok {
+		return zero, fmt.Errorf("%w: %T answered %T with %T, expected %T", ErrUnexpectedReply, target, message, reply, zero)
+	}
+	return resp, nil
+}
+
+// Handlers is an ActorProcessor that dispatches each message to the handler registered for
+// its protobuf full name, replacing type switches in ProcessMessage. Register handlers with
+// Handle and HandleTell before the actor starts. A message without a handler goes to the
+// fallback, or fails. Handlers.ProcessMessage can also be passed to Become as a Behavior.
+type Handlers struct {
+	byName   map[protoreflect.FullName]Behavior
+	fallback Behavior
+}
+
+// NewHandlers returns an empty handler set.
+func NewHandlers() *Handlers {
+	return &Handlers{byName: make(map[protoreflect.FullName]Behavior)}
+}
+
+// Handle registers fn for messages of type Req, replacing any earlier handler for Req. Req
+// must be a concrete message type such as *pb.Foo; Handle panics otherwise. A nil pointer
+// returned by fn is no reply, but a nil interface Resp fails the message.
+func Handle[Req, Resp proto.Message](h *Handlers, fn func(actorCtx IActorContext, req Req) (Resp, error)) *Handlers {
+	name := handlerName[Req]()
+	h.byName[name] = func(actorCtx IActorContext, msg proto.Message) (proto.Message, error) {
+		resp, err := fn(actorCtx, msg.(Req))
+		if any(resp) == nil {
+			if err == nil {
+				err = fmt.Errorf("handler for %s returned a nil %v", name, reflect.TypeFor[Resp]())
+			}
+			return nil, err
+		}
+		if !resp.ProtoReflect().IsValid() {
+			return nil, err // Keep a typed nil out of the untyped reply
+		}
+		return resp, err
+	}
+	return h
+}
+
+// HandleTell registers fn for messages of type Req that need no reply. Like Handle, it panics
+// if Req is not a concrete message type.
+func HandleTell[Req proto.Message](h *Handlers, fn func(actorCtx IActorContext, req Req) error) *Handlers {
+	h.byName[handlerName[Req]()] = func(actorCtx IActorContext, msg proto.Message) (proto.Message, error) {
+		return nil, fn(actorCtx, msg.(Req))
+	}
+	return h
+}
+
+// handlerName returns the protobuf full name of Req. An interface type such as proto.Message
+// names no message, and is a programming error.
+func handlerName[Req proto.Message]() protoreflect.FullName {
+	var req Req
+	if any(req) == nil {
+		panic(fmt.Sprintf("actor: cannot register a handler for %v: Req must be a concrete message type such as *pb.Foo", reflect.TypeFor[Req]()))
+	}
+	return messageName(req)
+}
+
+// Fallback sets the handler for messages that have no handler of their own.
+func (h *Handlers) Fallback(fn Behavior) *Handlers {
+	h.fallback = fn
+	return h
+}
+
+// ProcessMessage implements ActorProcessor.
+func (h *Handlers) ProcessMessage(actorCtx IActorContext, msg proto.Message) (proto.Message, error) {
+	name := messageName(msg)
+	if handler, ok := h.byName[name]; ok {
+		return handler(actorCtx, msg)
+	}
+	if h.fallback != nil {
+		return h.fallback(actorCtx, msg)
+	}
+	return nil, fmt.Errorf("no handler for %s", name)
+}
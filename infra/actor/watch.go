@@ -0,0 +1,101 @@
+package actor
+
+import (
+	"context"
+	"fmt"
+
+	pbactor "github.com/phuhao00/pandaparty/infra/pb/protocol/actor"
+	"google.golang.org/protobuf/proto"
+)
+
+// watch makes a receive a Terminated message when target stops.
+func (a *Actor) watch(target IActor) error {
+	t, ok := target.(*Actor)
+	if !ok || t == nil {
+		return fmt.Errorf("actor %s (%d) cannot watch %T: only local actors can be watched", a.name, a.id, target)
+	}
+	if t == a {
+		return fmt.Errorf("actor %s (%d) cannot watch itself", a.name, a.id)
+	}
+
+	a.watchMu.Lock()
+	a.watching[t.id] = t
+	a.watchMu.Unlock()
+
+	if !t.addWatcher(a) {
+		// Target is already gone; deliver Terminated right away.
+		a.deliver(t.terminatedMessage())
+	}
+	return nil
+}
+
+// unwatch cancels a previous watch. Unwatching an actor that is not watched is a no-op.
+func (a *Actor) unwatch(target IActor) error {
+	t, ok := target.(*Actor)
+	if !ok || t == nil {
+		return fmt.Errorf("actor %s (%d) cannot unwatch %T: only local actors can be watched", a.name, a.id, target)
+	}
+	a.forgetWatched(t.id)
+	t.removeWatcher(a.id)
+	return nil
+}
+
+// forgetWatched drops id from the set of watched actors, e.g. once its Terminated has arrived.
+func (a *Actor) forgetWatched(id ActorID) {
+	a.watchMu.Lock()
+	delete(a.watching, id)
+	a.watchMu.Unlock()
+}
+
+// addWatcher registers w as a watcher. It returns false if a has already terminated.
+func (a *Actor) addWatcher(w *Actor) bool {
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if a.terminated {
+		return false
+	}
+	a.watchers[w.id] = w
+	return true
+}
+
+func (a *Actor) removeWatcher(id ActorID) {
+	a.watchMu.Lock()
+	delete(a.watchers, id)
+	a.watchMu.Unlock()
+}
+
+// notifyWatchers marks a as terminated, sends Terminated to every watcher and
+// unregisters a from the actors it was watching. Called once from run's cleanup.
+func (a *Actor) notifyWatchers() {
+	a.watchMu.Lock()
+	a.terminated = true
+	watchers := a.watchers
+	watching := a.watching
+	a.watchers = make(map[ActorID]*Actor)
+	a.watching = make(map[ActorID]*Actor)
+	a.watchMu.Unlock()
+
+	for _, t := range watching {
+		t.removeWatcher(a.id)
+	}
+	if len(watchers) == 0 {
+		return
+	}
+	terminated := a.terminatedMessage()
+	for _, w := range watchers {
+		w.deliver(terminated)
+	}
+}
+
+func (a *Actor) terminatedMessage() *pbactor.Terminated {
+	msg := &pbactor.Terminated{ActorId: int64(a.id), Name: a.name}
+	if a.stopReason != nil {
+		msg.Reason = fmt.Sprint(a.stopReason)
+	}
+	return msg
+}
+
+// deliver enqueues a message generated by the actor runtime. Failures go to the dead letters.
+func (a *Actor) deliver(message proto.Message) {
+	_ = a.post(&Envelope{ctx: context.Background(), message: message})
+}
@@ -0,0 +1,69 @@
+package actor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/actor"
+	"github.com/phuhao00/pandaparty/infra/actor/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// lifecycle is a counter that reports its PostStop hook to probe.
+type lifecycle struct {
+	counter
+}
+
+func (l *lifecycle) PostStop(actorCtx actor.IActorContext) {
+	_ = l.probe.Tell(context.Background(), wrapperspb.String("post-stop"))
+}
+
+func TestWatch_TerminatedReachesEveryWatcher(t *testing.T) {
+	system := newSystem(t)
+	first := testkit.NewTestProbe(t, system, "first")
+	second := testkit.NewTestProbe(t, system, "second")
+	target := testkit.Spawn(t, system, "target", &lifecycle{counter{probe: first.Ref()}})
+	first.Watch(target)
+	second.Watch(target)
+
+	target.Stop()
+	first.ExpectMsg(wrapperspb.String("post-stop")) // PostStop runs before the watchers are told
+	terminated := first.ExpectTerminated(target)
+	assert.Equal(t, "target", terminated.Name)
+	assert.Empty(t, terminated.Reason, "a stopped actor has no failure reason")
+	second.ExpectTerminated(target)
+}
+
+func TestWatch_StoppedTargetIsReportedRightAway(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	target := testkit.Spawn(t, system, "target", &counter{})
+	target.Stop()
+
+	probe.Watch(target)
+	probe.ExpectTerminated(target)
+}
+
+func TestWatch_Unwatch(t *testing.T) {
+	system := newSystem(t)
+	probe := testkit.NewTestProbe(t, system, "probe")
+	var watcherCtx actor.IActorContext
+	testkit.Spawn(t, system, "watcher", &counter{probe: probe.Ref(), setup: func(actorCtx actor.IActorContext) {
+		watcherCtx = actorCtx
+	}})
+	require.Error(t, watcherCtx.Watch(watcherCtx.Self()), "an actor cannot watch itself")
+
+	kept := testkit.Spawn(t, system, "kept", &counter{})
+	dropped := testkit.Spawn(t, system, "dropped", &counter{})
+	require.NoError(t, watcherCtx.Watch(kept))
+	require.NoError(t, watcherCtx.Watch(dropped))
+	require.NoError(t, watcherCtx.Unwatch(dropped))
+
+	dropped.Stop()
+	probe.ExpectNoMsg(20 * time.Millisecond)
+	kept.Stop()
+	probe.ExpectTerminated(kept) // The watcher forwards Terminated to the probe
+}
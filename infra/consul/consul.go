@@ -1,7 +1,9 @@
 package consulx
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/phuhao00/pandaparty/config" // Added import
@@ -12,6 +14,9 @@ type ConsulClient struct {
 }
 
 func (c *ConsulClient) GetReal() *api.Client {
+	if c == nil {
+		return nil
+	}
 	return c.client
 }
 
@@ -28,11 +33,18 @@ func NewConsulClient(cfg config.ConsulConfig) (*ConsulClient, error) {
 }
 
 func (c *ConsulClient) RegisterService(id, name, address string, port int) error {
+	return c.RegisterServiceWithMeta(id, name, address, port, nil, nil)
+}
+
+// RegisterServiceWithMeta is RegisterService for an instance with tags and metadata.
+func (c *ConsulClient) RegisterServiceWithMeta(id, name, address string, port int, tags []string, meta map[string]string) error {
 	reg := &api.AgentServiceRegistration{
 		ID:      id,
 		Name:    name,
 		Address: address,
 		Port:    port,
+		Tags:    tags,
+		Meta:    meta,
 	}
 	return c.client.Agent().ServiceRegister(reg)
 }
@@ -68,7 +80,27 @@ func (c *ConsulClient) GetHealthyServices(serviceName string) ([]*ServiceInfo, e
 	if err != nil {
 		return nil, fmt.Errorf("failed to query service %s: %w", serviceName, err)
 	}
+	return toServiceInfos(services), nil
+}
+
+// WatchHealthyServices is GetHealthyServices as a blocking query: it returns once the healthy
+// instances differ from those at waitIndex, or after waitTime, along with the index to wait
+// on next. A waitIndex of 0 returns at once.
+func (c *ConsulClient) WatchHealthyServices(ctx context.Context, serviceName string, waitIndex uint64, waitTime time.Duration) ([]*ServiceInfo, uint64, error) {
+	if c.client == nil {
+		return nil, 0, fmt.Errorf("consul client is not initialized")
+	}
+
+	opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: waitTime}).WithContext(ctx)
+	services, meta, err := c.client.Health().Service(serviceName, "", true, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to watch service %s: %w", serviceName, err)
+	}
+	return toServiceInfos(services), meta.LastIndex, nil
+}
 
+// toServiceInfos converts the entries of a health query.
+func toServiceInfos(services []*api.ServiceEntry) []*ServiceInfo {
 	var result []*ServiceInfo
 	for _, entry := range services {
 		if entry.Service == nil {
@@ -92,7 +124,7 @@ func (c *ConsulClient) GetHealthyServices(serviceName string) ([]*ServiceInfo, e
 		result = append(result, service)
 	}
 
-	return result, nil
+	return result
 }
 
 // GetService returns a specific service instance by ID
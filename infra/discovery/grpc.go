@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// GRPCScheme is the target scheme of gRPC clients resolved by a Resolver, as in
+// "discovery:///gameserver".
+const GRPCScheme = "discovery"
+
+// GRPCBuilder returns a gRPC resolver builder for GRPCScheme targets, whose addresses follow
+// the instances of the target's service, e.g.:
+//
+//	conn, err := grpc.NewClient("discovery:///gameserver", grpc.WithResolvers(r.GRPCBuilder()), ...)
+func (r *Resolver) GRPCBuilder() resolver.Builder {
+	return grpcBuilder{r: r}
+}
+
+type grpcBuilder struct {
+	r *Resolver
+}
+
+func (b grpcBuilder) Scheme() string {
+	return GRPCScheme
+}
+
+func (b grpcBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service := target.Endpoint()
+	unsubscribe, err := b.r.Subscribe(service, func(instances []Instance) {
+		addrs := make([]resolver.Address, 0, len(instances))
+		for _, instance := range instances {
+			addrs = append(addrs, resolver.Address{Addr: instance.Addr()})
+		}
+		_ = cc.UpdateState(resolver.State{Addresses: addrs})
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// Tell gRPC if the service cannot be resolved yet, rather than leave its calls waiting.
+		if _, err := b.r.Instances(ctx, service); err != nil && ctx.Err() == nil {
+			cc.ReportError(err)
+		}
+	}()
+	return &grpcResolver{unsubscribe: unsubscribe, cancel: cancel}, nil
+}
+
+type grpcResolver struct {
+	unsubscribe func()
+	cancel      context.CancelFunc
+}
+
+// ResolveNow does nothing: the watch already follows every change.
+func (g *grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (g *grpcResolver) Close() {
+	g.cancel()
+	g.unsubscribe()
+}
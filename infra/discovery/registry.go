@@ -0,0 +1,398 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/pandaparty/config"
+	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPollInterval is how often a FileRegistry looks for changes.
+const defaultPollInterval = time.Second
+
+// Registry is where the instances of services are registered and found. Consul is the
+// registry of deployments; StaticRegistry, FileRegistry and MemoryRegistry let the servers run
+// without any outside service, on a laptop or in tests.
+type Registry interface {
+	// Register adds instance, or replaces the instance with the same ID.
+	Register(instance Instance) error
+	// Deregister removes the instance with instanceID, if there is one.
+	Deregister(instanceID string) error
+	// Watch returns the instances of service once they differ from those at index, or after
+	// waitTime, along with the index to wait on next. An index of 0 returns at once.
+	Watch(ctx context.Context, service string, index uint64, waitTime time.Duration) ([]Instance, uint64, error)
+}
+
+// NewRegistry returns the registry selected by the discovery section of cfg.
+func NewRegistry(cfg *config.ServerConfig) (Registry, error) {
+	switch cfg.Discovery.Backend {
+	case "", "consul":
+		client, err := consulx.NewConsulClient(cfg.Consul)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: failed to create Consul client: %w", err)
+		}
+		return NewConsulRegistry(client), nil
+	case "static":
+		var instances []Instance
+		for service, addrs := range cfg.Discovery.Static {
+			for _, addr := range addrs {
+				instance, err := staticInstance(service, addr)
+				if err != nil {
+					return nil, err
+				}
+				instances = append(instances, instance)
+			}
+		}
+		return NewStaticRegistry(instances...), nil
+	case "file":
+		registry, err := NewFileRegistry(cfg.Discovery.Dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		return registry, nil
+	case "memory":
+		return NewMemoryRegistry(), nil
+	}
+	return nil, fmt.Errorf("discovery: unknown backend %q", cfg.Discovery.Backend)
+}
+
+// staticInstance parses the "host:port" of a configured instance of service.
+func staticInstance(service, addr string) (Instance, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return Instance{}, fmt.Errorf("discovery: invalid address %q of service %s: %w", addr, service, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Instance{}, fmt.Errorf("discovery: invalid port in address %q of service %s", addr, service)
+	}
+	return Instance{ID: service + "@" + addr, Service: service, Address: host, Port: port}, nil
+}
+
+// validate checks the fields every registry needs.
+func (i Instance) validate() error {
+	if i.ID == "" || i.Service == "" {
+		return fmt.Errorf("discovery: instance %q of service %q needs an ID and a service", i.ID, i.Service)
+	}
+	return nil
+}
+
+// ConsulRegistry registers instances with the local Consul agent and finds the healthy ones.
+type ConsulRegistry struct {
+	client *consulx.ConsulClient
+}
+
+// NewConsulRegistry creates a registry on client.
+func NewConsulRegistry(client *consulx.ConsulClient) *ConsulRegistry {
+	return &ConsulRegistry{client: client}
+}
+
+// Client returns the Consul client of the registry, for components that still talk to Consul directly.
+func (r *ConsulRegistry) Client() *consulx.ConsulClient {
+	return r.client
+}
+
+// Register implements Registry.
+func (r *ConsulRegistry) Register(instance Instance) error {
+	if err := instance.validate(); err != nil {
+		return err
+	}
+	return r.client.RegisterServiceWithMeta(instance.ID, instance.Service, instance.Address, instance.Port, instance.Tags, instance.Meta)
+}
+
+// Deregister implements Registry.
+func (r *ConsulRegistry) Deregister(instanceID string) error {
+	return r.client.DeregisterService(instanceID)
+}
+
+// Watch implements Registry with a Consul blocking query.
+func (r *ConsulRegistry) Watch(ctx context.Context, service string, index uint64, waitTime time.Duration) ([]Instance, uint64, error) {
+	if r.client == nil {
+		return nil, 0, fmt.Errorf("consul client is not initialized")
+	}
+	services, next, err := r.client.WatchHealthyServices(ctx, service, index, waitTime)
+	if err != nil {
+		return nil, 0, err
+	}
+	instances := make([]Instance, 0, len(services))
+	for _, s := range services {
+		instances = append(instances, Instance{ID: s.ID, Service: s.Name, Address: s.Address, Port: s.Port, Tags: s.Tags, Meta: s.Meta})
+	}
+	return instances, next, nil
+}
+
+// MemoryRegistry keeps instances in memory, for the servers of one process, e.g. in tests.
+// It is safe for concurrent use.
+type MemoryRegistry struct {
+	mu        sync.Mutex
+	index     uint64              // Incremented on every change
+	instances map[string]Instance // By ID
+	changed   chan struct{}       // Closed and replaced on every change
+}
+
+// NewMemoryRegistry creates an empty registry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{index: 1, instances: make(map[string]Instance), changed: make(chan struct{})}
+}
+
+// Register implements Registry.
+func (r *MemoryRegistry) Register(instance Instance) error {
+	if err := instance.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.instances[instance.ID] = instance
+	r.notifyLocked()
+	return nil
+}
+
+// Deregister implements Registry.
+func (r *MemoryRegistry) Deregister(instanceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.instances[instanceID]; ok {
+		delete(r.instances, instanceID)
+		r.notifyLocked()
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) notifyLocked() {
+	r.index++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Watch implements Registry. The index counts changes to any service.
+func (r *MemoryRegistry) Watch(ctx context.Context, service string, index uint64, waitTime time.Duration) ([]Instance, uint64, error) {
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		current, changed := r.index, r.changed
+		var instances []Instance
+		if current != index {
+			for _, instance := range r.instances {
+				if instance.Service == service {
+					instances = append(instances, instance)
+				}
+			}
+		}
+		r.mu.Unlock()
+		if current != index {
+			return instances, current, nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			index = 0 // Answer with the unchanged instances
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// StaticRegistry serves a fixed list of instances, e.g. from the configuration. Register and
+// Deregister do nothing: the list is the truth.
+type StaticRegistry struct {
+	mem *MemoryRegistry
+}
+
+// NewStaticRegistry creates a registry of instances.
+func NewStaticRegistry(instances ...Instance) *StaticRegistry {
+	mem := NewMemoryRegistry()
+	for _, instance := range instances {
+		mem.instances[instance.ID] = instance
+	}
+	return &StaticRegistry{mem: mem}
+}
+
+// Register implements Registry.
+func (r *StaticRegistry) Register(instance Instance) error {
+	return instance.validate()
+}
+
+// Deregister implements Registry.
+func (r *StaticRegistry) Deregister(instanceID string) error {
+	return nil
+}
+
+// Watch implements Registry.
+func (r *StaticRegistry) Watch(ctx context.Context, service string, index uint64, waitTime time.Duration) ([]Instance, uint64, error) {
+	return r.mem.Watch(ctx, service, index, waitTime)
+}
+
+// FileRegistry keeps instances as files in a directory, so that the servers of a machine can
+// find each other without Consul. Register writes "<ID>.json" and Deregister removes it; the
+// directory may also hold hand-written files, in JSON or YAML, each with an instance or a list
+// of instances. Watch reads the directory again every poll interval.
+type FileRegistry struct {
+	dir          string
+	pollInterval time.Duration
+}
+
+// NewFileRegistry creates a registry in dir, creating the directory if needed. If
+// pollInterval <= 0, defaults to defaultPollInterval.
+func NewFileRegistry(dir string, pollInterval time.Duration) (*FileRegistry, error) {
+	if dir == "" {
+		return nil, errors.New("discovery: the file backend needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("discovery: failed to create registry directory: %w", err)
+	}
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	return &FileRegistry{dir: dir, pollInterval: pollInterval}, nil
+}
+
+// Register implements Registry. The file is replaced atomically, so readers never see it
+// half written.
+func (r *FileRegistry) Register(instance Instance) error {
+	if err := instance.validate(); err != nil {
+		return err
+	}
+	path, err := r.path(instance.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(instance, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.dir, ".register-*")
+	if err != nil {
+		return fmt.Errorf("discovery: failed to register %s: %w", instance.ID, err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("discovery: failed to register %s: %w", instance.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("discovery: failed to register %s: %w", instance.ID, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("discovery: failed to register %s: %w", instance.ID, err)
+	}
+	return nil
+}
+
+// Deregister implements Registry.
+func (r *FileRegistry) Deregister(instanceID string) error {
+	path, err := r.path(instanceID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("discovery: failed to deregister %s: %w", instanceID, err)
+	}
+	return nil
+}
+
+// path returns the file of instanceID.
+func (r *FileRegistry) path(instanceID string) (string, error) {
+	if instanceID == "" || instanceID != filepath.Base(instanceID) || strings.HasPrefix(instanceID, ".") {
+		return "", fmt.Errorf("discovery: instance ID %q cannot be a file name", instanceID)
+	}
+	return filepath.Join(r.dir, instanceID+".json"), nil
+}
+
+// Watch implements Registry. The index is a hash of the instances of service.
+func (r *FileRegistry) Watch(ctx context.Context, service string, index uint64, waitTime time.Duration) ([]Instance, uint64, error) {
+	deadline := time.Now().Add(waitTime)
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		instances, err := r.read(service)
+		if err != nil {
+			return nil, 0, err
+		}
+		current := hashInstances(instances)
+		if current != index || !time.Now().Before(deadline) {
+			return instances, current, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// read returns the instances of service in the directory, sorted by ID. Files that are not
+// JSON or YAML are ignored; a malformed one fails the read.
+func (r *FileRegistry) read(service string) ([]Instance, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: failed to read registry directory: %w", err)
+	}
+	var instances []Instance
+	for _, entry := range entries {
+		name := entry.Name()
+		switch filepath.Ext(name) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(r.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deregistered meanwhile
+		}
+		if err != nil {
+			return nil, fmt.Errorf("discovery: failed to read %s: %w", name, err)
+		}
+		found, err := parseInstances(data)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: malformed registry file %s: %w", name, err)
+		}
+		for _, instance := range found {
+			if instance.Service == service {
+				instances = append(instances, instance)
+			}
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, nil
+}
+
+// parseInstances decodes a registry file, which holds an instance or a list of them. YAML
+// decoding covers JSON too.
+func parseInstances(data []byte) ([]Instance, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) || bytes.HasPrefix(data, []byte("-")) {
+		var instances []Instance
+		err := yaml.Unmarshal(data, &instances)
+		return instances, err
+	}
+	var instance Instance
+	if err := yaml.Unmarshal(data, &instance); err != nil {
+		return nil, err
+	}
+	return []Instance{instance}, nil
+}
+
+// hashInstances returns a non-zero hash of instances, used as the index of a FileRegistry.
+func hashInstances(instances []Instance) uint64 {
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(instances)
+	return h.Sum64() | 1
+}
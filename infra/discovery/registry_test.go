@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/pandaparty/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_RegisterWatchDeregister(t *testing.T) {
+	fileRegistry, err := NewFileRegistry(t.TempDir(), 10*time.Millisecond)
+	require.NoError(t, err)
+	for name, registry := range map[string]Registry{"memory": NewMemoryRegistry(), "file": fileRegistry} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, registry.Register(Instance{ID: "game-1", Service: "gameserver", Address: "127.0.0.1", Port: 9001, Meta: map[string]string{"weight": "2"}}))
+			require.NoError(t, registry.Register(Instance{ID: "room-1", Service: "roomserver", Address: "127.0.0.1", Port: 9002}))
+			assert.Error(t, registry.Register(Instance{ID: "nameless"}))
+
+			instances, index, err := registry.Watch(ctx, "gameserver", 0, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, []Instance{{ID: "game-1", Service: "gameserver", Address: "127.0.0.1", Port: 9001, Meta: map[string]string{"weight": "2"}}}, instances)
+
+			// A watch at the current index waits for a change.
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				assert.NoError(t, registry.Register(Instance{ID: "game-2", Service: "gameserver", Address: "127.0.0.1", Port: 9003}))
+			}()
+			start := time.Now()
+			instances, index, err = registry.Watch(ctx, "gameserver", index, 5*time.Second)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+			assert.Equal(t, []string{"game-1", "game-2"}, sortedIDs(instances))
+
+			// Without a change it returns after the wait time.
+			start = time.Now()
+			instances, _, err = registry.Watch(ctx, "roomserver", 0, time.Second)
+			require.NoError(t, err)
+			_, _, err = registry.Watch(ctx, "gameserver", index, 100*time.Millisecond)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+			assert.Equal(t, []string{"room-1"}, sortedIDs(instances))
+
+			require.NoError(t, registry.Deregister("game-1"))
+			require.NoError(t, registry.Deregister("game-1"), "deregistering twice is harmless")
+			instances, index, err = registry.Watch(ctx, "gameserver", index, 5*time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"game-2"}, sortedIDs(instances))
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, _, err = registry.Watch(cancelled, "gameserver", index, time.Second)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func sortedIDs(instances []Instance) []string {
+	result := ids(instances)
+	sort.Strings(result)
+	return result
+}
+
+func TestFileRegistry_HandWrittenFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "chat.yaml"), []byte(`
+- id: chat-1
+  service: chatserver
+  address: 10.0.0.1
+  port: 7000
+- id: chat-2
+  service: chatserver
+  address: 10.0.0.2
+  port: 7000
+  tags: [eu]
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an instance"), 0o644))
+	registry, err := NewFileRegistry(dir, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	instances, _, err := registry.Watch(context.Background(), "chatserver", 0, time.Second)
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	assert.Equal(t, "10.0.0.2:7000", instances[1].Addr())
+	assert.Equal(t, []string{"eu"}, instances[1].Tags)
+
+	assert.Error(t, registry.Register(Instance{ID: "../escape", Service: "chatserver"}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644))
+	_, _, err = registry.Watch(context.Background(), "chatserver", 0, time.Second)
+	assert.ErrorContains(t, err, "broken.json")
+}
+
+func TestNewRegistry(t *testing.T) {
+	registry, err := NewRegistry(&config.ServerConfig{Discovery: config.DiscoveryConfig{
+		Backend: "static",
+		Static:  map[string][]string{"gameserver-rpc": {"localhost:50051", "localhost:50061"}},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(Instance{ID: "game-3", Service: "gameserver-rpc", Address: "localhost", Port: 50071}))
+	instances, _, err := registry.Watch(context.Background(), "gameserver-rpc", 0, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gameserver-rpc@localhost:50051", "gameserver-rpc@localhost:50061"}, sortedIDs(instances), "the configuration is the truth")
+
+	_, err = NewRegistry(&config.ServerConfig{Discovery: config.DiscoveryConfig{Backend: "static", Static: map[string][]string{"gameserver-rpc": {"localhost"}}}})
+	assert.Error(t, err)
+	_, err = NewRegistry(&config.ServerConfig{Discovery: config.DiscoveryConfig{Backend: "file"}})
+	assert.Error(t, err, "the file backend needs a directory")
+	_, err = NewRegistry(&config.ServerConfig{Discovery: config.DiscoveryConfig{Backend: "zookeeper"}})
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	registry, err = NewRegistry(&config.ServerConfig{Discovery: config.DiscoveryConfig{Backend: "file", Dir: dir}})
+	require.NoError(t, err)
+	assert.IsType(t, &FileRegistry{}, registry)
+	registry, err = NewRegistry(&config.ServerConfig{})
+	require.NoError(t, err)
+	assert.IsType(t, &ConsulRegistry{}, registry, "Consul is the default")
+}
+
+func TestResolver_OnMemoryRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Register(Instance{ID: "room-1", Service: "roomserver", Address: "127.0.0.1", Port: 9001}))
+	r := NewResolver(registry, WithWaitTime(time.Second))
+	defer r.Close()
+
+	instances, err := r.Instances(context.Background(), "roomserver")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"room-1"}, ids(instances))
+
+	require.NoError(t, registry.Register(Instance{ID: "room-2", Service: "roomserver", Address: "127.0.0.1", Port: 9002}))
+	assert.Eventually(t, func() bool {
+		instances, _ := r.Instances(context.Background(), "roomserver")
+		return len(instances) == 2
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,276 @@
+// Package discovery registers and finds the instances of services. Instances are kept in a
+// Registry: Consul in deployments, or a static list, a shared directory or memory to run
+// without outside services. A Resolver keeps a local cache of the instances of each service it
+// is asked about, kept up to date in the background by watching the registry, so that callers
+// never wait on the registry once a service is known. One Resolver is meant to be shared by all
+// clients of a process: the RPCClient, gRPC clients (see GRPCBuilder) and anything else that
+// needs addresses.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	consulx "github.com/phuhao00/pandaparty/infra/consul"
+)
+
+// Default values for Resolver configuration.
+const (
+	defaultWaitTime      = time.Minute      // Longest a blocking query waits for a change
+	defaultRetryInterval = time.Second      // First wait after a failed query
+	maxRetryInterval     = 30 * time.Second // Longest wait between failed queries
+)
+
+// ErrResolverClosed is returned by a Resolver after Close.
+var ErrResolverClosed = errors.New("resolver closed")
+
+// Instance is one healthy instance of a service.
+type Instance struct {
+	ID      string            `json:"id" yaml:"id"`
+	Service string            `json:"service" yaml:"service"`
+	Address string            `json:"address" yaml:"address"`
+	Port    int               `json:"port" yaml:"port"`
+	Tags    []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Addr returns the "host:port" of the instance.
+func (i Instance) Addr() string {
+	return net.JoinHostPort(i.Address, strconv.Itoa(i.Port))
+}
+
+// Resolver caches the instances of services. The first request for a service queries the
+// registry and starts a watch on it; later requests are answered from the cache, which the
+// watch updates as instances come and go. When the registry cannot be reached the cache keeps
+// the last list it got, and the watch retries with backoff. It is safe for concurrent use.
+type Resolver struct {
+	registry      Registry
+	waitTime      time.Duration
+	retryInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*serviceWatch
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ResolverOption configures a Resolver.
+type ResolverOption func(*Resolver)
+
+// WithWaitTime sets how long a blocking query waits for a change before it is renewed. If
+// d <= 0, defaults to defaultWaitTime.
+func WithWaitTime(d time.Duration) ResolverOption {
+	return func(r *Resolver) {
+		if d > 0 {
+			r.waitTime = d
+		}
+	}
+}
+
+// WithRetryInterval sets the wait after a failed query; it doubles with each further failure,
+// up to maxRetryInterval. If d <= 0, defaults to defaultRetryInterval.
+func WithRetryInterval(d time.Duration) ResolverOption {
+	return func(r *Resolver) {
+		if d > 0 {
+			r.retryInterval = d
+		}
+	}
+}
+
+// NewConsulResolver creates a resolver watching the healthy instances of services in Consul.
+func NewConsulResolver(client *consulx.ConsulClient, opts ...ResolverOption) *Resolver {
+	return NewResolver(NewConsulRegistry(client), opts...)
+}
+
+// NewResolver creates a resolver watching the instances of services in registry.
+func NewResolver(registry Registry, opts ...ResolverOption) *Resolver {
+	r := &Resolver{
+		registry:      registry,
+		waitTime:      defaultWaitTime,
+		retryInterval: defaultRetryInterval,
+		services:      make(map[string]*serviceWatch),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// serviceWatch is the cache of one service and the subscribers to its changes.
+type serviceWatch struct {
+	name     string
+	ready    chan struct{} // Closed once the first query has returned
+	notifyMu sync.Mutex    // Held while subscribers are called, so they see changes in order
+
+	mu        sync.Mutex
+	instances []Instance // Last good list, sorted by ID
+	known     bool       // instances holds a list from the registry
+	err       error      // Error of the last query, nil if it succeeded
+	subs      map[int]func([]Instance)
+	nextSub   int
+}
+
+// Instances returns the healthy instances of service, sorted by ID. It only waits, bounded by
+// ctx, on the first request for a service; if the registry has never answered for the service,
+// it returns the error of the last query. The returned slice must not be modified.
+func (r *Resolver) Instances(ctx context.Context, service string) ([]Instance, error) {
+	w, err := r.watch(service)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("discovery: waiting for instances of %s: %w", service, ctx.Err())
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.known {
+		return nil, fmt.Errorf("discovery: no instances of %s known: %w", service, w.err)
+	}
+	return w.instances, nil
+}
+
+// Subscribe calls fn with the instances of service once they are known, and again every time
+// they change, until the returned function is called. Calls for one service are made one at a
+// time, from the goroutine watching it, so fn should not block; it must not modify the slice.
+func (r *Resolver) Subscribe(service string, fn func(instances []Instance)) (unsubscribe func(), err error) {
+	w, err := r.watch(service)
+	if err != nil {
+		return nil, err
+	}
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+	w.mu.Lock()
+	id := w.nextSub
+	w.nextSub++
+	w.subs[id] = fn
+	known, instances := w.known, w.instances
+	w.mu.Unlock()
+	if known {
+		fn(instances)
+	}
+	return func() {
+		w.mu.Lock()
+		delete(w.subs, id)
+		w.mu.Unlock()
+	}, nil
+}
+
+// Close stops all watches. Requests made after Close fail with ErrResolverClosed.
+func (r *Resolver) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	r.mu.Unlock()
+	r.cancel()
+	r.wg.Wait()
+}
+
+// watch returns the cache of service, starting its watch on first use.
+func (r *Resolver) watch(service string) (*serviceWatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrResolverClosed
+	}
+	w, ok := r.services[service]
+	if !ok {
+		w = &serviceWatch{name: service, ready: make(chan struct{}), subs: make(map[int]func([]Instance))}
+		r.services[service] = w
+		r.wg.Add(1)
+		go r.run(w)
+	}
+	return w, nil
+}
+
+// run keeps the cache of w up to date until the resolver is closed.
+func (r *Resolver) run(w *serviceWatch) {
+	defer r.wg.Done()
+	var index uint64
+	retry := r.retryInterval
+	first := true
+	defer func() {
+		if first {
+			w.fail(ErrResolverClosed)
+			close(w.ready)
+		}
+	}()
+	for {
+		instances, next, err := r.registry.Watch(r.ctx, w.name, index, r.waitTime)
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.fail(err)
+			if first {
+				close(w.ready)
+				first = false
+			}
+			log.Printf("discovery: Query for service '%s' failed, retrying in %v: %v", w.name, retry, err)
+			select {
+			case <-time.After(retry):
+			case <-r.ctx.Done():
+				return
+			}
+			retry = min(2*retry, maxRetryInterval)
+			continue
+		}
+		retry = r.retryInterval
+
+		// The index may go backwards, e.g. when the Consul leader changes, and the next query
+		// then returns at once like for any stale index; it must not be 0, which never blocks.
+		index = max(next, 1)
+		w.notifyMu.Lock()
+		if subs, changed := w.update(instances); changed {
+			log.Printf("discovery: Service '%s' now has %d instances", w.name, len(instances))
+			for _, fn := range subs {
+				fn(instances)
+			}
+		}
+		w.notifyMu.Unlock()
+		if first {
+			close(w.ready)
+			first = false
+		}
+	}
+}
+
+// fail records a failed query. The last good list, if any, stays in use.
+func (w *serviceWatch) fail(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+}
+
+// update stores instances, and returns the subscribers to notify if they differ from the
+// previous list.
+func (w *serviceWatch) update(instances []Instance) (subs []func([]Instance), changed bool) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = nil
+	if w.known && reflect.DeepEqual(w.instances, instances) {
+		return nil, false
+	}
+	w.instances, w.known = instances, true
+	for _, fn := range w.subs {
+		subs = append(subs, fn)
+	}
+	return subs, true
+}
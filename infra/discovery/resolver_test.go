@@ -0,0 +1,232 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/phuhao00/pandaparty/config"
+	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+// fakeConsul serves the health endpoint of Consul, with blocking queries.
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	services map[string][]*api.ServiceEntry
+	changed  chan struct{} // Closed and replaced on every change
+	down     bool
+	queries  atomic.Int32
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *consulx.ConsulClient) {
+	f := &fakeConsul{index: 1, services: make(map[string][]*api.ServiceEntry), changed: make(chan struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(f.serveHealth))
+	t.Cleanup(srv.Close)
+	client, err := consulx.NewConsulClient(config.ConsulConfig{Addr: strings.TrimPrefix(srv.URL, "http://")})
+	require.NoError(t, err)
+	return f, client
+}
+
+func (f *fakeConsul) set(service string, ids ...string) {
+	entries := make([]*api.ServiceEntry, 0, len(ids))
+	for i, id := range ids {
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Address: "10.0.0.1"},
+			Service: &api.AgentService{ID: id, Service: service, Port: 9000 + i, Meta: map[string]string{"weight": "1"}},
+		})
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.services[service] = entries
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) serveHealth(w http.ResponseWriter, r *http.Request) {
+	f.queries.Add(1)
+	service := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	timeout := time.After(wait)
+	for {
+		f.mu.Lock()
+		index, entries, down, changed := f.index, f.services[service], f.down, f.changed
+		f.mu.Unlock()
+		if down {
+			http.Error(w, "no cluster leader", http.StatusInternalServerError)
+			return
+		}
+		if waitIndex < index {
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+			_ = json.NewEncoder(w).Encode(entries)
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			waitIndex = 0 // Answer with the unchanged list
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func ids(instances []Instance) []string {
+	var result []string
+	for _, instance := range instances {
+		result = append(result, instance.ID)
+	}
+	return result
+}
+
+func TestResolver_CachesAndFollowsChanges(t *testing.T) {
+	consul, client := newFakeConsul(t)
+	consul.set("roomserver", "room-2", "room-1")
+	r := NewConsulResolver(client, WithWaitTime(time.Second))
+	defer r.Close()
+
+	ctx := context.Background()
+	instances, err := r.Instances(ctx, "roomserver")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"room-1", "room-2"}, ids(instances), "instances are sorted by ID")
+	assert.Equal(t, "10.0.0.1:9001", instances[0].Addr(), "the node address stands in for a missing service address")
+	assert.Equal(t, "1", instances[0].Meta["weight"])
+	for i := 0; i < 100; i++ {
+		_, err := r.Instances(ctx, "roomserver")
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, consul.queries.Load(), int32(2), "lookups are served from the cache")
+
+	var mu sync.Mutex
+	var seen [][]string
+	unsubscribe, err := r.Subscribe("roomserver", func(instances []Instance) {
+		mu.Lock()
+		seen = append(seen, ids(instances))
+		mu.Unlock()
+	})
+	require.NoError(t, err)
+	seenSoFar := func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]string(nil), seen...)
+	}
+	consul.set("roomserver", "room-1", "room-2", "room-3")
+	assert.Eventually(t, func() bool { return len(seenSoFar()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [][]string{{"room-1", "room-2"}, {"room-1", "room-2", "room-3"}}, seenSoFar())
+	instances, err = r.Instances(ctx, "roomserver")
+	require.NoError(t, err)
+	assert.Len(t, instances, 3)
+
+	// Renewed queries that find nothing new do not notify.
+	time.Sleep(1200 * time.Millisecond)
+	assert.Len(t, seenSoFar(), 2)
+
+	unsubscribe()
+	consul.set("roomserver", "room-1")
+	assert.Eventually(t, func() bool {
+		instances, _ := r.Instances(ctx, "roomserver")
+		return len(instances) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, seenSoFar(), 2)
+}
+
+func TestResolver_KeepsLastGoodListWhenConsulIsDown(t *testing.T) {
+	consul, client := newFakeConsul(t)
+	consul.set("gameserver", "game-1")
+	r := NewConsulResolver(client, WithWaitTime(time.Second), WithRetryInterval(10*time.Millisecond))
+	defer r.Close()
+
+	ctx := context.Background()
+	_, err := r.Instances(ctx, "gameserver")
+	require.NoError(t, err)
+
+	consul.setDown(true)
+	_, err = r.Instances(ctx, "chatserver")
+	assert.Error(t, err, "a service never resolved has no fallback")
+	assert.Eventually(t, func() bool { return consul.queries.Load() > 5 }, time.Second, 5*time.Millisecond, "the watch retries")
+	instances, err := r.Instances(ctx, "gameserver")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"game-1"}, ids(instances))
+
+	consul.set("gameserver", "game-1", "game-2")
+	consul.setDown(false)
+	assert.Eventually(t, func() bool {
+		instances, _ := r.Instances(ctx, "gameserver")
+		return len(instances) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	r.Close()
+	_, err = r.Instances(ctx, "gameserver")
+	assert.True(t, errors.Is(err, ErrResolverClosed))
+}
+
+// fakeClientConn records what a gRPC resolver reports.
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	cc.states <- state
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {
+	cc.errs <- err
+}
+
+func TestResolver_GRPCBuilder(t *testing.T) {
+	consul, client := newFakeConsul(t)
+	consul.set("gameserver", "game-1")
+	r := NewConsulResolver(client, WithWaitTime(time.Second))
+	defer r.Close()
+
+	builder := r.GRPCBuilder()
+	assert.Equal(t, GRPCScheme, builder.Scheme())
+	cc := &fakeClientConn{states: make(chan resolver.State, 10), errs: make(chan error, 10)}
+	target := resolver.Target{URL: *mustParseURL(t, "discovery:///gameserver")}
+	res, err := builder.Build(target, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer res.Close()
+
+	state := <-cc.states
+	assert.Equal(t, []resolver.Address{{Addr: "10.0.0.1:9000"}}, state.Addresses)
+	consul.set("gameserver", "game-1", "game-2")
+	select {
+	case state = <-cc.states:
+		assert.Len(t, state.Addresses, 2)
+	case <-time.After(time.Second):
+		t.Fatal("the gRPC client was not told about the new instance")
+	}
+	assert.Empty(t, cc.errs)
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
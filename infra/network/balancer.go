@@ -0,0 +1,221 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+)
+
+// Instance is one endpoint of a service, as found by service discovery.
+type Instance struct {
+	ID   string            // Instance ID in the registry
+	Addr string            // "host:port" to dial
+	Meta map[string]string // Metadata registered with the instance
+}
+
+// MetaWeight is the key of the instance metadata WeightedBalancer reads the weight from, a
+// positive integer; instances without it have weight 1.
+const MetaWeight = "weight"
+
+// PickInfo describes the call an instance is picked for.
+type PickInfo struct {
+	Service    string // Service called
+	Method     string // Method called
+	RoutingKey string // Key of the call, see WithRoutingKey; "" if none
+
+	// InFlight returns the number of calls and streams this client has in flight to addr.
+	InFlight func(addr string) int
+}
+
+// Balancer picks the instance of a service a call goes to. Pick is called concurrently by
+// all callers with the instances that are available, never none, and must not modify them.
+type Balancer interface {
+	Pick(info PickInfo, instances []Instance) Instance
+}
+
+type routingKey struct{}
+
+// WithRoutingKey returns a copy of ctx whose calls carry key for ConsistentHashBalancer, e.g.
+// a room ID so that all calls for the room reach the same instance. The key is not sent.
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKey{}, key)
+}
+
+// RoutingKeyFromContext returns the routing key of ctx, or "".
+func RoutingKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(routingKey{}).(string)
+	return key
+}
+
+// WithBalancer sets the balancer of the services that have none of their own. The default
+// is RoundRobinBalancer.
+func WithBalancer(b Balancer) ClientOption {
+	return func(c *RPCClient) {
+		c.balancer = b
+	}
+}
+
+// WithServiceBalancer sets the balancer of serviceName, e.g. ConsistentHashBalancer for a
+// service that keeps state per key.
+func WithServiceBalancer(serviceName string, b Balancer) ClientOption {
+	return func(c *RPCClient) {
+		c.serviceBalancers[serviceName] = b
+	}
+}
+
+// RoundRobinBalancer picks the instances of each service in turn.
+func RoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{next: make(map[string]uint64)}
+}
+
+type roundRobinBalancer struct {
+	mu   sync.Mutex
+	next map[string]uint64 // Counter per service
+}
+
+func (b *roundRobinBalancer) Pick(info PickInfo, instances []Instance) Instance {
+	b.mu.Lock()
+	i := b.next[info.Service]
+	b.next[info.Service] = i + 1
+	b.mu.Unlock()
+	return instances[i%uint64(len(instances))]
+}
+
+// WeightedBalancer picks instances in proportion to their MetaWeight, spreading the picks of
+// each instance evenly (smooth weighted round-robin): with weights 3 and 1, one instance in
+// four picks goes to the second.
+func WeightedBalancer() Balancer {
+	return &weightedBalancer{current: make(map[string]map[string]int)}
+}
+
+type weightedBalancer struct {
+	mu      sync.Mutex
+	current map[string]map[string]int // Current weight per service and instance address
+}
+
+func (b *weightedBalancer) Pick(info PickInfo, instances []Instance) Instance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.current[info.Service]
+	current := make(map[string]int, len(instances)) // Instances gone from the service are dropped
+	best, total := 0, 0
+	for i, instance := range instances {
+		w := instanceWeight(instance)
+		current[instance.Addr] = prev[instance.Addr] + w
+		total += w
+		if current[instance.Addr] > current[instances[best].Addr] {
+			best = i
+		}
+	}
+	current[instances[best].Addr] -= total
+	b.current[info.Service] = current
+	return instances[best]
+}
+
+// instanceWeight returns the MetaWeight of instance, or 1 if it has no valid one.
+func instanceWeight(instance Instance) int {
+	w, err := strconv.Atoi(instance.Meta[MetaWeight])
+	if err != nil || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// LeastOutstandingBalancer picks the instance with the fewest calls in flight from this
+// client, at random among ties.
+func LeastOutstandingBalancer() Balancer {
+	return leastOutstandingBalancer{}
+}
+
+type leastOutstandingBalancer struct{}
+
+func (leastOutstandingBalancer) Pick(info PickInfo, instances []Instance) Instance {
+	start := rand.IntN(len(instances)) // Scanning from a random instance spreads ties
+	best, bestLoad := start, info.InFlight(instances[start].Addr)
+	for n := 1; n < len(instances) && bestLoad > 0; n++ {
+		i := (start + n) % len(instances)
+		if load := info.InFlight(instances[i].Addr); load < bestLoad {
+			best, bestLoad = i, load
+		}
+	}
+	return instances[best]
+}
+
+// PowerOfTwoBalancer picks two instances at random and takes the one with fewer calls in
+// flight from this client. It is nearly as good as LeastOutstandingBalancer, and less prone
+// to sending a burst of calls to the same instance before they show up in its load.
+func PowerOfTwoBalancer() Balancer {
+	return powerOfTwoBalancer{}
+}
+
+type powerOfTwoBalancer struct{}
+
+func (powerOfTwoBalancer) Pick(info PickInfo, instances []Instance) Instance {
+	if len(instances) == 1 {
+		return instances[0]
+	}
+	i := rand.IntN(len(instances))
+	j := rand.IntN(len(instances) - 1)
+	if j >= i {
+		j++ // Two different instances
+	}
+	if info.InFlight(instances[j].Addr) < info.InFlight(instances[i].Addr) {
+		return instances[j]
+	}
+	return instances[i]
+}
+
+// ConsistentHashBalancer sends all calls with the same routing key (see WithRoutingKey) to the
+// same instance, e.g. all calls for one room to the roomserver that holds it. When an instance
+// is added or removed, or skipped because its circuit breaker is open, only the keys of that
+// instance move. Calls without a routing key are spread round-robin.
+func ConsistentHashBalancer() Balancer {
+	return &consistentHashBalancer{fallback: RoundRobinBalancer()}
+}
+
+type consistentHashBalancer struct {
+	fallback Balancer
+}
+
+// Pick uses rendezvous hashing: the instance with the highest hash of (instance, key) wins.
+// Instances are identified by ID, or by address if they have none, so that an instance that
+// moves keeps its keys.
+func (b *consistentHashBalancer) Pick(info PickInfo, instances []Instance) Instance {
+	if info.RoutingKey == "" {
+		return b.fallback.Pick(info, instances)
+	}
+	var best int
+	var bestScore uint64
+	for i, instance := range instances {
+		id := instance.ID
+		if id == "" {
+			id = instance.Addr
+		}
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s#%s", id, info.RoutingKey)
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return instances[best]
+}
+
+// inFlight returns the number of calls and streams in flight to endpointAddress.
+func (c *RPCClient) inFlight(endpointAddress string) int {
+	c.mu.Lock()
+	pool, ok := c.pools[endpointAddress]
+	c.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	n := 0
+	for _, cc := range pool.conns {
+		n += cc.inFlight()
+	}
+	return n
+}
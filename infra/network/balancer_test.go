@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testInstances(n int) []Instance {
+	instances := make([]Instance, n)
+	for i := range instances {
+		instances[i] = Instance{ID: fmt.Sprintf("room-%d", i), Addr: fmt.Sprintf("10.0.0.%d:9000", i)}
+	}
+	return instances
+}
+
+func pickCounts(b Balancer, info PickInfo, instances []Instance, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		counts[b.Pick(info, instances).Addr]++
+	}
+	return counts
+}
+
+func TestBalancer_RoundRobinAndWeighted(t *testing.T) {
+	instances := testInstances(3)
+	info := PickInfo{Service: "room"}
+	assert.Equal(t, map[string]int{instances[0].Addr: 2, instances[1].Addr: 2, instances[2].Addr: 2}, pickCounts(RoundRobinBalancer(), info, instances, 6))
+
+	instances[0].Meta = map[string]string{MetaWeight: "3"}
+	instances[2].Meta = map[string]string{MetaWeight: "not a number"}
+	weighted := WeightedBalancer()
+	var picks []string
+	for i := 0; i < 5; i++ {
+		picks = append(picks, weighted.Pick(info, instances).Addr)
+	}
+	assert.Equal(t, []string{instances[0].Addr, instances[1].Addr, instances[0].Addr, instances[2].Addr, instances[0].Addr}, picks, "the heavy instance's picks should be spread out")
+	assert.Equal(t, map[string]int{instances[0].Addr: 300, instances[1].Addr: 100, instances[2].Addr: 100}, pickCounts(weighted, info, instances, 500))
+
+	// An instance that leaves takes its state with it.
+	assert.Equal(t, map[string]int{instances[1].Addr: 2}, pickCounts(weighted, info, instances[1:2], 2))
+}
+
+func TestBalancer_LoadAware(t *testing.T) {
+	instances := testInstances(4)
+	load := map[string]int{instances[0].Addr: 5, instances[1].Addr: 1, instances[2].Addr: 3, instances[3].Addr: 4}
+	info := PickInfo{Service: "room", InFlight: func(addr string) int { return load[addr] }}
+
+	assert.Equal(t, map[string]int{instances[1].Addr: 50}, pickCounts(LeastOutstandingBalancer(), info, instances, 50))
+
+	counts := pickCounts(PowerOfTwoBalancer(), info, instances, 1000)
+	assert.Zero(t, counts[instances[0].Addr], "the busiest instance never beats another")
+	assert.Greater(t, counts[instances[1].Addr], counts[instances[2].Addr])
+	assert.Greater(t, counts[instances[2].Addr], counts[instances[3].Addr])
+	assert.Equal(t, map[string]int{instances[3].Addr: 3}, pickCounts(PowerOfTwoBalancer(), info, instances[3:], 3))
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	instances := testInstances(4)
+	b := ConsistentHashBalancer()
+	owners := map[string]Instance{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("room-id-%d", i)
+		owners[key] = b.Pick(PickInfo{Service: "room", RoutingKey: key}, instances)
+		assert.Equal(t, owners[key], b.Pick(PickInfo{Service: "room", RoutingKey: key}, instances))
+	}
+	assert.Len(t, pickCounts(b, PickInfo{Service: "room"}, instances, 4), 4, "calls without a key are spread")
+
+	// Losing an instance only moves its keys; an instance that comes back at another address
+	// gets its keys back.
+	for key, owner := range owners {
+		now := b.Pick(PickInfo{Service: "room", RoutingKey: key}, instances[1:])
+		if owner.ID != instances[0].ID {
+			assert.Equal(t, owner, now)
+		}
+	}
+	moved := append([]Instance{{ID: instances[0].ID, Addr: "10.0.1.1:9000"}}, instances[1:]...)
+	for key, owner := range owners {
+		assert.Equal(t, owner.ID, b.Pick(PickInfo{Service: "room", RoutingKey: key}, moved).ID)
+	}
+}
+
+func TestRPCClient_PicksWithServiceBalancer(t *testing.T) {
+	instances := testInstances(3)
+	rpcClient := NewRPCClient(nil, 1, 0, WithServiceBalancer("room", ConsistentHashBalancer()), WithBalancer(LeastOutstandingBalancer()))
+	ctx := WithRoutingKey(context.Background(), "room-42")
+	assert.Equal(t, "room-42", RoutingKeyFromContext(ctx))
+
+	owner, err := rpcClient.pick(PickInfo{Service: "room", RoutingKey: RoutingKeyFromContext(ctx)}, instances)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		addr, err := rpcClient.pick(PickInfo{Service: "room", RoutingKey: "room-42"}, instances)
+		require.NoError(t, err)
+		assert.Equal(t, owner, addr)
+	}
+	// Other services use the default balancer; with nothing in flight any instance will do.
+	addr, err := rpcClient.pick(PickInfo{Service: "chat"}, instances)
+	require.NoError(t, err)
+	assert.Contains(t, []string{instances[0].Addr, instances[1].Addr, instances[2].Addr}, addr)
+}
@@ -0,0 +1,238 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/pandaparty/infra/discovery"
+)
+
+// ErrCircuitOpen is returned for calls to an endpoint whose circuit breaker is open, or to a
+// service whose endpoints all have open breakers.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerPolicy configures the circuit breaker the client keeps for each endpoint. After
+// FailureThreshold consecutive failed calls the breaker opens: calls to the endpoint fail at
+// once with ErrCircuitOpen, and round-robin selection skips it. After OpenTimeout one call is
+// let through as a probe; if it succeeds the breaker closes, otherwise it opens again.
+//
+// Failures to reach the endpoint count, such as connection errors, and so do calls that ran
+// out of time after waiting at least CallTimeout for an answer: an endpoint that accepts
+// connections and then hangs only ever fails through its callers' deadlines. Errors returned
+// by handlers show that the endpoint is up, and calls cancelled or given less than CallTimeout
+// say nothing about it. The breaker of an endpoint is dropped when the endpoint leaves its
+// service.
+type BreakerPolicy struct {
+	FailureThreshold int           // Consecutive failures that open the breaker; 0 for the default, < 0 to disable breakers
+	OpenTimeout      time.Duration // Time the breaker stays open before a probe; 0 for the default
+	CallTimeout      time.Duration // Wait after which a call ended by its deadline counts as a failure; 0 for the default, < 0 to never count them
+}
+
+// Default values for BreakerPolicy.
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 10 * time.Second
+	defaultBreakerCallTimeout      = 5 * time.Second
+)
+
+// WithCircuitBreaker turns on per-endpoint circuit breakers with policy. Breakers are off by
+// default.
+func WithCircuitBreaker(policy BreakerPolicy) ClientOption {
+	return func(c *RPCClient) {
+		if policy.FailureThreshold == 0 {
+			policy.FailureThreshold = defaultBreakerFailureThreshold
+		}
+		if policy.OpenTimeout <= 0 {
+			policy.OpenTimeout = defaultBreakerOpenTimeout
+		}
+		if policy.CallTimeout == 0 {
+			policy.CallTimeout = defaultBreakerCallTimeout
+		}
+		c.breakerPolicy = policy
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen // One probe call is allowed
+)
+
+// breakerOutcome is what a call tells about its endpoint.
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota // The endpoint answered
+	outcomeFailure                       // The endpoint could not be reached or did not answer within CallTimeout
+	outcomeIgnore                        // The call says nothing about the endpoint, e.g. the caller cancelled it
+)
+
+// circuitBreaker tracks the health of one endpoint.
+type circuitBreaker struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int // Consecutive failures while closed
+	openedAt time.Time
+	probing  bool // A probe call is in flight while half-open
+}
+
+// available reports whether a call to the endpoint would be allowed, without taking the probe.
+func (b *circuitBreaker) available() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return time.Since(b.openedAt) >= b.policy.OpenTimeout
+	case breakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// allow reports whether a call may go to the endpoint. Every allowed call must be followed by
+// record.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of an allowed call.
+func (b *circuitBreaker) record(outcome breakerOutcome) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	switch outcome {
+	case outcomeSuccess:
+		b.state, b.failures = breakerClosed, 0
+	case outcomeFailure:
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= b.policy.FailureThreshold {
+			b.state, b.openedAt, b.failures = breakerOpen, time.Now(), 0
+		}
+	}
+}
+
+// breaker returns the circuit breaker of endpointAddress, or nil if breakers are disabled.
+func (c *RPCClient) breaker(endpointAddress string) *circuitBreaker {
+	if c.breakerPolicy.FailureThreshold <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[endpointAddress]
+	if !ok {
+		b = &circuitBreaker{policy: c.breakerPolicy}
+		c.breakers[endpointAddress] = b
+	}
+	return b
+}
+
+// watchBreakers makes the breakers of the endpoints of serviceName follow its instances, from
+// the first time the service is resolved: the breaker of an endpoint that leaves the service is
+// dropped, unless another service still has the endpoint.
+func (c *RPCClient) watchBreakers(resolver *discovery.Resolver, serviceName string) {
+	if c.breakerPolicy.FailureThreshold <= 0 {
+		return
+	}
+	c.mu.Lock()
+	_, watched := c.serviceAddrs[serviceName]
+	if !watched {
+		c.serviceAddrs[serviceName] = nil
+	}
+	c.mu.Unlock()
+	if watched {
+		return
+	}
+	unsubscribe, err := resolver.Subscribe(serviceName, func(instances []discovery.Instance) {
+		c.pruneBreakers(serviceName, instances)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.serviceAddrs, serviceName)
+		return
+	}
+	if _, ok := c.serviceAddrs[serviceName]; !ok {
+		unsubscribe() // The client was closed meanwhile
+		return
+	}
+	c.unsubscribes = append(c.unsubscribes, unsubscribe)
+}
+
+// pruneBreakers records the instances of serviceName and drops the breakers of the endpoints
+// that left it.
+func (c *RPCClient) pruneBreakers(serviceName string, instances []discovery.Instance) {
+	addrs := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		addrs[instance.Addr()] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, watched := c.serviceAddrs[serviceName]
+	if !watched {
+		return // The client was closed meanwhile
+	}
+	c.serviceAddrs[serviceName] = addrs
+	for addr := range previous {
+		if addrs[addr] {
+			continue
+		}
+		inUse := false
+		for _, others := range c.serviceAddrs {
+			inUse = inUse || others[addr]
+		}
+		if !inUse {
+			delete(c.breakers, addr)
+		}
+	}
+}
+
+// outcomeOf classifies the error of a call made with ctx, which waited elapsed for an answer,
+// for the endpoint's breaker. A call whose deadline passed is a failure if it waited at least
+// callTimeout; a shorter deadline or a cancellation says nothing about the endpoint.
+func outcomeOf(ctx context.Context, err error, elapsed, callTimeout time.Duration) breakerOutcome {
+	var remoteErr *RemoteError
+	switch {
+	case err == nil, errors.As(err, &remoteErr):
+		return outcomeSuccess
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrFrameTooLarge):
+		return outcomeIgnore
+	case errors.Is(err, context.DeadlineExceeded), ctx.Err() != nil:
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && callTimeout > 0 && elapsed >= callTimeout {
+			return outcomeFailure
+		}
+		return outcomeIgnore
+	}
+	return outcomeFailure
+}
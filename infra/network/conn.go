@@ -0,0 +1,453 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errConnClosed is reported to calls still waiting when their connection closes.
+var errConnClosed = errors.New("connection closed")
+
+// errLegacyPeer is returned by dialClientConn when the peer hung up on the hello frame, as
+// servers that only speak unversioned framing do.
+var errLegacyPeer = errors.New("peer does not speak versioned framing")
+
+// connConfig configures the client connections to an endpoint.
+type connConfig struct {
+	dialTimeout  time.Duration
+	legacy       bool          // Use unversioned framing
+	maxFrameSize int           // Largest response body accepted
+	compressMin  int           // Smallest request body compressed; 0 disables compression
+	heartbeat    time.Duration // Interval between keepalives on a quiet connection; 0 disables them
+}
+
+// clientConn is a connection to one endpoint shared by many concurrent calls. Each call is
+// tagged with a request ID; a reader goroutine hands every response to the call with that ID,
+// so responses may arrive in any order. Unversioned framing has no request IDs, so such a
+// connection carries one call at a time, with ID 0.
+type clientConn struct {
+	addr    string
+	conn    net.Conn
+	cfg     connConfig
+	framer  framer
+	peerMax int // Largest request body the server accepts
+
+	writeMu sync.Mutex    // Serializes frames written to conn
+	turn    chan struct{} // Held by the call of an unversioned connection; nil on versioned ones
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *responseFrame // Calls waiting for a response, by request ID
+	streams map[uint64]*stream             // Open streams, by request ID
+	err     error                          // Why the connection closed; nil while it is open
+	done    chan struct{}                  // Closed once the connection is closed
+
+	lastRead atomic.Int64 // Unix nanoseconds of the last frame received
+}
+
+// dialClientConn connects to addr and, unless cfg.legacy is set, negotiates versioned framing.
+func dialClientConn(addr string, cfg connConfig) (*clientConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, cfg.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cc := &clientConn{
+		addr:    addr,
+		conn:    conn,
+		cfg:     cfg,
+		framer:  framer{version: legacyVersion, maxFrameSize: cfg.maxFrameSize, legacyType: frameResponse},
+		peerMax: math.MaxInt32,
+		pending: make(map[uint64]chan *responseFrame),
+		streams: make(map[uint64]*stream),
+		done:    make(chan struct{}),
+	}
+	if !cfg.legacy {
+		if err := cc.handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cc.framer.version == legacyVersion {
+		cc.turn = make(chan struct{}, 1)
+	}
+	cc.lastRead.Store(time.Now().UnixNano())
+	go cc.readLoop()
+	if cc.cfg.heartbeat > 0 && cc.framer.version != legacyVersion {
+		go cc.heartbeatLoop()
+	}
+	return cc, nil
+}
+
+// handshake exchanges hello frames, settling the protocol version and the server's limit.
+func (cc *clientConn) handshake() error {
+	cc.conn.SetDeadline(time.Now().Add(cc.cfg.dialTimeout))
+	defer cc.conn.SetDeadline(time.Time{})
+	cc.framer.version = protocolVersion
+	if err := cc.framer.writeFrame(cc.conn, frame{typ: frameHello, body: helloBody(cc.cfg.maxFrameSize)}); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	f, err := cc.framer.readFrame(cc.conn)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+			return fmt.Errorf("%w: %v", errLegacyPeer, err)
+		}
+		return fmt.Errorf("failed to read hello: %w", err)
+	}
+	if cc.peerMax, err = parseHello(f); err != nil {
+		return err
+	}
+	cc.framer.version = f.version
+	return nil
+}
+
+// call sends req and waits for its response. If ctx ends first, the server is told to cancel
+// the request and the connection stays usable for other calls. An unversioned connection
+// cannot skip the response of an abandoned call, so it is closed instead.
+func (cc *clientConn) call(ctx context.Context, req *requestFrame) (*responseFrame, error) {
+	body, err := cc.encode(req)
+	if err != nil {
+		return nil, err
+	}
+	if cc.turn != nil {
+		select {
+		case cc.turn <- struct{}{}:
+			defer func() { <-cc.turn }()
+		case <-cc.done:
+			return nil, cc.closeErr()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	replyCh := make(chan *responseFrame, 1)
+	cc.mu.Lock()
+	if cc.err != nil {
+		cc.mu.Unlock()
+		return nil, cc.err
+	}
+	var id uint64 // Unversioned responses all come back with ID 0
+	if cc.turn == nil {
+		cc.nextID++
+		id = cc.nextID
+	}
+	cc.pending[id] = replyCh
+	cc.mu.Unlock()
+
+	if err := cc.write(ctx, frame{typ: frameRequest, flags: cc.compressFlag(body), id: id, body: body}); err != nil {
+		cc.forget(id)
+		return nil, err
+	}
+
+	select {
+	case res := <-replyCh:
+		return res, nil
+	case <-cc.done:
+		return nil, cc.closeErr()
+	case <-ctx.Done():
+		if cc.forget(id) {
+			if cc.turn != nil {
+				cc.close(fmt.Errorf("call to %s abandoned: %w", cc.addr, ctx.Err()))
+			} else {
+				// Best effort: if the write fails the connection is closed, which cancels it too.
+				_ = cc.write(context.Background(), frame{typ: frameCancel, id: id})
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// openStream opens a stream for req. The stream is cancelled on the server when ctx ends,
+// unless the server ended it first.
+func (cc *clientConn) openStream(ctx context.Context, req *requestFrame) (*ClientStream, error) {
+	if cc.framer.version == legacyVersion {
+		return nil, errors.New("streams need versioned framing, which the server does not speak")
+	}
+	body, err := cc.encode(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cc.mu.Lock()
+	if cc.err != nil {
+		cc.mu.Unlock()
+		cancel()
+		return nil, cc.err
+	}
+	cc.nextID++
+	id := cc.nextID
+	st := newStream(ctx, cancel, id, req.method, cc.write)
+	st.compressMin = cc.cfg.compressMin
+	cc.streams[id] = st
+	cc.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		if cc.forgetStream(id) != nil {
+			// Best effort: if the write fails the connection is closed, which cancels it too.
+			_ = cc.write(context.Background(), frame{typ: frameCancel, id: id})
+			st.finish(ctx.Err())
+		}
+	})
+	if err := cc.write(ctx, frame{typ: frameStreamOpen, flags: cc.compressFlag(body), id: id, body: body}); err != nil {
+		cancel()
+		return nil, err
+	}
+	return &ClientStream{s: st}, nil
+}
+
+// forgetStream removes an open stream, returning nil if it was already removed.
+func (cc *clientConn) forgetStream(id uint64) *stream {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	st := cc.streams[id]
+	delete(cc.streams, id)
+	return st
+}
+
+// send sends req as a oneway request, which the server runs without answering. Unversioned
+// framing has no oneway requests, so on such a connection send waits for the response and
+// drops it.
+func (cc *clientConn) send(ctx context.Context, req *requestFrame) error {
+	if cc.framer.version == legacyVersion {
+		_, err := cc.call(ctx, req)
+		return err
+	}
+	body, err := cc.encode(req)
+	if err != nil {
+		return err
+	}
+	if err := cc.closeErr(); err != nil {
+		return err
+	}
+	return cc.write(ctx, frame{typ: frameRequest, flags: flagOneway | cc.compressFlag(body), body: body})
+}
+
+// encode encodes req, refusing bodies the server would not accept.
+func (cc *clientConn) encode(req *requestFrame) ([]byte, error) {
+	body := req.encode(cc.framer.version)
+	if len(body) > cc.peerMax {
+		return nil, fmt.Errorf("%w: request of %d bytes exceeds the limit of %d set by %s", ErrFrameTooLarge, len(body), cc.peerMax, cc.addr)
+	}
+	return body, nil
+}
+
+// compressFlag returns flagCompressed if body should be compressed.
+func (cc *clientConn) compressFlag(body []byte) byte {
+	if cc.cfg.compressMin > 0 && len(body) >= cc.cfg.compressMin {
+		return flagCompressed
+	}
+	return 0
+}
+
+// write sends f, giving up when ctx ends. A failed or interrupted write may leave a partial
+// frame on the wire, so it closes the connection.
+func (cc *clientConn) write(ctx context.Context, f frame) error {
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		cc.conn.SetWriteDeadline(deadline)
+	}
+	// Cancelling ctx expires the write deadline to interrupt a blocked write. The flag keeps a
+	// late cancellation from expiring the deadline for the next writer.
+	var interruptMu sync.Mutex
+	written := false
+	stop := context.AfterFunc(ctx, func() {
+		interruptMu.Lock()
+		defer interruptMu.Unlock()
+		if !written {
+			cc.conn.SetWriteDeadline(time.Now())
+		}
+	})
+	defer func() {
+		interruptMu.Lock()
+		written = true
+		interruptMu.Unlock()
+		stop()
+		cc.conn.SetWriteDeadline(time.Time{})
+	}()
+	if err := cc.framer.writeFrame(cc.conn, f); err != nil {
+		cc.close(fmt.Errorf("write to %s failed: %w", cc.addr, err))
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w (%v)", ctx.Err(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// forget removes a pending call. It returns false if the response was already delivered.
+func (cc *clientConn) forget(id uint64) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	_, ok := cc.pending[id]
+	delete(cc.pending, id)
+	return ok
+}
+
+// readLoop delivers responses and stream frames until the connection fails.
+func (cc *clientConn) readLoop() {
+	for {
+		f, err := cc.framer.readFrame(cc.conn)
+		if err != nil && !errors.Is(err, ErrFrameTooLarge) {
+			cc.close(fmt.Errorf("read from %s failed: %w", cc.addr, err))
+			return
+		}
+		cc.lastRead.Store(time.Now().UnixNano())
+		switch f.typ {
+		case frameResponse:
+			cc.deliverResponse(f, err)
+		case frameStreamMsg, frameStreamEnd, frameStreamWindow:
+			if err = cc.deliverStream(f, err); err != nil {
+				cc.close(fmt.Errorf("stream %d from %s: %w", f.id, cc.addr, err))
+				return
+			}
+		default:
+			cc.close(fmt.Errorf("unexpected frame type %d from %s", f.typ, cc.addr))
+			return
+		}
+		if cc.closeErr() != nil {
+			return
+		}
+	}
+}
+
+// deliverResponse hands a response to the call waiting for it. readErr is set if the response
+// was too large to read.
+func (cc *clientConn) deliverResponse(f frame, readErr error) {
+	if f.flags&flagHeartbeat != 0 {
+		return
+	}
+	var res *responseFrame
+	if readErr != nil {
+		res = &responseFrame{err: fmt.Sprintf("response dropped: %v", readErr)}
+	} else {
+		var err error
+		if res, err = decodeResponse(f.body); err != nil {
+			cc.close(fmt.Errorf("malformed response from %s: %w", cc.addr, err))
+			return
+		}
+	}
+	cc.mu.Lock()
+	replyCh, ok := cc.pending[f.id]
+	delete(cc.pending, f.id)
+	cc.mu.Unlock()
+	if ok {
+		replyCh <- res
+	} // Otherwise the caller gave up; drop the late response.
+}
+
+// deliverStream hands a stream frame to its stream. readErr is set if a message was too large
+// to read, which ends the stream. Frames of streams already forgotten are dropped. A returned
+// error is a protocol violation.
+func (cc *clientConn) deliverStream(f frame, readErr error) error {
+	cc.mu.Lock()
+	st := cc.streams[f.id]
+	cc.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+	if readErr != nil {
+		if cc.forgetStream(f.id) != nil {
+			_ = cc.write(context.Background(), frame{typ: frameCancel, id: f.id})
+			st.finish(readErr)
+			st.cancel()
+		}
+		return nil
+	}
+	switch f.typ {
+	case frameStreamMsg:
+		return st.deliver(f.body)
+	case frameStreamWindow:
+		n, err := parseWindow(f)
+		if err != nil {
+			return err
+		}
+		st.grant(n)
+	case frameStreamEnd:
+		// The server's end is final: the handler returned.
+		cc.forgetStream(f.id)
+		err := streamError(st.method, f)
+		st.endRecv(err)
+		st.finish(io.EOF)
+		st.cancel()
+	}
+	return nil
+}
+
+// heartbeatLoop sends a heartbeat every interval, and closes the connection when nothing,
+// heartbeat responses included, has been received for two intervals.
+func (cc *clientConn) heartbeatLoop() {
+	ticker := time.NewTicker(cc.cfg.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cc.done:
+			return
+		case now := <-ticker.C:
+			if quiet := now.Sub(time.Unix(0, cc.lastRead.Load())); quiet > 2*cc.cfg.heartbeat {
+				cc.close(fmt.Errorf("no heartbeat from %s for %v", cc.addr, quiet.Round(time.Millisecond)))
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cc.cfg.heartbeat)
+			_ = cc.write(ctx, frame{typ: frameRequest, flags: flagHeartbeat})
+			cancel()
+		}
+	}
+}
+
+// inFlight returns the number of calls waiting for a response and open streams.
+func (cc *clientConn) inFlight() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return len(cc.pending) + len(cc.streams)
+}
+
+// alive reports whether the connection can take new calls.
+func (cc *clientConn) alive() bool {
+	select {
+	case <-cc.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (cc *clientConn) closeErr() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.err
+}
+
+// close closes the connection, failing the calls still waiting with err. Only the first
+// call has an effect.
+func (cc *clientConn) close(err error) {
+	cc.mu.Lock()
+	if cc.err != nil {
+		cc.mu.Unlock()
+		return
+	}
+	cc.err = err
+	cc.pending = make(map[uint64]chan *responseFrame)
+	streams := cc.streams
+	cc.streams = make(map[uint64]*stream)
+	cc.mu.Unlock()
+	for _, st := range streams {
+		st.finish(err)
+		st.cancel()
+	}
+	if !errors.Is(err, errConnClosed) {
+		log.Printf("RPCClient: Closing connection to %s: %v", cc.addr, err)
+	}
+	cc.conn.Close()
+	close(cc.done)
+}
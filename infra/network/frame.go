@@ -0,0 +1,376 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Frame types.
+const (
+	frameRequest  byte = 1
+	frameResponse byte = 2
+	frameCancel   byte = 3 // Tells the server that the caller gave up on a request; no body
+	frameHello    byte = 4 // Opens a versioned connection; the body is the sender's maximum frame size (uint32)
+
+	// Stream frames, sent in versioned framing only. The request ID names the stream.
+	frameStreamOpen   byte = 5 // Opens a stream; the body is a request without payload
+	frameStreamMsg    byte = 6 // A stream message, in either direction; the body is the payload
+	frameStreamEnd    byte = 7 // The sender sends no more; from the server it ends the stream, with a response body carrying the error
+	frameStreamWindow byte = 8 // Lets the peer send more messages on the stream; the body is the count (uint32)
+)
+
+// Frame flags, sent in versioned headers only.
+const (
+	flagCompressed byte = 1 << 0 // The body is DEFLATE-compressed
+	flagOneway     byte = 1 << 1 // A request that gets no response
+	flagHeartbeat  byte = 1 << 2 // A keepalive request, answered by a response with the same ID; no body
+)
+
+// Codec IDs name the encoding of request and response payloads.
+const codecProto byte = 1
+
+// Protocol versions. Version 0 is the unversioned framing that predates the header below; it is
+// still read and written for peers that do not speak versioned framing.
+const (
+	legacyVersion   byte = 0
+	protocolVersion byte = 1 // Highest version this package speaks
+)
+
+// frameMagic starts every versioned header. Its first byte has the top bit set, so an
+// unversioned peer reads it as a negative length and drops the connection instead of trusting it.
+var frameMagic = [2]byte{0xCA, 0xFE}
+
+// DefaultMaxFrameSize is the largest frame body accepted unless configured otherwise.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is reported for a frame whose body exceeds the receiver's maximum frame size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// frame is the unit sent on a connection. Versioned frames have an 18-byte header:
+//
+//	Magic (2 bytes) | Version (uint8) | Type (uint8) | Flags (uint8) | Codec (uint8) |
+//	RequestID (uint64) | BodyLength (uint32) | Body
+//
+// The request ID matches a response, or a cancellation, with its request, so that many calls
+// can share a connection. Unversioned frames are the framing that predates the header:
+//
+//	TotalFrameLength (int32) | Body
+//
+// They carry no type, request ID, flags or codec: clients send requests and servers send
+// responses, one call at a time, and only request and response frames exist.
+//
+// A client opens a versioned connection with a hello frame carrying the highest version it
+// speaks; the server answers with a hello carrying the version both use. A server that only
+// speaks unversioned framing closes the connection instead, and the client redials unversioned.
+// Servers tell the two apart by the first byte of the connection.
+type frame struct {
+	version byte
+	typ     byte
+	flags   byte
+	codec   byte
+	id      uint64
+	body    []byte
+}
+
+const frameHeaderLen = 2 + 1 + 1 + 1 + 1 + 8 + 4 // Versioned header
+
+// requestFrame is a decoded request body. In versioned frames it is:
+//
+//	MethodNameLength (int32) | MethodName | Timeout (int64 ns, 0 = none) |
+//	MetadataCount (int32) | { KeyLength (int32) | Key | ValueLength (int32) | Value }... |
+//	PayloadLength (int32) | Payload
+//
+// The timeout is relative so that the deadline does not depend on the peers' clocks agreeing.
+// Unversioned frames carry the body that predates deadlines and metadata, which are dropped:
+//
+//	MethodNameLength (int32) | MethodName | PayloadLength (int32) | Payload
+type requestFrame struct {
+	method   string
+	timeout  time.Duration
+	metadata Metadata
+	payload  []byte
+}
+
+// responseFrame is a decoded response body:
+//
+//	ErrorLength (int32) | ErrorString | PayloadLength (int32) | Payload
+type responseFrame struct {
+	err     string
+	payload []byte
+}
+
+// encode returns the body of f for frames of the given version.
+func (f *requestFrame) encode(version byte) []byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(f.method))
+	if version == legacyVersion {
+		writeBytes(&buf, f.payload)
+		return buf.Bytes()
+	}
+	_ = binary.Write(&buf, binary.BigEndian, int64(f.timeout))
+	keys := make([]string, 0, len(f.metadata))
+	for k := range f.metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	_ = binary.Write(&buf, binary.BigEndian, int32(len(keys)))
+	for _, k := range keys {
+		writeBytes(&buf, []byte(k))
+		writeBytes(&buf, []byte(f.metadata[k]))
+	}
+	writeBytes(&buf, f.payload)
+	return buf.Bytes()
+}
+
+// decodeRequest decodes the body of a request frame of the given version.
+func decodeRequest(data []byte, version byte) (*requestFrame, error) {
+	r := bytes.NewReader(data)
+	f := &requestFrame{}
+	method, err := readBytes(r, "method name")
+	if err != nil {
+		return nil, err
+	}
+	f.method = string(method)
+	if version == legacyVersion {
+		if f.payload, err = readBytes(r, "payload"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	var timeout int64
+	if err := binary.Read(r, binary.BigEndian, &timeout); err != nil {
+		return nil, fmt.Errorf("failed to read timeout: %w", err)
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %d", timeout)
+	}
+	f.timeout = time.Duration(timeout)
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("failed to read metadata count: %w", err)
+	}
+	if count < 0 || int(count) > r.Len()/8 { // Every pair takes at least two lengths
+		return nil, fmt.Errorf("invalid metadata count %d", count)
+	}
+	if count > 0 {
+		f.metadata = make(Metadata, count)
+	}
+	for i := int32(0); i < count; i++ {
+		key, err := readBytes(r, "metadata key")
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r, "metadata value")
+		if err != nil {
+			return nil, err
+		}
+		f.metadata[string(key)] = string(value)
+	}
+	if f.payload, err = readBytes(r, "payload"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *responseFrame) encode() []byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(f.err))
+	writeBytes(&buf, f.payload)
+	return buf.Bytes()
+}
+
+func decodeResponse(data []byte) (*responseFrame, error) {
+	r := bytes.NewReader(data)
+	errBytes, err := readBytes(r, "error string")
+	if err != nil {
+		return nil, err
+	}
+	payload, err := readBytes(r, "payload")
+	if err != nil {
+		return nil, err
+	}
+	return &responseFrame{err: string(errBytes), payload: payload}, nil
+}
+
+// framer reads and writes the frames of one connection.
+type framer struct {
+	version      byte // Version written; legacyVersion for unversioned framing
+	maxFrameSize int  // Largest body accepted, after decompression
+	legacyType   byte // Type of the frames read in unversioned framing, which carry none
+}
+
+// isVersioned reports whether b, the first byte a peer sent, starts a versioned frame.
+func isVersioned(b byte) bool {
+	return b == frameMagic[0]
+}
+
+// writeFrame sends f in a single write, compressing its body if f has flagCompressed.
+// Unversioned framing cannot carry the request ID or flags, so they are dropped.
+func (fr *framer) writeFrame(w io.Writer, f frame) error {
+	if fr.version == legacyVersion {
+		if f.typ != frameRequest && f.typ != frameResponse {
+			return fmt.Errorf("frame type %d needs versioned framing", f.typ)
+		}
+		buf := make([]byte, 4+len(f.body))
+		binary.BigEndian.PutUint32(buf, uint32(len(f.body)))
+		copy(buf[4:], f.body)
+		_, err := w.Write(buf)
+		return err
+	}
+
+	body := f.body
+	if f.flags&flagCompressed != 0 {
+		var compressed bytes.Buffer
+		zw, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress frame body: %w", err)
+		}
+		body = compressed.Bytes()
+	}
+	codec := f.codec
+	if codec == 0 {
+		codec = codecProto
+	}
+	buf := make([]byte, frameHeaderLen+len(body))
+	copy(buf, frameMagic[:])
+	buf[2] = fr.version
+	buf[3] = f.typ
+	buf[4] = f.flags
+	buf[5] = codec
+	binary.BigEndian.PutUint64(buf[6:], f.id)
+	binary.BigEndian.PutUint32(buf[14:], uint32(len(body)))
+	copy(buf[frameHeaderLen:], body)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads one frame, versioned or not depending on fr.version. The body length is
+// checked before anything is allocated for it. A body over the limit is skipped, and the frame
+// header is returned along with an error wrapping ErrFrameTooLarge, so the connection stays
+// usable and the request can be answered.
+func (fr *framer) readFrame(r io.Reader) (frame, error) {
+	if fr.version == legacyVersion {
+		return fr.readLegacyFrame(r)
+	}
+
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	if header[0] != frameMagic[0] || header[1] != frameMagic[1] {
+		return frame{}, fmt.Errorf("invalid frame magic %#x%02x", header[0], header[1])
+	}
+	f := frame{
+		version: header[2],
+		typ:     header[3],
+		flags:   header[4],
+		codec:   header[5],
+		id:      binary.BigEndian.Uint64(header[6:]),
+	}
+	// A hello may come from a newer peer; the version is settled by answering it.
+	if f.version == legacyVersion || (f.version > protocolVersion && f.typ != frameHello) {
+		return frame{}, fmt.Errorf("unsupported protocol version %d", f.version)
+	}
+	size := binary.BigEndian.Uint32(header[14:])
+	if uint64(size) > uint64(fr.maxFrameSize) {
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return frame{}, err
+		}
+		return f, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrFrameTooLarge, size, fr.maxFrameSize)
+	}
+	f.body = make([]byte, size)
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return frame{}, err
+	}
+	if f.flags&flagCompressed != 0 {
+		// Reading one byte past the limit tells a body at the limit from a larger one.
+		body, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(f.body)), int64(fr.maxFrameSize)+1))
+		if err != nil {
+			return frame{}, fmt.Errorf("failed to decompress frame body: %w", err)
+		}
+		if len(body) > fr.maxFrameSize {
+			f.body = nil
+			return f, fmt.Errorf("%w: decompressed body exceeds the limit of %d bytes", ErrFrameTooLarge, fr.maxFrameSize)
+		}
+		f.body = body
+	}
+	return f, nil
+}
+
+func (fr *framer) readLegacyFrame(r io.Reader) (frame, error) {
+	var totalFrameLen int32
+	if err := binary.Read(r, binary.BigEndian, &totalFrameLen); err != nil {
+		return frame{}, err
+	}
+	if totalFrameLen <= 0 {
+		return frame{}, fmt.Errorf("invalid total frame length %d", totalFrameLen)
+	}
+	f := frame{typ: fr.legacyType, codec: codecProto}
+	size := int64(totalFrameLen)
+	if size > int64(fr.maxFrameSize) {
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return frame{}, err
+		}
+		return f, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrFrameTooLarge, size, fr.maxFrameSize)
+	}
+	f.body = make([]byte, size)
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+// windowBody returns the body of a window frame granting n messages.
+func windowBody(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+// parseWindow returns the number of messages granted by a window frame.
+func parseWindow(f frame) (int, error) {
+	if len(f.body) != 4 {
+		return 0, fmt.Errorf("malformed window frame of %d bytes", len(f.body))
+	}
+	return int(binary.BigEndian.Uint32(f.body)), nil
+}
+
+// helloBody returns the body of a hello frame announcing maxFrameSize.
+func helloBody(maxFrameSize int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(maxFrameSize))
+}
+
+// parseHello returns the maximum frame size announced by a hello frame.
+func parseHello(f frame) (int, error) {
+	if f.typ != frameHello || len(f.body) != 4 {
+		return 0, fmt.Errorf("expected hello frame, got type %d with %d bytes", f.typ, len(f.body))
+	}
+	return int(binary.BigEndian.Uint32(f.body)), nil
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	_ = binary.Write(buf, binary.BigEndian, int32(len(b)))
+	buf.Write(b)
+}
+
+// readBytes reads an int32 length followed by that many bytes, rejecting lengths that run
+// past the end of the frame.
+func readBytes(r *bytes.Reader, what string) ([]byte, error) {
+	var n int32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, fmt.Errorf("failed to read %s length: %w", what, err)
+	}
+	if n < 0 || int(n) > r.Len() {
+		return nil, fmt.Errorf("invalid %s length %d", what, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,168 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fuzzMaxFrameSize = 1 << 16
+
+func encodeFrames(t testing.TB, version byte, frames ...frame) []byte {
+	var buf bytes.Buffer
+	fr := &framer{version: version, maxFrameSize: fuzzMaxFrameSize}
+	for _, f := range frames {
+		require.NoError(t, fr.writeFrame(&buf, f))
+	}
+	return buf.Bytes()
+}
+
+func TestFramer_RoundTrip(t *testing.T) {
+	for _, version := range []byte{legacyVersion, protocolVersion} {
+		req := (&requestFrame{method: "Ping", timeout: time.Second, metadata: Metadata{MetadataTraceID: "t"}, payload: bytes.Repeat([]byte("x"), 4096)}).encode(version)
+		frames := []frame{
+			{typ: frameRequest, id: 1, body: req},
+			{typ: frameRequest, flags: flagCompressed | flagOneway, body: req},
+		}
+		if version == protocolVersion {
+			frames = append(frames, frame{typ: frameCancel, id: 1})
+		}
+		data := encodeFrames(t, version, frames...)
+		if version == protocolVersion {
+			assert.Less(t, len(data), 2*len(req), "compressed body should be smaller")
+		}
+		fr := &framer{version: version, maxFrameSize: fuzzMaxFrameSize, legacyType: frameRequest}
+		r := bytes.NewReader(data)
+		for i, want := range frames {
+			f, err := fr.readFrame(r)
+			require.NoError(t, err, "version %d frame %d", version, i)
+			assert.Equal(t, want.typ, f.typ)
+			assert.Equal(t, codecProto, f.codec)
+			assert.Equal(t, len(want.body), len(f.body))
+			if version == protocolVersion {
+				assert.Equal(t, want.id, f.id)
+				assert.Equal(t, want.flags, f.flags)
+			} else {
+				assert.Zero(t, f.id, "unversioned frames carry no request ID")
+				assert.Zero(t, f.flags, "unversioned frames carry no flags")
+			}
+		}
+	}
+
+	// Unversioned framing has requests and responses only.
+	fr := &framer{version: legacyVersion, maxFrameSize: fuzzMaxFrameSize}
+	assert.Error(t, fr.writeFrame(io.Discard, frame{typ: frameCancel, id: 1}))
+}
+
+func TestFramer_RejectsOversizedFrames(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), fuzzMaxFrameSize+1)
+	for _, version := range []byte{legacyVersion, protocolVersion} {
+		data := encodeFrames(t, version,
+			frame{typ: frameRequest, id: 7, body: big},
+			frame{typ: frameRequest, id: 8, flags: flagCompressed, body: big},
+			frame{typ: frameRequest, id: 9, body: []byte("ok")},
+		)
+		fr := &framer{version: version, maxFrameSize: fuzzMaxFrameSize, legacyType: frameRequest}
+		r := bytes.NewReader(data)
+		f, err := fr.readFrame(r)
+		require.ErrorIs(t, err, ErrFrameTooLarge)
+		assert.Equal(t, frameRequest, f.typ, "the header of an oversized frame is returned")
+		if version == protocolVersion {
+			assert.Equal(t, uint64(7), f.id)
+		}
+		f, err = fr.readFrame(r)
+		require.ErrorIs(t, err, ErrFrameTooLarge, "the limit applies after decompression")
+		if version == protocolVersion {
+			assert.Equal(t, uint64(8), f.id)
+		}
+		f, err = fr.readFrame(r)
+		require.NoError(t, err, "the connection stays usable after an oversized frame")
+		assert.Equal(t, []byte("ok"), f.body)
+	}
+
+	// A length near 2 GB is skipped, not allocated; here the stream ends while skipping.
+	fr := &framer{version: legacyVersion, maxFrameSize: fuzzMaxFrameSize}
+	_, err := fr.readFrame(bytes.NewReader([]byte{0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 4, 'P', 'i', 'n', 'g'}))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// An unversioned peer sees the magic as a negative length.
+	hello := encodeFrames(t, protocolVersion, frame{typ: frameHello, body: helloBody(fuzzMaxFrameSize)})
+	_, err = fr.readFrame(bytes.NewReader(hello))
+	assert.ErrorContains(t, err, "invalid total frame length")
+}
+
+func TestRequestFrame_LegacyBody(t *testing.T) {
+	req := &requestFrame{method: "Ping", timeout: time.Second, metadata: Metadata{"k": "v"}, payload: []byte("hello")}
+
+	// The body of unversioned frames is the one that predates deadlines and metadata.
+	body := req.encode(legacyVersion)
+	assert.Equal(t, []byte("\x00\x00\x00\x04Ping\x00\x00\x00\x05hello"), body)
+	decoded, err := decodeRequest(body, legacyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, &requestFrame{method: "Ping", payload: []byte("hello")}, decoded)
+
+	decoded, err = decodeRequest(req.encode(protocolVersion), protocolVersion)
+	require.NoError(t, err)
+	assert.Equal(t, req, decoded)
+}
+
+func FuzzReadFrame(f *testing.F) {
+	for _, version := range []byte{legacyVersion, protocolVersion} {
+		req := (&requestFrame{method: "Ping", timeout: time.Second, metadata: Metadata{"k": "v"}, payload: []byte("hello")}).encode(version)
+		frames := []frame{
+			{typ: frameRequest, id: 1, body: req},
+			{typ: frameRequest, id: 2, flags: flagCompressed, body: req},
+			{typ: frameResponse, id: 1, body: (&responseFrame{err: "boom"}).encode()},
+		}
+		if version == protocolVersion {
+			frames = append([]frame{{typ: frameHello, body: helloBody(fuzzMaxFrameSize)}}, frames...)
+		}
+		f.Add(version, encodeFrames(f, version, frames...))
+	}
+	f.Fuzz(func(t *testing.T, version byte, data []byte) {
+		fr := &framer{version: version % (protocolVersion + 1), maxFrameSize: fuzzMaxFrameSize, legacyType: frameRequest}
+		r := bytes.NewReader(data)
+		for {
+			frm, err := fr.readFrame(r)
+			if errors.Is(err, ErrFrameTooLarge) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if len(frm.body) > fuzzMaxFrameSize {
+				t.Fatalf("body of %d bytes exceeds the limit", len(frm.body))
+			}
+			switch frm.typ {
+			case frameRequest:
+				_, _ = decodeRequest(frm.body, frm.version)
+			case frameResponse:
+				_, _ = decodeResponse(frm.body)
+			case frameHello:
+				_, _ = parseHello(frm)
+			}
+		}
+	})
+}
+
+func FuzzDecodeRequest(f *testing.F) {
+	for _, version := range []byte{legacyVersion, protocolVersion} {
+		f.Add(version, (&requestFrame{method: "Ping", timeout: time.Second, metadata: Metadata{"k": "v"}, payload: []byte("hello")}).encode(version))
+		f.Add(version, (&requestFrame{}).encode(version))
+	}
+	f.Fuzz(func(t *testing.T, version byte, data []byte) {
+		version %= protocolVersion + 1
+		req, err := decodeRequest(data, version)
+		if err != nil {
+			return
+		}
+		again, err := decodeRequest(req.encode(version), version)
+		require.NoError(t, err)
+		assert.Equal(t, req, again)
+	})
+}
@@ -0,0 +1,272 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ServerInfo describes the call an interceptor wraps.
+type ServerInfo struct {
+	Method string // Method name the client called
+	Peer   string // Client address
+}
+
+// ServerInterceptor wraps the handling of a unary request: it may inspect or change the
+// request, reject it, or observe the result, and calls next to go on. See WithInterceptors.
+type ServerInterceptor func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error)
+
+// StreamServerInterceptor wraps the handling of a stream, like ServerInterceptor.
+type StreamServerInterceptor func(stream *ServerStream, info *ServerInfo, next StreamHandlerFunc) error
+
+// Invoker performs a call from an RPCClient. responseProto is nil for RPCClient.Send.
+type Invoker func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error
+
+// ClientInterceptor wraps RPCClient.Call and RPCClient.Send, and calls next to go on.
+// See WithClientInterceptors.
+type ClientInterceptor func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message, next Invoker) error
+
+// ErrRateLimited is returned by the interceptor of RateLimitInterceptor for rejected requests.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// WithInterceptors adds interceptors around every unary handler. The first one added is the
+// outermost: it sees the request first and the result last.
+func WithInterceptors(interceptors ...ServerInterceptor) ServerOption {
+	return func(s *RPCServer) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds interceptors around every stream handler, in the order of
+// WithInterceptors.
+func WithStreamInterceptors(interceptors ...StreamServerInterceptor) ServerOption {
+	return func(s *RPCServer) {
+		s.streamInterceptors = append(s.streamInterceptors, interceptors...)
+	}
+}
+
+// WithClientInterceptors adds interceptors around every Call and Send. The first one added is
+// the outermost.
+func WithClientInterceptors(interceptors ...ClientInterceptor) ClientOption {
+	return func(c *RPCClient) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+// handle runs handler behind the server's interceptors. A panic, in the handler or an
+// interceptor, is logged and becomes an error for the client; the handler's panic is turned
+// into an error before the interceptors see the result.
+func (s *RPCServer) handle(ctx context.Context, info *ServerInfo, reqPayload []byte, handler HandlerFunc) (resPayload []byte, err error) {
+	defer recoverHandler(info, &err)
+	next := func(ctx context.Context, reqPayload []byte) (resPayload []byte, err error) {
+		defer recoverHandler(info, &err)
+		return handler(ctx, reqPayload)
+	}
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := s.interceptors[i], next
+		next = func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+			return interceptor(ctx, info, reqPayload, inner)
+		}
+	}
+	return next(ctx, reqPayload)
+}
+
+// handleStream runs a stream handler behind the server's stream interceptors, recovering
+// panics like handle.
+func (s *RPCServer) handleStream(stream *ServerStream, info *ServerInfo, handler StreamHandlerFunc) (err error) {
+	defer recoverHandler(info, &err)
+	next := func(stream *ServerStream) (err error) {
+		defer recoverHandler(info, &err)
+		return handler(stream)
+	}
+	for i := len(s.streamInterceptors) - 1; i >= 0; i-- {
+		interceptor, inner := s.streamInterceptors[i], next
+		next = func(stream *ServerStream) error {
+			return interceptor(stream, info, inner)
+		}
+	}
+	return next(stream)
+}
+
+func recoverHandler(info *ServerInfo, errp *error) {
+	if r := recover(); r != nil {
+		log.Printf("Panic in coordinator for method '%s' from %s: %v\n%s", info.Method, info.Peer, r, debug.Stack())
+		*errp = fmt.Errorf("internal error in method %s", info.Method)
+	}
+}
+
+// invoke runs call behind the client's interceptors.
+func (c *RPCClient) invoke(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message, call Invoker) error {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := c.interceptors[i], call
+		call = func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
+			return interceptor(ctx, serviceName, methodName, requestProto, responseProto, inner)
+		}
+	}
+	return call(ctx, serviceName, methodName, requestProto, responseProto)
+}
+
+// LoggingInterceptor logs every request with its duration and outcome. If logger is nil,
+// the standard logger is used.
+func LoggingInterceptor(logger *log.Logger) ServerInterceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error) {
+		start := time.Now()
+		resPayload, err := next(ctx, reqPayload)
+		logger.Printf("RPC %s from %s: %d bytes in, %d bytes out, %v, error: %v", info.Method, info.Peer, len(reqPayload), len(resPayload), time.Since(start).Round(time.Microsecond), err)
+		return resPayload, err
+	}
+}
+
+// StreamLoggingInterceptor logs every stream when it ends. If logger is nil, the standard
+// logger is used.
+func StreamLoggingInterceptor(logger *log.Logger) StreamServerInterceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(stream *ServerStream, info *ServerInfo, next StreamHandlerFunc) error {
+		start := time.Now()
+		err := next(stream)
+		logger.Printf("RPC stream %s from %s: open for %v, error: %v", info.Method, info.Peer, time.Since(start).Round(time.Microsecond), err)
+		return err
+	}
+}
+
+// ClientLoggingInterceptor logs every call with its duration and outcome. If logger is nil,
+// the standard logger is used.
+func ClientLoggingInterceptor(logger *log.Logger) ClientInterceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message, next Invoker) error {
+		start := time.Now()
+		err := next(ctx, serviceName, methodName, requestProto, responseProto)
+		logger.Printf("RPC call %s on %s: %v, error: %v", methodName, serviceName, time.Since(start).Round(time.Microsecond), err)
+		return err
+	}
+}
+
+// RequireMetadata rejects requests whose metadata lacks any of keys, e.g. MetadataUserID
+// for methods that act on behalf of a user.
+func RequireMetadata(keys ...string) ServerInterceptor {
+	return func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error) {
+		md := MetadataFromContext(ctx)
+		for _, key := range keys {
+			if md.Get(key) == "" {
+				return nil, fmt.Errorf("missing metadata %q for method %s", key, info.Method)
+			}
+		}
+		return next(ctx, reqPayload)
+	}
+}
+
+// RateLimitInterceptor allows perSecond requests per second on average across all
+// connections, and bursts of up to burst requests; others fail with ErrRateLimited.
+func RateLimitInterceptor(perSecond float64, burst int) ServerInterceptor {
+	bucket := &tokenBucket{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	return func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error) {
+		if !bucket.take() {
+			return nil, fmt.Errorf("%w for method %s", ErrRateLimited, info.Method)
+		}
+		return next(ctx, reqPayload)
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RPCMetrics counts calls per method. It is filled by MetricsInterceptor or
+// ClientMetricsInterceptor and safe for concurrent use.
+type RPCMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// MethodStats are the counts of one method.
+type MethodStats struct {
+	Method       string        `json:"method"`
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// NewRPCMetrics returns empty metrics.
+func NewRPCMetrics() *RPCMetrics {
+	return &RPCMetrics{methods: make(map[string]*MethodStats)}
+}
+
+func (m *RPCMetrics) record(method string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.methods[method]
+	if !ok {
+		stats = &MethodStats{Method: method}
+		m.methods[method] = stats
+	}
+	stats.Calls++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.TotalLatency += elapsed
+	stats.MaxLatency = max(stats.MaxLatency, elapsed)
+}
+
+// Snapshot returns the counts of every method called so far, sorted by method.
+func (m *RPCMetrics) Snapshot() []MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make([]MethodStats, 0, len(m.methods))
+	for _, stats := range m.methods {
+		snapshot = append(snapshot, *stats)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Method < snapshot[j].Method })
+	return snapshot
+}
+
+// MetricsInterceptor records every request in m.
+func MetricsInterceptor(m *RPCMetrics) ServerInterceptor {
+	return func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error) {
+		start := time.Now()
+		resPayload, err := next(ctx, reqPayload)
+		m.record(info.Method, time.Since(start), err)
+		return resPayload, err
+	}
+}
+
+// ClientMetricsInterceptor records every call in m.
+func ClientMetricsInterceptor(m *RPCMetrics) ClientInterceptor {
+	return func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message, next Invoker) error {
+		start := time.Now()
+		err := next(ctx, serviceName, methodName, requestProto, responseProto)
+		m.record(methodName, time.Since(start), err)
+		return err
+	}
+}
@@ -0,0 +1,46 @@
+package network
+
+import "context"
+
+// Metadata holds key/value pairs sent along with an RPC, such as a trace ID or the user on
+// whose behalf the call is made. RPCClient.Call sends the metadata of its context, and
+// RPCServer hands it to the handler in the handler's context, so that it follows a request
+// from service to service.
+type Metadata map[string]string
+
+// Well-known metadata keys.
+const (
+	MetadataTraceID   = "trace-id"
+	MetadataUserID    = "user-id"
+	MetadataSessionID = "session-id"
+)
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying md merged over the metadata ctx already has.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := make(Metadata, len(md))
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// WithMetadataValue returns a copy of ctx with key set to value in its metadata.
+func WithMetadataValue(ctx context.Context, key, value string) context.Context {
+	return WithMetadata(ctx, Metadata{key: value})
+}
+
+// MetadataFromContext returns the metadata of ctx, or nil. It must not be modified.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// Get returns the value of key, or "" if it is not set.
+func (md Metadata) Get(key string) string {
+	return md[key]
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// RetryPolicy configures how Call retries a method. Only set one for idempotent methods: a
+// call that failed on the way back may have run on the server, and with hedging several
+// attempts may run at once.
+type RetryPolicy struct {
+	MaxAttempts    int           // Attempts in all, including the first; <= 1 means no retries
+	InitialBackoff time.Duration // Wait before the first retry; 0 for the default
+	MaxBackoff     time.Duration // Cap of the wait between retries; 0 for the default
+	Multiplier     float64       // Growth of the wait after each retry; < 1 for the default
+
+	// HedgeDelay, if set, hedges instead of waiting for failures: when an attempt has not
+	// answered within HedgeDelay another one starts, on the instance the service's balancer
+	// picks next, up to MaxAttempts in flight. The first answer wins and the others are cancelled. Attempts that
+	// fail with a retryable error are replaced at once.
+	HedgeDelay time.Duration
+
+	// Retryable decides which errors are retried. By default everything but errors returned
+	// by the handler (see RemoteError), cancellation and the caller's deadline.
+	Retryable func(err error) bool
+}
+
+// Default values for RetryPolicy.
+const (
+	defaultInitialBackoff = 50 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	defaultMultiplier     = 2
+)
+
+// WithRetryPolicy sets the retry policy of Call for methodName. Send and streams are never
+// retried.
+func WithRetryPolicy(methodName string, policy RetryPolicy) ClientOption {
+	return func(c *RPCClient) {
+		if policy.InitialBackoff <= 0 {
+			policy.InitialBackoff = defaultInitialBackoff
+		}
+		if policy.MaxBackoff <= 0 {
+			policy.MaxBackoff = defaultMaxBackoff
+		}
+		if policy.Multiplier < 1 {
+			policy.Multiplier = defaultMultiplier
+		}
+		if policy.Retryable == nil {
+			policy.Retryable = isRetryable
+		}
+		c.retryPolicies[methodName] = policy
+	}
+}
+
+// isRetryable is the default RetryPolicy.Retryable.
+func isRetryable(err error) bool {
+	var remoteErr *RemoteError
+	return !errors.As(err, &remoteErr) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, ErrFrameTooLarge)
+}
+
+// callWithRetry is the Invoker behind Call: it makes the call under the method's retry policy.
+func (c *RPCClient) callWithRetry(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
+	policy, ok := c.retryPolicies[methodName]
+	if !ok || policy.MaxAttempts <= 1 {
+		return c.call(ctx, serviceName, methodName, requestProto, responseProto)
+	}
+	if policy.HedgeDelay > 0 {
+		return c.hedge(ctx, policy, serviceName, methodName, requestProto, responseProto)
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.call(ctx, serviceName, methodName, requestProto, responseProto)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+		// Full jitter keeps clients that failed together from retrying together.
+		wait := rand.N(backoff) + 1
+		log.Printf("RPCClient: Attempt %d of method '%s' on service '%s' failed, retrying in %v: %v", attempt, methodName, serviceName, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff = min(time.Duration(float64(backoff)*policy.Multiplier), policy.MaxBackoff)
+	}
+}
+
+// hedge makes the call under a policy with HedgeDelay.
+func (c *RPCClient) hedge(ctx context.Context, policy RetryPolicy, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
+	type result struct {
+		resp proto.Message
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Cancels the attempts still in flight
+	results := make(chan result, policy.MaxAttempts)
+	launched, inFlight := 0, 0
+	launch := func() {
+		// Each attempt decodes into its own message, as several may answer.
+		var resp proto.Message
+		if responseProto != nil {
+			resp = responseProto.ProtoReflect().New().Interface()
+		}
+		launched++
+		inFlight++
+		go func() {
+			results <- result{resp: resp, err: c.call(ctx, serviceName, methodName, requestProto, resp)}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(policy.HedgeDelay)
+	defer timer.Stop()
+	var lastErr error
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			if launched < policy.MaxAttempts {
+				log.Printf("RPCClient: Method '%s' on service '%s' has not answered in %v; hedging with attempt %d", methodName, serviceName, policy.HedgeDelay, launched+1)
+				launch()
+				timer.Reset(policy.HedgeDelay)
+			}
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				if responseProto != nil {
+					proto.Reset(responseProto)
+					proto.Merge(responseProto, res.resp)
+				}
+				return nil
+			}
+			lastErr = res.err
+			if !policy.Retryable(res.err) {
+				return res.err
+			}
+			if launched < policy.MaxAttempts && ctx.Err() == nil {
+				launch()
+				timer.Reset(policy.HedgeDelay)
+			}
+		}
+	}
+	return lastErr
+}
@@ -1,11 +1,11 @@
 package network
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net"
 
 	// Assuming your consul package is aliased or directly usable.
@@ -15,8 +15,8 @@ import (
 	"sync"
 	"time"
 
-	consul "github.com/hashicorp/consul/api"
 	consulx "github.com/phuhao00/pandaparty/infra/consul"
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -27,6 +27,19 @@ type RPCRequest struct {
 	Payload    []byte // Protobuf-marshaled payload for the request.
 }
 
+// RemoteError is an error returned by the handler of a call, as opposed to a failure to
+// reach it.
+type RemoteError struct {
+	Method  string // Method called
+	Service string // Service or address called
+	Addr    string // Endpoint that answered
+	Message string // Error text of the handler
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("RPC call to method '%s' on service '%s' at '%s' failed: %s", e.Method, e.Service, e.Addr, e.Message)
+}
+
 // RPCResponse defines the structure for an RPC response.
 // This is used by the server to structure its reply.
 type RPCResponse struct {
@@ -36,34 +49,134 @@ type RPCResponse struct {
 
 // RPCServer manages RPC handlers and listens for incoming TCP connections.
 // It allows registration of methods and their corresponding coordinator functions.
-// Each incoming connection is handled in a separate goroutine.
-// The server uses a length-prefixed framing protocol:
-//
-//	Request Frame: TotalFrameLength (int32) | MethodNameLength (int32) | MethodName ([]byte) | PayloadLength (int32) | Payload ([]byte)
-//	Response Frame: TotalFrameLength (int32) | ErrorLength (int32) | ErrorString ([]byte) | PayloadLength (int32) | Payload ([]byte)
+// Each incoming connection is handled in a separate goroutine, and the requests arriving on a
+// connection run concurrently, up to a per-connection limit; responses go back in the order
+// they complete, tagged with the request ID. The server speaks both versioned and unversioned
+// framing, whichever the client uses; see frame for the wire format. Unversioned clients are
+// served the way servers did before versioned framing: one request at a time, in order. Besides unary calls it
+// serves streams (see HandleStream) on the same connections.
 type RPCServer struct {
-	handlers     map[string]func(reqPayload []byte) (resPayload []byte, err error) // Map of method names to coordinator functions.
-	listener     net.Listener                                                      // TCP listener.
-	consulClient *consulx.ConsulClient                                             // Optional Consul client for potential future use (e.g., dynamic re-registration).
+	handlers       map[string]HandlerFunc // Map of method names to coordinator functions.
+	listenerMu     sync.Mutex             // Protects listener, which Close reads while Serve runs.
+	listener       net.Listener           // TCP listener.
+	consulClient   *consulx.ConsulClient  // Optional Consul client for potential future use (e.g., dynamic re-registration).
+	maxConcurrent  int                    // Maximum number of requests run at once per connection.
+	maxQueued      int                    // Maximum number of requests waiting to run per connection.
+	maxRequestSize int                    // Largest request body accepted.
+	maxStreams     int                    // Maximum number of streams open at once per connection.
+	writeTimeout   time.Duration          // Longest a frame may take to write before the connection is closed.
+
+	streamHandlers     map[string]StreamHandlerFunc // Map of method names to stream coordinator functions.
+	interceptors       []ServerInterceptor          // Wrap every unary coordinator, outermost first.
+	streamInterceptors []StreamServerInterceptor    // Wrap every stream coordinator, outermost first.
+}
+
+// HandlerFunc handles one RPC. ctx carries the caller's Metadata and is cancelled when the
+// caller's deadline passes, the caller gives up or the caller disconnects, so long-running
+// handlers can stop early.
+type HandlerFunc func(ctx context.Context, reqPayload []byte) (resPayload []byte, err error)
+
+// Default values for RPCServer configuration.
+const (
+	defaultMaxConcurrentRequests = 64               // Default maximum number of requests run at once per connection.
+	defaultMaxQueuedRequests     = 1024             // Default maximum number of requests waiting to run per connection.
+	defaultMaxConcurrentStreams  = 100              // Default maximum number of streams open at once per connection.
+	defaultWriteTimeout          = 10 * time.Second // Default longest time to write a frame to a connection.
+)
+
+// ServerOption configures an RPCServer.
+type ServerOption func(*RPCServer)
+
+// WithMaxConcurrentRequests limits how many requests of one connection run at once. Further
+// requests wait in a queue (see WithMaxQueuedRequests); the connection is still read meanwhile,
+// so cancellations and heartbeats get through. If n <= 0, defaults to
+// defaultMaxConcurrentRequests.
+func WithMaxConcurrentRequests(n int) ServerOption {
+	return func(s *RPCServer) {
+		if n > 0 {
+			s.maxConcurrent = n
+		}
+	}
+}
+
+// WithMaxQueuedRequests limits how many requests of one connection wait for one of the
+// WithMaxConcurrentRequests slots. Requests arriving when the queue is full are answered at
+// once with a "server busy" error. If n <= 0, defaults to defaultMaxQueuedRequests.
+func WithMaxQueuedRequests(n int) ServerOption {
+	return func(s *RPCServer) {
+		if n > 0 {
+			s.maxQueued = n
+		}
+	}
+}
+
+// WithMaxConcurrentStreams limits how many streams of one connection are open at once. Streams
+// do not count against WithMaxConcurrentRequests, as they may stay open for long; streams
+// opened past the limit fail at once. If n <= 0, defaults to defaultMaxConcurrentStreams.
+func WithMaxConcurrentStreams(n int) ServerOption {
+	return func(s *RPCServer) {
+		if n > 0 {
+			s.maxStreams = n
+		}
+	}
+}
+
+// WithMaxRequestSize sets the largest request body the server accepts, after decompression.
+// Larger requests are skipped without being read into memory and answered with an error.
+// If n <= 0, defaults to DefaultMaxFrameSize.
+func WithMaxRequestSize(n int) ServerOption {
+	return func(s *RPCServer) {
+		if n > 0 {
+			s.maxRequestSize = min(n, math.MaxUint32)
+		}
+	}
+}
+
+// WithWriteTimeout sets how long the server waits for a frame to be written before it gives up
+// on the client and closes the connection. If d <= 0, defaults to defaultWriteTimeout.
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(s *RPCServer) {
+		if d > 0 {
+			s.writeTimeout = d
+		}
+	}
 }
 
 // NewRPCServer creates a new RPC server instance.
 // The provided consulClient is stored for potential future extensions but is not
 // actively used by the server's core listening/handling logic currently.
-func NewRPCServer(client *consulx.ConsulClient) (*RPCServer, error) {
-	return &RPCServer{
-		handlers:     make(map[string]func(reqPayload []byte) (resPayload []byte, err error)),
-		consulClient: client,
-	}, nil
+func NewRPCServer(client *consulx.ConsulClient, opts ...ServerOption) (*RPCServer, error) {
+	s := &RPCServer{
+		handlers:       make(map[string]HandlerFunc),
+		consulClient:   client,
+		maxConcurrent:  defaultMaxConcurrentRequests,
+		maxQueued:      defaultMaxQueuedRequests,
+		maxRequestSize: DefaultMaxFrameSize,
+		maxStreams:     defaultMaxConcurrentStreams,
+		writeTimeout:   defaultWriteTimeout,
+		streamHandlers: make(map[string]StreamHandlerFunc),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
 }
 
 // RegisterHandler adds a new coordinator function for a given RPC method name.
 // If a coordinator for the methodName already exists, it will be overwritten.
 // The coordinator function takes the raw byte payload of the request and is expected
 // to return the raw byte payload of the response and an application-level error if any.
+// Use Handle for a coordinator that needs the request context.
 func (s *RPCServer) RegisterHandler(methodName string, handler func(reqPayload []byte) (resPayload []byte, err error)) {
+	s.Handle(methodName, func(_ context.Context, reqPayload []byte) ([]byte, error) {
+		return handler(reqPayload)
+	})
+}
+
+// Handle is like RegisterHandler for a coordinator that takes the request context.
+func (s *RPCServer) Handle(methodName string, handler HandlerFunc) {
 	if s.handlers == nil {
-		s.handlers = make(map[string]func(reqPayload []byte) (resPayload []byte, err error))
+		s.handlers = make(map[string]HandlerFunc)
 	}
 	s.handlers[methodName] = handler
 	log.Printf("Registered coordinator for method: %s", methodName)
@@ -74,15 +187,24 @@ func (s *RPCServer) RegisterHandler(methodName string, handler func(reqPayload [
 // This method blocks until the listener fails with a non-recoverable error or is closed.
 // Example address: "0.0.0.0:50051".
 func (s *RPCServer) Listen(address string) error {
-	var err error
-	s.listener, err = net.Listen("tcp", address)
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", address, err)
 	}
+	return s.Serve(listener)
+}
+
+// Serve accepts incoming connections on an existing listener, e.g. one bound to "localhost:0"
+// whose address the caller needs to know in advance. It blocks like Listen.
+func (s *RPCServer) Serve(listener net.Listener) error {
+	s.listenerMu.Lock()
+	s.listener = listener
+	s.listenerMu.Unlock()
+	address := listener.Addr().String()
 	log.Printf("RPC Server listening on %s", address)
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			// Handle listener errors (e.g., if listener is closed)
 			if opError, ok := err.(*net.OpError); ok && opError.Err.Error() == "use of closed network connection" {
@@ -102,247 +224,224 @@ func (s *RPCServer) Listen(address string) error {
 	}
 }
 
-// handleConnection reads requests, calls handlers, and sends responses
+// handleConnection serves the requests arriving on conn until it closes.
 func (s *RPCServer) handleConnection(conn net.Conn) {
-	defer func() {
-		log.Printf("Closing connection from %s", conn.RemoteAddr())
-		conn.Close()
-	}()
-
-	for {
-		// Frame format for request:
-		// TotalFrameLength (int32) - Length of (MethodNameLength + MethodName + PayloadLength + Payload)
-		// MethodNameLength (int32)
-		// MethodName ([]byte)
-		// PayloadLength (int32)
-		// Payload ([]byte)
-
-		var totalFrameLen int32
-		if err := binary.Read(conn, binary.BigEndian, &totalFrameLen); err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				log.Printf("Connection closed by client %s (EOF reading total frame length)", conn.RemoteAddr())
-				return
-			}
-			log.Printf("Error reading total frame length from %s: %v", conn.RemoteAddr(), err)
-			return
-		}
-
-		if totalFrameLen <= 0 {
-			log.Printf("Invalid total frame length %d received from %s", totalFrameLen, conn.RemoteAddr())
-			return
-		}
+	newServerConn(s, conn).serve()
+}
 
-		frameData := make([]byte, totalFrameLen)
-		if _, err := io.ReadFull(conn, frameData); err != nil {
-			log.Printf("Error reading frame data from %s: %v", conn.RemoteAddr(), err)
-			return
-		}
-		frameReader := bytes.NewReader(frameData)
+// serveRequest runs the handler for req, behind the interceptors, with ctx, which is bounded by
+// the caller's deadline. Requests whose context has already ended are answered without running
+// the handler.
+func (s *RPCServer) serveRequest(ctx context.Context, conn net.Conn, req *requestFrame) *responseFrame {
+	if len(req.metadata) > 0 {
+		ctx = context.WithValue(ctx, metadataKey{}, req.metadata)
+	}
 
-		// 1. Read MethodName length (int32)
-		var methodNameLen int32
-		if err := binary.Read(frameReader, binary.BigEndian, &methodNameLen); err != nil {
-			log.Printf("Error reading method name length from %s: %v", conn.RemoteAddr(), err)
-			return
-		}
+	handler, ok := s.handlers[req.method]
+	if !ok {
+		errMsg := fmt.Sprintf("no coordinator found for method: %s", req.method)
+		log.Println(errMsg)
+		return &responseFrame{err: errMsg}
+	}
+	if err := ctx.Err(); err != nil {
+		log.Printf("Dropping request for method '%s' from %s: %v", req.method, conn.RemoteAddr(), err)
+		return &responseFrame{err: fmt.Sprintf("request for method %s dropped: %v", req.method, err)}
+	}
+	resPayload, appErr := s.handle(ctx, &ServerInfo{Method: req.method, Peer: conn.RemoteAddr().String()}, req.payload, handler)
+	rpcResp := &responseFrame{payload: resPayload}
+	if appErr != nil {
+		rpcResp.err = appErr.Error()
+	}
+	return rpcResp
+}
 
-		// 2. Read MethodName string
-		methodNameBytes := make([]byte, methodNameLen)
-		if _, err := io.ReadFull(frameReader, methodNameBytes); err != nil {
-			log.Printf("Error reading method name from %s: %v", conn.RemoteAddr(), err)
-			return
-		}
-		methodName := string(methodNameBytes)
+// Default values for RPCClient configuration.
+const (
+	defaultMaxConnsPerEndpoint = 10              // Default maximum connections per target endpoint.
+	defaultDialTimeout         = 5 * time.Second // Default timeout for establishing a new connection.
+	defaultMaxCallsPerConn     = 100             // Default number of in-flight calls before another connection is opened.
+)
 
-		// 3. Read Payload length (int32)
-		var payloadLen int32
-		if err := binary.Read(frameReader, binary.BigEndian, &payloadLen); err != nil {
-			log.Printf("Error reading payload length for method %s from %s: %v", methodName, conn.RemoteAddr(), err)
-			return
-		}
+// RPCClient provides a client for making RPC calls to services.
+// Calls to the same endpoint share a small set of multiplexed connections: each connection
+// carries many concurrent calls, tagged with request IDs, and responses may come back in any
+// order. A new connection is only opened when every existing one is busy with
+// maxCallsPerConn calls, up to maxConnsPerEndpoint connections.
+// Service discovery is handled via a discovery.Resolver, which caches the instances of each
+// service and follows their changes in Consul, and the instance each call goes to is picked by
+// the service's Balancer (see WithBalancer). If a serviceName provided to Call
+// resembles a direct "host:port" address, Consul discovery is bypassed for testing or direct connections.
+//
+// The client uses the framing protocol of RPCServer; see frame.
+type RPCClient struct {
+	pools               map[string]*endpointConns  // Map of endpointAddress (host:port) to its connections.
+	maxConnsPerEndpoint int                        // Maximum number of connections to open to each endpoint.
+	maxCallsPerConn     int                        // In-flight calls per connection before another one is opened.
+	mu                  sync.Mutex                 // Protects access to the pools map.
+	consulClient        *consulx.ConsulClient      // Client for Consul service discovery.
+	resolver            *discovery.Resolver        // Cache of service instances; protected by mu.
+	ownsResolver        bool                       // resolver was created from consulClient, and is closed with the connections.
+	connCfg             connConfig                 // Dial timeout, frame limits, compression and heartbeats of new connections.
+	balancer            Balancer                   // Picks the instance of services without a balancer of their own.
+	serviceBalancers    map[string]Balancer        // Balancers by serviceName.
+	interceptors        []ClientInterceptor        // Wrap every Call and Send, outermost first.
+	retryPolicies       map[string]RetryPolicy     // Retry policies of Call, by method name.
+	breakerPolicy       BreakerPolicy              // Policy of the breakers.
+	breakers            map[string]*circuitBreaker // Circuit breakers by endpointAddress; protected by mu.
+	serviceAddrs        map[string]map[string]bool // Endpoints of the services whose breakers are pruned; protected by mu.
+	unsubscribes        []func()                   // Stop the watches behind serviceAddrs; protected by mu.
+}
 
-		// 4. Read Payload
-		reqPayload := make([]byte, payloadLen)
-		if _, err := io.ReadFull(frameReader, reqPayload); err != nil {
-			log.Printf("Error reading payload for method %s from %s: %v", methodName, conn.RemoteAddr(), err)
-			return
-		}
+// endpointConns holds the connections to one endpoint.
+type endpointConns struct {
+	mu     sync.Mutex
+	conns  []*clientConn
+	legacy bool // The endpoint only speaks unversioned framing
+}
 
-		log.Printf("Received request for method '%s' with payload size %d from %s", methodName, payloadLen, conn.RemoteAddr())
-
-		handler, ok := s.handlers[methodName]
-		var resPayload []byte
-		var appErr error
-		rpcResp := RPCResponse{}
-
-		if !ok {
-			errMsg := fmt.Sprintf("no coordinator found for method: %s", methodName)
-			log.Println(errMsg)
-			rpcResp.Error = errMsg
-		} else {
-			resPayload, appErr = handler(reqPayload)
-			if appErr != nil {
-				log.Printf("Handler for method '%s' returned error: %v", methodName, appErr)
-				rpcResp.Error = appErr.Error()
-			}
-			rpcResp.Payload = resPayload
-		}
+// ClientOption configures an RPCClient.
+type ClientOption func(*RPCClient)
 
-		// Serialize and send RPCResponse
-		// Frame format for response:
-		// TotalFrameLength (int32) - Length of (ErrorLength + Error + PayloadLength + Payload)
-		// ErrorLength (int32)
-		// Error ([]byte)
-		// PayloadLength (int32)
-		// Payload ([]byte)
-		var responseBuffer bytes.Buffer
-
-		errorBytes := []byte(rpcResp.Error)
-		// Write Error string length
-		if err := binary.Write(&responseBuffer, binary.BigEndian, int32(len(errorBytes))); err != nil {
-			log.Printf("Error writing error length to buffer for %s: %v", conn.RemoteAddr(), err)
-			return
-		}
-		// Write Error string
-		if _, err := responseBuffer.Write(errorBytes); err != nil {
-			log.Printf("Error writing error to buffer for %s: %v", conn.RemoteAddr(), err)
-			return
+// WithMaxCallsPerConn sets how many calls a connection carries at once before the client
+// opens another connection to the same endpoint. If n <= 0, defaults to defaultMaxCallsPerConn.
+func WithMaxCallsPerConn(n int) ClientOption {
+	return func(c *RPCClient) {
+		if n > 0 {
+			c.maxCallsPerConn = n
 		}
+	}
+}
 
-		// Write Payload length
-		if err := binary.Write(&responseBuffer, binary.BigEndian, int32(len(rpcResp.Payload))); err != nil {
-			log.Printf("Error writing payload length to buffer for %s: %v", conn.RemoteAddr(), err)
-			return
-		}
-		// Write Payload
-		if _, err := responseBuffer.Write(rpcResp.Payload); err != nil {
-			log.Printf("Error writing payload to buffer for %s: %v", conn.RemoteAddr(), err)
-			return
+// WithMaxResponseSize sets the largest response body the client accepts, after
+// decompression. Calls receiving a larger response fail without it being read into memory.
+// If n <= 0, defaults to DefaultMaxFrameSize.
+func WithMaxResponseSize(n int) ClientOption {
+	return func(c *RPCClient) {
+		if n > 0 {
+			c.connCfg.maxFrameSize = min(n, math.MaxUint32)
 		}
+	}
+}
 
-		// Send the total length of the response frame first
-		resTotalFrameLen := int32(responseBuffer.Len())
-		if err := binary.Write(conn, binary.BigEndian, resTotalFrameLen); err != nil {
-			log.Printf("Error sending response total frame length for method %s to %s: %v", methodName, conn.RemoteAddr(), err)
-			return
-		}
-		// Send the response frame itself
-		if _, err := conn.Write(responseBuffer.Bytes()); err != nil {
-			log.Printf("Error sending response frame for method %s to %s: %v", methodName, conn.RemoteAddr(), err)
-			return
-		}
-		log.Printf("Sent response for method '%s' to %s (Error: '%s', PayloadSize: %d)", methodName, conn.RemoteAddr(), rpcResp.Error, len(rpcResp.Payload))
+// WithCompression compresses request bodies of at least minSize bytes; the server then
+// compresses large responses too. Compression is off by default.
+func WithCompression(minSize int) ClientOption {
+	return func(c *RPCClient) {
+		c.connCfg.compressMin = max(minSize, 1)
 	}
 }
 
-// Call performs an RPC call to a specified service and method.
-// consulClient is required for service discovery.
-// The config.ConsulConfig is passed to potentially initialize a new Consul client if one is not provided.
-// Default values for RPCClient configuration.
-const (
-	defaultMaxConnsPerEndpoint = 10              // Default maximum idle connections per target endpoint.
-	defaultDialTimeout         = 5 * time.Second // Default timeout for establishing a new connection.
-)
+// WithResolver makes the client find service instances with r, which it shares with other
+// clients, instead of creating a resolver of its own from its Consul client.
+func WithResolver(r *discovery.Resolver) ClientOption {
+	return func(c *RPCClient) {
+		c.resolver = r
+	}
+}
 
-// RPCClient provides a client for making RPC calls to services.
-// It features a connection pooling mechanism to reuse TCP connections, reducing
-// the overhead of connection establishment for frequent calls to the same endpoint.
-// Service discovery is handled via a Consul client. If a serviceName provided to Call
-// resembles a direct "host:port" address, Consul discovery is bypassed for testing or direct connections.
-//
-// The client uses a length-prefixed framing protocol identical to RPCServer:
-//
-//	Request Frame: TotalFrameLength (int32) | MethodNameLength (int32) | MethodName ([]byte) | PayloadLength (int32) | Payload ([]byte)
-//	Response Frame: TotalFrameLength (int32) | ErrorLength (int32) | ErrorString ([]byte) | PayloadLength (int32) | Payload ([]byte)
-type RPCClient struct {
-	pools               map[string]chan net.Conn // Map of endpointAddress (host:port) to a buffered channel of net.Conn (connection pool).
-	maxConnsPerEndpoint int                      // Maximum number of idle connections to keep in the pool for each endpoint.
-	mu                  sync.Mutex               // Protects access to the pools map.
-	consulClient        *consulx.ConsulClient    // Client for Consul service discovery.
-	dialTimeout         time.Duration            // Timeout for dialing new TCP connections.
-	nextInstance        map[string]uint64        // Stores the next index for round-robin per serviceName
-	nextInstanceMu      sync.Mutex               // Protects access to nextInstance map
+// WithHeartbeat sends a heartbeat on each connection every interval, and closes a connection
+// that has received nothing for two intervals, so a dead peer fails calls instead of leaving
+// them to their deadlines. Heartbeats are off by default.
+func WithHeartbeat(interval time.Duration) ClientOption {
+	return func(c *RPCClient) {
+		c.connCfg.heartbeat = max(interval, 0)
+	}
 }
 
-// NewRPCClient creates a new RPC client with connection pooling capabilities.
+// NewRPCClient creates a new RPC client with multiplexed connection pooling.
 // Parameters:
-//   - cc: A *consul.ConsulClient for service discovery. Can be nil if direct addressing is always used (not recommended for production).
-//   - maxConns: Maximum number of idle connections to maintain per endpoint. If <= 0, defaults to defaultMaxConnsPerEndpoint.
+//   - cc: A *consul.ConsulClient for service discovery. Can be nil if the client is given a resolver on another registry
+//     (see WithResolver), or if direct addressing is always used (not recommended for production).
+//   - maxConns: Maximum number of connections to open per endpoint. If <= 0, defaults to defaultMaxConnsPerEndpoint.
 //   - timeout: Timeout for establishing new connections. If <= 0, defaults to defaultDialTimeout.
-func NewRPCClient(cc *consulx.ConsulClient, maxConns int, timeout time.Duration) *RPCClient {
+func NewRPCClient(cc *consulx.ConsulClient, maxConns int, timeout time.Duration, opts ...ClientOption) *RPCClient {
 	if maxConns <= 0 {
 		maxConns = defaultMaxConnsPerEndpoint
 	}
 	if timeout <= 0 {
 		timeout = defaultDialTimeout
 	}
-	return &RPCClient{
-		pools:               make(map[string]chan net.Conn),
+	c := &RPCClient{
+		pools:               make(map[string]*endpointConns),
 		maxConnsPerEndpoint: maxConns,
+		maxCallsPerConn:     defaultMaxCallsPerConn,
 		consulClient:        cc,
-		dialTimeout:         timeout,
-		nextInstance:        make(map[string]uint64), // Initialize nextInstance
+		connCfg:             connConfig{dialTimeout: timeout, maxFrameSize: DefaultMaxFrameSize},
+		balancer:            RoundRobinBalancer(),
+		serviceBalancers:    make(map[string]Balancer),
+		retryPolicies:       make(map[string]RetryPolicy),
+		breakers:            make(map[string]*circuitBreaker),
+		serviceAddrs:        make(map[string]map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
-// getConnection retrieves an existing connection from the pool for the given endpointAddress
-// or creates a new one if the pool is empty.
-// It manages the creation of pool channels on-demand.
-func (c *RPCClient) getConnection(endpointAddress string) (net.Conn, error) {
-	c.mu.Lock() // Lock to safely access/create the pool channel for the endpoint.
+// getConnection returns the least busy live connection to endpointAddress, dialing a new one
+// if there is none yet, or if all are at maxCallsPerConn and the endpoint has room for more.
+// Unversioned connections carry one call at a time, so for them the limit is 1.
+func (c *RPCClient) getConnection(endpointAddress string) (*clientConn, error) {
+	c.mu.Lock() // Lock to safely access/create the connections of the endpoint.
 	pool, ok := c.pools[endpointAddress]
 	if !ok {
-		pool = make(chan net.Conn, c.maxConnsPerEndpoint)
+		pool = &endpointConns{}
 		c.pools[endpointAddress] = pool
 	}
 	c.mu.Unlock()
 
-	select {
-	case conn := <-pool: // Try to get an existing connection from the pool.
-		log.Printf("RPCClient: Reusing connection to %s from pool.", endpointAddress)
-		// TODO: Implement a health check for pooled connections (e.g., a test read/write)
-		// before returning, to ensure the connection is still alive.
-		return conn, nil
-	default: // Pool is empty or no connections available immediately.
-		log.Printf("RPCClient: Pool for %s is empty, dialing new connection.", endpointAddress)
-		conn, err := net.DialTimeout("tcp", endpointAddress, c.dialTimeout)
-		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s: %w", endpointAddress, err)
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var best *clientConn
+	bestLoad := 0
+	live := pool.conns[:0]
+	for _, cc := range pool.conns {
+		if !cc.alive() {
+			continue // Dropped from the pool
+		}
+		live = append(live, cc)
+		if load := cc.inFlight(); best == nil || load < bestLoad {
+			best, bestLoad = cc, load
 		}
-		log.Printf("RPCClient: Successfully dialed new connection to %s", endpointAddress)
-		return conn, nil
 	}
-}
-
-// returnConnection returns a connection to the pool for the specified endpointAddress.
-// If the pool is full (i.e., the buffered channel is at capacity), the connection is closed.
-func (c *RPCClient) returnConnection(endpointAddress string, conn net.Conn) {
-	if conn == nil {
-		return
+	for i := len(live); i < len(pool.conns); i++ {
+		pool.conns[i] = nil
 	}
-	c.mu.Lock() // Lock to safely access the pool channel.
-	pool, ok := c.pools[endpointAddress]
-	if !ok {
-		// This case should ideally not happen if getConnection was called correctly.
-		// It implies the pool was removed or not created.
-		c.mu.Unlock()
-		log.Printf("RPCClient: Pool for %s not found on returnConnection. Closing connection.", endpointAddress)
-		conn.Close()
-		return
+	pool.conns = live
+	maxCalls := c.maxCallsPerConn
+	if pool.legacy {
+		maxCalls = 1
+	}
+	if best != nil && (bestLoad < maxCalls || len(pool.conns) >= c.maxConnsPerEndpoint) {
+		return best, nil
 	}
-	c.mu.Unlock()
 
-	select {
-	case pool <- conn: // Attempt to return the connection to the pool.
-		log.Printf("RPCClient: Connection returned to pool for %s.", endpointAddress)
-	default: // Pool is full.
-		log.Printf("RPCClient: Pool for %s is full. Closing surplus connection.", endpointAddress)
-		conn.Close()
+	// Dialing under pool.mu keeps concurrent callers from opening a burst of connections.
+	log.Printf("RPCClient: Dialing new connection to %s (%d open).", endpointAddress, len(pool.conns))
+	cfg := c.connCfg
+	cfg.legacy = pool.legacy
+	cc, err := dialClientConn(endpointAddress, cfg)
+	if errors.Is(err, errLegacyPeer) {
+		log.Printf("RPCClient: %s does not speak versioned framing; redialing unversioned.", endpointAddress)
+		pool.legacy, cfg.legacy = true, true
+		cc, err = dialClientConn(endpointAddress, cfg)
+	}
+	if err != nil {
+		if best != nil {
+			return best, nil // Keep using the busy connection rather than failing the call
+		}
+		return nil, fmt.Errorf("failed to dial %s: %w", endpointAddress, err)
 	}
+	log.Printf("RPCClient: Successfully dialed new connection to %s", endpointAddress)
+	pool.conns = append(pool.conns, cc)
+	return cc, nil
 }
 
-// Call performs an RPC to a specified service and method using a pooled connection.
+// Call performs an RPC to a specified service and method over a shared connection.
 // Parameters:
+//   - ctx: Bounds the call. Its deadline is sent to the server, which cancels the handler's
+//     context when it passes, and so is its Metadata (see WithMetadata).
 //   - serviceName: The logical name of the service to call (e.g., "roomserver") or a direct "host:port" address.
 //     If it's a service name, Consul will be used for discovery. If it's a direct address, Consul is bypassed.
 //   - methodName: The name of the RPC method to invoke on the service.
@@ -351,180 +450,176 @@ func (c *RPCClient) returnConnection(endpointAddress string, conn net.Conn) {
 //
 // Returns:
 //   - An error if the call fails at any stage (discovery, connection, sending, receiving, unmarshaling, or application-level error from server).
+//     If ctx ends first, the error wraps ctx.Err() and the server is told to cancel the handler.
 //   - Nil error on successful call, with responseProto populated.
 //
 // Connection Management:
-//   - Connections are obtained from a pool specific to the target endpoint.
-//   - If a network error occurs during the request/response transmission, the connection is considered unhealthy and closed, not returned to the pool.
-//   - Healthy connections are returned to the pool for reuse.
-func (c *RPCClient) Call(serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
-	var targetAddr string
-	var err error
-
-	// Check if serviceName resembles a direct address (e.g., "localhost:1234")
-	if _, _, errNet := net.SplitHostPort(serviceName); errNet == nil {
-		targetAddr = serviceName
-		log.Printf("RPCClient: Service name '%s' appears to be a direct address. Bypassing Consul discovery.", serviceName)
-	} else {
-		if c.consulClient == nil {
-			return fmt.Errorf("RPCClient: Consul client is not initialized and service name '%s' is not a direct address", serviceName)
-		}
-		instances, errDiscover := c.consulClient.DiscoverService(serviceName)
-		if errDiscover != nil {
-			return fmt.Errorf("RPCClient: failed to discover service %s: %w", serviceName, errDiscover)
-		}
-		if len(instances) == 0 {
-			return fmt.Errorf("RPCClient: no instances found for service %s", serviceName)
-		}
+//   - Connections are shared by all calls to the same endpoint; see RPCClient.
+//   - If a network error occurs on a connection, it is closed and every call waiting on it fails.
+//
+// The call goes through the client's interceptors, and is retried or hedged according to the
+// method's RetryPolicy; see WithClientInterceptors and WithRetryPolicy. Errors returned by the
+// handler are *RemoteError.
+func (c *RPCClient) Call(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
+	return c.invoke(ctx, serviceName, methodName, requestProto, responseProto, c.callWithRetry)
+}
 
-		var selectedInstance *consul.ServiceEntry // Use consul.ServiceEntry which should be []*api.ServiceEntry
+// call makes one attempt of a Call.
+func (c *RPCClient) call(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message) error {
+	out, err := c.prepare(ctx, serviceName, methodName, requestProto)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	rpcResp, err := out.conn.call(ctx, out.req)
+	out.breaker.record(outcomeOf(ctx, err, time.Since(start), c.breakerPolicy.CallTimeout))
+	if err != nil {
+		return fmt.Errorf("RPCClient: call to method '%s' on %s failed: %w", methodName, out.addr, err)
+	}
 
-		// Round-robin selection
-		c.nextInstanceMu.Lock()
-		currentIndex := c.nextInstance[serviceName] // Get current index/counter for this service
-		selectedInstance = instances[currentIndex%uint64(len(instances))]
-		c.nextInstance[serviceName] = currentIndex + 1 // Increment for next call
-		c.nextInstanceMu.Unlock()
+	if rpcResp.err != "" {
+		// This is an application-level error from the server, not a connection error.
+		return &RemoteError{Method: methodName, Service: serviceName, Addr: out.addr, Message: rpcResp.err}
+	}
 
-		if selectedInstance.Service == nil {
-			// This check might be redundant if DiscoverService filters unhealthy, but good for safety
-			return fmt.Errorf("RPCClient: selected service instance for %s has nil Service data after round-robin", serviceName)
+	if len(rpcResp.payload) > 0 {
+		if err = proto.Unmarshal(rpcResp.payload, responseProto); err != nil {
+			return fmt.Errorf("RPCClient: failed to unmarshal response protobuf for method '%s' from service '%s' at '%s': %w", methodName, serviceName, out.addr, err)
 		}
-		targetAddr = fmt.Sprintf("%s:%d", selectedInstance.Service.Address, selectedInstance.Service.Port)
+	} else if responseProto != nil && responseProto.ProtoReflect().IsValid() {
+		log.Printf("RPCClient: Received empty payload for method '%s' on service '%s' at '%s', but responseProto object was provided.", methodName, serviceName, out.addr)
 	}
 
-	log.Printf("RPCClient: Attempting to call service/address '%s' method '%s' at resolved address %s", serviceName, methodName, targetAddr)
+	return nil
+}
 
-	conn, err := c.getConnection(targetAddr)
-	if err != nil {
-		return fmt.Errorf("RPCClient: failed to get connection to %s for service %s: %w", targetAddr, serviceName, err)
-	}
-
-	// Defer returning the connection. If a network error occurs, conn will be set to nil.
-	// This ensures we don't return a bad connection.
-	connHealthy := true
-	defer func() {
-		if connHealthy {
-			c.returnConnection(targetAddr, conn)
-		} else if conn != nil {
-			// If not healthy, but conn is not nil, it means we had it but it broke. Close it.
-			log.Printf("RPCClient: Closing unhealthy connection to %s after failed call.", targetAddr)
-			conn.Close()
-		}
-	}()
+// outgoing is a request ready to be sent on a connection.
+type outgoing struct {
+	conn    *clientConn
+	req     *requestFrame
+	addr    string
+	breaker *circuitBreaker // Breaker of addr, which allowed the request; its outcome must be recorded
+}
 
-	log.Printf("RPCClient: Using connection to %s for RPC call to method '%s'", targetAddr, methodName)
+// prepare encodes a request and picks the endpoint and connection to send it on.
+func (c *RPCClient) prepare(ctx context.Context, serviceName string, methodName string, requestProto proto.Message) (*outgoing, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("RPCClient: call to method '%s' on service '%s' not sent: %w", methodName, serviceName, err)
+	}
 
 	// Prepare request payload
 	reqPayloadBytes, err := proto.Marshal(requestProto)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request protobuf for method %s: %w", methodName, err)
-	}
-
-	var requestFrameBuffer bytes.Buffer
-	methodNameBytes := []byte(methodName)
-
-	// Write MethodName length and MethodName
-	if err := binary.Write(&requestFrameBuffer, binary.BigEndian, int32(len(methodNameBytes))); err != nil {
-		return fmt.Errorf("failed to write method name length: %w", err)
+		return nil, fmt.Errorf("failed to marshal request protobuf for method %s: %w", methodName, err)
 	}
-	if _, err := requestFrameBuffer.Write(methodNameBytes); err != nil {
-		return fmt.Errorf("failed to write method name: %w", err)
+	req := &requestFrame{method: methodName, metadata: MetadataFromContext(ctx), payload: reqPayloadBytes}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.timeout = time.Until(deadline)
+		if req.timeout <= 0 {
+			return nil, fmt.Errorf("RPCClient: call to method '%s' on service '%s' not sent: %w", methodName, serviceName, context.DeadlineExceeded)
+		}
 	}
 
-	// Write Payload length and Payload
-	if err := binary.Write(&requestFrameBuffer, binary.BigEndian, int32(len(reqPayloadBytes))); err != nil {
-		return fmt.Errorf("failed to write payload length: %w", err)
+	targetAddr, err := c.resolve(ctx, serviceName, methodName)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := requestFrameBuffer.Write(reqPayloadBytes); err != nil {
-		return fmt.Errorf("failed to write payload: %w", err)
+	breaker := c.breaker(targetAddr)
+	if !breaker.allow() {
+		return nil, fmt.Errorf("RPCClient: call to method '%s' on %s not sent: %w", methodName, targetAddr, ErrCircuitOpen)
 	}
 
-	// Send the total length of the request frame first
-	reqTotalFrameLen := int32(requestFrameBuffer.Len())
-	if err = binary.Write(conn, binary.BigEndian, reqTotalFrameLen); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to send request total frame length for method %s to %s: %w", methodName, targetAddr, err)
-	}
-	// Send the request frame itself
-	if _, err = conn.Write(requestFrameBuffer.Bytes()); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to send request frame for method %s to %s: %w", methodName, targetAddr, err)
+	conn, err := c.getConnection(targetAddr)
+	if err != nil {
+		breaker.record(outcomeFailure)
+		return nil, fmt.Errorf("RPCClient: failed to get connection to %s for service %s: %w", targetAddr, serviceName, err)
 	}
-	log.Printf("RPCClient: Sent request for method '%s' to %s", methodName, targetAddr)
 
-	// Receive Response
-	var resTotalFrameLen int32
-	if err = binary.Read(conn, binary.BigEndian, &resTotalFrameLen); err != nil {
-		connHealthy = false
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			return fmt.Errorf("RPCClient: connection closed by server %s while reading response total frame length for method %s: %w", targetAddr, methodName, err)
-		}
-		return fmt.Errorf("RPCClient: failed to read response total frame length from %s for method %s: %w", targetAddr, methodName, err)
-	}
+	return &outgoing{conn: conn, req: req, addr: targetAddr, breaker: breaker}, nil
+}
 
-	if resTotalFrameLen <= 0 {
-		connHealthy = false // Or perhaps the connection is fine but response is corrupt
-		return fmt.Errorf("RPCClient: invalid response total frame length %d received from %s for method %s", resTotalFrameLen, targetAddr, methodName)
-	}
+// Send sends a oneway request: the server runs the method and sends nothing back, so Send
+// returns once the request is written and handler errors are only logged by the server.
+// Servers that only speak unversioned framing answer anyway; Send then waits for the answer.
+// The interceptors of the client see Send as a call with a nil responseProto.
+func (c *RPCClient) Send(ctx context.Context, serviceName string, methodName string, requestProto proto.Message) error {
+	return c.invoke(ctx, serviceName, methodName, requestProto, nil, func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, _ proto.Message) error {
+		return c.send(ctx, serviceName, methodName, requestProto)
+	})
+}
 
-	resFrameData := make([]byte, resTotalFrameLen)
-	if _, err = io.ReadFull(conn, resFrameData); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to read response frame data from %s for method %s: %w", targetAddr, methodName, err)
+// send is the Invoker behind Send.
+func (c *RPCClient) send(ctx context.Context, serviceName string, methodName string, requestProto proto.Message) error {
+	out, err := c.prepare(ctx, serviceName, methodName, requestProto)
+	if err != nil {
+		return err
 	}
-	responseReader := bytes.NewReader(resFrameData)
-
-	var errLen int32
-	if err = binary.Read(responseReader, binary.BigEndian, &errLen); err != nil {
-		connHealthy = false // Connection might be okay, but data is malformed. For safety, mark unhealthy.
-		return fmt.Errorf("RPCClient: failed to read error string length from %s: %w", targetAddr, err)
+	start := time.Now()
+	err = out.conn.send(ctx, out.req)
+	out.breaker.record(outcomeOf(ctx, err, time.Since(start), c.breakerPolicy.CallTimeout))
+	if err != nil {
+		return fmt.Errorf("RPCClient: send to method '%s' on %s failed: %w", methodName, out.addr, err)
 	}
+	return nil
+}
 
-	errBytes := make([]byte, errLen)
-	if _, err = io.ReadFull(responseReader, errBytes); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to read error string from %s: %w", targetAddr, err)
+// resolve returns the "host:port" to call for serviceName, picking a discovered instance with
+// the service's balancer unless serviceName is already a direct address.
+func (c *RPCClient) resolve(ctx context.Context, serviceName string, methodName string) (string, error) {
+	// Check if serviceName resembles a direct address (e.g., "localhost:1234")
+	if _, _, errNet := net.SplitHostPort(serviceName); errNet == nil {
+		log.Printf("RPCClient: Service name '%s' appears to be a direct address. Bypassing Consul discovery.", serviceName)
+		return serviceName, nil
 	}
-	rpcErrStr := string(errBytes)
-
-	var resPayloadLen int32
-	if err = binary.Read(responseReader, binary.BigEndian, &resPayloadLen); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to read response payload length from %s: %w", targetAddr, err)
+	resolver := c.discovery()
+	if resolver == nil {
+		return "", fmt.Errorf("RPCClient: Consul client is not initialized and service name '%s' is not a direct address", serviceName)
 	}
-
-	resPayloadBytes := make([]byte, resPayloadLen)
-	if _, err = io.ReadFull(responseReader, resPayloadBytes); err != nil {
-		connHealthy = false
-		return fmt.Errorf("RPCClient: failed to read response payload from %s: %w", targetAddr, err)
+	services, errDiscover := resolver.Instances(ctx, serviceName)
+	if errDiscover != nil {
+		return "", fmt.Errorf("RPCClient: failed to discover service %s: %w", serviceName, errDiscover)
+	}
+	if len(services) == 0 {
+		return "", fmt.Errorf("RPCClient: no instances found for service %s", serviceName)
 	}
+	c.watchBreakers(resolver, serviceName)
 
-	if rpcErrStr != "" {
-		// This is an application-level error from the server, not necessarily a connection error.
-		return fmt.Errorf("RPC call to method '%s' on service '%s' at '%s' failed: %s", methodName, serviceName, targetAddr, rpcErrStr)
+	instances := make([]Instance, 0, len(services))
+	for _, service := range services {
+		instances = append(instances, Instance{ID: service.ID, Addr: service.Addr(), Meta: service.Meta})
 	}
+	return c.pick(PickInfo{Service: serviceName, Method: methodName, RoutingKey: RoutingKeyFromContext(ctx)}, instances)
+}
 
-	if resPayloadLen > 0 {
-		if err = proto.Unmarshal(resPayloadBytes, responseProto); err != nil {
-			// This is a deserialization error, not a network error. Connection might still be okay.
-			// However, if the server sends garbage, it's safer to assume the channel might be problematic.
-			// For now, we don't mark connHealthy = false here, but this is debatable.
-			return fmt.Errorf("RPCClient: failed to unmarshal response protobuf for method '%s' from service '%s' at '%s': %w", methodName, serviceName, targetAddr, err)
+// pick selects one of the instances of a service with its balancer. Instances whose circuit
+// breaker is open are left out until they may be probed.
+func (c *RPCClient) pick(info PickInfo, instances []Instance) (string, error) {
+	available := make([]Instance, 0, len(instances))
+	for _, instance := range instances {
+		if c.breaker(instance.Addr).available() {
+			available = append(available, instance)
 		}
-	} else if responseProto != nil && responseProto.ProtoReflect().IsValid() {
-		log.Printf("RPCClient: Received empty payload for method '%s' on service '%s' at '%s', but responseProto object was provided.", methodName, serviceName, targetAddr)
+	}
+	if len(available) == 0 {
+		return "", fmt.Errorf("RPCClient: no available instances of service %s (%d found): %w", info.Service, len(instances), ErrCircuitOpen)
 	}
 
-	log.Printf("RPCClient: Successfully called method '%s' on service '%s' at %s", methodName, serviceName, targetAddr)
-	return nil
+	balancer, ok := c.serviceBalancers[info.Service]
+	if !ok {
+		balancer = c.balancer
+	}
+	info.InFlight = c.inFlight
+	return balancer.Pick(info, available).Addr, nil
 }
 
 // Close gracefully shuts down the RPC server.
 func (s *RPCServer) Close() error {
-	if s.listener != nil {
-		log.Printf("Closing RPC server listener on %s", s.listener.Addr())
-		return s.listener.Close()
+	s.listenerMu.Lock()
+	listener := s.listener
+	s.listenerMu.Unlock()
+	if listener != nil {
+		log.Printf("Closing RPC server listener on %s", listener.Addr())
+		return listener.Close()
 	}
 	return nil
 }
@@ -536,18 +631,47 @@ func (s *RPCServer) Close() error {
 // 	Token   string
 // }
 
-// CloseAllConnections closes all connections in all pools managed by the RPCClient.
-// This should be called when the application is shutting down.
+// CloseAllConnections closes all connections managed by the RPCClient, failing the calls
+// still waiting on them. This should be called when the application is shutting down.
 func (c *RPCClient) CloseAllConnections() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	log.Println("RPCClient: Closing all pooled connections.")
 	for endpoint, pool := range c.pools {
-		close(pool)              // Close the channel to signal no more connections will be added/retrieved via it.
-		for conn := range pool { // Drain and close existing idle connections
-			log.Printf("RPCClient: Closing idle connection to %s from pool.", endpoint)
-			conn.Close()
+		pool.mu.Lock()
+		for _, cc := range pool.conns {
+			log.Printf("RPCClient: Closing connection to %s.", endpoint)
+			cc.close(errConnClosed)
 		}
+		pool.conns = nil
+		pool.mu.Unlock()
 		delete(c.pools, endpoint) // Remove the pool from the map
 	}
+	for _, unsubscribe := range c.unsubscribes {
+		unsubscribe()
+	}
+	c.unsubscribes = nil
+	c.serviceAddrs = make(map[string]map[string]bool)
+	var owned *discovery.Resolver
+	if c.ownsResolver {
+		// A new one is created if the client is used again.
+		owned = c.resolver
+		c.resolver, c.ownsResolver = nil, false
+	}
+	c.mu.Unlock()
+	if owned != nil {
+		// Stop its watches outside mu: Close waits for them, and one may be delivering an
+		// update to pruneBreakers, which takes mu.
+		owned.Close()
+	}
+}
+
+// discovery returns the resolver of the client, creating one from its Consul client on first
+// use. It returns nil if the client has neither.
+func (c *RPCClient) discovery() *discovery.Resolver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resolver == nil && c.consulClient != nil {
+		c.resolver, c.ownsResolver = discovery.NewConsulResolver(c.consulClient), true
+	}
+	return c.resolver
 }
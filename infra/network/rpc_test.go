@@ -1,13 +1,22 @@
 package network
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/phuhao00/pandaparty/infra/discovery"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
@@ -16,126 +25,22 @@ import (
 	pb "github.com/phuhao00/pandaparty/infra/pb/protocol/rpctest"
 )
 
-// Helper function to start an RPCServer on a dynamic port for testing.
+// Helper function to start an RPCServer on a dynamic port for testing. The server serves the
+// listener the test bound, so the address is known and nothing else can take the port.
 func startTestRPCServer(t *testing.T) (*RPCServer, string) {
 	server, err := NewRPCServer(nil) // No Consul client needed for server in this test
 	require.NoError(t, err)
 
-	listener, err := net.Listen("tcp", "localhost:0") // Dynamic port
-	require.NoError(t, err)
-
-	addr := listener.Addr().String()
-	log.Printf("Test RPCServer listening on %s", addr)
-
-	go func() {
-		// Override server.listener with the one we created so we know the address
-		// This is a bit of a hack for testing. A cleaner way would be for Listen to return the listener or address.
-		// For now, we will manually assign it if the Listen method in RPCServer is not directly using the passed address string to make a new listener
-		// but rather expects s.listener to be pre-set or uses the address to create one internally.
-		// The current RPCServer.Listen does `s.listener, err = net.Listen("tcp", address)`. So we just need to pass the address.
-		// The issue is that `s.listener.Accept()` will be called.
-		// The provided `Listen` method in `RPCServer` creates its own listener.
-		// So, we need to ensure the server's internal listener is used.
-		// The test needs the address. One way is to let server listen, then try to get addr.
-		// For simplicity, the current Listen method in RPCServer takes an address string.
-		// We'll let the OS pick a port, then pass that specific address to Listen.
-
-		// This setup is slightly different from the actual Listen method which takes an address string.
-		// To test with a dynamic port, we listen on "localhost:0", get the address,
-		// then the server should listen on that specific address.
-		// However, the RPCServer's Listen method itself calls net.Listen.
-		// So, we will create a listener, get its address, close it, and then pass this address to RPCServer.Listen.
-		// This is to ensure we know the port.
-
-		// Simpler: Let the server listen and if it logs the address, use that.
-		// For robust testing, it's better if Listen could return the address or if server had an Addr() method.
-		// Given current structure, we'll use "localhost:0" for the server to pick a port,
-		// but the client needs to know this port.
-		// The dynamic port from listener.Addr().String() is what we need.
-		// The server's Listen method will then re-listen. This is slightly redundant but works for testing.
-
-		// Let's modify the test setup:
-		// 1. Create listener on localhost:0
-		// 2. Get addr
-		// 3. Close this listener (as RPCServer.Listen will create its own)
-		// 4. Pass addr to RPCServer.Listen
-
-		// The current RPCServer.Listen takes an address. If we pass "localhost:0", it will pick a port,
-		// but we won't know it directly from the calling test to give to the client.
-		// For testing, it's common to have the server start and report its listening address.
-		// Let's assume we can make RPCServer.Listen more test-friendly or use a fixed port for testing (less ideal).
-
-		// For now, we'll use a fixed, hopefully available, port for the test server.
-		// Or, start the server, and the client will use Consul (if we set up a mock Consul).
-		// The instruction was to bypass Consul for client by using direct address.
-		// So, the client needs to know the server's address.
-
-		// Re-evaluating: The listener created here is what the server should use.
-		// This requires a small modification to RPCServer to allow injecting a listener,
-		// or for Listen to return the address.
-		// Let's try to make RPCServer.Listen use the passed address string.
-		// The challenge is that the client part of the test needs this address.
-
-		// Simplest for now: use a known test port. If it conflicts, test will fail.
-		// testServerAddr := "localhost:55555" // Example fixed port
-		// For dynamic port:
-		// Listener is created above. Server should use this listener.
-		// Modify RPCServer to accept a listener (ideal) or make Listen report its port (hacky).
-		// Let's try with the dynamic port and assume the server's Listen is robust.
-		// The client will use listener.Addr().String().
-
-		// The server's Listen method will be called with listener.Addr().String().
-		// This means the server will try to listen on the *same port* we just got.
-		// This should work if the initial listener is closed before the server's Listen is called.
-
-		// Actually, RPCServer.Listen will internally call net.Listen.
-		// So, we create a listener to find a free port, get its address, close it,
-		// then tell the server to listen on that specific address.
-
-		initialListener, err := net.Listen("tcp", "localhost:0")
-		require.NoError(t, err, "Failed to create initial listener to find free port")
-		dynamicAddr := initialListener.Addr().String()
-		require.NoError(t, initialListener.Close(), "Failed to close initial listener")
-
-		// Now start the actual server on this dynamic address
-		go func() {
-			if err := server.Listen(dynamicAddr); err != nil && !errors.Is(err, net.ErrClosed) {
-				// Don't fail test here from goroutine, just log
-				log.Printf("Test RPCServer Listen error: %v", err)
-			}
-		}()
-
-		// This setup is still a bit complex. A channel to signal server readiness would be better.
-		// For now, a short sleep to allow the server to start.
-		time.Sleep(100 * time.Millisecond)
-
-	}() // This outer go func isn't needed if we manage server start properly.
-	// The inner go func for server.Listen is correct.
-
-	// The address is dynamicAddr from above.
-	// The server is started in a goroutine. We need its address.
-	// Let's refine the server start logic to be more testable.
-
-	// Corrected server start for testing:
-	lis, err := net.Listen("tcp", "localhost:0")
+	lis, err := net.Listen("tcp", "localhost:0") // Dynamic port
 	require.NoError(t, err)
 	serverAddr := lis.Addr().String()
-
-	// We need to make RPCServer use this listener.
-	// This implies a change to RPCServer or a different Listen method for tests.
-	// For now, assume we can't change RPCServer.Listen's internals for this task.
-	// So, we close `lis` and tell the server to listen on `serverAddr`.
-	// This is a common pattern if the server doesn't support listener injection.
-	lis.Close()
+	log.Printf("Test RPCServer listening on %s", serverAddr)
 
 	go func() {
-		if errS := server.Listen(serverAddr); errS != nil {
-			if !errors.Is(errS, net.ErrClosed) { // ErrClosed is expected on server.Close()
-				t.Logf("RPCServer Listen error: %v", errS) // Use t.Logf for test logging
-			}
+		if errS := server.Serve(lis); errS != nil {
+			t.Logf("RPCServer Serve error: %v", errS) // Use t.Logf for test logging
 		}
 	}()
-	time.Sleep(50 * time.Millisecond) // Give server a moment to start
 
 	return server, serverAddr
 }
@@ -164,7 +69,7 @@ func TestRPCCall_Success(t *testing.T) {
 	pingResp := &pb.PingResponse{}
 
 	// First call
-	err := rpcClient.Call(serverAddr, "Ping", pingReq, pingResp)
+	err := rpcClient.Call(context.Background(), serverAddr, "Ping", pingReq, pingResp)
 	require.NoError(t, err, "First RPC call failed")
 	assert.Equal(t, "Pong: Hello RPC", pingResp.Reply, "Unexpected reply in first call")
 	t.Logf("First call response: %s", pingResp.Reply)
@@ -175,7 +80,7 @@ func TestRPCCall_Success(t *testing.T) {
 	// But we can ensure it still works.
 	pingReq2 := &pb.PingRequest{Message: "Hello RPC Again"}
 	pingResp2 := &pb.PingResponse{}
-	err = rpcClient.Call(serverAddr, "Ping", pingReq2, pingResp2)
+	err = rpcClient.Call(context.Background(), serverAddr, "Ping", pingReq2, pingResp2)
 	require.NoError(t, err, "Second RPC call failed")
 	assert.Equal(t, "Pong: Hello RPC Again", pingResp2.Reply, "Unexpected reply in second call")
 	t.Logf("Second call response: %s", pingResp2.Reply)
@@ -201,7 +106,7 @@ func TestRPCCall_HandlerError(t *testing.T) {
 	pingReq := &pb.PingRequest{Message: "Trigger Error"}
 	pingResp := &pb.PingResponse{} // Response proto not strictly needed if error is expected
 
-	err := rpcClient.Call(serverAddr, "PingError", pingReq, pingResp)
+	err := rpcClient.Call(context.Background(), serverAddr, "PingError", pingReq, pingResp)
 	require.Error(t, err, "RPC call should have returned an error")
 	assert.Contains(t, err.Error(), "coordinator error: something went wrong", "Error message does not match expected coordinator error")
 	t.Logf("Received expected error: %v", err)
@@ -217,7 +122,7 @@ func TestRPCCall_ServerNotAvailable(t *testing.T) {
 
 	// Attempt to call a non-existent server
 	nonExistentServerAddr := "localhost:12345" // Assume this port is not in use
-	err := rpcClient.Call(nonExistentServerAddr, "Ping", pingReq, pingResp)
+	err := rpcClient.Call(context.Background(), nonExistentServerAddr, "Ping", pingReq, pingResp)
 
 	require.Error(t, err, "RPC call to non-existent server should fail")
 	// Error might be "connection refused" or "i/o timeout" depending on system and timing
@@ -238,8 +143,1038 @@ func TestRPCCall_MethodNotFound(t *testing.T) {
 	pingReq := &pb.PingRequest{Message: "Test Method Not Found"}
 	pingResp := &pb.PingResponse{}
 
-	err := rpcClient.Call(serverAddr, "MethodDoesNotExist", pingReq, pingResp)
+	err := rpcClient.Call(context.Background(), serverAddr, "MethodDoesNotExist", pingReq, pingResp)
 	require.Error(t, err, "RPC call to non-existent method should fail")
 	assert.Contains(t, err.Error(), "no coordinator found for method: MethodDoesNotExist", "Error message should indicate method not found")
 	t.Logf("Received expected error for method not found: %v", err)
 }
+
+func TestRPCCall_DeadlineAndMetadata(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+
+	server.Handle("Whoami", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return nil, errors.New("handler context has no deadline")
+		}
+		md := MetadataFromContext(ctx)
+		reply := fmt.Sprintf("%s/%s %v", md.Get(MetadataTraceID), md.Get(MetadataUserID), time.Until(deadline) <= time.Second)
+		return proto.Marshal(&pb.PingResponse{Reply: reply})
+	})
+
+	rpcClient := NewRPCClient(nil, 5, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx = WithMetadata(ctx, Metadata{MetadataTraceID: "trace-1", MetadataUserID: "42"})
+	pingResp := &pb.PingResponse{}
+	require.NoError(t, rpcClient.Call(ctx, serverAddr, "Whoami", &pb.PingRequest{}, pingResp))
+	assert.Equal(t, "trace-1/42 true", pingResp.Reply)
+}
+
+func TestRPCCall_CancelReachesHandler(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+
+	handlerDone := make(chan error, 1)
+	server.Handle("Slow", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			handlerDone <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			handlerDone <- nil
+		}
+		return nil, nil
+	})
+
+	rpcClient := NewRPCClient(nil, 5, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := rpcClient.Call(ctx, serverAddr, "Slow", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	select {
+	case err := <-handlerDone:
+		assert.Error(t, err, "handler context should have been cancelled")
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = rpcClient.Call(ctx, serverAddr, "Slow", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, context.Canceled)
+	select {
+	case err := <-handlerDone:
+		assert.ErrorIs(t, err, context.Canceled, "client disconnect should cancel the handler")
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context was not cancelled on disconnect")
+	}
+}
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestRPCCall_MultiplexedOnOneConnection(t *testing.T) {
+	server, err := NewRPCServer(nil, WithMaxConcurrentRequests(4))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	counting := &countingListener{Listener: lis}
+	go server.Serve(counting)
+	defer server.Close()
+
+	// Echo sleeps for the number of milliseconds in the request, so later calls finish first.
+	var running, peak atomic.Int32
+	server.Handle("Echo", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		var req pb.PingRequest
+		if err := proto.Unmarshal(reqPayload, &req); err != nil {
+			return nil, err
+		}
+		delay, _ := time.ParseDuration(req.Message + "ms")
+		time.Sleep(delay)
+		return proto.Marshal(&pb.PingResponse{Reply: req.Message})
+	})
+
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+
+	const calls = 8
+	var (
+		orderMu sync.Mutex
+		order   []string
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(delay int) {
+			defer wg.Done()
+			resp := &pb.PingResponse{}
+			msg := fmt.Sprint(delay)
+			if assert.NoError(t, rpcClient.Call(context.Background(), lis.Addr().String(), "Echo", &pb.PingRequest{Message: msg}, resp)) {
+				assert.Equal(t, msg, resp.Reply)
+				orderMu.Lock()
+				order = append(order, resp.Reply)
+				orderMu.Unlock()
+			}
+		}((calls - i) * 50)
+		time.Sleep(5 * time.Millisecond) // Send in order of decreasing delay
+	}
+	wg.Wait()
+
+	// Run one at a time, the handlers would take 1.8s; four at a time, about 0.6s.
+	assert.Less(t, time.Since(start), 1200*time.Millisecond)
+	assert.Equal(t, int32(1), counting.accepted.Load(), "calls should share one connection")
+	assert.Equal(t, int32(4), peak.Load(), "handlers should run concurrently up to the limit")
+	require.Len(t, order, calls)
+	assert.NotEqual(t, fmt.Sprint(calls*50), order[0], "responses should not come back in request order")
+}
+
+func TestRPCServer_BusyHandlersDoNotStallTheConnection(t *testing.T) {
+	server, err := NewRPCServer(nil, WithMaxConcurrentRequests(1), WithMaxQueuedRequests(1))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Close()
+	serverAddr := lis.Addr().String()
+
+	started := make(chan string, 3)
+	cancelled := make(chan string, 3)
+	server.Handle("Block", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		started <- string(reqPayload)
+		<-ctx.Done()
+		cancelled <- string(reqPayload)
+		return nil, ctx.Err()
+	})
+
+	// Heartbeats keep being answered while the only handler slot is taken.
+	cc, err := dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, maxFrameSize: DefaultMaxFrameSize, heartbeat: 30 * time.Millisecond})
+	require.NoError(t, err)
+	defer cc.close(errConnClosed)
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := cc.call(first, &requestFrame{method: "Block", payload: []byte("first")})
+		firstDone <- err
+	}()
+	assert.Equal(t, "first", <-started)
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	secondDone := make(chan error, 1)
+	go func() {
+		_, err := cc.call(second, &requestFrame{method: "Block", payload: []byte("second")})
+		secondDone <- err
+	}()
+
+	// With the queue full too, requests are rejected rather than left waiting.
+	require.Eventually(t, func() bool { return cc.inFlight() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // Let the second request reach the queue
+	res, err := cc.call(context.Background(), &requestFrame{method: "Block", payload: []byte("third")})
+	require.NoError(t, err)
+	assert.Contains(t, res.err, "server busy")
+
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, cc.alive(), "heartbeats should have been answered")
+
+	// Cancel frames reach the running handler, and the queued request runs next.
+	cancelFirst()
+	assert.ErrorIs(t, <-firstDone, context.Canceled)
+	assert.Equal(t, "first", <-cancelled)
+	assert.Equal(t, "second", <-started)
+	cancelSecond()
+	assert.ErrorIs(t, <-secondDone, context.Canceled)
+	assert.Equal(t, "second", <-cancelled)
+}
+
+func TestRPCServer_WriteTimeoutClosesStalledConnection(t *testing.T) {
+	server, err := NewRPCServer(nil, WithWriteTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Close()
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	server.Handle("Big", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return big, nil })
+
+	// A client that asks for more than the socket buffers hold, and stops reading.
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fr := &framer{version: protocolVersion, maxFrameSize: DefaultMaxFrameSize}
+	require.NoError(t, fr.writeFrame(conn, frame{typ: frameHello, body: helloBody(DefaultMaxFrameSize)}))
+	body := (&requestFrame{method: "Big"}).encode(protocolVersion)
+	for id := uint64(1); id <= 32; id++ {
+		require.NoError(t, fr.writeFrame(conn, frame{typ: frameRequest, id: id, body: body}))
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	// The server gave up on it: what it managed to send is followed by the end of the connection.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		require.False(t, netErr.Timeout(), "the server should have closed the connection")
+	}
+}
+
+// The helpers below speak the framing of the first release, byte for byte, without this
+// package's framer:
+//
+//	Request:  TotalFrameLength (int32) | MethodNameLength (int32) | MethodName | PayloadLength (int32) | Payload
+//	Response: TotalFrameLength (int32) | ErrorLength (int32) | Error | PayloadLength (int32) | Payload
+
+// writeBaselineFrame writes a frame made of two length-prefixed fields.
+func writeBaselineFrame(w io.Writer, first, second []byte) error {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, int32(4+len(first)+4+len(second)))
+	_ = binary.Write(&buf, binary.BigEndian, int32(len(first)))
+	buf.Write(first)
+	_ = binary.Write(&buf, binary.BigEndian, int32(len(second)))
+	buf.Write(second)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readBaselineFrame reads a frame made of two length-prefixed fields.
+func readBaselineFrame(r io.Reader) (first, second []byte, err error) {
+	var total int32
+	if err := binary.Read(r, binary.BigEndian, &total); err != nil {
+		return nil, nil, err
+	}
+	if total <= 0 {
+		return nil, nil, fmt.Errorf("invalid total frame length %d", total)
+	}
+	data := make([]byte, total)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, err
+	}
+	fr := bytes.NewReader(data)
+	for _, field := range []*[]byte{&first, &second} {
+		var n int32
+		if err := binary.Read(fr, binary.BigEndian, &n); err != nil {
+			return nil, nil, err
+		}
+		if n < 0 || int(n) > fr.Len() {
+			return nil, nil, fmt.Errorf("invalid field length %d", n)
+		}
+		*field = make([]byte, n)
+		_, _ = io.ReadFull(fr, *field)
+	}
+	return first, second, nil
+}
+
+// startLegacyServer serves Echo the way servers of the first release did: anything that is
+// not a request frame of that release, such as a versioned hello, is a bad length and drops
+// the connection.
+func startLegacyServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					method, payload, err := readBaselineFrame(conn)
+					if err != nil {
+						return
+					}
+					var errString []byte
+					if string(method) != "Echo" {
+						errString, payload = []byte("no coordinator found for method: "+string(method)), nil
+					}
+					if err := writeBaselineFrame(conn, errString, payload); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestRPCCall_BaselineClient(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Echo", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return nil, errors.New("the first release sends no deadline")
+		}
+		return reqPayload, nil
+	})
+
+	// A client of the first release sends its requests one after another, and reads the
+	// responses in order.
+	conn, err := net.Dial("tcp", serverAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, payload := range []string{"one", "two", "three"} {
+		require.NoError(t, writeBaselineFrame(conn, []byte("Echo"), []byte(payload)))
+	}
+	for _, payload := range []string{"one", "two", "three"} {
+		errString, res, err := readBaselineFrame(conn)
+		require.NoError(t, err)
+		assert.Empty(t, string(errString))
+		assert.Equal(t, payload, string(res))
+	}
+	require.NoError(t, writeBaselineFrame(conn, []byte("Missing"), nil))
+	errString, _, err := readBaselineFrame(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "no coordinator found for method: Missing", string(errString))
+}
+
+func TestRPCCall_FramingNegotiation(t *testing.T) {
+	// A new client falls back to unversioned framing for an old server.
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+	legacyAddr := startLegacyServer(t)
+	pingResp := &pb.PingResponse{}
+	require.NoError(t, rpcClient.Call(context.Background(), legacyAddr, "Echo", &pb.PingResponse{Reply: "old"}, pingResp))
+	assert.Equal(t, "old", pingResp.Reply)
+	require.NoError(t, rpcClient.Send(context.Background(), legacyAddr, "Echo", &pb.PingRequest{}), "oneway falls back to a call")
+
+	// A new server answers an old client in unversioned framing.
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Echo", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return reqPayload, nil })
+	oldClient, err := dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, legacy: true, maxFrameSize: DefaultMaxFrameSize})
+	require.NoError(t, err)
+	defer oldClient.close(errConnClosed)
+	res, err := oldClient.call(context.Background(), &requestFrame{method: "Echo", payload: []byte("hi")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hi"), res.payload)
+
+	// Two new peers settle on the current version.
+	newClient, err := dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, maxFrameSize: DefaultMaxFrameSize})
+	require.NoError(t, err)
+	defer newClient.close(errConnClosed)
+	assert.Equal(t, protocolVersion, newClient.framer.version)
+	assert.Equal(t, DefaultMaxFrameSize, newClient.peerMax)
+}
+
+func TestRPCCall_UnversionedOneCallAtATime(t *testing.T) {
+	// Concurrent calls to an old server spread over unversioned connections, one call each.
+	rpcClient := NewRPCClient(nil, 2, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+	legacyAddr := startLegacyServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := &pb.PingResponse{}
+			assert.NoError(t, rpcClient.Call(context.Background(), legacyAddr, "Echo", &pb.PingResponse{Reply: strconv.Itoa(i)}, reply))
+			assert.Equal(t, strconv.Itoa(i), reply.Reply, "each call gets its own response")
+		}(i)
+	}
+	wg.Wait()
+
+	// The server answers unversioned requests in order. A call given up on cannot be matched
+	// with its late response, so its connection is closed.
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Echo", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return reqPayload, nil })
+	server.Handle("Slow", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		time.Sleep(200 * time.Millisecond)
+		return reqPayload, nil
+	})
+	oldClient, err := dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, legacy: true, maxFrameSize: DefaultMaxFrameSize})
+	require.NoError(t, err)
+	defer oldClient.close(errConnClosed)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = oldClient.call(ctx, &requestFrame{method: "Slow", payload: []byte("late")})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, oldClient.alive())
+
+	oldClient, err = dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, legacy: true, maxFrameSize: DefaultMaxFrameSize})
+	require.NoError(t, err)
+	defer oldClient.close(errConnClosed)
+	for _, payload := range []string{"a", "b", "c"} {
+		res, err := oldClient.call(context.Background(), &requestFrame{method: "Echo", payload: []byte(payload)})
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(res.payload))
+	}
+}
+
+func TestRPCCall_FrameLimitsCompressionAndOneway(t *testing.T) {
+	server, err := NewRPCServer(nil, WithMaxRequestSize(4096))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	counting := &countingListener{Listener: lis}
+	go server.Serve(counting)
+	defer server.Close()
+	serverAddr := lis.Addr().String()
+
+	server.Handle("Echo", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return reqPayload, nil })
+	notified := make(chan string, 1)
+	server.Handle("Notify", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		var req pb.PingRequest
+		if err := proto.Unmarshal(reqPayload, &req); err != nil {
+			return nil, err
+		}
+		notified <- req.Message
+		return nil, nil
+	})
+
+	big := &pb.PingResponse{Reply: strings.Repeat("x", 8192)}
+	small := &pb.PingResponse{Reply: strings.Repeat("x", 2048)}
+
+	// The client knows the server's limit from the hello and does not send a larger request.
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second, WithMaxResponseSize(4096))
+	defer rpcClient.CloseAllConnections()
+	err = rpcClient.Call(context.Background(), serverAddr, "Echo", big, &pb.PingResponse{})
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+
+	// A client that does not know it is answered with an error, and the connection survives.
+	oldClient, err := dialClientConn(serverAddr, connConfig{dialTimeout: time.Second, legacy: true, maxFrameSize: DefaultMaxFrameSize})
+	require.NoError(t, err)
+	defer oldClient.close(errConnClosed)
+	payload, _ := proto.Marshal(big)
+	res, err := oldClient.call(context.Background(), &requestFrame{method: "Echo", payload: payload})
+	require.NoError(t, err)
+	assert.Contains(t, res.err, ErrFrameTooLarge.Error())
+	res, err = oldClient.call(context.Background(), &requestFrame{method: "Echo", payload: []byte("ok")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ok"), res.payload)
+
+	// Compressed on the wire, limits apply to the decompressed size.
+	compressing := NewRPCClient(nil, 1, 2*time.Second, WithCompression(256))
+	defer compressing.CloseAllConnections()
+	echoed := &pb.PingResponse{}
+	require.NoError(t, compressing.Call(context.Background(), serverAddr, "Echo", small, echoed))
+	assert.Equal(t, small.Reply, echoed.Reply)
+
+	// Responses over the client's limit fail the call, not the connection.
+	limited := NewRPCClient(nil, 1, 2*time.Second, WithMaxResponseSize(1024))
+	defer limited.CloseAllConnections()
+	err = limited.Call(context.Background(), serverAddr, "Echo", small, &pb.PingResponse{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ErrFrameTooLarge.Error())
+	require.NoError(t, limited.Call(context.Background(), serverAddr, "Echo", &pb.PingResponse{Reply: "ok"}, echoed))
+
+	require.NoError(t, limited.Send(context.Background(), serverAddr, "Notify", &pb.PingRequest{Message: "oneway"}))
+	select {
+	case msg := <-notified:
+		assert.Equal(t, "oneway", msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("oneway request did not reach the handler")
+	}
+
+	// Answered heartbeats keep an idle connection open.
+	accepted := counting.accepted.Load()
+	beating := NewRPCClient(nil, 1, 2*time.Second, WithHeartbeat(20*time.Millisecond))
+	defer beating.CloseAllConnections()
+	require.NoError(t, beating.Call(context.Background(), serverAddr, "Echo", small, echoed))
+	time.Sleep(150 * time.Millisecond)
+	require.NoError(t, beating.Call(context.Background(), serverAddr, "Echo", small, echoed))
+	assert.Equal(t, int32(1), counting.accepted.Load()-accepted, "the connection should have survived")
+}
+
+func TestRPCStream_ServerStreamingAndBidi(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+
+	// Feed sends as many messages as the request asks for, then fails if asked to.
+	server.HandleStream("Feed", func(stream *ServerStream) error {
+		var req pb.PingRequest
+		if err := stream.Recv(&req); err != nil {
+			return err
+		}
+		var n int
+		fmt.Sscan(req.Message, &n)
+		trace := MetadataFromContext(stream.Context()).Get(MetadataTraceID)
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&pb.PingResponse{Reply: fmt.Sprintf("%s-%d", trace, i)}); err != nil {
+				return err
+			}
+		}
+		if strings.HasSuffix(req.Message, "!") {
+			return errors.New("feed broke")
+		}
+		return nil
+	})
+	server.HandleStream("Echo", func(stream *ServerStream) error {
+		for {
+			var req pb.PingRequest
+			if err := stream.Recv(&req); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.PingResponse{Reply: req.Message}); err != nil {
+				return err
+			}
+		}
+	})
+
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+	ctx := WithMetadataValue(context.Background(), MetadataTraceID, "t")
+
+	// Many more messages than the flow control window.
+	feed, err := rpcClient.CallStream(ctx, serverAddr, "Feed", &pb.PingRequest{Message: "200"})
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		resp := &pb.PingResponse{}
+		require.NoError(t, feed.Recv(resp))
+		require.Equal(t, fmt.Sprintf("t-%d", i), resp.Reply)
+	}
+	require.ErrorIs(t, feed.Recv(&pb.PingResponse{}), io.EOF)
+
+	// The handler's error comes after its messages.
+	feed, err = rpcClient.CallStream(ctx, serverAddr, "Feed", &pb.PingRequest{Message: "3!"})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, feed.Recv(&pb.PingResponse{}))
+	}
+	err = feed.Recv(&pb.PingResponse{})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+	assert.Contains(t, err.Error(), "feed broke")
+	assert.ErrorIs(t, feed.Send(&pb.PingRequest{}), io.EOF, "sending after the end")
+
+	// Sending and receiving at once.
+	echo, err := rpcClient.NewStream(ctx, serverAddr, "Echo")
+	require.NoError(t, err)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := echo.Send(&pb.PingRequest{Message: fmt.Sprint(i)}); err != nil {
+				return
+			}
+		}
+		echo.CloseSend()
+	}()
+	for i := 0; i < 100; i++ {
+		resp := &pb.PingResponse{}
+		require.NoError(t, echo.Recv(resp))
+		require.Equal(t, fmt.Sprint(i), resp.Reply)
+	}
+	require.ErrorIs(t, echo.Recv(&pb.PingResponse{}), io.EOF)
+
+	missing, err := rpcClient.NewStream(ctx, serverAddr, "Missing")
+	require.NoError(t, err, "the stream opens before the server answers")
+	err = missing.Recv(&pb.PingResponse{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no stream coordinator found for method: Missing")
+}
+
+func TestRPCStream_FlowControlAndCancel(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Ping", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		return proto.Marshal(&pb.PingResponse{Reply: "pong"})
+	})
+
+	var sent atomic.Int32
+	handlerDone := make(chan error, 1)
+	server.HandleStream("Firehose", func(stream *ServerStream) error {
+		for {
+			if err := stream.Send(&pb.PingResponse{Reply: "x"}); err != nil {
+				handlerDone <- err
+				return err
+			}
+			sent.Add(1)
+		}
+	})
+
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+
+	firehose, err := rpcClient.NewStream(context.Background(), serverAddr, "Firehose")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(streamWindow), sent.Load(), "the server should stop at the window while nothing is read")
+
+	// The stalled stream does not hold up other calls on the connection.
+	pingResp := &pb.PingResponse{}
+	require.NoError(t, rpcClient.Call(context.Background(), serverAddr, "Ping", &pb.PingRequest{}, pingResp))
+	assert.Equal(t, "pong", pingResp.Reply)
+
+	// Reading lets it go on, a window ahead of the reader.
+	for i := 0; i < 3*streamWindow; i++ {
+		require.NoError(t, firehose.Recv(&pb.PingResponse{}))
+	}
+	assert.Eventually(t, func() bool { return sent.Load() == 4*streamWindow }, time.Second, 10*time.Millisecond)
+
+	// Closing the stream cancels the handler.
+	firehose.Close()
+	select {
+	case err := <-handlerDone:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+	assert.ErrorIs(t, firehose.Recv(&pb.PingResponse{}), context.Canceled)
+}
+
+func TestRPCInterceptors(t *testing.T) {
+	var (
+		orderMu sync.Mutex
+		order   []string
+	)
+	trace := func(name string) ServerInterceptor {
+		return func(ctx context.Context, info *ServerInfo, reqPayload []byte, next HandlerFunc) ([]byte, error) {
+			orderMu.Lock()
+			order = append(order, name+">"+info.Method)
+			orderMu.Unlock()
+			resPayload, err := next(ctx, reqPayload)
+			orderMu.Lock()
+			order = append(order, name+"<")
+			orderMu.Unlock()
+			return resPayload, err
+		}
+	}
+	serverMetrics := NewRPCMetrics()
+	var streamErr atomic.Value
+	server, err := NewRPCServer(nil,
+		WithInterceptors(trace("outer"), MetricsInterceptor(serverMetrics), RequireMetadata(MetadataUserID), trace("inner")),
+		WithStreamInterceptors(func(stream *ServerStream, info *ServerInfo, next StreamHandlerFunc) error {
+			err := next(stream)
+			streamErr.Store(fmt.Sprint(err))
+			return err
+		}),
+	)
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Close()
+	serverAddr := lis.Addr().String()
+
+	server.Handle("Whoami", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		return proto.Marshal(&pb.PingResponse{Reply: MetadataFromContext(ctx).Get(MetadataUserID)})
+	})
+	server.Handle("Panic", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		panic("boom")
+	})
+	server.HandleStream("PanicStream", func(stream *ServerStream) error {
+		panic("stream boom")
+	})
+
+	// A client interceptor supplies the metadata the server requires.
+	clientMetrics := NewRPCMetrics()
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second, WithClientInterceptors(
+		ClientMetricsInterceptor(clientMetrics),
+		func(ctx context.Context, serviceName string, methodName string, requestProto proto.Message, responseProto proto.Message, next Invoker) error {
+			return next(WithMetadataValue(ctx, MetadataUserID, "42"), serviceName, methodName, requestProto, responseProto)
+		},
+	))
+	defer rpcClient.CloseAllConnections()
+
+	resp := &pb.PingResponse{}
+	require.NoError(t, rpcClient.Call(context.Background(), serverAddr, "Whoami", &pb.PingRequest{}, resp))
+	assert.Equal(t, "42", resp.Reply)
+	assert.Equal(t, []string{"outer>Whoami", "inner>Whoami", "inner<", "outer<"}, order)
+
+	plainClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer plainClient.CloseAllConnections()
+	err = plainClient.Call(context.Background(), serverAddr, "Whoami", &pb.PingRequest{}, resp)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `missing metadata "user-id"`)
+
+	// Panics become errors, seen by the interceptors, and the connection survives them.
+	err = rpcClient.Call(context.Background(), serverAddr, "Panic", &pb.PingRequest{}, resp)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "internal error in method Panic")
+	stream, err := rpcClient.NewStream(context.Background(), serverAddr, "PanicStream")
+	require.NoError(t, err)
+	err = stream.Recv(&pb.PingResponse{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "internal error in method PanicStream")
+	assert.Equal(t, "internal error in method PanicStream", streamErr.Load())
+	require.NoError(t, rpcClient.Call(context.Background(), serverAddr, "Whoami", &pb.PingRequest{}, resp))
+
+	stats := serverMetrics.Snapshot()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "Panic", stats[0].Method)
+	assert.Equal(t, int64(1), stats[0].Errors)
+	assert.Equal(t, "Whoami", stats[1].Method)
+	assert.Equal(t, int64(3), stats[1].Calls)
+	assert.Equal(t, int64(1), stats[1].Errors)
+	clientStats := clientMetrics.Snapshot()
+	require.Len(t, clientStats, 2)
+	assert.Equal(t, int64(2), clientStats[1].Calls)
+}
+
+func TestRPCInterceptors_RateLimit(t *testing.T) {
+	server, err := NewRPCServer(nil, WithInterceptors(RateLimitInterceptor(1, 2)))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Close()
+	server.Handle("Ping", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return nil, nil })
+
+	rpcClient := NewRPCClient(nil, 1, 2*time.Second)
+	defer rpcClient.CloseAllConnections()
+	for i := 0; i < 2; i++ {
+		require.NoError(t, rpcClient.Call(context.Background(), lis.Addr().String(), "Ping", &pb.PingRequest{}, &pb.PingResponse{}))
+	}
+	err = rpcClient.Call(context.Background(), lis.Addr().String(), "Ping", &pb.PingRequest{}, &pb.PingResponse{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ErrRateLimited.Error())
+}
+
+func TestRPCCall_RetryAndHedging(t *testing.T) {
+	server, err := NewRPCServer(nil)
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Close()
+	serverAddr := lis.Addr().String()
+
+	var flakyCalls atomic.Int32
+	server.Handle("Flaky", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		if flakyCalls.Add(1) < 3 {
+			return nil, errors.New("try again")
+		}
+		return proto.Marshal(&pb.PingResponse{Reply: "finally"})
+	})
+	var slowCalls atomic.Int32
+	slowCancelled := make(chan struct{})
+	server.Handle("SlowOnce", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		if slowCalls.Add(1) == 1 {
+			select {
+			case <-ctx.Done():
+				close(slowCancelled)
+				return nil, ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+		return proto.Marshal(&pb.PingResponse{Reply: fmt.Sprint(slowCalls.Load())})
+	})
+
+	// Handler errors are not retried unless the policy says so.
+	rpcClient := NewRPCClient(nil, 1, time.Second,
+		WithRetryPolicy("Flaky", RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+		WithRetryPolicy("SlowOnce", RetryPolicy{MaxAttempts: 2, HedgeDelay: 50 * time.Millisecond}),
+	)
+	defer rpcClient.CloseAllConnections()
+	err = rpcClient.Call(context.Background(), serverAddr, "Flaky", &pb.PingRequest{}, &pb.PingResponse{})
+	var remoteErr *RemoteError
+	require.ErrorAs(t, err, &remoteErr)
+	assert.Equal(t, "try again", remoteErr.Message)
+	assert.Equal(t, int32(1), flakyCalls.Load())
+
+	retrying := NewRPCClient(nil, 1, time.Second, WithRetryPolicy("Flaky", RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Retryable:      func(err error) bool { return errors.As(err, &remoteErr) && remoteErr.Message == "try again" },
+	}))
+	defer retrying.CloseAllConnections()
+	resp := &pb.PingResponse{}
+	require.NoError(t, retrying.Call(context.Background(), serverAddr, "Flaky", &pb.PingRequest{}, resp))
+	assert.Equal(t, "finally", resp.Reply)
+	assert.Equal(t, int32(3), flakyCalls.Load())
+
+	// The hedge answers long before the first attempt would, and the first attempt is cancelled.
+	start := time.Now()
+	require.NoError(t, rpcClient.Call(context.Background(), serverAddr, "SlowOnce", &pb.PingRequest{}, resp))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "2", resp.Reply)
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the losing attempt was not cancelled")
+	}
+}
+
+func TestRPCClient_CircuitBreaker(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Ping", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return nil, nil })
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	deadAddr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	rpcClient := NewRPCClient(nil, 1, 100*time.Millisecond, WithCircuitBreaker(BreakerPolicy{FailureThreshold: 2, OpenTimeout: 200 * time.Millisecond}))
+	defer rpcClient.CloseAllConnections()
+
+	for i := 0; i < 2; i++ {
+		err := rpcClient.Call(context.Background(), deadAddr, "Ping", &pb.PingRequest{}, &pb.PingResponse{})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	err = rpcClient.Call(context.Background(), deadAddr, "Ping", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, ErrCircuitOpen, "the breaker should open after two failures")
+
+	// Round-robin skips the open endpoint until it may be probed.
+	for i := 0; i < 4; i++ {
+		addr, err := rpcClient.pick(PickInfo{Service: "room"}, []Instance{{Addr: deadAddr}, {Addr: serverAddr}})
+		require.NoError(t, err)
+		assert.Equal(t, serverAddr, addr)
+	}
+	_, err = rpcClient.pick(PickInfo{Service: "room"}, []Instance{{Addr: deadAddr}})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// After the timeout one probe goes through; it fails, so the breaker opens again.
+	time.Sleep(250 * time.Millisecond)
+	addrs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		addr, err := rpcClient.pick(PickInfo{Service: "room"}, []Instance{{Addr: deadAddr}, {Addr: serverAddr}})
+		require.NoError(t, err)
+		addrs[addr] = true
+	}
+	assert.True(t, addrs[deadAddr], "the endpoint should be back in rotation for a probe")
+	err = rpcClient.Call(context.Background(), deadAddr, "Ping", &pb.PingRequest{}, &pb.PingResponse{})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCircuitOpen, "the probe should be sent")
+	err = rpcClient.Call(context.Background(), deadAddr, "Ping", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// Handler errors do not open the breaker.
+	for i := 0; i < 3; i++ {
+		err := rpcClient.Call(context.Background(), serverAddr, "Missing", &pb.PingRequest{}, &pb.PingResponse{})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+}
+
+func TestRPCClient_CircuitBreakerScope(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Slow", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	server.Handle("Ping", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return nil, nil })
+
+	// Breakers are off unless asked for.
+	assert.Nil(t, NewRPCClient(nil, 1, time.Second).breaker(serverAddr))
+
+	// Deadlines shorter than CallTimeout do not count against the endpoint.
+	rpcClient := NewRPCClient(nil, 1, time.Second, WithCircuitBreaker(BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute}))
+	defer rpcClient.CloseAllConnections()
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := rpcClient.Call(ctx, serverAddr, "Slow", &pb.PingRequest{}, &pb.PingResponse{})
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	require.NoError(t, rpcClient.Call(context.Background(), serverAddr, "Ping", &pb.PingRequest{}, &pb.PingResponse{}))
+
+	// The breaker of an endpoint goes away with the endpoint.
+	registry := discovery.NewMemoryRegistry()
+	host, port, err := net.SplitHostPort(serverAddr)
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(discovery.Instance{ID: "room-0", Service: "roomserver", Address: host, Port: portNum}))
+	resolver := discovery.NewResolver(registry)
+	defer resolver.Close()
+	discovering := NewRPCClient(nil, 1, time.Second, WithResolver(resolver), WithCircuitBreaker(BreakerPolicy{}))
+	defer discovering.CloseAllConnections()
+	require.NoError(t, discovering.Call(context.Background(), "roomserver", "Ping", &pb.PingRequest{}, &pb.PingResponse{}))
+	breakers := func() int {
+		discovering.mu.Lock()
+		defer discovering.mu.Unlock()
+		return len(discovering.breakers)
+	}
+	assert.Equal(t, 1, breakers())
+	require.NoError(t, registry.Deregister("room-0"))
+	assert.Eventually(t, func() bool { return breakers() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRPCClient_CircuitBreakerCountsHangs(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Hang", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	// Calls that waited at least CallTimeout count, however short the caller's deadline was.
+	rpcClient := NewRPCClient(nil, 1, time.Second, WithCircuitBreaker(BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute, CallTimeout: 20 * time.Millisecond}))
+	defer rpcClient.CloseAllConnections()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		err := rpcClient.Call(ctx, serverAddr, "Hang", &pb.PingRequest{}, &pb.PingResponse{})
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	err := rpcClient.Call(context.Background(), serverAddr, "Hang", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, ErrCircuitOpen, "the breaker should open after two calls that hung")
+
+	// A cancellation never counts.
+	cancelling := NewRPCClient(nil, 1, time.Second, WithCircuitBreaker(BreakerPolicy{FailureThreshold: 1, CallTimeout: time.Millisecond}))
+	defer cancelling.CloseAllConnections()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = cancelling.Call(ctx, serverAddr, "Hang", &pb.PingRequest{}, &pb.PingResponse{})
+	require.ErrorIs(t, err, context.Canceled)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = cancelling.Call(ctx, serverAddr, "Hang", &pb.PingRequest{}, &pb.PingResponse{})
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestRPCClient_CloseWhileDeliveringAnUpdate(t *testing.T) {
+	server, serverAddr := startTestRPCServer(t)
+	defer server.Close()
+	server.Handle("Ping", func(ctx context.Context, reqPayload []byte) ([]byte, error) { return nil, nil })
+	host, port, err := net.SplitHostPort(serverAddr)
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	// Subscribers are notified in no particular order, so the update reaches the breakers
+	// after the blocking subscriber only some of the time.
+	for i := 0; i < 10; i++ {
+		registry := discovery.NewMemoryRegistry()
+		require.NoError(t, registry.Register(discovery.Instance{ID: "room-0", Service: "roomserver", Address: host, Port: portNum}))
+		resolver := discovery.NewResolver(registry)
+		rpcClient := NewRPCClient(nil, 1, time.Second, WithResolver(resolver), WithCircuitBreaker(BreakerPolicy{}))
+		rpcClient.ownsResolver = true // As if created from its Consul client
+		require.NoError(t, rpcClient.Call(context.Background(), "roomserver", "Ping", &pb.PingRequest{}, &pb.PingResponse{}))
+
+		delivering, release := make(chan struct{}), make(chan struct{})
+		_, err := resolver.Subscribe("roomserver", func(instances []discovery.Instance) {
+			if len(instances) == 0 {
+				close(delivering)
+				<-release
+			}
+		})
+		require.NoError(t, err)
+		require.NoError(t, registry.Deregister("room-0"))
+		<-delivering
+
+		closed := make(chan struct{})
+		go func() {
+			rpcClient.CloseAllConnections()
+			close(closed)
+		}()
+		time.Sleep(5 * time.Millisecond) // Let the close reach the resolver
+		close(release)
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("CloseAllConnections deadlocked with the update it was waiting for")
+		}
+	}
+}
+
+func TestRPCCall_DiscoveryWithoutConsul(t *testing.T) {
+	registry := discovery.NewMemoryRegistry()
+	var servers []*RPCServer
+	for i := 0; i < 2; i++ {
+		server, err := NewRPCServer(nil)
+		require.NoError(t, err)
+		lis, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		go server.Serve(lis)
+		servers = append(servers, server)
+		instanceID := fmt.Sprintf("room-%d", i)
+		server.Handle("Whoami", func(ctx context.Context, reqPayload []byte) ([]byte, error) {
+			return proto.Marshal(&pb.PingResponse{Reply: instanceID})
+		})
+		host, port, err := net.SplitHostPort(lis.Addr().String())
+		require.NoError(t, err)
+		portNum, err := strconv.Atoi(port)
+		require.NoError(t, err)
+		require.NoError(t, registry.Register(discovery.Instance{ID: instanceID, Service: "roomserver", Address: host, Port: portNum}))
+	}
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+
+	resolver := discovery.NewResolver(registry)
+	defer resolver.Close()
+	rpcClient := NewRPCClient(nil, 1, time.Second, WithResolver(resolver))
+	defer rpcClient.CloseAllConnections()
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		resp := &pb.PingResponse{}
+		require.NoError(t, rpcClient.Call(context.Background(), "roomserver", "Whoami", &pb.PingRequest{}, resp))
+		seen[resp.Reply] = true
+	}
+	assert.Equal(t, map[string]bool{"room-0": true, "room-1": true}, seen)
+
+	require.NoError(t, registry.Deregister("room-0"))
+	assert.Eventually(t, func() bool {
+		resp := &pb.PingResponse{}
+		for i := 0; i < 4; i++ {
+			if err := rpcClient.Call(context.Background(), "roomserver", "Whoami", &pb.PingRequest{}, resp); err != nil || resp.Reply != "room-1" {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond, "a deregistered instance gets no more calls")
+
+	err := rpcClient.Call(context.Background(), "chatserver", "Whoami", &pb.PingRequest{}, &pb.PingResponse{})
+	assert.ErrorContains(t, err, "no instances found for service chatserver")
+}
@@ -0,0 +1,363 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// serverCompressMin is the smallest response body the server compresses, for clients that
+// compress their requests.
+const serverCompressMin = 1024
+
+// serverConn serves the requests of one client connection. Requests run on their own
+// goroutines, at most maxConcurrent at a time; further requests wait in a queue of up to
+// maxQueued, and requests past that are rejected. A cancel frame, the request's deadline or the
+// client disconnecting cancels the context of the request's handler, queued or running.
+type serverConn struct {
+	server *RPCServer
+	conn   net.Conn
+	r      *bufio.Reader
+	framer framer
+
+	ctx          context.Context // Cancelled when the connection closes
+	disconnected context.CancelFunc
+
+	writeMu sync.Mutex // Serializes frames written to conn
+
+	mu       sync.Mutex
+	inFlight map[uint64]context.CancelFunc // Running requests and open streams, by request ID
+	streams  map[uint64]*stream            // Open streams, by request ID
+	handlers sync.WaitGroup
+	running  int              // Handlers running
+	queue    []*queuedRequest // Requests waiting for a handler, oldest first
+}
+
+func newServerConn(s *RPCServer, conn net.Conn) *serverConn {
+	sc := &serverConn{
+		server:   s,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		framer:   framer{version: legacyVersion, maxFrameSize: s.maxRequestSize, legacyType: frameRequest},
+		inFlight: make(map[uint64]context.CancelFunc),
+		streams:  make(map[uint64]*stream),
+	}
+	sc.ctx, sc.disconnected = context.WithCancel(context.Background())
+	return sc
+}
+
+// serve reads frames until the connection fails, then waits for the running handlers.
+func (sc *serverConn) serve() {
+	defer func() {
+		sc.disconnected()
+		sc.handlers.Wait()
+		log.Printf("Closing connection from %s", sc.conn.RemoteAddr())
+		sc.conn.Close()
+	}()
+
+	// Versioned clients start with the magic; anything else is an unversioned length.
+	first, err := sc.r.Peek(1)
+	if err != nil {
+		log.Printf("Connection closed by client %s before sending a frame", sc.conn.RemoteAddr())
+		return
+	}
+	if isVersioned(first[0]) {
+		sc.framer.version = protocolVersion
+	}
+
+	for {
+		f, err := sc.framer.readFrame(sc.r)
+		if errors.Is(err, ErrFrameTooLarge) {
+			log.Printf("Rejecting frame from %s: %v", sc.conn.RemoteAddr(), err)
+			switch {
+			case f.typ == frameRequest && f.flags&flagOneway == 0:
+				sc.reply(f.id, 0, &responseFrame{err: err.Error()})
+			case f.typ == frameStreamOpen:
+				sc.endStream(f.id, err)
+			case f.typ == frameStreamMsg:
+				sc.cancel(f.id) // The stream cannot go on without the message
+			}
+			continue
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				log.Printf("Connection closed by client %s (EOF reading frame header)", sc.conn.RemoteAddr())
+			} else {
+				log.Printf("Error reading frame from %s: %v", sc.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		switch {
+		case f.typ == frameHello:
+			if _, err := parseHello(f); err != nil {
+				log.Printf("Malformed hello from %s: %v", sc.conn.RemoteAddr(), err)
+				return
+			}
+			// Answer with the highest version both sides speak.
+			sc.writeMu.Lock()
+			sc.framer.version = min(f.version, protocolVersion)
+			sc.writeMu.Unlock()
+			sc.write(frame{typ: frameHello, body: helloBody(sc.framer.maxFrameSize)})
+		case f.typ == frameCancel:
+			sc.cancel(f.id)
+		case f.typ == frameRequest && f.flags&flagHeartbeat != 0:
+			sc.write(frame{typ: frameResponse, flags: flagHeartbeat, id: f.id})
+		case f.typ == frameRequest && sc.framer.version == legacyVersion:
+			if !sc.serveInOrder(f) {
+				return
+			}
+		case f.typ == frameRequest:
+			if !sc.dispatch(f) {
+				return
+			}
+		case f.typ == frameStreamOpen && sc.framer.version != legacyVersion:
+			sc.openStream(f)
+		case f.typ == frameStreamMsg || f.typ == frameStreamEnd || f.typ == frameStreamWindow:
+			if err := sc.deliverStream(f); err != nil {
+				log.Printf("Stream %d from %s: %v", f.id, sc.conn.RemoteAddr(), err)
+				return
+			}
+		default:
+			log.Printf("Unexpected frame type %d from %s", f.typ, sc.conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// cancel cancels the request or stream with the given ID, if it is still running.
+func (sc *serverConn) cancel(id uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if cancel, ok := sc.inFlight[id]; ok {
+		cancel()
+	}
+}
+
+// openStream starts the stream handler for a stream open frame.
+func (sc *serverConn) openStream(f frame) {
+	req, err := decodeRequest(f.body, f.version)
+	if err != nil {
+		sc.endStream(f.id, fmt.Errorf("malformed stream request: %w", err))
+		return
+	}
+	handler, ok := sc.server.streamHandlers[req.method]
+	if !ok {
+		sc.endStream(f.id, fmt.Errorf("no stream coordinator found for method: %s", req.method))
+		return
+	}
+	sc.mu.Lock()
+	open := len(sc.streams)
+	sc.mu.Unlock()
+	if open >= sc.server.maxStreams {
+		sc.endStream(f.id, fmt.Errorf("too many concurrent streams (limit %d)", sc.server.maxStreams))
+		return
+	}
+
+	ctx, cancel := sc.requestContext(req)
+	if len(req.metadata) > 0 {
+		ctx = context.WithValue(ctx, metadataKey{}, req.metadata)
+	}
+	st := newStream(ctx, cancel, f.id, req.method, func(_ context.Context, f frame) error {
+		if !sc.write(f) {
+			return errConnClosed
+		}
+		return nil
+	})
+	if f.flags&flagCompressed != 0 {
+		st.compressMin = serverCompressMin
+	}
+	sc.mu.Lock()
+	sc.streams[f.id] = st
+	sc.inFlight[f.id] = cancel
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		err := sc.server.handleStream(&ServerStream{s: st}, &ServerInfo{Method: req.method, Peer: sc.conn.RemoteAddr().String()}, handler)
+		st.finish(errStreamFinished)
+		sc.mu.Lock()
+		delete(sc.streams, f.id)
+		delete(sc.inFlight, f.id)
+		sc.mu.Unlock()
+		if sc.ctx.Err() == nil { // Unless the client went away
+			sc.endStream(f.id, err)
+		}
+		cancel()
+	}()
+}
+
+// endStream sends the end of a stream, carrying err if it is not nil.
+func (sc *serverConn) endStream(id uint64, err error) {
+	res := &responseFrame{}
+	if err != nil {
+		res.err = err.Error()
+	}
+	sc.write(frame{typ: frameStreamEnd, id: id, body: res.encode()})
+}
+
+// deliverStream hands a stream frame from the client to its stream. Frames of streams that
+// already ended are dropped. A returned error is a protocol violation.
+func (sc *serverConn) deliverStream(f frame) error {
+	sc.mu.Lock()
+	st := sc.streams[f.id]
+	sc.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+	switch f.typ {
+	case frameStreamMsg:
+		return st.deliver(f.body)
+	case frameStreamWindow:
+		n, err := parseWindow(f)
+		if err != nil {
+			return err
+		}
+		st.grant(n)
+	case frameStreamEnd:
+		st.endRecv(io.EOF)
+	}
+	return nil
+}
+
+// queuedRequest is a request waiting for a free handler slot.
+type queuedRequest struct {
+	id     uint64
+	flags  byte
+	req    *requestFrame
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// dispatch starts the handler for a request frame if a slot is free, and queues it otherwise;
+// requests past the queue limit are answered with an error at once. It never waits, so that
+// cancel and heartbeat frames are read while the handlers are busy. It returns false if the
+// connection should be closed.
+func (sc *serverConn) dispatch(f frame) bool {
+	oneway := f.flags&flagOneway != 0
+	if f.codec != codecProto {
+		log.Printf("Unsupported codec %d from %s", f.codec, sc.conn.RemoteAddr())
+		if !oneway {
+			sc.reply(f.id, f.flags, &responseFrame{err: fmt.Sprintf("unsupported codec %d", f.codec)})
+		}
+		return true
+	}
+	req, err := decodeRequest(f.body, f.version)
+	if err != nil {
+		log.Printf("Malformed request frame from %s: %v", sc.conn.RemoteAddr(), err)
+		return false
+	}
+
+	// The handler context starts now, so time spent in the queue counts against the deadline.
+	ctx, cancel := sc.requestContext(req)
+	q := &queuedRequest{id: f.id, flags: f.flags, req: req, ctx: ctx, cancel: cancel}
+	sc.mu.Lock()
+	start := sc.running < sc.server.maxConcurrent
+	if !start && len(sc.queue) >= sc.server.maxQueued {
+		sc.mu.Unlock()
+		cancel()
+		log.Printf("Rejecting request for method '%s' from %s: %d requests running and %d queued", req.method, sc.conn.RemoteAddr(), sc.server.maxConcurrent, sc.server.maxQueued)
+		if !oneway {
+			sc.reply(f.id, f.flags, &responseFrame{err: fmt.Sprintf("server busy: %d requests running and %d queued on the connection", sc.server.maxConcurrent, sc.server.maxQueued)})
+		}
+		return true
+	}
+	if start {
+		sc.running++
+	} else {
+		sc.queue = append(sc.queue, q)
+	}
+	if !oneway { // Oneway requests share ID 0 and cannot be cancelled
+		sc.inFlight[f.id] = cancel
+	}
+	sc.mu.Unlock()
+
+	if start {
+		sc.handlers.Add(1)
+		go sc.run(q)
+	}
+	return true
+}
+
+// run runs the handler of q, then those of the queued requests, oldest first, until the queue
+// is empty.
+func (sc *serverConn) run(q *queuedRequest) {
+	defer sc.handlers.Done()
+	for q != nil {
+		oneway := q.flags&flagOneway != 0
+		rpcResp := sc.server.serveRequest(q.ctx, sc.conn, q.req)
+		if !oneway && sc.ctx.Err() == nil { // Unless the client went away
+			sc.reply(q.id, q.flags, rpcResp)
+		}
+		q.cancel()
+
+		sc.mu.Lock()
+		if !oneway {
+			delete(sc.inFlight, q.id)
+		}
+		q = nil
+		if len(sc.queue) > 0 {
+			q = sc.queue[0]
+			sc.queue[0] = nil
+			sc.queue = sc.queue[1:]
+		} else {
+			sc.running--
+		}
+		sc.mu.Unlock()
+	}
+}
+
+// serveInOrder runs the handler of a request frame of an unversioned client and sends the
+// response before the next request is read: unversioned responses carry no request ID, so they
+// must come back in request order. It returns false if the connection should be closed.
+func (sc *serverConn) serveInOrder(f frame) bool {
+	req, err := decodeRequest(f.body, f.version)
+	if err != nil {
+		log.Printf("Malformed request frame from %s: %v", sc.conn.RemoteAddr(), err)
+		return false
+	}
+	ctx, cancel := context.WithCancel(sc.ctx)
+	defer cancel()
+	return sc.reply(f.id, f.flags, sc.server.serveRequest(ctx, sc.conn, req))
+}
+
+// requestContext returns the context of the handler of req, bounded by its deadline if it has one.
+func (sc *serverConn) requestContext(req *requestFrame) (context.Context, context.CancelFunc) {
+	if req.timeout > 0 {
+		return context.WithTimeout(sc.ctx, req.timeout)
+	}
+	return context.WithCancel(sc.ctx)
+}
+
+// reply sends the response to request id, compressing it if the request was compressed.
+func (sc *serverConn) reply(id uint64, requestFlags byte, res *responseFrame) bool {
+	body := res.encode()
+	var flags byte
+	if requestFlags&flagCompressed != 0 && len(body) >= serverCompressMin {
+		flags = flagCompressed
+	}
+	return sc.write(frame{typ: frameResponse, flags: flags, id: id, body: body})
+}
+
+// write sends f. A failed write closes the connection, which stops the read loop and cancels
+// the running handlers. So does a write that does not complete within the server's write
+// timeout, so that a client that stops reading cannot hold up the other handlers and streams of
+// the connection behind writeMu.
+func (sc *serverConn) write(f frame) bool {
+	sc.writeMu.Lock()
+	sc.conn.SetWriteDeadline(time.Now().Add(sc.server.writeTimeout))
+	err := sc.framer.writeFrame(sc.conn, f)
+	sc.writeMu.Unlock()
+	if err != nil {
+		log.Printf("Error sending frame to %s: %v", sc.conn.RemoteAddr(), err)
+		sc.conn.Close()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,348 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// streamWindow is the flow control window of a stream, in messages: a sender may have this
+// many messages not yet read by the receiver, after which Send blocks until the receiver
+// catches up. Both ends use the same window, so a slow reader never makes the connection's
+// read loop wait and never stalls the other calls sharing the connection.
+const streamWindow = 32
+
+// errStreamWindow is a protocol violation: a peer sent more than the window allowed.
+var errStreamWindow = errors.New("stream flow control window exceeded")
+
+// errSendClosed is returned by Send after CloseSend.
+var errSendClosed = errors.New("send on closed stream")
+
+// errStreamFinished is returned by a ServerStream used after its handler returned.
+var errStreamFinished = errors.New("stream finished")
+
+// StreamHandlerFunc handles a streaming RPC. The stream ends when the handler returns; its
+// error, if any, is what the client's Recv reports after the messages sent before it.
+type StreamHandlerFunc func(stream *ServerStream) error
+
+// stream is the state shared by both ends of a stream: send credit granted by the peer, and
+// the peer's messages waiting to be read.
+type stream struct {
+	id          uint64
+	method      string
+	ctx         context.Context
+	cancel      context.CancelFunc                       // Cancels ctx
+	write       func(ctx context.Context, f frame) error // Writes a frame on the stream's connection
+	compressMin int                                      // Smallest message compressed; 0 disables compression
+
+	mu         sync.Mutex
+	credit     int   // Messages that may still be sent
+	unacked    int   // Messages read since the last window update
+	peerEnded  bool  // The peer sends no more; recvErr says why
+	recvErr    error // io.EOF, or the server's error on a client stream
+	sendClosed bool
+	finishErr  error
+
+	creditCh chan struct{} // Signalled when credit is granted
+	msgs     chan []byte   // Received messages not read yet, at most streamWindow
+	recvDone chan struct{} // Closed once the peer sends no more
+	finished chan struct{} // Closed once the stream is over for this end
+	once     sync.Once
+}
+
+func newStream(ctx context.Context, cancel context.CancelFunc, id uint64, method string, write func(ctx context.Context, f frame) error) *stream {
+	return &stream{
+		id:       id,
+		method:   method,
+		ctx:      ctx,
+		cancel:   cancel,
+		write:    write,
+		credit:   streamWindow,
+		creditCh: make(chan struct{}, 1),
+		msgs:     make(chan []byte, streamWindow),
+		recvDone: make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// send sends msg once the peer has room for it.
+func (s *stream) send(msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream message for method %s: %w", s.method, err)
+	}
+	for {
+		s.mu.Lock()
+		if s.finishErr != nil {
+			s.mu.Unlock()
+			return s.finishErr
+		}
+		if s.sendClosed {
+			s.mu.Unlock()
+			return errSendClosed
+		}
+		if s.credit > 0 {
+			s.credit--
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.creditCh:
+		case <-s.finished:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.finishErr
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	var flags byte
+	if s.compressMin > 0 && len(payload) >= s.compressMin {
+		flags = flagCompressed
+	}
+	return s.write(s.ctx, frame{typ: frameStreamMsg, flags: flags, id: s.id, body: payload})
+}
+
+// recv reads the next message into msg. Messages that arrived before the peer's end are read
+// first, and then recv reports why the peer ended; when this end is aborted, by its context or
+// its connection, recv fails at once.
+func (s *stream) recv(msg proto.Message) error {
+	for {
+		s.mu.Lock()
+		peerEnded, aborted := s.peerEnded, s.finishErr != nil
+		s.mu.Unlock()
+		if !peerEnded && (aborted || s.ctx.Err() != nil) {
+			return s.endErr()
+		}
+		select {
+		case payload := <-s.msgs:
+			return s.consume(payload, msg)
+		default:
+		}
+		if peerEnded {
+			return s.endErr()
+		}
+		select {
+		case payload := <-s.msgs:
+			return s.consume(payload, msg)
+		case <-s.recvDone:
+		case <-s.finished:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+// consume decodes a message and, every half window, gives the peer credit for the messages read.
+func (s *stream) consume(payload []byte, msg proto.Message) error {
+	s.mu.Lock()
+	s.unacked++
+	grant := 0
+	if s.unacked >= streamWindow/2 {
+		grant, s.unacked = s.unacked, 0
+	}
+	s.mu.Unlock()
+	if grant > 0 {
+		// Best effort: if this fails the stream is over anyway.
+		_ = s.write(s.ctx, frame{typ: frameStreamWindow, id: s.id, body: windowBody(grant)})
+	}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Errorf("failed to unmarshal stream message for method %s: %w", s.method, err)
+	}
+	return nil
+}
+
+// endErr returns why the stream stopped: the peer's end, this end's, or the context's.
+func (s *stream) endErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerEnded {
+		return s.recvErr
+	}
+	if s.finishErr != nil {
+		return s.finishErr
+	}
+	return s.ctx.Err()
+}
+
+// deliver queues a message from the peer. It is called by the connection's read loop, and
+// returns errStreamWindow if the peer overran the window.
+func (s *stream) deliver(payload []byte) error {
+	s.mu.Lock()
+	ended := s.peerEnded
+	s.mu.Unlock()
+	if ended {
+		return nil // Nothing is read after the end; drop it
+	}
+	select {
+	case s.msgs <- payload:
+		return nil
+	default:
+		return errStreamWindow
+	}
+}
+
+// endRecv records that the peer sends no more, because of err.
+func (s *stream) endRecv(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerEnded {
+		return
+	}
+	s.peerEnded, s.recvErr = true, err
+	close(s.recvDone)
+}
+
+// grant adds send credit from a window frame.
+func (s *stream) grant(n int) {
+	s.mu.Lock()
+	s.credit += n
+	s.mu.Unlock()
+	select {
+	case s.creditCh <- struct{}{}:
+	default:
+	}
+}
+
+// finish ends the stream for this end; Send and Recv then fail with err.
+func (s *stream) finish(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.finishErr = err
+		s.mu.Unlock()
+		close(s.finished)
+	})
+}
+
+// ServerStream is the server's end of a stream opened by RPCClient.NewStream.
+type ServerStream struct {
+	s *stream
+}
+
+// Context returns the stream's context. It carries the caller's Metadata and is cancelled
+// when the caller's deadline passes, the caller closes the stream or disconnects.
+func (ss *ServerStream) Context() context.Context {
+	return ss.s.ctx
+}
+
+// Method returns the name the stream was opened with.
+func (ss *ServerStream) Method() string {
+	return ss.s.method
+}
+
+// Send sends msg to the client, blocking while the client has streamWindow messages unread.
+func (ss *ServerStream) Send(msg proto.Message) error {
+	return ss.s.send(msg)
+}
+
+// Recv reads the next message from the client into msg. It returns io.EOF once the client
+// has called CloseSend and every message before it was read.
+func (ss *ServerStream) Recv(msg proto.Message) error {
+	return ss.s.recv(msg)
+}
+
+// ClientStream is the client's end of a stream. Send and Recv may be called from different
+// goroutines, but neither from several at once.
+type ClientStream struct {
+	s *stream
+}
+
+// Context returns the stream's context.
+func (cs *ClientStream) Context() context.Context {
+	return cs.s.ctx
+}
+
+// Send sends msg to the server, blocking while the server has streamWindow messages unread.
+// Once the stream has ended it returns io.EOF; Recv then tells how it ended.
+func (cs *ClientStream) Send(msg proto.Message) error {
+	return cs.s.send(msg)
+}
+
+// Recv reads the next message from the server into msg. It returns io.EOF once the handler
+// has returned without error and every message before was read, and the handler's error if
+// it returned one.
+func (cs *ClientStream) Recv(msg proto.Message) error {
+	return cs.s.recv(msg)
+}
+
+// CloseSend tells the server that no more messages follow; the server's Recv returns io.EOF.
+func (cs *ClientStream) CloseSend() error {
+	cs.s.mu.Lock()
+	if cs.s.sendClosed {
+		cs.s.mu.Unlock()
+		return nil
+	}
+	cs.s.sendClosed = true
+	cs.s.mu.Unlock()
+	return cs.s.write(cs.s.ctx, frame{typ: frameStreamEnd, id: cs.s.id})
+}
+
+// Close abandons the stream: the server's handler is cancelled unless it already returned.
+// It must be called, or the stream's context cancelled, unless Recv has returned an error.
+func (cs *ClientStream) Close() {
+	cs.s.cancel()
+}
+
+// HandleStream registers a handler for streams opened with methodName, overwriting any
+// previous one. Streams and unary calls have separate method names.
+func (s *RPCServer) HandleStream(methodName string, handler StreamHandlerFunc) {
+	if s.streamHandlers == nil {
+		s.streamHandlers = make(map[string]StreamHandlerFunc)
+	}
+	s.streamHandlers[methodName] = handler
+	log.Printf("Registered stream coordinator for method: %s", methodName)
+}
+
+// NewStream opens a stream to the given service and method, on a connection shared with other
+// calls. The stream lasts until the handler returns, ctx is done or Close is called; ctx's
+// deadline and Metadata are sent as for Call. Servers that only speak unversioned framing do
+// not support streams.
+func (c *RPCClient) NewStream(ctx context.Context, serviceName string, methodName string) (*ClientStream, error) {
+	out, err := c.prepare(ctx, serviceName, methodName, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	cs, err := out.conn.openStream(ctx, out.req)
+	out.breaker.record(outcomeOf(ctx, err, time.Since(start), c.breakerPolicy.CallTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("RPCClient: failed to open stream '%s' on %s: %w", methodName, out.addr, err)
+	}
+	return cs, nil
+}
+
+// CallStream opens a server-streaming call: it sends requestProto as the only message and
+// returns the stream to Recv the responses from, until io.EOF.
+func (c *RPCClient) CallStream(ctx context.Context, serviceName string, methodName string, requestProto proto.Message) (*ClientStream, error) {
+	cs, err := c.NewStream(ctx, serviceName, methodName)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.Send(requestProto); err != nil {
+		cs.Close()
+		return nil, err
+	}
+	if err := cs.CloseSend(); err != nil {
+		cs.Close()
+		return nil, err
+	}
+	return cs, nil
+}
+
+// streamError converts the end frame of a client stream into what Recv returns.
+func streamError(method string, f frame) error {
+	res, err := decodeResponse(f.body)
+	if err != nil {
+		return fmt.Errorf("malformed end of stream '%s': %w", method, err)
+	}
+	if res.err != "" {
+		return fmt.Errorf("RPC stream '%s' failed: %s", method, res.err)
+	}
+	return io.EOF
+}
@@ -0,0 +1,664 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: actor.proto
+
+package actor
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Terminated is delivered to every watcher of an actor once that actor has stopped.
+type Terminated struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ActorId       int64                  `protobuf:"varint,1,opt,name=actor_id,json=actorId,proto3" json:"actor_id,omitempty"` // ID of the stopped actor
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`                       // Name of the stopped actor
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`                   // Failure that stopped the actor; empty for a normal stop
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Terminated) Reset() {
+	*x = Terminated{}
+	mi := &file_actor_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Terminated) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Terminated) ProtoMessage() {}
+
+func (x *Terminated) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Terminated.ProtoReflect.Descriptor instead.
+func (*Terminated) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Terminated) GetActorId() int64 {
+	if x != nil {
+		return x.ActorId
+	}
+	return 0
+}
+
+func (x *Terminated) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Terminated) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// RemoteEnvelope carries a Tell or Ask to an actor living in another process.
+type RemoteEnvelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TargetId      int64                  `protobuf:"varint,1,opt,name=target_id,json=targetId,proto3" json:"target_id,omitempty"`      // ID of the target actor; used when target_path is empty
+	TargetPath    string                 `protobuf:"bytes,2,opt,name=target_path,json=targetPath,proto3" json:"target_path,omitempty"` // Path of the target actor, e.g. "/player-42"
+	Message       *anypb.Any             `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`                         // The message to deliver
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoteEnvelope) Reset() {
+	*x = RemoteEnvelope{}
+	mi := &file_actor_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoteEnvelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoteEnvelope) ProtoMessage() {}
+
+func (x *RemoteEnvelope) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoteEnvelope.ProtoReflect.Descriptor instead.
+func (*RemoteEnvelope) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RemoteEnvelope) GetTargetId() int64 {
+	if x != nil {
+		return x.TargetId
+	}
+	return 0
+}
+
+func (x *RemoteEnvelope) GetTargetPath() string {
+	if x != nil {
+		return x.TargetPath
+	}
+	return ""
+}
+
+func (x *RemoteEnvelope) GetMessage() *anypb.Any {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+// RemoteReply carries the response of a remote Ask.
+type RemoteReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       *anypb.Any             `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"` // Unset if the target replied with nil
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoteReply) Reset() {
+	*x = RemoteReply{}
+	mi := &file_actor_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoteReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoteReply) ProtoMessage() {}
+
+func (x *RemoteReply) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoteReply.ProtoReflect.Descriptor instead.
+func (*RemoteReply) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RemoteReply) GetMessage() *anypb.Any {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+// ShardEnvelope carries a message for a sharded entity between ShardRegions.
+type ShardEnvelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EntityId      string                 `protobuf:"bytes,1,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"` // Entity the message is for
+	Message       *anypb.Any             `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`                   // The message to deliver
+	Ask           bool                   `protobuf:"varint,3,opt,name=ask,proto3" json:"ask,omitempty"`                          // True if the sender waits for a reply
+	Hops          int32                  `protobuf:"varint,4,opt,name=hops,proto3" json:"hops,omitempty"`                        // Times the envelope has been forwarded between regions
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardEnvelope) Reset() {
+	*x = ShardEnvelope{}
+	mi := &file_actor_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardEnvelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardEnvelope) ProtoMessage() {}
+
+func (x *ShardEnvelope) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardEnvelope.ProtoReflect.Descriptor instead.
+func (*ShardEnvelope) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ShardEnvelope) GetEntityId() string {
+	if x != nil {
+		return x.EntityId
+	}
+	return ""
+}
+
+func (x *ShardEnvelope) GetMessage() *anypb.Any {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *ShardEnvelope) GetAsk() bool {
+	if x != nil {
+		return x.Ask
+	}
+	return false
+}
+
+func (x *ShardEnvelope) GetHops() int32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+// ShardHandOff tells the new owner of a shard that the previous owner has stopped its entities.
+type ShardHandOff struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardId       int32                  `protobuf:"varint,1,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`         // Shard that changed owner
+	FromMember    string                 `protobuf:"bytes,2,opt,name=from_member,json=fromMember,proto3" json:"from_member,omitempty"` // Member ID of the previous owner
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardHandOff) Reset() {
+	*x = ShardHandOff{}
+	mi := &file_actor_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardHandOff) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardHandOff) ProtoMessage() {}
+
+func (x *ShardHandOff) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardHandOff.ProtoReflect.Descriptor instead.
+func (*ShardHandOff) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ShardHandOff) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+func (x *ShardHandOff) GetFromMember() string {
+	if x != nil {
+		return x.FromMember
+	}
+	return ""
+}
+
+// ReceiveTimeout is delivered to an actor that set a receive timeout and received no other
+// message for that long.
+type ReceiveTimeout struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReceiveTimeout) Reset() {
+	*x = ReceiveTimeout{}
+	mi := &file_actor_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReceiveTimeout) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReceiveTimeout) ProtoMessage() {}
+
+func (x *ReceiveTimeout) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReceiveTimeout.ProtoReflect.Descriptor instead.
+func (*ReceiveTimeout) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{5}
+}
+
+// DeadLetter describes a message that could not be delivered or processed. It is the payload
+// published to NSQ when dead letters are forwarded.
+type DeadLetter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	System        string                 `protobuf:"bytes,1,opt,name=system,proto3" json:"system,omitempty"`                               // Name of the actor system
+	Sender        string                 `protobuf:"bytes,2,opt,name=sender,proto3" json:"sender,omitempty"`                               // Path of the sender; empty if unknown
+	Recipient     string                 `protobuf:"bytes,3,opt,name=recipient,proto3" json:"recipient,omitempty"`                         // Path of the recipient
+	RecipientId   int64                  `protobuf:"varint,4,opt,name=recipient_id,json=recipientId,proto3" json:"recipient_id,omitempty"` // ID of the recipient
+	MessageType   string                 `protobuf:"bytes,5,opt,name=message_type,json=messageType,proto3" json:"message_type,omitempty"`  // Full protobuf name of the message
+	Message       *anypb.Any             `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`                             // The message itself
+	Reason        string                 `protobuf:"bytes,7,opt,name=reason,proto3" json:"reason,omitempty"`                               // Why the message was not delivered, e.g. "mailbox full"
+	Error         string                 `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`                                 // Processing error, for reason "processing failed"
+	Timestamp     int64                  `protobuf:"varint,9,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                        // Unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeadLetter) Reset() {
+	*x = DeadLetter{}
+	mi := &file_actor_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeadLetter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeadLetter) ProtoMessage() {}
+
+func (x *DeadLetter) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeadLetter.ProtoReflect.Descriptor instead.
+func (*DeadLetter) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeadLetter) GetSystem() string {
+	if x != nil {
+		return x.System
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetSender() string {
+	if x != nil {
+		return x.Sender
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetRecipientId() int64 {
+	if x != nil {
+		return x.RecipientId
+	}
+	return 0
+}
+
+func (x *DeadLetter) GetMessageType() string {
+	if x != nil {
+		return x.MessageType
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetMessage() *anypb.Any {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *DeadLetter) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// RouterResize asks a router pool to grow or shrink to size routees.
+type RouterResize struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Size          int32                  `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RouterResize) Reset() {
+	*x = RouterResize{}
+	mi := &file_actor_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouterResize) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterResize) ProtoMessage() {}
+
+func (x *RouterResize) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterResize.ProtoReflect.Descriptor instead.
+func (*RouterResize) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RouterResize) GetSize() int32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+// StreamEvent carries an EventStream event between services through a message queue.
+type StreamEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Origin        string                 `protobuf:"bytes,1,opt,name=origin,proto3" json:"origin,omitempty"`   // Bridge that published the event; it ignores its own events
+	Message       *anypb.Any             `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"` // The event
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEvent) Reset() {
+	*x = StreamEvent{}
+	mi := &file_actor_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEvent) ProtoMessage() {}
+
+func (x *StreamEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEvent.ProtoReflect.Descriptor instead.
+func (*StreamEvent) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *StreamEvent) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *StreamEvent) GetMessage() *anypb.Any {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+var File_actor_proto protoreflect.FileDescriptor
+
+const file_actor_proto_rawDesc = "" +
+	"\n" +
+	"\vactor.proto\x12\x05actor\x1a\x19google/protobuf/any.proto\"S\n" +
+	"\n" +
+	"Terminated\x12\x19\n" +
+	"\bactor_id\x18\x01 \x01(\x03R\aactorId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"~\n" +
+	"\x0eRemoteEnvelope\x12\x1b\n" +
+	"\ttarget_id\x18\x01 \x01(\x03R\btargetId\x12\x1f\n" +
+	"\vtarget_path\x18\x02 \x01(\tR\n" +
+	"targetPath\x12.\n" +
+	"\amessage\x18\x03 \x01(\v2\x14.google.protobuf.AnyR\amessage\"=\n" +
+	"\vRemoteReply\x12.\n" +
+	"\amessage\x18\x01 \x01(\v2\x14.google.protobuf.AnyR\amessage\"\x82\x01\n" +
+	"\rShardEnvelope\x12\x1b\n" +
+	"\tentity_id\x18\x01 \x01(\tR\bentityId\x12.\n" +
+	"\amessage\x18\x02 \x01(\v2\x14.google.protobuf.AnyR\amessage\x12\x10\n" +
+	"\x03ask\x18\x03 \x01(\bR\x03ask\x12\x12\n" +
+	"\x04hops\x18\x04 \x01(\x05R\x04hops\"J\n" +
+	"\fShardHandOff\x12\x19\n" +
+	"\bshard_id\x18\x01 \x01(\x05R\ashardId\x12\x1f\n" +
+	"\vfrom_member\x18\x02 \x01(\tR\n" +
+	"fromMember\"\x10\n" +
+	"\x0eReceiveTimeout\"\x9c\x02\n" +
+	"\n" +
+	"DeadLetter\x12\x16\n" +
+	"\x06system\x18\x01 \x01(\tR\x06system\x12\x16\n" +
+	"\x06sender\x18\x02 \x01(\tR\x06sender\x12\x1c\n" +
+	"\trecipient\x18\x03 \x01(\tR\trecipient\x12!\n" +
+	"\frecipient_id\x18\x04 \x01(\x03R\vrecipientId\x12!\n" +
+	"\fmessage_type\x18\x05 \x01(\tR\vmessageType\x12.\n" +
+	"\amessage\x18\x06 \x01(\v2\x14.google.protobuf.AnyR\amessage\x12\x16\n" +
+	"\x06reason\x18\a \x01(\tR\x06reason\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\x12\x1c\n" +
+	"\ttimestamp\x18\t \x01(\x03R\ttimestamp\"\"\n" +
+	"\fRouterResize\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x05R\x04size\"U\n" +
+	"\vStreamEvent\x12\x16\n" +
+	"\x06origin\x18\x01 \x01(\tR\x06origin\x12.\n" +
+	"\amessage\x18\x02 \x01(\v2\x14.google.protobuf.AnyR\amessageB8Z6github.com/phuhao00/pandaparty/infra/pb/protocol/actorb\x06proto3"
+
+var (
+	file_actor_proto_rawDescOnce sync.Once
+	file_actor_proto_rawDescData []byte
+)
+
+func file_actor_proto_rawDescGZIP() []byte {
+	file_actor_proto_rawDescOnce.Do(func() {
+		file_actor_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_actor_proto_rawDesc), len(file_actor_proto_rawDesc)))
+	})
+	return file_actor_proto_rawDescData
+}
+
+var file_actor_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_actor_proto_goTypes = []any{
+	(*Terminated)(nil),     // 0: actor.Terminated
+	(*RemoteEnvelope)(nil), // 1: actor.RemoteEnvelope
+	(*RemoteReply)(nil),    // 2: actor.RemoteReply
+	(*ShardEnvelope)(nil),  // 3: actor.ShardEnvelope
+	(*ShardHandOff)(nil),   // 4: actor.ShardHandOff
+	(*ReceiveTimeout)(nil), // 5: actor.ReceiveTimeout
+	(*DeadLetter)(nil),     // 6: actor.DeadLetter
+	(*RouterResize)(nil),   // 7: actor.RouterResize
+	(*StreamEvent)(nil),    // 8: actor.StreamEvent
+	(*anypb.Any)(nil),      // 9: google.protobuf.Any
+}
+var file_actor_proto_depIdxs = []int32{
+	9, // 0: actor.RemoteEnvelope.message:type_name -> google.protobuf.Any
+	9, // 1: actor.RemoteReply.message:type_name -> google.protobuf.Any
+	9, // 2: actor.ShardEnvelope.message:type_name -> google.protobuf.Any
+	9, // 3: actor.DeadLetter.message:type_name -> google.protobuf.Any
+	9, // 4: actor.StreamEvent.message:type_name -> google.protobuf.Any
+	5, // [5:5] is the sub-list for method output_type
+	5, // [5:5] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_actor_proto_init() }
+func file_actor_proto_init() {
+	if File_actor_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_actor_proto_rawDesc), len(file_actor_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_actor_proto_goTypes,
+		DependencyIndexes: file_actor_proto_depIdxs,
+		MessageInfos:      file_actor_proto_msgTypes,
+	}.Build()
+	File_actor_proto = out.File
+	file_actor_proto_goTypes = nil
+	file_actor_proto_depIdxs = nil
+}